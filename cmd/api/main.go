@@ -6,6 +6,7 @@ import (
 	"log"
 	"logtrace/docs"
 	"logtrace/internal/config"
+	"logtrace/internal/health"
 	"logtrace/internal/middleware"
 	natsclient "logtrace/internal/nats"
 	"net/http"
@@ -15,14 +16,21 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
 func main() {
-	cfg := config.Load()
-	shutdown, err := middleware.InitTracer(cfg.ServiceName, cfg.JaegerURL)
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	shutdown, err := middleware.InitTracer(cfg.ServiceName, cfg.JaegerURL, cfg.TracingSampleRatio)
 	if err != nil {
 		log.Fatalf("Failed to initialize tracer: %v", err)
 	}
@@ -33,27 +41,66 @@ func main() {
 	}()
 
 	// Set up NATS client
+	natsTLS, err := natsclient.LoadTLSConfig(cfg.NatsTLSCAFile, cfg.NatsTLSCertFile, cfg.NatsTLSKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load NATS TLS config: %v", err)
+	}
 	natsConfig := natsclient.Config{
 		URL:             cfg.NatsURL,
+		Servers:         cfg.NatsServers,
 		ReconnectWait:   2 * time.Second,
 		MaxReconnects:   -1,
 		ConnectionName:  cfg.ServiceName,
 		StreamName:      cfg.NatsStreamName,
 		StreamSubjects:  cfg.NatsSubjects,
-		RetentionPolicy: nats.WorkQueuePolicy,
+		RetentionPolicy: jetstream.WorkQueuePolicy,
 		StorageType:     cfg.NatsStorageType,
 		MaxAge:          cfg.NatsMaxAge,
 		Replicas:        cfg.NatsReplicas,
+		JSDomain:        cfg.NatsJSDomain,
+		JSAPIPrefix:     cfg.NatsJSAPIPrefix,
+		TLSConfig:       natsTLS,
+		CredsFile:       cfg.NatsCredsFile,
+		Username:        cfg.NatsUser,
+		Password:        cfg.NatsPassword,
+		NKeySeed:        cfg.NatsNKeySeed,
+	}
+
+	// Replay entries buffered to disk whenever NATS comes back, and once at startup for
+	// entries left by a previous run
+	reconnected := make(chan struct{}, 1)
+	reconnected <- struct{}{}
+	natsConfig.OnReconnect = func() {
+		select {
+		case reconnected <- struct{}{}:
+		default:
+		}
 	}
 
 	client, err := natsclient.NewClient(natsConfig)
 	if err != nil {
 		log.Fatalf("Failed to create NATS client: %v", err)
 	}
-	defer client.Close()
 
 	log.Printf("Connected to NATS at %s", cfg.NatsURL)
 
+	// Set up the optional secondary cluster for failover publishing
+	var secondary *natsclient.NatsClient
+	var secondaryJS jetstream.JetStream
+	if cfg.NatsSecondaryURL != "" {
+		secondaryConfig := natsConfig
+		secondaryConfig.URL = cfg.NatsSecondaryURL
+		secondaryConfig.Servers = cfg.NatsSecondaryServers
+
+		secondary, err = natsclient.NewClient(secondaryConfig)
+		if err != nil {
+			log.Fatalf("Failed to create secondary NATS client: %v", err)
+		}
+
+		secondaryJS = secondary.JS
+		log.Printf("Connected to secondary NATS at %s", cfg.NatsSecondaryURL)
+	}
+
 	// Set up the log subject
 	logSubject := fmt.Sprintf("logs.%s", cfg.ServiceName)
 
@@ -63,13 +110,67 @@ func main() {
 	router.Use(gin.Recovery())
 	router.Use(gin.Logger())
 	router.Use(middleware.Tracing(cfg.ServiceName))
-	router.Use(middleware.Logger(client.JS, cfg.ServiceName, cfg.Environment, logSubject))
+	router.Use(middleware.Metrics())
+	requestLogger := middleware.NewRequestLogger(loggerConfig(cfg, client.JS, secondaryJS, logSubject))
+	router.Use(requestLogger.Handler())
+	if cfg.LogDiskBufferPath != "" {
+		go func() {
+			for range reconnected {
+				replayed, err := requestLogger.ReplayBuffer()
+				if err != nil {
+					log.Printf("Error replaying buffered log entries: %v", err)
+				}
+				if replayed > 0 {
+					log.Printf("Replayed %d buffered log entries", replayed)
+				}
+			}
+		}()
+	}
+
+	// Reload the logger's sampling, capture and redaction settings on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloadLogger(requestLogger, client.JS, secondaryJS, logSubject); err != nil {
+				log.Printf("Keeping the current logger configuration: %v", err)
+				continue
+			}
+			log.Println("Logger configuration reloaded")
+		}
+	}()
+
+	if cfg.LogBaggageAll {
+		log.Println("LOG_BAGGAGE_ALL is set: every baggage member is logged, so upstream callers control which fields entries carry; prefer LOG_BAGGAGE_KEYS")
+	}
+
+	// Periodically report how many logs were published, sampled out or dropped
+	if cfg.LogReportInterval > 0 {
+		stopReporter := middleware.StartReporter(cfg.LogReportInterval)
+		defer stopReporter()
+	}
 
 	// Validation endpoints
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerfiles.Handler))
 
 	// Set up routes
-	setupRoutes(router)
+	thresholds := health.DefaultThresholds()
+	thresholds.MaxDropRate = cfg.StatusMaxDropRate
+	thresholds.DropRateWindow = cfg.StatusDropWindow
+	thresholds.ErrorRateWindow = cfg.StatusErrorWindow
+	checker := &health.Checker{
+		Client:       client,
+		ConsumerName: cfg.ConsumerName,
+		Thresholds:   thresholds,
+	}
+	readiness := &health.ReadinessChecker{Client: client}
+	if cfg.ReadyCheckLoki {
+		readiness.LokiReadyURL = cfg.LokiReadyURL
+		if readiness.LokiReadyURL == "" {
+			readiness.LokiReadyURL = health.LokiReadyURL(cfg.LokiURL)
+		}
+	}
+	setupRoutes(router, checker, readiness)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -98,17 +199,126 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	// Publish any log entries still queued
+	if err := requestLogger.Close(ctx); err != nil {
+		log.Printf("Error draining log entries: %v", err)
+	}
+
+	// Flush pending publishes before closing the NATS connections
+	if err := client.Drain(ctx); err != nil {
+		log.Printf("Error draining NATS connection: %v", err)
+	}
+	if secondary != nil {
+		if err := secondary.Drain(ctx); err != nil {
+			log.Printf("Error draining secondary NATS connection: %v", err)
+		}
+	}
+
 	log.Println("Server exiting")
 }
 
+// reloadLogger loads the configuration again and applies its logger settings, leaving the
+// current ones in place when it can't be loaded or is invalid
+func reloadLogger(requestLogger *middleware.RequestLogger, js, secondaryJS jetstream.JetStream, logSubject string) error {
+	reloaded, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("reload failed: %w", err)
+	}
+	if err := reloaded.Validate(); err != nil {
+		return fmt.Errorf("reloaded configuration is invalid: %w", err)
+	}
+	requestLogger.Reload(loggerConfig(reloaded, js, secondaryJS, logSubject))
+	return nil
+}
+
+// logSampler returns the built-in sampler chosen by LOG_SAMPLER; nil keeps the rate-based
+// sampling of LOG_SAMPLE_RATE
+func logSampler(cfg *config.Config) middleware.Sampler {
+	switch cfg.LogSampler {
+	case "always":
+		return middleware.AlwaysSample()
+	case "never":
+		return middleware.NeverSample()
+	case "errors":
+		return middleware.ErrorSampler()
+	case "ratelimit":
+		return middleware.RateLimitSampler(cfg.LogSampleLimit)
+	default:
+		return nil
+	}
+}
+
+// loggerConfig builds the request logger's configuration from the service config
+func loggerConfig(cfg *config.Config, js, secondaryJS jetstream.JetStream, logSubject string) middleware.LoggerConfig {
+	return middleware.LoggerConfig{
+		JS:            js,
+		ServiceName:   cfg.ServiceName,
+		Environment:   cfg.Environment,
+		Subject:       logSubject,
+		Format:        middleware.LogFormat(cfg.LogFormat),
+		TrailingSlash: middleware.TrailingSlashMode(cfg.LogTrailingSlash),
+		SkipPaths:     cfg.LogSkipPaths,
+		SampleRate:    cfg.LogSampleRate,
+		Sampler:       logSampler(cfg),
+
+		TenantBaggageKey: cfg.LogTenantBaggageKey,
+		BaggageKeys:      cfg.LogBaggageKeys,
+		BaggageAll:       cfg.LogBaggageAll,
+		RetryHeader:      cfg.LogRetryHeader,
+		RequestIDHeader:  cfg.LogRequestIDHeader,
+		SpanEvents:       cfg.LogSpanEvents,
+		FallbackIDFormat: middleware.FallbackIDFormat(cfg.LogFallbackIDFormat),
+
+		SyntheticHeader:     cfg.LogSyntheticHeader,
+		SyntheticUserAgents: cfg.LogSyntheticUserAgents,
+		HeaderLabels:        cfg.LogHeaderLabels,
+
+		RedactHeaders: cfg.LogRedactHeaders,
+		RedactAll:     cfg.LogRedactAll,
+		AllowHeaders:  cfg.LogAllowHeaders,
+
+		MaskBodyFields: cfg.LogMaskBodyFields,
+
+		Secondary:         secondaryJS,
+		FailoverThreshold: cfg.NatsFailoverThreshold,
+
+		MaxHeaderBytes:       cfg.LogMaxHeaderBytes,
+		MaxRequestBodyBytes:  cfg.LogMaxRequestBodyBytes,
+		MaxResponseBodyBytes: cfg.LogMaxResponseBodyBytes,
+		ResponseBodyTypes:    cfg.LogResponseBodyTypes,
+		MaxMessageBytes:      cfg.LogMaxMessageBytes,
+
+		MaxConcurrentCaptures: cfg.LogMaxConcurrentCaptures,
+
+		Async:           cfg.LogAsync,
+		AsyncBufferSize: cfg.LogAsyncBufferSize,
+		AsyncWorkers:    cfg.LogAsyncWorkers,
+
+		PublishMaxAttempts: cfg.LogPublishMaxAttempts,
+		PublishBaseDelay:   cfg.LogPublishBaseDelay,
+
+		DiskBufferPath:     cfg.LogDiskBufferPath,
+		DiskBufferMaxBytes: int64(cfg.LogDiskBufferMaxBytes),
+
+		OnDropEntry: func(entry middleware.LogEntry, err error) {
+			log.Printf("Dropped log entry %s %s (trace %s): %v", entry.Method, entry.Path, entry.TraceID, err)
+		},
+	}
+}
+
 // setupRoutes adds routes to the Gin router
-func setupRoutes(router *gin.Engine) {
+func setupRoutes(router *gin.Engine, checker *health.Checker, readiness *health.ReadinessChecker) {
 	// Health check
-	router.GET("/ping", ping)
+	router.GET("/ping", middleware.Operation("ping"), ping)
+	router.GET("/readyz", middleware.Operation("ready"), ready(readiness))
+
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Example API endpoints
 	v1 := router.Group("/api/v1")
 	{
+		v1.GET("/status", middleware.Operation("status"), status(checker))
 		v1.GET("/users")
 		v1.GET("/users/:id")
 		v1.POST("/users")
@@ -121,8 +331,47 @@ func setupRoutes(router *gin.Engine) {
 // @Tags health
 // @Accept  json
 // @Produce json
+// @ID ping
 // @Success 200 {string} string "pong"
 // @Router /ping [get]
 func ping(c *gin.Context) {
 	c.String(http.StatusOK, "pong")
 }
+
+// @Summary Readiness probe
+// @Description Reports whether NATS, and Loki when READY_CHECK_LOKI is set, are reachable
+// @Tags health
+// @Produce json
+// @ID ready
+// @Success 200 {object} health.Readiness
+// @Failure 503 {object} health.Readiness
+// @Router /readyz [get]
+func ready(checker *health.ReadinessChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := checker.Check(c.Request.Context())
+		code := http.StatusOK
+		if !r.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, r)
+	}
+}
+
+// @Summary Pipeline status
+// @Description Summarizes log pipeline health: NATS connectivity, consumer lag, last Loki push and publish error rate
+// @Tags health
+// @Produce json
+// @ID status
+// @Success 200 {object} health.Status
+// @Failure 503 {object} health.Status
+// @Router /api/v1/status [get]
+func status(checker *health.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s := checker.Check()
+		code := http.StatusOK
+		if s.Status == health.StateDown {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, s)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"logtrace/internal/config"
+	"logtrace/internal/health"
+	"logtrace/internal/middleware"
+	natsclient "logtrace/internal/nats"
+	"logtrace/internal/natstest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeJS records published entries in place of JetStream
+type fakeJS struct {
+	jetstream.JetStream
+
+	mu      sync.Mutex
+	entries []middleware.LogEntry
+}
+
+func (f *fakeJS) Publish(_ context.Context, _ string, data []byte, _ ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	entry, err := middleware.DecodeLogEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, entry)
+	return &jetstream.PubAck{Stream: "logs"}, nil
+}
+
+// take returns the entries published since the last call
+func (f *fakeJS) take() []middleware.LogEntry {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := f.entries
+	f.entries = nil
+	return entries
+}
+
+// newTestClient connects to a fresh embedded server with a logs stream and a log-consumer
+func newTestClient(t *testing.T) *natsclient.NatsClient {
+	t.Helper()
+	s := natstest.RunServer(t)
+	client, err := natsclient.NewClient(natsclient.Config{
+		URL:             s.ClientURL(),
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>"},
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     jetstream.MemoryStorage,
+		Replicas:        1,
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.>"}); err != nil {
+		t.Fatalf("CreatePullConsumer: %v", err)
+	}
+	return client
+}
+
+// get serves a GET for path on handler, decoding the JSON response into v
+func get(t *testing.T, handler gin.HandlerFunc, path string, v any) int {
+	t.Helper()
+	router := gin.New()
+	router.GET(path, handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s returned %q: %v", path, w.Body.String(), err)
+	}
+	return w.Code
+}
+
+// scrape fetches /metrics from router, returning each series' value keyed by its name and
+// labels as exposed, e.g. http_requests_total{method="GET",path="/ping",status="200"}
+func scrape(t *testing.T, router http.Handler) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d: %s", w.Code, w.Body.String())
+	}
+
+	series := make(map[string]float64)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("unparseable series %q: %v", line, err)
+		}
+		series[line[:i]] = value
+	}
+	return series
+}
+
+// writeConfig writes content to the config file at path
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadLoggerAppliesConfigFileEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("CONFIG_FILE", path)
+	for _, key := range []string{"LOG_SAMPLER", "LOG_SAMPLE_RATE", "LOG_REDACT_HEADERS", "ENVIRONMENT"} {
+		t.Setenv(key, "")
+	}
+	writeConfig(t, path, "log_sampler: always\nlog_redact_headers: [X-Api-Key]\n")
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := &fakeJS{}
+	requestLogger := middleware.NewRequestLogger(loggerConfig(cfg, js, nil, "logs.api"))
+	r := gin.New()
+	r.Use(requestLogger.Handler())
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	// request serves both routes with both headers, returning what was logged
+	request := func() []middleware.LogEntry {
+		for _, path := range []string{"/ok", "/fail"} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("X-Api-Key", "key-123")
+			req.Header.Set("X-Session", "session-456")
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		return js.take()
+	}
+	// expect checks only /fail was logged when errorsOnly is set, and which header was redacted
+	expect := func(what string, entries []middleware.LogEntry, errorsOnly bool, redacted, kept string) {
+		t.Helper()
+		if want := map[bool]int{false: 2, true: 1}[errorsOnly]; len(entries) != want {
+			t.Fatalf("%s: logged %d requests, want %d", what, len(entries), want)
+		}
+		headers := entries[len(entries)-1].Headers
+		if headers[redacted] != middleware.RedactedValue || headers[kept] == middleware.RedactedValue {
+			t.Errorf("%s: logged headers %v, want %s redacted and %s kept", what, headers, redacted, kept)
+		}
+	}
+
+	expect("initial config", request(), false, "X-Api-Key", "X-Session")
+
+	writeConfig(t, path, "log_sampler: errors\nlog_redact_headers: [X-Session]\n")
+	if err := reloadLogger(requestLogger, js, nil, "logs.api"); err != nil {
+		t.Fatalf("reloadLogger: %v", err)
+	}
+	expect("after reload", request(), true, "X-Session", "X-Api-Key")
+
+	// Neither an invalid nor an unreadable file replaces the running config
+	for _, content := range []string{"log_sampler: sometimes\n", "log_sampler: [always\n"} {
+		writeConfig(t, path, content)
+		if err := reloadLogger(requestLogger, js, nil, "logs.api"); err == nil {
+			t.Errorf("reloadLogger accepted %q", content)
+		}
+		expect("after a failed reload", request(), true, "X-Session", "X-Api-Key")
+	}
+}
+
+func TestStatusEndpoint(t *testing.T) {
+	client := newTestClient(t)
+	closed := newTestClient(t)
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		client   *natsclient.NatsClient
+		consumer string
+		want     health.State
+		wantCode int
+	}{
+		{"healthy", client, "log-consumer", health.StateHealthy, http.StatusOK},
+		{"degraded without the consumer", client, "missing", health.StateDegraded, http.StatusOK},
+		{"down without NATS", closed, "log-consumer", health.StateDown, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := &health.Checker{Client: tt.client, ConsumerName: tt.consumer, Thresholds: health.DefaultThresholds()}
+
+			var got health.Status
+			code := get(t, status(checker), "/api/v1/status", &got)
+			if code != tt.wantCode || got.Status != tt.want {
+				t.Errorf("GET /api/v1/status = %d %s (%v), want %d %s", code, got.Status, got.Issues, tt.wantCode, tt.want)
+			}
+			if got.Consumer.Name != tt.consumer {
+				t.Errorf("consumer = %q, want %q", got.Consumer.Name, tt.consumer)
+			}
+			if got.NATS.Connected != (tt.want != health.StateDown) {
+				t.Errorf("nats connected = %t in a %s status", got.NATS.Connected, tt.want)
+			}
+			if up := tt.want != health.StateDown; (got.Stream != nil) != up || (up && got.Stream.Stream != "logs") {
+				t.Errorf("stream = %+v in a %s status, want the logs stream snapshot while NATS is up", got.Stream, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadyEndpoint(t *testing.T) {
+	client := newTestClient(t)
+	closed := newTestClient(t)
+	closed.Close()
+	lokiReady := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer lokiReady.Close()
+	lokiStarting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer lokiStarting.Close()
+
+	tests := []struct {
+		name      string
+		checker   *health.ReadinessChecker
+		want      bool
+		wantDown  string
+		wantCheck bool // whether Loki is reported at all
+	}{
+		{name: "ready", checker: &health.ReadinessChecker{Client: client}, want: true},
+		{name: "NATS disconnected", checker: &health.ReadinessChecker{Client: closed}, wantDown: "nats"},
+		{name: "Loki ready", checker: &health.ReadinessChecker{Client: client, LokiReadyURL: lokiReady.URL}, want: true, wantCheck: true},
+		{name: "Loki not ready", checker: &health.ReadinessChecker{Client: client, LokiReadyURL: lokiStarting.URL}, wantDown: "loki", wantCheck: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got health.Readiness
+			code := get(t, ready(tt.checker), "/readyz", &got)
+			if wantCode := map[bool]int{true: http.StatusOK, false: http.StatusServiceUnavailable}[tt.want]; code != wantCode || got.Ready != tt.want {
+				t.Errorf("GET /readyz = %d ready %t, want %d ready %t", code, got.Ready, wantCode, tt.want)
+			}
+			if (got.Loki != nil) != tt.wantCheck {
+				t.Fatalf("loki = %+v, want it reported: %t", got.Loki, tt.wantCheck)
+			}
+			if down := tt.wantDown == "nats"; got.NATS.Up == down || (got.NATS.Error != "") != down {
+				t.Errorf("nats = %+v, want it down: %t", got.NATS, down)
+			}
+			if down := tt.wantDown == "loki"; got.Loki != nil && (got.Loki.Up == down || (got.Loki.Error != "") != down) {
+				t.Errorf("loki = %+v, want it down: %t", *got.Loki, down)
+			}
+		})
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	router := gin.New()
+	router.Use(middleware.Metrics())
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/items/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/items", func(c *gin.Context) { c.Status(http.StatusBadRequest) })
+
+	before := scrape(t, router)
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/items/1", nil),
+		httptest.NewRequest(http.MethodGet, "/items/2", nil),
+		httptest.NewRequest(http.MethodPost, "/items", nil),
+		httptest.NewRequest(http.MethodGet, "/no/such/path", nil),
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	after := scrape(t, router)
+
+	// Requests are counted by route template, so both item IDs share a series
+	want := map[string]float64{
+		`http_requests_total{method="GET",path="/items/:id",status="200"}`:            2,
+		`http_requests_total{method="POST",path="/items",status="400"}`:               1,
+		`http_requests_total{method="GET",path="unmatched",status="404"}`:             1,
+		`http_request_duration_seconds_count{method="GET",path="/items/:id"}`:         2,
+		`http_request_duration_seconds_count{method="GET",path="unmatched"}`:          1,
+		`http_request_duration_seconds_bucket{method="POST",path="/items",le="+Inf"}`: 1,
+	}
+	for series, delta := range want {
+		if _, ok := after[series]; !ok {
+			t.Errorf("%s missing from /metrics", series)
+			continue
+		}
+		if got := after[series] - before[series]; got != delta {
+			t.Errorf("%s rose by %g, want %g", series, got, delta)
+		}
+	}
+	for series := range after {
+		if strings.Contains(series, "/items/1") || strings.Contains(series, "/no/such/path") {
+			t.Errorf("raw path in a label: %s", series)
+		}
+	}
+	for _, series := range []string{"logger_published_total", "logger_publish_failures_total", "logger_dropped_total"} {
+		if _, ok := after[series]; !ok {
+			t.Errorf("%s missing from /metrics", series)
+		}
+	}
+}
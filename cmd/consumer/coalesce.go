@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// coalesceKey identifies entries reporting the same failure
+type coalesceKey struct {
+	service string
+	route   string
+	status  int
+	message string
+	stack   string
+}
+
+// stackAddresses matches pointers and offsets, which differ between otherwise identical stacks
+var stackAddresses = regexp.MustCompile(`0x[0-9a-f]+`)
+
+// normalizeStack drops the goroutine header and addresses from a stack trace
+func normalizeStack(stack string) string {
+	if strings.HasPrefix(stack, "goroutine ") {
+		if _, rest, ok := strings.Cut(stack, "\n"); ok {
+			stack = rest
+		}
+	}
+	return stackAddresses.ReplaceAllString(stack, "0x")
+}
+
+// coalesceErrors folds entries with an identical error and stack into the first of them,
+// which records how many there were and when the first and last happened. Entries
+// without an error pass through. The batch is reused for the result.
+func coalesceErrors(batch []received) []received {
+	index := make(map[coalesceKey]int)
+	kept := batch[:0]
+	for _, r := range batch {
+		if r.entry.Error == "" && r.entry.StackTrace == "" {
+			kept = append(kept, r)
+			continue
+		}
+
+		key := coalesceKey{
+			service: r.entry.LabelService(),
+			route:   r.entry.Route,
+			status:  r.entry.Status,
+			message: r.entry.Error,
+			stack:   normalizeStack(r.entry.StackTrace),
+		}
+		if i, ok := index[key]; ok {
+			kept[i].absorb(r)
+			coalesced.Inc()
+			continue
+		}
+		index[key] = len(kept)
+		kept = append(kept, r)
+	}
+	return kept
+}
+
+// absorb counts dup as another occurrence of r's failure; acknowledging r then
+// acknowledges dup's message too
+func (r *received) absorb(dup received) {
+	if r.entry.Occurrences == 0 {
+		first, last := r.entry.Timestamp, r.entry.Timestamp
+		r.entry.Occurrences = 1
+		r.entry.FirstSeen, r.entry.LastSeen = &first, &last
+	}
+	r.entry.Occurrences++
+	if dup.entry.Timestamp.Before(*r.entry.FirstSeen) {
+		*r.entry.FirstSeen = dup.entry.Timestamp
+	}
+	if dup.entry.Timestamp.After(*r.entry.LastSeen) {
+		*r.entry.LastSeen = dup.entry.Timestamp
+	}
+	if dup.arrived.Before(r.arrived) {
+		r.arrived = dup.arrived
+	}
+
+	msg, ok := r.msg.(*coalescedMsg)
+	if !ok {
+		msg = &coalescedMsg{Msg: r.msg}
+		r.msg = msg
+	}
+	msg.copies = append(msg.copies, dup.msg)
+}
+
+// coalescedMsg settles the messages of coalesced entries along with the representative's
+type coalescedMsg struct {
+	jetstream.Msg
+	copies []jetstream.Msg
+}
+
+func (m *coalescedMsg) each(settle func(jetstream.Msg) error) error {
+	errs := []error{settle(m.Msg)}
+	for _, msg := range m.copies {
+		errs = append(errs, settle(msg))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *coalescedMsg) Ack() error {
+	return m.each(jetstream.Msg.Ack)
+}
+
+func (m *coalescedMsg) Nak() error {
+	return m.each(jetstream.Msg.Nak)
+}
+
+func (m *coalescedMsg) NakWithDelay(delay time.Duration) error {
+	return m.each(func(msg jetstream.Msg) error { return msg.NakWithDelay(delay) })
+}
+
+func (m *coalescedMsg) Term() error {
+	return m.each(jetstream.Msg.Term)
+}
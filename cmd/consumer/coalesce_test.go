@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"logtrace/internal/loki"
+	"logtrace/internal/middleware"
+)
+
+const testStack = "goroutine 42 [running]:\nmain.handler(0xc000123456)\n\t/app/main.go:10 +0x1f\n"
+
+// failure returns an entry reporting the same panic as every other failure of route
+func failure(traceID, route string, at time.Time) middleware.LogEntry {
+	return middleware.LogEntry{
+		Timestamp:   at,
+		TraceID:     traceID,
+		ServiceName: "api",
+		Route:       route,
+		Status:      500,
+		Error:       "panic: nil map",
+		StackTrace:  testStack,
+	}
+}
+
+func TestCoalescedEntryCountsAndAcksEveryCopy(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var batch []received
+	var msgs []*fakeMsg
+	add := func(entry middleware.LogEntry) {
+		r, msg := receivedEntry(entry)
+		batch = append(batch, r)
+		msgs = append(msgs, msg)
+	}
+
+	// Five copies of one failure arriving out of order, with goroutine IDs and addresses
+	// that differ between them, one other failure and a success
+	for i, offset := range []int{3, 0, 9, 5, 1} {
+		entry := failure(fmt.Sprintf("dup-%d", i), "/users/:id", base.Add(time.Duration(offset)*time.Second))
+		entry.StackTrace = fmt.Sprintf("goroutine %d [running]:\nmain.handler(0xc0001%05x)\n\t/app/main.go:10 +0x1f\n", 40+i, i)
+		add(entry)
+	}
+	add(failure("other", "/orders/:id", base))
+	add(middleware.LogEntry{Timestamp: base, TraceID: "ok", ServiceName: "api", Status: 200})
+
+	counter := newCountingSink()
+	f := &forwarder{name: "test", sink: counter, coalesce: true}
+	f.processBatch(context.Background(), batch)
+
+	if len(counter.entries) != 3 {
+		t.Fatalf("sink received %d entries, want 3", len(counter.entries))
+	}
+	rep := counter.entries[0]
+	if rep.TraceID != "dup-0" || rep.Occurrences != 5 {
+		t.Errorf("representative %s has %d occurrences, want dup-0 with 5", rep.TraceID, rep.Occurrences)
+	}
+	if rep.FirstSeen == nil || !rep.FirstSeen.Equal(base) {
+		t.Errorf("first seen = %v, want %v", rep.FirstSeen, base)
+	}
+	if rep.LastSeen == nil || !rep.LastSeen.Equal(base.Add(9*time.Second)) {
+		t.Errorf("last seen = %v, want %v", rep.LastSeen, base.Add(9*time.Second))
+	}
+	for _, entry := range counter.entries[1:] {
+		if entry.Occurrences != 0 || entry.FirstSeen != nil {
+			t.Errorf("%s marked as coalesced", entry.TraceID)
+		}
+	}
+
+	for i, msg := range msgs {
+		if got := msg.outcome(t); got != "ack" {
+			t.Errorf("message %d settled with %q, want ack", i, got)
+		}
+	}
+}
+
+func TestCoalescedEntryRedeliversEveryCopy(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var batch []received
+	var msgs []*fakeMsg
+	for i := range 3 {
+		r, msg := receivedEntry(failure(fmt.Sprintf("dup-%d", i), "/users/:id", base))
+		batch = append(batch, r)
+		msgs = append(msgs, msg)
+	}
+
+	counter := newCountingSink()
+	counter.fail = func(int, []middleware.LogEntry) error {
+		return &loki.PushError{StatusCode: 503}
+	}
+	f := &forwarder{name: "test", sink: counter, coalesce: true}
+	f.processBatch(context.Background(), batch)
+
+	for i, msg := range msgs {
+		if got := msg.outcome(t); got != "nak_delay" {
+			t.Errorf("message %d settled with %q, want nak_delay", i, got)
+		}
+	}
+}
+
+func TestCoalesceKeepsDistinctFailuresApart(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	same := failure("a", "/users/:id", base)
+	variants := []func(e *middleware.LogEntry){
+		func(e *middleware.LogEntry) { e.Route = "/orders/:id" },
+		func(e *middleware.LogEntry) { e.Status = 502 },
+		func(e *middleware.LogEntry) { e.Error = "panic: index out of range" },
+		func(e *middleware.LogEntry) { e.ServiceName = "web" },
+		func(e *middleware.LogEntry) { e.StackTrace = "goroutine 1 [running]:\nmain.other()\n" },
+	}
+
+	batch := []received{{entry: same, msg: &fakeMsg{}}}
+	for i, change := range variants {
+		entry := failure(fmt.Sprintf("v%d", i), "/users/:id", base)
+		change(&entry)
+		batch = append(batch, received{entry: entry, msg: &fakeMsg{}})
+	}
+
+	if kept := coalesceErrors(batch); len(kept) != len(variants)+1 {
+		t.Errorf("coalesced %d distinct failures into %d entries", len(variants)+1, len(kept))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	natsclient "logtrace/internal/nats"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// reportLag logs the consumer's pending, ack-pending and redelivered counts every interval
+// and sets the lag gauges until shutdown
+func reportLag(client *natsclient.NatsClient, stream, consumer string, interval time.Duration, shutdown <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		pending, ackPending, redelivered, err := client.ConsumerPending(stream, consumer)
+		if err != nil {
+			logger.WithError(err).WithField("consumer", consumer).Warn("Error reading consumer lag")
+			continue
+		}
+
+		lagPending.Set(float64(pending))
+		lagAckPending.Set(float64(ackPending))
+		lagRedelivered.Set(float64(redelivered))
+
+		logger.WithFields(logrus.Fields{
+			"consumer":    consumer,
+			"pending":     pending,
+			"ack_pending": ackPending,
+			"redelivered": redelivered,
+		}).Info("Consumer lag")
+	}
+}
+
+// pushRecordInterval is how often the consumer records its last successful push
+const pushRecordInterval = 5 * time.Second
+
+// recordPushes stores the forwarder's last successful push in the status bucket whenever
+// it changed, for the API's status endpoint, until shutdown
+func recordPushes(client *natsclient.NatsClient, consumer string, fwd *forwarder, interval time.Duration, shutdown <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var recorded int64
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		if last := fwd.lastPush.Load(); last != 0 && last != recorded && recordLastPush(client, consumer, fwd) {
+			recorded = last
+		}
+	}
+}
+
+// recordLastPush stores the forwarder's last successful push, if any, reporting whether it was stored
+func recordLastPush(client *natsclient.NatsClient, consumer string, fwd *forwarder) bool {
+	last := fwd.lastPush.Load()
+	if last == 0 {
+		return false
+	}
+	if err := client.RecordPush(consumer, time.Unix(0, last)); err != nil {
+		logger.WithError(err).WithField("consumer", consumer).Warn("Error recording last push")
+		return false
+	}
+	return true
+}
+
+// serveMetrics exposes the consumer's Prometheus metrics, including lag, at /metrics
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	logger.WithField("addr", addr).Info("Serving metrics at /metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.WithError(err).Error("Metrics server stopped")
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReportLagSetsGauges(t *testing.T) {
+	client := newTestClient(t)
+	if _, err := client.SubscribePull("log-consumer", []string{"logs.>"}); err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+	publishEntries(t, client, "lag", 4)
+
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		reportLag(client, "logs", "log-consumer", 10*time.Millisecond, shutdown)
+		close(done)
+	}()
+	waitFor(t, 5*time.Second, "consumer_pending_messages to report 4", func() bool {
+		return testutil.ToFloat64(lagPending) == 4
+	})
+	if got := testutil.ToFloat64(lagAckPending); got != 0 {
+		t.Errorf("consumer_ack_pending_messages = %g, want 0", got)
+	}
+
+	close(shutdown)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reportLag didn't stop on shutdown")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logger is the consumer's own structured logger
+var logger = logrus.New()
+
+// setupLogger configures the consumer logger's level and output format ("json" or "text")
+func setupLogger(level, format string) {
+	logger.SetOutput(os.Stdout)
+
+	if format == "text" {
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	} else {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		logger.WithField("level", level).Warn("Unknown log level, using info")
+		lvl = logrus.InfoLevel
+	}
+	logger.SetLevel(lvl)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"logtrace/internal/middleware"
+
+	"github.com/sirupsen/logrus"
+)
+
+// captureLogs configures the consumer logger like setupLogger and records its JSON lines
+// until the test ends
+func captureLogs(t *testing.T, level string) *bytes.Buffer {
+	t.Helper()
+	previousLevel, previousFormatter := logger.GetLevel(), logger.Formatter
+	t.Cleanup(func() {
+		logger.SetOutput(io.Discard)
+		logger.SetLevel(previousLevel)
+		logger.SetFormatter(previousFormatter)
+	})
+
+	setupLogger(level, "json")
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	return &buf
+}
+
+// logLines decodes the JSON lines logged to buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var lines []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatalf("log line is not JSON: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestSetupLogger(t *testing.T) {
+	tests := []struct {
+		level  string
+		format string
+		want   logrus.Level
+		text   bool
+	}{
+		{"debug", "json", logrus.DebugLevel, false},
+		{"WARN", "", logrus.WarnLevel, false},
+		{"error", "text", logrus.ErrorLevel, true},
+		{"verbose", "json", logrus.InfoLevel, false}, // unknown levels fall back to info
+	}
+	for _, tt := range tests {
+		captureLogs(t, "info")
+		setupLogger(tt.level, tt.format)
+		logger.SetOutput(io.Discard)
+
+		if got := logger.GetLevel(); got != tt.want {
+			t.Errorf("setupLogger(%q): level %s, want %s", tt.level, got, tt.want)
+		}
+		_, text := logger.Formatter.(*logrus.TextFormatter)
+		if text != tt.text {
+			t.Errorf("setupLogger(%q, %q): formatter %T", tt.level, tt.format, logger.Formatter)
+		}
+	}
+}
+
+func TestProcessBatchLogsStructuredFields(t *testing.T) {
+	batch := func() []received {
+		first, _ := receivedEntry(middleware.LogEntry{TraceID: "t1", ServiceName: "api"})
+		second, _ := receivedEntry(middleware.LogEntry{TraceID: "t2", ServiceName: "api"})
+		return []received{first, second}
+	}
+
+	buf := captureLogs(t, "debug")
+	f := &forwarder{name: "test", sink: newCountingSink()}
+	f.processBatch(context.Background(), batch())
+
+	lines := logLines(t, buf)
+	want := map[string]string{"Processing batch of logs": "debug", "Successfully sent logs": "info"}
+	for _, line := range lines {
+		level, ok := want[line["msg"].(string)]
+		if !ok {
+			continue
+		}
+		delete(want, line["msg"].(string))
+		if line["level"] != level || line["batch_size"] != float64(2) {
+			t.Errorf("logged %v, want level %s and batch_size 2", line, level)
+		}
+		if _, ok := line["time"]; !ok {
+			t.Errorf("logged %v without a time", line)
+		}
+	}
+	if len(want) != 0 {
+		t.Errorf("missing log lines %v in %v", want, lines)
+	}
+
+	// Lines below the configured level are left out
+	buf = captureLogs(t, "info")
+	f.processBatch(context.Background(), batch())
+	for _, line := range logLines(t, buf) {
+		if line["level"] == "debug" {
+			t.Errorf("logged %v at level info", line)
+		}
+	}
+}
@@ -2,171 +2,698 @@
 package main
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"logtrace/internal/cloudlogging"
 	"logtrace/internal/config"
+	"logtrace/internal/geoip"
 	"logtrace/internal/loki"
 	"logtrace/internal/middleware"
 	natsclient "logtrace/internal/nats"
+	"logtrace/internal/sink"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load configuration")
+	}
+	setupLogger(cfg.ConsumerLogLevel, cfg.ConsumerLogFormat)
+	if err := cfg.Validate(); err != nil {
+		logger.WithError(err).Fatal("Invalid configuration")
+	}
+	for _, warning := range cfg.Warnings() {
+		logger.Warn(warning)
+	}
+
+	// Trace batch pushes, linked to the requests whose logs they carry
+	shutdownTracer, err := middleware.InitTracer("log-consumer", cfg.JaegerURL, cfg.TracingSampleRatio)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize tracer")
+	}
+	defer func() {
+		if err := shutdownTracer(context.Background()); err != nil {
+			logger.WithError(err).Error("Error shutting down tracer")
+		}
+	}()
 
 	// Set consumer name
-	consumerName := "loki-consumer"
+	consumerName := cfg.ConsumerName
 
 	// Set up NATS client
+	natsTLS, err := natsclient.LoadTLSConfig(cfg.NatsTLSCAFile, cfg.NatsTLSCertFile, cfg.NatsTLSKeyFile)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load NATS TLS config")
+	}
 	natsConfig := natsclient.Config{
 		URL:             cfg.NatsURL,
+		Servers:         cfg.NatsServers,
 		ReconnectWait:   2 * time.Second,
 		MaxReconnects:   -1,
 		ConnectionName:  "log-consumer",
 		StreamName:      cfg.NatsStreamName,
 		StreamSubjects:  cfg.NatsSubjects,
-		RetentionPolicy: nats.WorkQueuePolicy,
+		RetentionPolicy: jetstream.WorkQueuePolicy,
 		StorageType:     cfg.NatsStorageType,
 		MaxAge:          cfg.NatsMaxAge,
 		Replicas:        cfg.NatsReplicas,
+		JSDomain:        cfg.NatsJSDomain,
+		JSAPIPrefix:     cfg.NatsJSAPIPrefix,
+		TLSConfig:       natsTLS,
+		CredsFile:       cfg.NatsCredsFile,
+		Username:        cfg.NatsUser,
+		Password:        cfg.NatsPassword,
+		NKeySeed:        cfg.NatsNKeySeed,
+		MaxAckPending:   cfg.ConsumerMaxAckPending,
+		AckWait:         cfg.ConsumerAckWait,
 	}
 
 	client, err := natsclient.NewClient(natsConfig)
 	if err != nil {
-		log.Fatalf("Failed to create NATS client: %v", err)
+		logger.WithError(err).Fatal("Failed to create NATS client")
 	}
-	defer client.Close()
 
-	log.Printf("Connected to NATS at %s", cfg.NatsURL)
+	logger.WithField("url", cfg.NatsURL).Info("Connected to NATS")
+
+	// Channel to signal shutdown
+	shutdown := make(chan struct{})
 
-	// Create Loki client
-	lokiClient := loki.NewClient(cfg.LokiURL)
+	// Sink pushes are cancelled if shutdown takes too long so a hung push can't block it
+	sendCtx, cancelSends := context.WithCancel(context.Background())
+	defer cancelSends()
 
-	// Create a pull consumer to batch process logs
-	sub, err := client.SubscribePull(consumerName, cfg.NatsSubjects[0])
-	if err != nil {
-		log.Fatalf("Failed to create pull subscription: %v", err)
+	// Ordering and coalescing only see entries pending together, so batches wait out their windows
+	fwd := &forwarder{
+		batchSize:          cfg.ConsumerBatchSize,
+		batchTimeout:       max(cfg.ConsumerBatchTimeout, cfg.ConsumerOrderWindow, cfg.ConsumerCoalesceWindow),
+		eventOrder:         cfg.ConsumerOrderWindow > 0,
+		coalesce:           cfg.ConsumerCoalesceWindow > 0,
+		priority:           cfg.ConsumerPriority,
+		priorityMaxWait:    cfg.ConsumerPriorityMaxWait,
+		serviceFromSubject: cfg.ConsumerSubjectLabels,
+		dropSynthetic:      cfg.ConsumerSynthetic == "drop",
+		ackWait:            cfg.ConsumerAckWait,
+		redeliveryDelay:    redeliveryDelay,
 	}
+	routeSynthetic := cfg.ConsumerSynthetic == "route"
+	switch cfg.ConsumerSink {
+	case "cloudlogging":
+		if cfg.GCPProjectID == "" {
+			logger.Fatal("GCP_PROJECT_ID is required for the cloudlogging sink")
+		}
+		fwd.name = "cloudlogging"
+		cloudSink := cloudlogging.NewCloudLoggingSink(cfg.GCPProjectID, cfg.GCPLogName)
+		cloudSink.SyntheticLabel = routeSynthetic
+		fwd.sink = cloudSink
+		logger.WithField("project", cfg.GCPProjectID).Info("Forwarding logs to Cloud Logging")
 
-	log.Printf("Pull subscription created, waiting for logs")
-
-	// Channel to signal shutdown
-	shutdown := make(chan struct{})
+	default:
+		// Create Loki client
+		lokiClient := loki.NewClient(cfg.LokiURL)
+		lokiClient.Encoding = loki.Encoding(cfg.LokiEncoding)
+		lokiClient.Method = cfg.LokiPushMethod
+		lokiClient.LabelPrefix = cfg.LokiLabelPrefix
+		lokiClient.QueryURL = cfg.LokiQueryURL
+		lokiClient.MaxAttempts = cfg.LokiMaxAttempts
+		lokiClient.RetryBaseDelay = cfg.LokiRetryDelay
+		lokiClient.HTTPClient.Timeout = cfg.LokiTimeout
+		lokiClient.BreakerThreshold = cfg.LokiBreakerThreshold
+		lokiClient.BreakerCooldown = cfg.LokiBreakerCooldown
+		registerBreakerState(lokiClient)
+		lokiClient.TenantField = cfg.LokiTenantField
+		lokiClient.DefaultTenant = cfg.LokiDefaultTenant
+		lokiClient.ResourceSegment = cfg.LokiResourceSegment
+		lokiClient.ResourceAllowlist = make(map[string]bool)
+		for _, resource := range cfg.LokiResourceAllowlist {
+			lokiClient.ResourceAllowlist[strings.ToLower(resource)] = true
+		}
+		lokiClient.HeaderLabels = make(map[string]map[string]bool)
+		for label, values := range cfg.LokiHeaderLabels {
+			lokiClient.HeaderLabels[label] = make(map[string]bool)
+			for _, value := range values {
+				lokiClient.HeaderLabels[label][value] = true
+			}
+		}
+		lokiClient.SyntheticLabel = routeSynthetic
+		lokiClient.OutOfOrder = cfg.LokiOutOfOrder
+		lokiClient.MaxLabelValueLength = cfg.LokiMaxLabelValue
+		lokiClient.OnLabelFixed = func(name, problem string) {
+			logger.WithField("label", name).Debug("Loki label " + problem)
+		}
+		fwd.name = "loki"
+		fwd.sink = lokiClient
 
-	// Start the consumer loop
-	go func() {
-		// Buffer for batch processing
-		var batch []middleware.LogEntry
-		var batchTimer *time.Timer
-		const batchSize = 100
-		const batchTimeoutMs = 1000 // 1 second
-
-		resetTimer := func() {
-			if batchTimer != nil {
-				batchTimer.Stop()
+		// Optionally read back a sample of pushed entries to detect ingestion gaps
+		if cfg.LokiVerifyRate > 0 {
+			verifier := loki.NewVerifier(lokiClient, cfg.LokiVerifyRate, cfg.LokiVerifyDelay)
+			verifier.OnMissing = func(traceID string) {
+				logger.WithField("trace_id", traceID).Error("Pushed log entry not found in Loki")
 			}
-			batchTimer = time.AfterFunc(batchTimeoutMs*time.Millisecond, func() {
-				if len(batch) > 0 {
-					// Process the batch when the timer expires
-					processBatch(batch, lokiClient)
-					batch = batch[:0] // Clear the batch
-				}
-			})
+			verifier.OnError = func(traceID string, err error) {
+				logger.WithError(err).WithField("trace_id", traceID).Warn("Error verifying log entry in Loki")
+			}
+			go verifier.Run(shutdown)
+			fwd.verifier = verifier
 		}
+	}
 
-		resetTimer()
+	// Optionally add the client's country and city to entries
+	if cfg.GeoIPDBPath != "" {
+		geo, err := geoip.Open(cfg.GeoIPDBPath)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to open GeoIP database")
+		}
+		defer geo.Close()
+		fwd.geo = geo
+		logger.WithField("path", cfg.GeoIPDBPath).Info("GeoIP enrichment enabled")
+	}
 
-		for {
-			select {
-			case <-shutdown:
-				// Process any remaining logs before exiting
-				if len(batch) > 0 {
-					processBatch(batch, lokiClient)
-				}
-				return
-			default:
-				// Try to fetch messages
-				msgs, err := sub.Fetch(batchSize, nats.MaxWait(500*time.Millisecond))
-				if err == nats.ErrTimeout {
-					// No messages, continue
-					continue
-				}
-				if err != nil {
-					log.Printf("Error fetching messages: %v", err)
-					time.Sleep(1 * time.Second)
-					continue
-				}
+	// Republish entries the sink permanently rejects to the dead-letter subject
+	if cfg.NatsDLQSubject != "" {
+		if err := client.SetupDLQStream(cfg.NatsDLQStream, cfg.NatsDLQSubject, cfg.NatsMaxAge); err != nil {
+			logger.WithError(err).Fatal("Failed to set up dead-letter stream")
+		}
+		fwd.deadLetter = func(data []byte, reason string) error {
+			return client.PublishDLQ(cfg.NatsDLQSubject, data, reason)
+		}
+	}
 
-				// Process received messages
-				for _, msg := range msgs {
-					var logEntry middleware.LogEntry
-					err := json.Unmarshal(msg.Data, &logEntry)
-					if err != nil {
-						log.Printf("Error unmarshaling log entry: %v", err)
-						msg.Ack() // Acknowledge even if we couldn't process it
-						continue
-					}
+	// Each worker's received entries flow to its own batcher goroutine, which is the only
+	// owner of that worker's batch, so pushes stay ordered within a worker
+	queues := make([]chan received, cfg.ConsumerWorkers)
+	for i := range queues {
+		queues[i] = make(chan received, cfg.ConsumerBatchSize)
+	}
 
-					// Add to batch
-					batch = append(batch, logEntry)
+	// Deliver logs from the durable consumer to the batchers
+	src := source{
+		client:    client,
+		consumer:  consumerName,
+		subjects:  cfg.NatsSubjects,
+		batchSize: cfg.ConsumerBatchSize,
+	}
+	if cfg.ConsumerPushFallback {
+		src.pushFallback = &natsclient.PushConfig{
+			FlowControl: cfg.ConsumerPushFlowControl,
+			Heartbeat:   cfg.ConsumerPushHeartbeat,
+		}
+	}
+	queues, err = src.start(queues, shutdown)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to subscribe to logs")
+	}
 
-					// Acknowledge the message in NATS
-					msg.Ack()
-				}
+	// Report how far behind the consumer is
+	if cfg.ConsumerLagInterval > 0 {
+		go reportLag(client, cfg.NatsStreamName, consumerName, cfg.ConsumerLagInterval, shutdown)
+	}
+	if cfg.ConsumerMetricsAddr != "" {
+		go serveMetrics(cfg.ConsumerMetricsAddr)
+	}
 
-				// Process batch if it's full
-				if len(batch) >= batchSize {
-					processBatch(batch, lokiClient)
-					batch = batch[:0] // Clear the batch
-					resetTimer()
-				} else if len(batch) > 0 {
-					// Reset the timer whenever we add to a non-empty batch
-					resetTimer()
-				}
-			}
-		}
-	}()
+	// Record successful pushes for the API's status endpoint
+	go recordPushes(client, consumerName, fwd, pushRecordInterval, shutdown)
+
+	batcher := fwd.start(sendCtx, queues)
 
 	// Wait for interrupt signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 
-	log.Println("Shutting down...")
+	logger.Info("Shutting down...")
 	close(shutdown)
-	time.Sleep(1 * time.Second) // Give the consumer loop time to finish
 
-	log.Println("Consumer exiting")
+	// Wait for every batcher to flush, cancelling a push that hangs past the timeout
+	timer := time.AfterFunc(shutdownTimeout, cancelSends)
+	batcher.Wait()
+	timer.Stop()
+	recordLastPush(client, consumerName, fwd)
+
+	// Flush the final acks and naks before closing the connection
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelDrain()
+	if err := client.Drain(drainCtx); err != nil {
+		logger.WithError(err).Error("Error draining NATS connection")
+	}
+
+	logger.Info("Consumer exiting")
+}
+
+// received is a decoded log entry with the message it came from, which stays
+// unacknowledged until the sink has the entry
+type received struct {
+	entry   middleware.LogEntry
+	msg     jetstream.Msg
+	arrived time.Time
+}
+
+// forwarder sends batches of received entries to the log sink
+type forwarder struct {
+	// name identifies the sink in spans, e.g. "loki"
+	name string
+	sink sink.LogSink
+	// batchSize and batchTimeout bound a batch by entries and by age
+	batchSize    int
+	batchTimeout time.Duration
+
+	// priority sends error entries ahead of others, promoting any entry that has
+	// waited priorityMaxWait
+	priority        bool
+	priorityMaxWait time.Duration
+
+	// verifier reads back entries pushed to Loki; nil for other sinks
+	verifier *loki.Verifier
+
+	// deadLetter republishes entries the sink permanently rejects; nil drops them
+	deadLetter func(data []byte, reason string) error
+
+	// geo adds the client's location to entries; nil disables enrichment
+	geo *geoip.Enricher
+
+	// serviceFromSubject labels entries with the service named by their subject
+	// (logs.<service>) rather than the one in the body
+	serviceFromSubject bool
+
+	// dropSynthetic acknowledges synthetic entries without sending them
+	dropSynthetic bool
+
+	// eventOrder sorts each batch by entry timestamp before sending it
+	eventOrder bool
+
+	// coalesce sends identical error entries within a batch as one entry with a count
+	coalesce bool
+
+	// ackWait bounds a batch's sends: once its oldest entry has gone unacknowledged that
+	// long NATS redelivers it, so a push still running is wasted; zero disables the bound
+	ackWait time.Duration
+
+	// redeliveryDelay is how long NATS waits before redelivering entries the sink
+	// couldn't take
+	redeliveryDelay time.Duration
+
+	// lastPush is when the sink last took a batch, in Unix nanoseconds
+	lastPush atomic.Int64
+}
+
+const (
+	// redeliveryDelay spaces out redelivery of batches the sink couldn't take
+	redeliveryDelay = 5 * time.Second
+
+	// shutdownTimeout is how long the final flush may run after shutdown starts
+	shutdownTimeout = 5 * time.Second
+)
+
+// source is the durable consumer the forwarder's entries come from
+type source struct {
+	client    *natsclient.NatsClient
+	consumer  string
+	subjects  []string
+	batchSize int
+
+	// pushFallback, when set, delivers through a push consumer of the same name when the
+	// pull consumer can't be set up, e.g. because the durable was provisioned as push
+	pushFallback *natsclient.PushConfig
+}
+
+// start feeds each queue from its own fetch worker on the pull consumer, or falls back to
+// push delivery into the first queue alone. It returns the queues being fed, each of which
+// is closed once delivery has stopped after shutdown.
+func (s source) start(queues []chan received, shutdown <-chan struct{}) ([]chan received, error) {
+	consumer, err := s.client.SubscribePull(s.consumer, s.subjects)
+	if err == nil {
+		logger.WithFields(logrus.Fields{"consumer": s.consumer, "workers": len(queues)}).Info("Pull subscription created, waiting for logs")
+
+		// JetStream spreads messages across the workers' fetches from the shared consumer
+		for _, entries := range queues {
+			go fetchLogs(consumer, s.batchSize, entries, shutdown)
+		}
+		return queues, nil
+	}
+	if s.pushFallback == nil {
+		return nil, fmt.Errorf("failed to create pull subscription: %w", err)
+	}
+
+	// Fall back to push delivery from the same durable, so no second consumer competes
+	// for the work queue's messages
+	logger.WithError(err).Warn("Failed to create pull subscription, falling back to push consumer")
+
+	// Push delivery has a single handler, so it feeds one worker
+	if len(queues) > 1 {
+		logger.WithField("workers", len(queues)).Warn("Push consumer runs a single worker")
+		queues = queues[:1]
+	}
+	entries := queues[0]
+	cc, err := s.client.CreatePushConsumer(s.consumer, s.subjects, *s.pushFallback, func(msg jetstream.Msg) {
+		handleMsg(msg, entries)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create push subscription: %w", err)
+	}
+
+	logger.WithField("consumer", s.consumer).Info("Push subscription created, waiting for logs")
+	go drainPush(cc, entries, shutdown)
+	return queues, nil
+}
+
+// fetchLogs pulls messages from NATS and hands decoded entries to the batcher until shutdown
+func fetchLogs(consumer jetstream.Consumer, batchSize int, entries chan<- received, shutdown <-chan struct{}) {
+	defer close(entries)
+
+	for {
+		select {
+		case <-shutdown:
+			return
+		default:
+		}
+
+		// Try to fetch messages
+		batch, err := consumer.Fetch(batchSize, jetstream.FetchMaxWait(500*time.Millisecond))
+		if err != nil {
+			logger.WithError(err).Error("Error fetching messages")
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		// Process received messages
+		for msg := range batch.Messages() {
+			handleMsg(msg, entries)
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, jetstream.ErrNoMessages) {
+			logger.WithError(err).Error("Error fetching messages")
+		}
+	}
+}
+
+// drainPush waits for shutdown, then drains the push consumer before closing entries
+// so no handler is still sending when the batcher stops
+func drainPush(cc jetstream.ConsumeContext, entries chan<- received, shutdown <-chan struct{}) {
+	<-shutdown
+
+	cc.Drain()
+	<-cc.Closed()
+
+	close(entries)
+}
+
+// handleMsg decodes a message and hands the entry to the batcher, which acknowledges it
+// once the sink has it
+func handleMsg(msg jetstream.Msg, entries chan<- received) {
+	messagesProcessed.Inc()
+
+	logEntry, err := middleware.DecodeLogEntry(msg.Data())
+	if err != nil {
+		logger.WithError(err).WithField("subject", msg.Subject()).Warn("Error unmarshaling log entry")
+		msg.Term() // Redelivery can't fix a malformed message
+		return
+	}
+
+	// Hand off to the batcher
+	entries <- received{entry: logEntry, msg: msg, arrived: time.Now()}
+}
+
+// start runs a batcher for each queue, returning a WaitGroup that is done once every
+// batcher has flushed its final partial batch after its queue was closed
+func (f *forwarder) start(ctx context.Context, queues []chan received) *sync.WaitGroup {
+	var batcher sync.WaitGroup
+	for _, entries := range queues {
+		batcher.Add(1)
+		go func() {
+			defer batcher.Done()
+			f.batchLogs(ctx, entries)
+		}()
+	}
+	return &batcher
 }
 
-// processBatch sends a batch of logs to Loki
-func processBatch(batch []middleware.LogEntry, lokiClient *loki.Client) {
+// batchLogs owns the pending entries and flushes a batch when a full one is waiting or
+// the oldest pending entry has waited batchTimeout, so each entry is sent to Loki exactly
+// once and none waits much longer than batchTimeout however entries trickle in. Entries
+// already queued are read ahead, up to two batches, so priority can pick among them.
+func (f *forwarder) batchLogs(ctx context.Context, entries <-chan received) {
+	pending := &priorityBuffer{enabled: f.priority, maxWait: f.priorityMaxWait}
+	bufferSize := 2 * f.batchSize
+	batch := make([]received, 0, f.batchSize)
+
+	// The timer only runs while entries are pending
+	timer := time.NewTimer(f.batchTimeout)
+	timer.Stop()
+	defer timer.Stop()
+
+	// schedule sets the timer for the oldest pending entry's deadline
+	schedule := func() {
+		timer.Stop()
+		if oldest, ok := pending.oldest(); ok {
+			timer.Reset(time.Until(oldest.Add(f.batchTimeout)))
+		}
+	}
+
+	flush := func() {
+		batch = pending.take(batch[:0], f.batchSize, time.Now())
+		if len(batch) > 0 {
+			f.processBatch(ctx, batch)
+		}
+	}
+
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				// Fetch loop stopped; stop the timer first so the final flushes are the
+				// last, then process any remaining logs before exiting
+				timer.Stop()
+				for pending.len() > 0 {
+					flush()
+				}
+				return
+			}
+
+			pending.push(entry)
+		readAhead:
+			for pending.len() < bufferSize {
+				select {
+				case entry, ok := <-entries:
+					if !ok {
+						break readAhead
+					}
+					pending.push(entry)
+				default:
+					break readAhead
+				}
+			}
+
+			// Process batches while a full one is waiting
+			for pending.len() >= f.batchSize {
+				flush()
+			}
+			schedule()
+		case <-timer.C:
+			// Process the batch when the oldest entry is due
+			flush()
+			schedule()
+		}
+	}
+}
+
+// processBatch sends a batch of logs to the sink and samples it for read-back verification
+func (f *forwarder) processBatch(ctx context.Context, batch []received) {
 	if len(batch) == 0 {
 		return
 	}
 
-	log.Printf("Processing batch of %d logs", len(batch))
+	// Acknowledge dropped synthetic entries without sending them
+	if f.dropSynthetic {
+		kept := batch[:0]
+		for _, r := range batch {
+			if r.entry.Synthetic {
+				r.msg.Ack()
+				syntheticDropped.Inc()
+				continue
+			}
+			kept = append(kept, r)
+		}
+		if batch = kept; len(batch) == 0 {
+			return
+		}
+	}
 
-	// Send batch to Loki
-	err := lokiClient.SendBatchLogs(batch)
-	if err != nil {
-		log.Printf("Error sending logs to Loki: %v", err)
-
-		// If batch send fails, try sending logs individually
-		log.Println("Attempting to send logs individually")
-		for _, entry := range batch {
-			err := lokiClient.SendLog(entry)
-			if err != nil {
-				log.Printf("Error sending log to Loki: %v", err)
+	// Send repeats of the same failure once
+	if f.coalesce {
+		batch = coalesceErrors(batch)
+	}
+
+	// Send entries merged from several services in the order they happened
+	if f.eventOrder {
+		slices.SortStableFunc(batch, func(a, b received) int {
+			return a.entry.Timestamp.Compare(b.entry.Timestamp)
+		})
+	}
+
+	// Give up on the sends once NATS would redeliver the batch anyway
+	if f.ackWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, oldestArrival(batch).Add(f.ackWait))
+		defer cancel()
+	}
+
+	logger.WithField("batch_size", len(batch)).Debug("Processing batch of logs")
+
+	logEntries := make([]middleware.LogEntry, len(batch))
+	for i, r := range batch {
+		logEntries[i] = r.entry
+		if f.serviceFromSubject {
+			logEntries[i].SubjectService = subjectService(r.msg.Subject())
+		}
+		f.geo.Enrich(&logEntries[i])
+	}
+
+	// Send batch to the sink
+	pushCtx, span := startPushSpan(ctx, f.name, batch)
+	pushStart := time.Now()
+	err := f.sink.SendBatchLogsContext(pushCtx, logEntries)
+	pushDuration.Observe(time.Since(pushStart).Seconds())
+	batchSizes.Observe(float64(len(batch)))
+	endPushSpan(span, err)
+
+	// The sink took part of the batch: acknowledge that part and retry only the rest
+	var partial *sink.PartialError
+	if errors.As(err, &partial) {
+		failed := make([]bool, len(batch))
+		for _, group := range partial.Failed {
+			for _, i := range group.Indexes {
+				failed[i] = true
+			}
+		}
+
+		var delivered []middleware.LogEntry
+		for i, r := range batch {
+			if !failed[i] {
+				r.msg.Ack()
+				delivered = append(delivered, logEntries[i])
+			}
+		}
+		if len(delivered) > 0 {
+			batchesSent.Inc()
+			f.lastPush.Store(time.Now().UnixNano())
+			logger.WithField("batch_size", len(delivered)).Info("Successfully sent part of the logs")
+			f.verifier.Sample(delivered)
+		}
+
+		for _, group := range partial.Failed {
+			groupBatch := make([]received, len(group.Indexes))
+			groupEntries := make([]middleware.LogEntry, len(group.Indexes))
+			for j, i := range group.Indexes {
+				groupBatch[j], groupEntries[j] = batch[i], logEntries[i]
 			}
+			f.sendFailed(ctx, groupBatch, groupEntries, group.Err)
 		}
 		return
 	}
 
-	log.Printf("Successfully sent %d logs to Loki", len(batch))
+	if err != nil {
+		f.sendFailed(ctx, batch, logEntries, err)
+		return
+	}
+
+	// Only acknowledge once the sink has the entries, so a failure means redelivery rather than loss
+	for _, r := range batch {
+		r.msg.Ack()
+	}
+
+	batchesSent.Inc()
+	f.lastPush.Store(time.Now().UnixNano())
+	logger.WithField("batch_size", len(batch)).Info("Successfully sent logs")
+	f.verifier.Sample(logEntries)
+}
+
+// sendFailed settles entries the sink failed to take with err: redelivered when the
+// send was interrupted or may succeed later, otherwise resent one by one so only the
+// entries the sink rejects go to the dead letter stream
+func (f *forwarder) sendFailed(ctx context.Context, batch []received, logEntries []middleware.LogEntry, err error) {
+	pushErrors.WithLabelValues(pushErrorReason(err)).Inc()
+
+	if errors.Is(err, context.Canceled) {
+		logger.WithField("batch_size", len(batch)).Warn("Log push interrupted by shutdown; messages will be redelivered")
+		for _, r := range batch {
+			r.msg.Nak()
+		}
+		return
+	}
+
+	logger.WithError(err).WithField("batch_size", len(batch)).Error("Error sending logs")
+
+	// Retries are exhausted; resending entries one by one won't help, so let NATS redeliver
+	if sink.IsRetryable(err) {
+		for _, r := range batch {
+			r.msg.NakWithDelay(f.redeliveryDelay)
+		}
+		return
+	}
+
+	// The sink rejected the batch, so send logs individually to isolate the bad entries
+	logger.Info("Attempting to send logs individually")
+	for i, r := range batch {
+		err := f.sink.SendLogContext(ctx, logEntries[i])
+		switch {
+		case err == nil:
+			r.msg.Ack()
+		case sink.IsRetryable(err):
+			logger.WithError(err).WithField("trace_id", r.entry.TraceID).Error("Error sending log")
+			r.msg.NakWithDelay(f.redeliveryDelay)
+		default:
+			logger.WithError(err).WithField("trace_id", r.entry.TraceID).Error("Error sending log")
+			f.sendToDeadLetter(r, err)
+		}
+	}
+}
+
+// oldestArrival returns when the longest-waiting entry of a non-empty batch was received
+func oldestArrival(batch []received) time.Time {
+	oldest := batch[0].arrived
+	for _, r := range batch[1:] {
+		if r.arrived.Before(oldest) {
+			oldest = r.arrived
+		}
+	}
+	return oldest
+}
+
+// sendToDeadLetter republishes an entry the sink rejected, with the rejection as the reason,
+// and terminates the message when there is no dead-letter subject
+func (f *forwarder) sendToDeadLetter(r received, rejection error) {
+	if f.deadLetter == nil {
+		r.msg.Term()
+		return
+	}
+
+	if err := f.deadLetter(r.msg.Data(), rejection.Error()); err != nil {
+		logger.WithError(err).WithField("trace_id", r.entry.TraceID).Error("Error publishing log to dead-letter subject")
+		r.msg.NakWithDelay(f.redeliveryDelay)
+		return
+	}
+	r.msg.Ack()
+	logger.WithField("trace_id", r.entry.TraceID).Warn("Log sent to dead-letter subject")
+}
+
+// subjectService returns the service token of a logs.<service> subject, or "" when the
+// subject has no second token
+func subjectService(subject string) string {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) < 2 {
+		return ""
+	}
+	return tokens[1]
 }
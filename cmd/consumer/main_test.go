@@ -0,0 +1,1089 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"logtrace/internal/loki"
+	"logtrace/internal/middleware"
+	natsclient "logtrace/internal/nats"
+	"logtrace/internal/natstest"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestMain(m *testing.M) {
+	logger.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// fakeMsg is a message that records how it was settled
+type fakeMsg struct {
+	jetstream.Msg
+	subject string
+	data    []byte
+
+	mu      sync.Mutex
+	settled []string // "ack", "nak", "nak_delay" or "term", in order
+}
+
+func (m *fakeMsg) Subject() string { return m.subject }
+func (m *fakeMsg) Data() []byte    { return m.data }
+
+func (m *fakeMsg) settle(how string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settled = append(m.settled, how)
+	return nil
+}
+
+func (m *fakeMsg) Ack() error                       { return m.settle("ack") }
+func (m *fakeMsg) Nak() error                       { return m.settle("nak") }
+func (m *fakeMsg) NakWithDelay(time.Duration) error { return m.settle("nak_delay") }
+func (m *fakeMsg) Term() error                      { return m.settle("term") }
+
+// outcome returns how the message was settled, or "" if it wasn't
+func (m *fakeMsg) outcome(t *testing.T) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch len(m.settled) {
+	case 0:
+		return ""
+	case 1:
+		return m.settled[0]
+	}
+	t.Errorf("message %s settled %v", m.data, m.settled)
+	return m.settled[len(m.settled)-1]
+}
+
+// receivedEntry wraps an entry in a fakeMsg as the consumer would receive it
+func receivedEntry(entry middleware.LogEntry) (received, *fakeMsg) {
+	msg := &fakeMsg{subject: "logs." + entry.ServiceName, data: []byte(entry.TraceID)}
+	return received{entry: entry, msg: msg, arrived: time.Now()}, msg
+}
+
+func TestProcessBatchRetriesOnlyFailedTenant(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Scope-OrgID") == "acme" {
+			http.Error(w, "ingester unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := loki.NewClient(srv.URL)
+	client.TenantField = "tenant"
+	f := &forwarder{name: "loki", sink: client}
+
+	var batch []received
+	msgs := make(map[string]*fakeMsg)
+	for _, e := range []struct{ traceID, tenant string }{
+		{"a1", "acme"}, {"g1", "globex"}, {"a2", "acme"}, {"g2", "globex"},
+	} {
+		r, msg := receivedEntry(middleware.LogEntry{TraceID: e.traceID, Tenant: e.tenant, ServiceName: "api"})
+		batch = append(batch, r)
+		msgs[e.traceID] = msg
+	}
+
+	f.processBatch(context.Background(), batch)
+
+	want := map[string]string{"a1": "nak_delay", "a2": "nak_delay", "g1": "ack", "g2": "ack"}
+	for traceID, msg := range msgs {
+		if got := msg.outcome(t); got != want[traceID] {
+			t.Errorf("%s settled with %q, want %q", traceID, got, want[traceID])
+		}
+	}
+	if f.lastPush.Load() == 0 {
+		t.Errorf("last push not recorded for the delivered tenant")
+	}
+}
+
+// countingSink counts the entries it receives by trace ID. fail, when set, decides the
+// error of each batch send.
+type countingSink struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	entries []middleware.LogEntry
+	sends   int
+	fail    func(send int, entries []middleware.LogEntry) error
+	// batches records each batch the sink took
+	batches []sentBatch
+}
+
+// sentBatch is a batch a countingSink took, and when
+type sentBatch struct {
+	size int
+	at   time.Time
+}
+
+func newCountingSink() *countingSink {
+	return &countingSink{counts: make(map[string]int)}
+}
+
+func (s *countingSink) SendBatchLogsContext(ctx context.Context, entries []middleware.LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sends++
+	if s.fail != nil {
+		if err := s.fail(s.sends, entries); err != nil {
+			return err
+		}
+	}
+	for _, entry := range entries {
+		s.counts[entry.TraceID]++
+	}
+	s.entries = append(s.entries, entries...)
+	s.batches = append(s.batches, sentBatch{size: len(entries), at: time.Now()})
+	return nil
+}
+
+// sent returns the batches the sink took so far
+func (s *countingSink) sent() []sentBatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.batches)
+}
+
+func (s *countingSink) SendLogContext(ctx context.Context, entry middleware.LogEntry) error {
+	return s.SendBatchLogsContext(ctx, []middleware.LogEntry{entry})
+}
+
+// total returns how many entries the sink took
+func (s *countingSink) total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, count := range s.counts {
+		n += count
+	}
+	return n
+}
+
+// waitFor polls cond until it holds, failing the test after timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestClient connects to a fresh embedded server with the consumer's work-queue stream
+func newTestClient(t *testing.T, configure ...func(cfg *natsclient.Config)) *natsclient.NatsClient {
+	t.Helper()
+	s := natstest.RunServer(t)
+
+	cfg := natsclient.Config{
+		URL:             s.ClientURL(),
+		ReconnectWait:   100 * time.Millisecond,
+		ConnectionName:  t.Name(),
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>"},
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     jetstream.MemoryStorage,
+		Replicas:        1,
+		AckWait:         30 * time.Second,
+	}
+	for _, c := range configure {
+		c(&cfg)
+	}
+
+	client, err := natsclient.NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+// publishEntries publishes n entries with trace IDs <prefix>-0 to <prefix>-(n-1),
+// spread over a few services' subjects
+func publishEntries(t *testing.T, client *natsclient.NatsClient, prefix string, n int) {
+	t.Helper()
+	for i := range n {
+		entry := middleware.LogEntry{
+			Timestamp:   time.Now(),
+			TraceID:     fmt.Sprintf("%s-%d", prefix, i),
+			ServiceName: fmt.Sprintf("svc%d", i%3),
+			Status:      200,
+		}
+		data, err := middleware.EncodeLogEntry(entry, middleware.FormatJSON)
+		if err != nil {
+			t.Fatalf("EncodeLogEntry: %v", err)
+		}
+		if _, err := client.Publish("logs."+entry.ServiceName, data); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+func TestConcurrentFetchSendsEachEntryOnce(t *testing.T) {
+	const (
+		workers = 8
+		total   = 3000
+	)
+	client := newTestClient(t)
+	consumer, err := client.SubscribePull("log-consumer", []string{"logs.>"})
+	if err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+
+	counter := newCountingSink()
+	f := &forwarder{name: "test", sink: counter, batchSize: 50, batchTimeout: 20 * time.Millisecond}
+
+	shutdown := make(chan struct{})
+	queues := make([]chan received, workers)
+	for i := range queues {
+		queues[i] = make(chan received, f.batchSize)
+		go fetchLogs(consumer, f.batchSize, queues[i], shutdown)
+	}
+	batcher := f.start(context.Background(), queues)
+
+	// Publish while the workers are already fetching
+	publishEntries(t, client, "stress", total)
+
+	waitFor(t, 20*time.Second, "every entry to reach the sink", func() bool { return counter.total() >= total })
+	close(shutdown)
+	batcher.Wait()
+
+	for i := range total {
+		traceID := fmt.Sprintf("stress-%d", i)
+		if n := counter.counts[traceID]; n != 1 {
+			t.Errorf("%s sent %d times", traceID, n)
+		}
+	}
+	if len(counter.counts) != total {
+		t.Errorf("sink received %d distinct entries, want %d", len(counter.counts), total)
+	}
+
+	// Every message was acknowledged, so none is left to redeliver
+	waitFor(t, 5*time.Second, "the acks to settle", func() bool {
+		pending, ackPending, _, err := client.ConsumerPending("logs", "log-consumer")
+		return err == nil && pending == 0 && ackPending == 0
+	})
+}
+
+// provisionPushDurable creates the durable as a push consumer, so pull setup fails
+func provisionPushDurable(t *testing.T, client *natsclient.NatsClient, name string) {
+	t.Helper()
+	js, err := client.Conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.AddConsumer("logs", &nats.ConsumerConfig{
+		Durable:        name,
+		DeliverSubject: nats.NewInbox(),
+		AckPolicy:      nats.AckExplicitPolicy,
+		FilterSubject:  "logs.>",
+		FlowControl:    true,
+		Heartbeat:      time.Second,
+	}); err != nil {
+		t.Fatalf("AddConsumer: %v", err)
+	}
+}
+
+func TestPushFallbackDeliversToBatcher(t *testing.T) {
+	client := newTestClient(t)
+	provisionPushDurable(t, client, "log-consumer")
+
+	counter := newCountingSink()
+	f := &forwarder{name: "test", sink: counter, batchSize: 10, batchTimeout: 20 * time.Millisecond}
+	src := source{
+		client:       client,
+		consumer:     "log-consumer",
+		subjects:     []string{"logs.>"},
+		batchSize:    f.batchSize,
+		pushFallback: &natsclient.PushConfig{FlowControl: true, Heartbeat: time.Second},
+	}
+
+	shutdown := make(chan struct{})
+	queues := make([]chan received, 4)
+	for i := range queues {
+		queues[i] = make(chan received, f.batchSize)
+	}
+	queues, err := src.start(queues, shutdown)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if len(queues) != 1 {
+		t.Errorf("push fallback feeds %d queues, want 1", len(queues))
+	}
+	batcher := f.start(context.Background(), queues)
+
+	publishEntries(t, client, "push", 25)
+	waitFor(t, 10*time.Second, "the pushed entries to reach the sink", func() bool { return counter.total() >= 25 })
+	close(shutdown)
+	batcher.Wait()
+
+	for i := range 25 {
+		if n := counter.counts[fmt.Sprintf("push-%d", i)]; n != 1 {
+			t.Errorf("push-%d sent %d times", i, n)
+		}
+	}
+	waitFor(t, 5*time.Second, "the acks to settle", func() bool {
+		pending, ackPending, _, err := client.ConsumerPending("logs", "log-consumer")
+		return err == nil && pending == 0 && ackPending == 0
+	})
+}
+
+func TestPullFailureWithoutFallback(t *testing.T) {
+	client := newTestClient(t)
+	provisionPushDurable(t, client, "log-consumer")
+
+	src := source{client: client, consumer: "log-consumer", subjects: []string{"logs.>"}, batchSize: 10}
+	if _, err := src.start([]chan received{make(chan received)}, make(chan struct{})); err == nil {
+		t.Error("start succeeded without a pull consumer or fallback")
+	}
+}
+
+// redeliveryAckWait is the ack wait of the redelivery tests: short enough that a message
+// left unacknowledged would be redelivered while the test watches
+const redeliveryAckWait = 300 * time.Millisecond
+
+// runForwarder feeds f from a fresh "log-consumer" pull consumer until the test ends
+func runForwarder(t *testing.T, client *natsclient.NatsClient, f *forwarder) {
+	t.Helper()
+	consumer, err := client.SubscribePull("log-consumer", []string{"logs.>"})
+	if err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+
+	shutdown := make(chan struct{})
+	queue := make(chan received, f.batchSize)
+	go fetchLogs(consumer, f.batchSize, queue, shutdown)
+	batcher := f.start(context.Background(), []chan received{queue})
+	t.Cleanup(func() {
+		close(shutdown)
+		batcher.Wait()
+	})
+}
+
+// waitSettled waits until no message is pending or awaiting an ack, then watches past the
+// ack wait to make sure none is redelivered
+func waitSettled(t *testing.T, client *natsclient.NatsClient, sink *countingSink) {
+	t.Helper()
+	waitFor(t, 5*time.Second, "every message to be settled", func() bool {
+		pending, ackPending, _, err := client.ConsumerPending("logs", "log-consumer")
+		return err == nil && pending == 0 && ackPending == 0
+	})
+
+	sink.mu.Lock()
+	sends := sink.sends
+	sink.mu.Unlock()
+	time.Sleep(3 * redeliveryAckWait)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.sends != sends {
+		t.Errorf("sink received %d more sends after every message was settled", sink.sends-sends)
+	}
+
+	info, err := client.JS.Stream(context.Background(), "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs := info.CachedInfo().State.Msgs; msgs != 0 {
+		t.Errorf("%d messages left in the work queue", msgs)
+	}
+}
+
+func TestFailedSendIsRedeliveredThenAckedOnce(t *testing.T) {
+	client := newTestClient(t, func(cfg *natsclient.Config) { cfg.AckWait = redeliveryAckWait })
+
+	// Fail any send holding an entry sent for the first time; counter.mu is held while
+	// fail runs
+	failed := make(map[string]int)
+	counter := newCountingSink()
+	counter.fail = func(_ int, entries []middleware.LogEntry) error {
+		retry := false
+		for _, entry := range entries {
+			if failed[entry.TraceID] == 0 {
+				failed[entry.TraceID]++
+				retry = true
+			}
+		}
+		if retry {
+			return &loki.PushError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	}
+	f := &forwarder{
+		name:            "test",
+		sink:            counter,
+		batchSize:       10,
+		batchTimeout:    50 * time.Millisecond,
+		redeliveryDelay: 50 * time.Millisecond,
+	}
+	runForwarder(t, client, f)
+
+	publishEntries(t, client, "redeliver", 5)
+	waitFor(t, 5*time.Second, "the redelivered entries to reach the sink", func() bool { return counter.total() >= 5 })
+	waitSettled(t, client, counter)
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	for i := range 5 {
+		traceID := fmt.Sprintf("redeliver-%d", i)
+		if failed[traceID] != 1 || counter.counts[traceID] != 1 {
+			t.Errorf("%s failed %d times and was then taken %d times, want once each", traceID, failed[traceID], counter.counts[traceID])
+		}
+	}
+}
+
+func TestPermanentFailureIsNotRedelivered(t *testing.T) {
+	rejection := &loki.PushError{StatusCode: http.StatusBadRequest, Body: "entry out of order"}
+	rejected := func(int, []middleware.LogEntry) error { return rejection }
+
+	t.Run("term", func(t *testing.T) {
+		client := newTestClient(t, func(cfg *natsclient.Config) { cfg.AckWait = redeliveryAckWait })
+		counter := newCountingSink()
+		counter.fail = rejected
+		runForwarder(t, client, &forwarder{name: "test", sink: counter, batchSize: 10, batchTimeout: 50 * time.Millisecond})
+
+		publishEntries(t, client, "rejected", 3)
+		waitSettled(t, client, counter)
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		client := newTestClient(t, func(cfg *natsclient.Config) { cfg.AckWait = redeliveryAckWait })
+		if err := client.SetupDLQStream("logs-dlq", "dlq.logs", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		counter := newCountingSink()
+		counter.fail = rejected
+		runForwarder(t, client, &forwarder{
+			name:         "test",
+			sink:         counter,
+			batchSize:    10,
+			batchTimeout: 50 * time.Millisecond,
+			deadLetter: func(data []byte, reason string) error {
+				return client.PublishDLQ("dlq.logs", data, reason)
+			},
+		})
+
+		publishEntries(t, client, "rejected", 3)
+		waitSettled(t, client, counter)
+
+		dlq, err := client.JS.Stream(context.Background(), "logs-dlq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgs := dlq.CachedInfo().State.Msgs; msgs != 3 {
+			t.Fatalf("dead-letter stream holds %d messages, want 3", msgs)
+		}
+		var traceIDs []string
+		for seq := uint64(1); seq <= 3; seq++ {
+			msg, err := dlq.GetMsg(context.Background(), seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reason := msg.Header.Get(natsclient.DLQReasonHeader); reason != rejection.Error() {
+				t.Errorf("dead letter %d has reason %q, want %q", seq, reason, rejection.Error())
+			}
+			entry, err := middleware.DecodeLogEntry(msg.Data)
+			if err != nil {
+				t.Fatalf("dead letter %d doesn't decode: %v", seq, err)
+			}
+			traceIDs = append(traceIDs, entry.TraceID)
+		}
+		slices.Sort(traceIDs)
+		if want := []string{"rejected-0", "rejected-1", "rejected-2"}; !slices.Equal(traceIDs, want) {
+			t.Errorf("dead letters %v, want %v", traceIDs, want)
+		}
+	})
+}
+
+func TestShutdownFlushesPartialBatchOnce(t *testing.T) {
+	counter := newCountingSink()
+	f := &forwarder{name: "test", sink: counter, batchSize: 10, batchTimeout: time.Hour}
+
+	queue := make(chan received, f.batchSize)
+	batcher := f.start(context.Background(), []chan received{queue})
+	var msgs []*fakeMsg
+	for i := range 3 {
+		r, msg := receivedEntry(middleware.LogEntry{TraceID: fmt.Sprintf("partial-%d", i), Status: 200})
+		queue <- r
+		msgs = append(msgs, msg)
+	}
+
+	// The fetch loop stopping closes the queue; the batcher returns once the partial batch is sent
+	close(queue)
+	batcher.Wait()
+
+	if counter.sends != 1 || counter.total() != 3 {
+		t.Errorf("sink received %d entries in %d sends, want 3 in one", counter.total(), counter.sends)
+	}
+	for i, msg := range msgs {
+		if got := msg.outcome(t); got != "ack" {
+			t.Errorf("message %d settled with %q, want ack", i, got)
+		}
+	}
+}
+
+func TestBatchTimerFiringAtShutdownSendsOnce(t *testing.T) {
+	const batchTimeout = time.Millisecond
+	for i := range 200 {
+		counter := newCountingSink()
+		f := &forwarder{name: "test", sink: counter, batchSize: 10, batchTimeout: batchTimeout}
+
+		queue := make(chan received, f.batchSize)
+		batcher := f.start(context.Background(), []chan received{queue})
+		var msgs []*fakeMsg
+		for j := range 3 {
+			r, msg := receivedEntry(middleware.LogEntry{TraceID: fmt.Sprintf("race-%d", j), Status: 200})
+			queue <- r
+			msgs = append(msgs, msg)
+		}
+
+		// Close the queue around the moment the batch timer fires, before, at or after it
+		time.Sleep(time.Duration(i%5) * batchTimeout / 2)
+		close(queue)
+		batcher.Wait()
+
+		for traceID, n := range counter.counts {
+			if n != 1 {
+				t.Fatalf("run %d: %s sent %d times", i, traceID, n)
+			}
+		}
+		if counter.total() != 3 {
+			t.Fatalf("run %d: sink received %d of 3 entries", i, counter.total())
+		}
+		for j, msg := range msgs {
+			if got := msg.outcome(t); got != "ack" {
+				t.Fatalf("run %d: message %d settled with %q, want ack", i, j, got)
+			}
+		}
+	}
+}
+
+func TestSendFailedSettlesByCause(t *testing.T) {
+	rejection := &loki.PushError{StatusCode: http.StatusBadRequest, Body: "entry out of order"}
+	tests := []struct {
+		name string
+		err  error
+		// want is how each of the entries ok, bad and late is settled; the sink rejects
+		// bad and can't take late when they are resent one by one
+		want map[string]string
+		// resends is how many entries are resent one by one
+		resends int
+	}{
+		{
+			name: "canceled",
+			err:  context.Canceled,
+			want: map[string]string{"ok": "nak", "bad": "nak", "late": "nak"},
+		},
+		{
+			name: "canceled inside a push error",
+			err:  &loki.PushError{Err: fmt.Errorf("post: %w", context.Canceled)},
+			want: map[string]string{"ok": "nak", "bad": "nak", "late": "nak"},
+		},
+		{
+			name: "retryable",
+			err:  &loki.PushError{StatusCode: http.StatusServiceUnavailable},
+			want: map[string]string{"ok": "nak_delay", "bad": "nak_delay", "late": "nak_delay"},
+		},
+		{
+			name:    "rejected",
+			err:     rejection,
+			want:    map[string]string{"ok": "ack", "bad": "ack", "late": "nak_delay"},
+			resends: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := newCountingSink()
+			counter.fail = func(_ int, entries []middleware.LogEntry) error {
+				switch entries[0].TraceID {
+				case "bad":
+					return rejection
+				case "late":
+					return &loki.PushError{StatusCode: http.StatusTooManyRequests}
+				}
+				return nil
+			}
+			deadLetters := make(map[string]string)
+			f := &forwarder{
+				name: "test",
+				sink: counter,
+				deadLetter: func(data []byte, reason string) error {
+					deadLetters[string(data)] = reason
+					return nil
+				},
+			}
+
+			var batch []received
+			var entries []middleware.LogEntry
+			msgs := make(map[string]*fakeMsg)
+			for _, traceID := range []string{"ok", "bad", "late"} {
+				r, msg := receivedEntry(middleware.LogEntry{TraceID: traceID, ServiceName: "api"})
+				batch = append(batch, r)
+				entries = append(entries, r.entry)
+				msgs[traceID] = msg
+			}
+
+			f.sendFailed(context.Background(), batch, entries, tt.err)
+
+			for traceID, msg := range msgs {
+				if got := msg.outcome(t); got != tt.want[traceID] {
+					t.Errorf("%s settled with %q, want %q", traceID, got, tt.want[traceID])
+				}
+			}
+			if counter.sends != tt.resends {
+				t.Errorf("resent %d entries, want %d", counter.sends, tt.resends)
+			}
+
+			// Only the entry the sink rejected goes to the dead letter subject, with the rejection
+			wantDeadLetters := map[string]string{}
+			if tt.resends > 0 {
+				wantDeadLetters["bad"] = rejection.Error()
+			}
+			if !maps.Equal(deadLetters, wantDeadLetters) {
+				t.Errorf("dead letters %q, want %q", deadLetters, wantDeadLetters)
+			}
+		})
+	}
+}
+
+func TestDeadLetterPublishFailureIsRedelivered(t *testing.T) {
+	f := &forwarder{
+		name:       "test",
+		sink:       newCountingSink(),
+		deadLetter: func([]byte, string) error { return fmt.Errorf("nats: timeout") },
+	}
+	r, msg := receivedEntry(middleware.LogEntry{TraceID: "bad"})
+
+	f.sendToDeadLetter(r, &loki.PushError{StatusCode: http.StatusBadRequest})
+
+	if got := msg.outcome(t); got != "nak_delay" {
+		t.Errorf("settled with %q, want nak_delay so the entry isn't lost", got)
+	}
+}
+
+// hungSink holds every send until its context is done, as a hung Loki push would
+type hungSink struct {
+	started chan struct{}
+}
+
+func (s *hungSink) SendBatchLogsContext(ctx context.Context, entries []middleware.LogEntry) error {
+	s.started <- struct{}{}
+	<-ctx.Done()
+	return &loki.PushError{Err: ctx.Err()}
+}
+
+func (s *hungSink) SendLogContext(ctx context.Context, entry middleware.LogEntry) error {
+	return s.SendBatchLogsContext(ctx, []middleware.LogEntry{entry})
+}
+
+func TestCancelDuringSendNaksPromptly(t *testing.T) {
+	hung := &hungSink{started: make(chan struct{}, 1)}
+	f := &forwarder{name: "test", sink: hung}
+	var batch []received
+	var msgs []*fakeMsg
+	for i := range 3 {
+		r, msg := receivedEntry(middleware.LogEntry{TraceID: fmt.Sprintf("hung-%d", i)})
+		batch = append(batch, r)
+		msgs = append(msgs, msg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-hung.started
+		cancel()
+	}()
+
+	start := time.Now()
+	f.processBatch(ctx, batch)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("processBatch returned %s after the cancel", elapsed)
+	}
+	for i, msg := range msgs {
+		if got := msg.outcome(t); got != "nak" {
+			t.Errorf("message %d settled with %q, want nak for redelivery", i, got)
+		}
+	}
+	if f.lastPush.Load() != 0 {
+		t.Error("canceled send recorded as a push")
+	}
+}
+
+func TestAckWaitBoundsTheSend(t *testing.T) {
+	const ackWait = 300 * time.Millisecond
+	tests := []struct {
+		name string
+		// waited is how long the batch's oldest entry has gone unacknowledged
+		waited time.Duration
+		// want is roughly how long the send may run
+		want time.Duration
+	}{
+		{name: "fresh batch", waited: 0, want: ackWait},
+		{name: "oldest entry waited", waited: 200 * time.Millisecond, want: 100 * time.Millisecond},
+		{name: "already due", waited: time.Second, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hung := &hungSink{started: make(chan struct{}, 1)}
+			f := &forwarder{name: "test", sink: hung, ackWait: ackWait}
+			oldest, oldestMsg := receivedEntry(middleware.LogEntry{TraceID: "oldest"})
+			oldest.arrived = time.Now().Add(-tt.waited)
+			newest, newestMsg := receivedEntry(middleware.LogEntry{TraceID: "newest"})
+
+			start := time.Now()
+			f.processBatch(context.Background(), []received{newest, oldest})
+			elapsed := time.Since(start)
+
+			if elapsed < tt.want-20*time.Millisecond || elapsed > tt.want+200*time.Millisecond {
+				t.Errorf("send ran %s, want about %s", elapsed, tt.want)
+			}
+			// A send cut off by the deadline may succeed later, so NATS redelivers the batch
+			for _, msg := range []*fakeMsg{oldestMsg, newestMsg} {
+				if got := msg.outcome(t); got != "nak_delay" {
+					t.Errorf("%s settled with %q, want nak_delay", msg.data, got)
+				}
+			}
+		})
+	}
+
+	// Without an ack wait only the caller's context bounds the send
+	hung := &hungSink{started: make(chan struct{}, 1)}
+	f := &forwarder{name: "test", sink: hung}
+	r, _ := receivedEntry(middleware.LogEntry{TraceID: "unbounded"})
+	r.arrived = time.Now().Add(-time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	f.processBatch(ctx, []received{r})
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("send without an ack wait ended after %s, before the caller's timeout", elapsed)
+	}
+}
+
+// sizes returns the size of each batch
+func sizes(batches []sentBatch) []int {
+	var n []int
+	for _, b := range batches {
+		n = append(n, b.size)
+	}
+	return n
+}
+
+func TestBatchFlushesWhenFull(t *testing.T) {
+	counter := newCountingSink()
+	f := &forwarder{name: "test", sink: counter, batchSize: 5, batchTimeout: time.Hour}
+	queue := make(chan received, 20)
+	batcher := f.start(context.Background(), []chan received{queue})
+
+	for i := range 12 {
+		r, _ := receivedEntry(middleware.LogEntry{TraceID: fmt.Sprintf("full-%d", i)})
+		queue <- r
+	}
+	waitFor(t, 5*time.Second, "two full batches", func() bool { return len(counter.sent()) >= 2 })
+	time.Sleep(50 * time.Millisecond)
+	if got := sizes(counter.sent()); !slices.Equal(got, []int{5, 5}) {
+		t.Errorf("sent batches of %v before the timeout, want two of 5", got)
+	}
+
+	// The 2 left over wait for the timeout, or here the shutdown flush
+	close(queue)
+	batcher.Wait()
+	if got := sizes(counter.sent()); !slices.Equal(got, []int{5, 5, 2}) {
+		t.Errorf("sent batches of %v, want 5, 5 and 2", got)
+	}
+}
+
+func TestBatchFlushesOnTimeout(t *testing.T) {
+	const batchTimeout = 50 * time.Millisecond
+	counter := newCountingSink()
+	f := &forwarder{name: "test", sink: counter, batchSize: 100, batchTimeout: batchTimeout}
+	queue := make(chan received, 10)
+	batcher := f.start(context.Background(), []chan received{queue})
+	defer func() {
+		close(queue)
+		batcher.Wait()
+	}()
+
+	start := time.Now()
+	for i := range 3 {
+		r, _ := receivedEntry(middleware.LogEntry{TraceID: fmt.Sprintf("timeout-%d", i)})
+		queue <- r
+	}
+	waitFor(t, 5*time.Second, "the partial batch", func() bool { return len(counter.sent()) == 1 })
+
+	sent := counter.sent()[0]
+	if sent.size != 3 {
+		t.Errorf("sent a batch of %d, want all 3", sent.size)
+	}
+	if waited := sent.at.Sub(start); waited < batchTimeout || waited > batchTimeout+200*time.Millisecond {
+		t.Errorf("partial batch sent after %s, want after the %s timeout", waited, batchTimeout)
+	}
+}
+
+func TestBatchFlushesByOldestEntryAge(t *testing.T) {
+	const batchTimeout = 100 * time.Millisecond
+	counter := newCountingSink()
+	f := &forwarder{name: "test", sink: counter, batchSize: 100, batchTimeout: batchTimeout}
+	queue := make(chan received, 10)
+	batcher := f.start(context.Background(), []chan received{queue})
+
+	// A trickle faster than the timeout mustn't keep pushing the flush back
+	start := time.Now()
+	for i := range 12 {
+		r, _ := receivedEntry(middleware.LogEntry{TraceID: fmt.Sprintf("trickle-%d", i)})
+		queue <- r
+		time.Sleep(30 * time.Millisecond)
+	}
+	close(queue)
+	batcher.Wait()
+
+	sent := counter.sent()
+	if len(sent) < 3 {
+		t.Fatalf("sent %d batches over %s of trickle, want one about every %s", len(sent), time.Since(start), batchTimeout)
+	}
+	if waited := sent[0].at.Sub(start); waited > batchTimeout+100*time.Millisecond {
+		t.Errorf("first entry waited %s, want at most about %s", waited, batchTimeout)
+	}
+	if counter.total() != 12 {
+		t.Errorf("sink took %d of 12 entries", counter.total())
+	}
+}
+
+// barrierSink holds each send until want sends are in flight at once, recording the most
+// it saw, so a test can tell how many batchers send in parallel
+type barrierSink struct {
+	want int
+
+	mu       sync.Mutex
+	inFlight int
+	most     int
+	taken    int
+	arrived  chan struct{}
+}
+
+func (s *barrierSink) SendBatchLogsContext(ctx context.Context, entries []middleware.LogEntry) error {
+	s.mu.Lock()
+	s.inFlight++
+	s.most = max(s.most, s.inFlight)
+	if s.inFlight == s.want {
+		close(s.arrived)
+	}
+	arrived := s.arrived
+	s.mu.Unlock()
+
+	select {
+	case <-arrived:
+	case <-time.After(2 * time.Second):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.taken += len(entries)
+	return nil
+}
+
+func (s *barrierSink) SendLogContext(ctx context.Context, entry middleware.LogEntry) error {
+	return s.SendBatchLogsContext(ctx, []middleware.LogEntry{entry})
+}
+
+func TestWorkerCountIsHonored(t *testing.T) {
+	const workers = 3
+	client := newTestClient(t)
+	barrier := &barrierSink{want: workers, arrived: make(chan struct{})}
+	f := &forwarder{name: "test", sink: barrier, batchSize: 1, batchTimeout: time.Hour}
+	src := source{client: client, consumer: "log-consumer", subjects: []string{"logs.>"}, batchSize: f.batchSize}
+
+	shutdown := make(chan struct{})
+	queues := make([]chan received, workers)
+	for i := range queues {
+		queues[i] = make(chan received, f.batchSize)
+	}
+	queues, err := src.start(queues, shutdown)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	batcher := f.start(context.Background(), queues)
+
+	publishEntries(t, client, "worker", 4*workers)
+	select {
+	case <-barrier.arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("never had %d sends in flight at once", workers)
+	}
+	waitFor(t, 10*time.Second, "every entry to reach the sink", func() bool {
+		barrier.mu.Lock()
+		defer barrier.mu.Unlock()
+		return barrier.taken == 4*workers
+	})
+	close(shutdown)
+	batcher.Wait()
+
+	if len(queues) != workers || barrier.most != workers {
+		t.Errorf("%d workers sent up to %d batches at once, want %d", len(queues), barrier.most, workers)
+	}
+}
+
+func TestEventOrderSortsAcrossServices(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	arrivals := []struct {
+		traceID, service string
+		offset           time.Duration
+	}{
+		{"web-2", "web", 2 * time.Millisecond},
+		{"api-1", "api", time.Millisecond},
+		{"worker-3", "worker", 3 * time.Millisecond},
+		{"api-0", "api", 0},
+		{"web-1", "web", time.Millisecond},
+	}
+	tests := []struct {
+		name       string
+		eventOrder bool
+		want       []string
+	}{
+		{"arrival order", false, []string{"web-2", "api-1", "worker-3", "api-0", "web-1"}},
+		// Entries logged at the same time keep their arrival order
+		{"event order", true, []string{"api-0", "api-1", "web-1", "web-2", "worker-3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := newCountingSink()
+			f := &forwarder{name: "test", sink: counter, eventOrder: tt.eventOrder}
+			var batch []received
+			var msgs []*fakeMsg
+			for _, a := range arrivals {
+				r, msg := receivedEntry(middleware.LogEntry{TraceID: a.traceID, ServiceName: a.service, Timestamp: base.Add(a.offset)})
+				batch = append(batch, r)
+				msgs = append(msgs, msg)
+			}
+
+			f.processBatch(context.Background(), batch)
+
+			var sent []string
+			for _, entry := range counter.entries {
+				sent = append(sent, entry.TraceID)
+			}
+			if !slices.Equal(sent, tt.want) {
+				t.Errorf("sent %v, want %v", sent, tt.want)
+			}
+			for i, msg := range msgs {
+				if got := msg.outcome(t); got != "ack" {
+					t.Errorf("%s settled with %q, want ack", arrivals[i].traceID, got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMsgDecodesBothFormats(t *testing.T) {
+	entry := middleware.LogEntry{
+		Timestamp:   time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+		TraceID:     "4bf92f3577b34da6a3ce929d0e0e4736",
+		ServiceName: "orders",
+		Status:      201,
+	}
+	for _, format := range []middleware.LogFormat{middleware.FormatJSON, middleware.FormatCloudEvents} {
+		data, err := middleware.EncodeLogEntry(entry, format)
+		if err != nil {
+			t.Fatalf("EncodeLogEntry(%q): %v", format, err)
+		}
+		entries := make(chan received, 1)
+		msg := &fakeMsg{subject: "logs.orders", data: data}
+		handleMsg(msg, entries)
+
+		select {
+		case got := <-entries:
+			if !reflect.DeepEqual(got.entry, entry) {
+				t.Errorf("%s: consumed %+v, want %+v", format, got.entry, entry)
+			}
+		default:
+			t.Fatalf("%s: message %s was not handed to the batcher (settled %q)", format, data, msg.outcome(t))
+		}
+		// The batcher settles the message once the sink has the entry
+		if outcome := msg.outcome(t); outcome != "" {
+			t.Errorf("%s: message settled %q before reaching the sink", format, outcome)
+		}
+	}
+}
+
+func TestSubjectService(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"logs.orders", "orders"},
+		{"logs.orders.v2", "orders"},
+		{"logs", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := subjectService(tt.subject); got != tt.want {
+			t.Errorf("subjectService(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestServiceLabelFromSubject(t *testing.T) {
+	tests := []struct {
+		name               string
+		serviceFromSubject bool
+		want               string
+	}{
+		{"body service by default", false, "orders-v1"},
+		{"subject service when enabled", true, "orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var pushes []loki.PushRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var push loki.PushRequest
+				if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+					t.Errorf("decoding push: %v", err)
+				}
+				mu.Lock()
+				pushes = append(pushes, push)
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			f := &forwarder{name: "loki", sink: loki.NewClient(srv.URL), serviceFromSubject: tt.serviceFromSubject}
+			// The body names a stale service; the subject the entry was published on is authoritative
+			entry := middleware.LogEntry{TraceID: "t1", ServiceName: "orders-v1", Environment: "prod"}
+			msg := &fakeMsg{subject: "logs.orders", data: []byte(entry.TraceID)}
+			f.processBatch(context.Background(), []received{{entry: entry, msg: msg, arrived: time.Now()}})
+
+			if got := msg.outcome(t); got != "ack" {
+				t.Fatalf("message settled %q, want ack", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(pushes) != 1 || len(pushes[0].Streams) != 1 {
+				t.Fatalf("pushed %v, want one stream", pushes)
+			}
+			stream := pushes[0].Streams[0]
+			if got := stream.Stream["service"]; got != tt.want {
+				t.Errorf("service label = %q, want %q", got, tt.want)
+			}
+			// The line keeps the service the body named
+			var line middleware.LogEntry
+			if err := json.Unmarshal([]byte(stream.Values[0][1]), &line); err != nil || line.ServiceName != "orders-v1" {
+				t.Errorf("log line %s, want service_name orders-v1 (%v)", stream.Values[0][1], err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"logtrace/internal/loki"
+	"logtrace/internal/sink"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	batchesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "loki_batches_sent_total",
+		Help: "Batches the sink accepted.",
+	})
+
+	batchSizes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "loki_batch_size",
+		Help:    "Entries per batch pushed to the sink.",
+		Buckets: []float64{1, 5, 10, 25, 50, 75, 100},
+	})
+
+	pushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "loki_push_duration_seconds",
+		Help:    "Duration of batch pushes to the sink, including retries.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	pushErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "loki_push_errors_total",
+		Help: "Failed batch pushes by reason (canceled, timeout, circuit_open, retryable or rejected).",
+	}, []string{"reason"})
+
+	messagesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_messages_processed_total",
+		Help: "Messages received from NATS, including malformed ones.",
+	})
+
+	syntheticDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_synthetic_dropped_total",
+		Help: "Synthetic entries acknowledged without being sent, with CONSUMER_SYNTHETIC=drop.",
+	})
+
+	coalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_coalesced_total",
+		Help: "Error entries folded into an identical one's occurrences count, with CONSUMER_COALESCE_WINDOW.",
+	})
+
+	// Consumer lag as of the last check, every CONSUMER_LAG_INTERVAL
+	lagPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "consumer_pending_messages",
+		Help: "Messages in the stream not yet delivered to the consumer.",
+	})
+
+	lagAckPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "consumer_ack_pending_messages",
+		Help: "Messages delivered to the consumer and not yet acknowledged.",
+	})
+
+	lagRedelivered = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "consumer_redelivered_messages",
+		Help: "Messages the consumer has been delivered more than once.",
+	})
+
+	// Read-back checks of pushed entries, with LOKI_VERIFY_SAMPLE_RATE
+	verifyChecked = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "loki_verify_checked_total",
+		Help: "Sampled entries read back from Loki.",
+	}, func() float64 {
+		checked, _, _ := loki.VerifyStats()
+		return float64(checked)
+	})
+
+	verifyMissing = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "loki_verify_missing_total",
+		Help: "Sampled entries Loki didn't return when read back.",
+	}, func() float64 {
+		_, missing, _ := loki.VerifyStats()
+		return float64(missing)
+	})
+
+	verifyErrors = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "loki_verify_errors_total",
+		Help: "Read-back queries that failed.",
+	}, func() float64 {
+		_, _, errs := loki.VerifyStats()
+		return float64(errs)
+	})
+)
+
+// registerBreakerState exposes the Loki client's circuit breaker state
+// (0 closed, 1 open, 2 half-open)
+func registerBreakerState(client *loki.Client) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "loki_circuit_breaker_state",
+		Help: "State of the Loki circuit breaker: 0 closed, 1 open, 2 half-open.",
+	}, func() float64 {
+		return float64(client.BreakerState())
+	})
+}
+
+// pushErrorReason classifies a failed push for loki_push_errors_total
+func pushErrorReason(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, loki.ErrCircuitOpen):
+		return "circuit_open"
+	case sink.IsRetryable(err):
+		return "retryable"
+	default:
+		return "rejected"
+	}
+}
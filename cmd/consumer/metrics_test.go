@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"logtrace/internal/loki"
+	"logtrace/internal/middleware"
+)
+
+// scrape fetches url in the Prometheus text format, returning each series' value keyed by
+// its name and labels as exposed, e.g. loki_push_errors_total{reason="retryable"}
+func scrape(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET %s = %d: %s", url, resp.StatusCode, body)
+	}
+
+	series := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("unparseable series %q: %v", line, err)
+		}
+		series[line[:i]] = value
+	}
+	return series
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	// serveMetrics takes an address, so reserve a free port for it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	go serveMetrics(addr)
+
+	url := "http://" + addr + "/metrics"
+	waitFor(t, 5*time.Second, "the metrics server", func() bool {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err == nil
+	})
+	before := scrape(t, url)
+
+	// Two messages arrive, one of them malformed
+	entries := make(chan received, 1)
+	valid, _ := receivedEntry(middleware.LogEntry{TraceID: "t1", ServiceName: "api"})
+	data, err := middleware.EncodeLogEntry(valid.entry, middleware.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handleMsg(&fakeMsg{subject: "logs.api", data: data}, entries)
+	handleMsg(&fakeMsg{subject: "logs.api", data: []byte("{")}, entries)
+
+	// A batch of three is sent, then one fails with a retryable error
+	sink := newCountingSink()
+	sink.fail = func(send int, _ []middleware.LogEntry) error {
+		if send == 2 {
+			return &loki.PushError{StatusCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	}
+	f := &forwarder{name: "loki", sink: sink}
+	var batch []received
+	for _, traceID := range []string{"t1", "t2", "t3"} {
+		r, _ := receivedEntry(middleware.LogEntry{TraceID: traceID, ServiceName: "api"})
+		batch = append(batch, r)
+	}
+	f.processBatch(context.Background(), batch)
+	failed, _ := receivedEntry(middleware.LogEntry{TraceID: "t4", ServiceName: "api"})
+	f.processBatch(context.Background(), []received{failed})
+
+	// Other tests move the same counters, so compare against the first scrape
+	after := scrape(t, url)
+	want := map[string]float64{
+		"consumer_messages_processed_total":          2,
+		"loki_batches_sent_total":                    1,
+		"loki_batch_size_count":                      2,
+		"loki_batch_size_sum":                        4,
+		`loki_batch_size_bucket{le="1"}`:             1,
+		`loki_batch_size_bucket{le="5"}`:             2,
+		"loki_push_duration_seconds_count":           2,
+		`loki_push_errors_total{reason="retryable"}`: 1,
+	}
+	for series, delta := range want {
+		if _, ok := after[series]; !ok {
+			t.Errorf("%s missing from /metrics", series)
+			continue
+		}
+		if got := after[series] - before[series]; got != delta {
+			t.Errorf("%s rose by %g, want %g", series, got, delta)
+		}
+	}
+	for _, series := range []string{"consumer_pending_messages", "consumer_ack_pending_messages", "loki_verify_missing_total"} {
+		if _, ok := after[series]; !ok {
+			t.Errorf("%s missing from /metrics", series)
+		}
+	}
+}
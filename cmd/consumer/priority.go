@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	"logtrace/internal/middleware"
+)
+
+// priorityBuffer holds received entries waiting for a batch. With priority enabled,
+// error entries are taken before others, but an entry that has waited maxWait is taken
+// first regardless, so info logs aren't starved; otherwise entries leave in arrival order.
+type priorityBuffer struct {
+	enabled bool
+	maxWait time.Duration
+
+	high []received
+	low  []received
+}
+
+// highPriority reports whether an entry records a failure
+func highPriority(entry middleware.LogEntry) bool {
+	switch entry.Severity {
+	case middleware.SeverityError, middleware.SeverityFatal:
+		return true
+	case "":
+		// Entries from publishers that predate severities
+		return entry.Status >= 500 || entry.Error != ""
+	default:
+		return false
+	}
+}
+
+func (b *priorityBuffer) push(r received) {
+	if b.enabled && highPriority(r.entry) {
+		b.high = append(b.high, r)
+	} else {
+		b.low = append(b.low, r)
+	}
+}
+
+func (b *priorityBuffer) len() int {
+	return len(b.high) + len(b.low)
+}
+
+// oldest returns the earliest arrival among the pending entries
+func (b *priorityBuffer) oldest() (time.Time, bool) {
+	switch {
+	case len(b.high) == 0 && len(b.low) == 0:
+		return time.Time{}, false
+	case len(b.high) == 0:
+		return b.low[0].arrived, true
+	case len(b.low) == 0:
+		return b.high[0].arrived, true
+	}
+	if b.high[0].arrived.Before(b.low[0].arrived) {
+		return b.high[0].arrived, true
+	}
+	return b.low[0].arrived, true
+}
+
+// take moves up to n entries into batch: low-priority entries that waited maxWait,
+// then high-priority entries, then the remaining low-priority ones, each oldest first
+func (b *priorityBuffer) take(batch []received, n int, now time.Time) []received {
+	// Promote overdue low-priority entries; low is in arrival order, so they lead it
+	overdue := 0
+	for overdue < len(b.low) && overdue < n && now.Sub(b.low[overdue].arrived) >= b.maxWait {
+		overdue++
+	}
+	batch = append(batch, b.low[:overdue]...)
+	b.low = b.low[overdue:]
+
+	k := min(n-len(batch), len(b.high))
+	batch = append(batch, b.high[:k]...)
+	b.high = b.high[k:]
+
+	k = min(n-len(batch), len(b.low))
+	batch = append(batch, b.low[:k]...)
+	b.low = b.low[k:]
+
+	return batch
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+)
+
+func TestHighPriority(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry middleware.LogEntry
+		want  bool
+	}{
+		{"error", middleware.LogEntry{Severity: middleware.SeverityError}, true},
+		{"fatal", middleware.LogEntry{Severity: middleware.SeverityFatal}, true},
+		{"warning", middleware.LogEntry{Severity: middleware.SeverityWarn, Status: 500}, false},
+		{"info", middleware.LogEntry{Severity: middleware.SeverityInfo}, false},
+		{"no severity, 5xx", middleware.LogEntry{Status: 503}, true},
+		{"no severity, error", middleware.LogEntry{Status: 200, Error: "timeout"}, true},
+		{"no severity, 4xx", middleware.LogEntry{Status: 404}, false},
+	}
+	for _, tt := range tests {
+		if got := highPriority(tt.entry); got != tt.want {
+			t.Errorf("highPriority(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+// pendingEntries returns entries arriving in order, errors for the trace IDs starting with "e"
+func pendingEntries(start time.Time, traceIDs ...string) []received {
+	var entries []received
+	for i, traceID := range traceIDs {
+		severity := middleware.SeverityInfo
+		if traceID[0] == 'e' {
+			severity = middleware.SeverityError
+		}
+		r, _ := receivedEntry(middleware.LogEntry{TraceID: traceID, Severity: severity})
+		r.arrived = start.Add(time.Duration(i) * time.Millisecond)
+		entries = append(entries, r)
+	}
+	return entries
+}
+
+// traceIDs returns the trace IDs of entries, in order
+func traceIDs(entries []received) []string {
+	var ids []string
+	for _, r := range entries {
+		ids = append(ids, r.entry.TraceID)
+	}
+	return ids
+}
+
+func TestPriorityBufferTake(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name    string
+		enabled bool
+		// now is how long after the first arrival the batch is taken
+		now  time.Duration
+		want [][]string
+	}{
+		{
+			name: "arrival order when disabled",
+			want: [][]string{{"i1", "e1", "i2"}, {"e2", "i3", "e3"}},
+		},
+		{
+			name:    "errors first, info left for later",
+			enabled: true,
+			want:    [][]string{{"e1", "e2", "e3"}, {"i1", "i2", "i3"}},
+		},
+		{
+			name:    "overdue info promoted",
+			enabled: true,
+			// Only i1 has waited the 100ms maxWait; i2 arrived 2ms later
+			now:  100 * time.Millisecond,
+			want: [][]string{{"i1", "e1", "e2"}, {"e3", "i2", "i3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &priorityBuffer{enabled: tt.enabled, maxWait: 100 * time.Millisecond}
+			for _, r := range pendingEntries(start, "i1", "e1", "i2", "e2", "i3", "e3") {
+				b.push(r)
+			}
+			if oldest, ok := b.oldest(); !ok || !oldest.Equal(start) {
+				t.Errorf("oldest = %v, want the first arrival", oldest)
+			}
+
+			var got [][]string
+			for b.len() > 0 {
+				got = append(got, traceIDs(b.take(nil, 3, start.Add(tt.now))))
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("took %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriorityBatchesSendErrorsFirst(t *testing.T) {
+	counter := newCountingSink()
+	f := &forwarder{
+		name:            "test",
+		sink:            counter,
+		batchSize:       4,
+		batchTimeout:    time.Hour,
+		priority:        true,
+		priorityMaxWait: time.Hour,
+	}
+
+	// Queue a backlog of mostly info entries before the batcher starts reading
+	queue := make(chan received, 8)
+	var ids []string
+	for i := range 8 {
+		id := fmt.Sprintf("i%d", i)
+		if i%3 == 2 {
+			id = fmt.Sprintf("e%d", i)
+		}
+		ids = append(ids, id)
+	}
+	for _, r := range pendingEntries(time.Now(), ids...) {
+		queue <- r
+	}
+	close(queue)
+	f.start(context.Background(), []chan received{queue}).Wait()
+
+	// Both errors lead the first batch, ahead of the info entries that arrived before them
+	var sent []string
+	for _, entry := range counter.entries {
+		sent = append(sent, entry.TraceID)
+	}
+	if want := []string{"e2", "e5", "i0", "i1", "i3", "i4", "i6", "i7"}; !slices.Equal(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+}
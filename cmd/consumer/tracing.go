@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the consumer's spans
+const tracerName = "logtrace/consumer"
+
+// startPushSpan starts the <sink>.send span covering one batch push, linked to the traces
+// of the requests whose entries it carries; the Loki client adds a loki.push child per request
+func startPushSpan(ctx context.Context, sinkName string, batch []received) (context.Context, trace.Span) {
+	var bytes int
+	for _, r := range batch {
+		bytes += len(r.msg.Data())
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, sinkName+".send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithLinks(entryLinks(batch)...),
+		trace.WithAttributes(
+			attribute.Int("batch.size", len(batch)),
+			attribute.Int("batch.bytes", bytes),
+		),
+	)
+}
+
+// entryLinks builds one span link per distinct trace in the batch; entries whose trace ID
+// isn't an OTel trace ID (e.g. the logger's UUID fallback) are skipped
+func entryLinks(batch []received) []trace.Link {
+	seen := make(map[trace.TraceID]bool)
+	var links []trace.Link
+	for _, r := range batch {
+		traceID, err := trace.TraceIDFromHex(r.entry.TraceID)
+		if err != nil || seen[traceID] {
+			continue
+		}
+		seen[traceID] = true
+
+		spanID, _ := trace.SpanIDFromHex(r.entry.SpanID)
+		links = append(links, trace.Link{
+			SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    traceID,
+				SpanID:     spanID,
+				TraceFlags: trace.FlagsSampled,
+				Remote:     true,
+			}),
+		})
+	}
+	return links
+}
+
+// endPushSpan records the push result and ends the span
+func endPushSpan(span trace.Span, err error) {
+	if err != nil {
+		span.SetAttributes(attribute.String("push.result", "error"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.String("push.result", "success"))
+	}
+	span.End()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"logtrace/internal/middleware"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that records every ended span, for the test's duration
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+// spanAttributes returns the span's attributes by key
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestPushSpan(t *testing.T) {
+	const (
+		traceA = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanA  = "00f067aa0ba902b7"
+		traceB = "0af7651916cd43dd8448eb211c80319c"
+		spanB  = "b7ad6b7169203331"
+	)
+	entries := []middleware.LogEntry{
+		{TraceID: traceA, SpanID: spanA, ServiceName: "api"},
+		{TraceID: traceA, SpanID: spanA, ServiceName: "api"},
+		{TraceID: "8d0b4c5e-3b9a-4f61-9a8e-2f1c7d6b5a40", ServiceName: "api"}, // the logger's UUID fallback
+		{TraceID: traceB, SpanID: spanB, ServiceName: "api"},
+	}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantResult string
+		wantStatus codes.Code
+	}{
+		{"success", nil, "success", codes.Unset},
+		{"failure", errors.New("ingester unavailable"), "error", codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := recordSpans(t)
+			sink := newCountingSink()
+			sink.fail = func(int, []middleware.LogEntry) error { return tt.err }
+			f := &forwarder{name: "loki", sink: sink}
+
+			var batch []received
+			var bytes int
+			for _, entry := range entries {
+				r, msg := receivedEntry(entry)
+				batch = append(batch, r)
+				bytes += len(msg.data)
+			}
+			f.processBatch(context.Background(), batch)
+
+			spans := recorder.Ended()
+			if len(spans) != 1 {
+				t.Fatalf("recorded %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != "loki.send" || span.SpanKind() != trace.SpanKindClient {
+				t.Errorf("span = %s (%s), want loki.send (client)", span.Name(), span.SpanKind())
+			}
+			attrs := spanAttributes(span)
+			if got := attrs["batch.size"].AsInt64(); got != int64(len(entries)) {
+				t.Errorf("batch.size = %d, want %d", got, len(entries))
+			}
+			if got := attrs["batch.bytes"].AsInt64(); got != int64(bytes) {
+				t.Errorf("batch.bytes = %d, want %d", got, bytes)
+			}
+			if got := attrs["push.result"].AsString(); got != tt.wantResult {
+				t.Errorf("push.result = %q, want %q", got, tt.wantResult)
+			}
+			if span.Status().Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", span.Status().Code, tt.wantStatus)
+			}
+
+			// One link per distinct OTel trace; the UUID trace ID can't be linked
+			links := span.Links()
+			want := [][2]string{{traceA, spanA}, {traceB, spanB}}
+			if len(links) != len(want) {
+				t.Fatalf("span has %d links, want %d", len(links), len(want))
+			}
+			for i, link := range links {
+				sc := link.SpanContext
+				if sc.TraceID().String() != want[i][0] || sc.SpanID().String() != want[i][1] || !sc.IsRemote() || !sc.IsSampled() {
+					t.Errorf("link %d = %s/%s remote %t sampled %t, want %s/%s remote and sampled",
+						i, sc.TraceID(), sc.SpanID(), sc.IsRemote(), sc.IsSampled(), want[i][0], want[i][1])
+				}
+			}
+		})
+	}
+}
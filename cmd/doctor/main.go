@@ -0,0 +1,354 @@
+// cmd/doctor/main.go
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"logtrace/internal/config"
+	"logtrace/internal/loki"
+	"logtrace/internal/middleware"
+	natsclient "logtrace/internal/nats"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// doctorService is the service name of synthetic entries; filter them out with
+// service!="logtrace-doctor" in a stream selector or | json | synthetic!="true"
+const doctorService = "logtrace-doctor"
+
+// stage is one step of the pipeline check; it returns a short detail on success
+type stage struct {
+	name string
+	run  func() (string, error)
+}
+
+// Each stage after config reaches NATS or Loki through one of these, so it can be run
+// against a fake
+
+// streamInspector is what the stream stage reads the logs stream's state through
+type streamInspector interface {
+	StreamInfo(ctx context.Context, name string) (*jetstream.StreamInfo, error)
+}
+
+// consumerChecker is what the consumer stage provisions and inspects the durable through
+type consumerChecker interface {
+	CreatePullConsumer(name string, filterSubjects []string) (jetstream.Consumer, error)
+	ConsumerPending(stream, consumer string) (numPending uint64, numAckPending int, redelivered uint64, err error)
+}
+
+// entryPublisher is what the publish stage publishes the synthetic entry through
+type entryPublisher interface {
+	Publish(subject string, data []byte) (*jetstream.PubAck, error)
+}
+
+// messageReader is what the consume stage reads the published entry back through
+type messageReader interface {
+	GetMsg(ctx context.Context, stream string, seq uint64) (*jetstream.RawStreamMsg, error)
+}
+
+// broker is the NATS connection the nats stage opens for the stages after it
+type broker interface {
+	streamInspector
+	consumerChecker
+	entryPublisher
+	messageReader
+	ConnectedURL() string
+	Close()
+}
+
+// lokiPusher is what the loki push stage sends the entry through
+type lokiPusher interface {
+	SendLog(entry middleware.LogEntry) error
+}
+
+// traceFinder is what the loki query stage looks the entry up through
+type traceFinder interface {
+	DefaultLabels(entry middleware.LogEntry) map[string]string
+	HasTrace(tenant string, labels map[string]string, traceID string, timestamp time.Time) (bool, error)
+}
+
+// pipeline holds what the stages share as the synthetic entry moves through them
+type pipeline struct {
+	cfg     *config.Config
+	subject string
+	wait    time.Duration
+	// poll is how often the query stage asks Loki for the entry
+	poll time.Duration
+
+	// dial connects to NATS; the stages after the nats stage use the broker it returns
+	dial   func(natsclient.Config) (broker, error)
+	broker broker
+	pusher lokiPusher
+	finder traceFinder
+
+	entry middleware.LogEntry
+	seq   uint64
+}
+
+func main() {
+	subject := flag.String("subject", "logs."+doctorService, "subject the synthetic entry is published to; must match NATS_SUBJECTS")
+	wait := flag.Duration("wait", 30*time.Second, "how long to wait for the entry to become queryable in Loki")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("FAIL  %-10s %8s  %v\n", "config", time.Duration(0), err)
+		os.Exit(1)
+	}
+	lokiClient := newLokiClient(cfg)
+	p := &pipeline{
+		cfg:     cfg,
+		subject: *subject,
+		wait:    *wait,
+		poll:    time.Second,
+		dial:    dialNATS,
+		pusher:  lokiClient,
+		finder:  lokiClient,
+	}
+	p.newEntry()
+
+	stages := []stage{
+		{"config", p.checkConfig},
+		{"nats", p.connect},
+		{"stream", p.checkStream},
+		{"consumer", p.checkConsumer},
+		{"publish", p.publish},
+		{"consume", p.consume},
+		{"loki push", p.push},
+		{"loki query", p.query},
+	}
+
+	fmt.Printf("Checking the log pipeline with synthetic trace %s\n", p.entry.TraceID)
+	ok := runStages(stages)
+	p.close()
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Println("All stages passed")
+}
+
+// runStages runs the stages in order, reporting each one's outcome and timing, and stops
+// at the first failure since later stages depend on it
+func runStages(stages []stage) bool {
+	for _, s := range stages {
+		start := time.Now()
+		detail, err := s.run()
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			fmt.Printf("FAIL  %-10s %8s  %v\n", s.name, elapsed, err)
+			return false
+		}
+		fmt.Printf("PASS  %-10s %8s  %s\n", s.name, elapsed, detail)
+	}
+	return true
+}
+
+// newEntry builds the synthetic entry, clearly marked so it can be filtered out
+func (p *pipeline) newEntry() {
+	p.entry = middleware.LogEntry{
+		TraceID:     strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Timestamp:   time.Now(),
+		Method:      "GET",
+		Path:        "/_doctor",
+		Route:       "/_doctor",
+		Status:      200,
+		Severity:    middleware.SeverityInfo,
+		UserAgent:   doctorService,
+		ServiceName: doctorService,
+		Environment: p.cfg.Environment,
+		Attempt:     1,
+		Synthetic:   true,
+	}
+}
+
+func (p *pipeline) checkConfig() (string, error) {
+	if err := p.cfg.Validate(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("stream %s, subjects %v", p.cfg.NatsStreamName, p.cfg.NatsSubjects), nil
+}
+
+// connect connects to NATS and creates the stream, or updates it, as the API and consumer do
+func (p *pipeline) connect() (string, error) {
+	natsTLS, err := natsclient.LoadTLSConfig(p.cfg.NatsTLSCAFile, p.cfg.NatsTLSCertFile, p.cfg.NatsTLSKeyFile)
+	if err != nil {
+		return "", err
+	}
+
+	p.broker, err = p.dial(natsclient.Config{
+		URL:             p.cfg.NatsURL,
+		Servers:         p.cfg.NatsServers,
+		ReconnectWait:   2 * time.Second,
+		MaxReconnects:   1,
+		ConnectionName:  doctorService,
+		StreamName:      p.cfg.NatsStreamName,
+		StreamSubjects:  p.cfg.NatsSubjects,
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     p.cfg.NatsStorageType,
+		MaxAge:          p.cfg.NatsMaxAge,
+		Replicas:        p.cfg.NatsReplicas,
+		JSDomain:        p.cfg.NatsJSDomain,
+		JSAPIPrefix:     p.cfg.NatsJSAPIPrefix,
+		MaxAckPending:   p.cfg.ConsumerMaxAckPending,
+		TLSConfig:       natsTLS,
+		CredsFile:       p.cfg.NatsCredsFile,
+		Username:        p.cfg.NatsUser,
+		Password:        p.cfg.NatsPassword,
+		NKeySeed:        p.cfg.NatsNKeySeed,
+	})
+	if err != nil {
+		return "", err
+	}
+	return "connected to " + p.broker.ConnectedURL(), nil
+}
+
+// natsBroker adds the stream reads the stages need to the NATS client
+type natsBroker struct {
+	*natsclient.NatsClient
+}
+
+// dialNATS connects to NATS with the client the API and consumer use
+func dialNATS(cfg natsclient.Config) (broker, error) {
+	client, err := natsclient.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return natsBroker{client}, nil
+}
+
+func (b natsBroker) ConnectedURL() string {
+	return b.Conn.ConnectedUrl()
+}
+
+func (b natsBroker) StreamInfo(ctx context.Context, name string) (*jetstream.StreamInfo, error) {
+	stream, err := b.JS.Stream(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return stream.CachedInfo(), nil
+}
+
+func (b natsBroker) GetMsg(ctx context.Context, name string, seq uint64) (*jetstream.RawStreamMsg, error) {
+	stream, err := b.JS.Stream(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return stream.GetMsg(ctx, seq)
+}
+
+func (p *pipeline) checkStream() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	info, err := p.broker.StreamInfo(ctx, p.cfg.NatsStreamName)
+	if err != nil {
+		return "", fmt.Errorf("stream %s: %w", p.cfg.NatsStreamName, err)
+	}
+	return fmt.Sprintf("%s has %d messages", info.Config.Name, info.State.Msgs), nil
+}
+
+// checkConsumer makes sure the log consumer's durable consumer exists; the doctor never
+// fetches from it, so it doesn't take entries from a running consumer
+func (p *pipeline) checkConsumer() (string, error) {
+	if _, err := p.broker.CreatePullConsumer(p.cfg.ConsumerName, p.cfg.NatsSubjects); err != nil {
+		return "", err
+	}
+	pending, ackPending, _, err := p.broker.ConsumerPending(p.cfg.NatsStreamName, p.cfg.ConsumerName)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s has %d pending, %d awaiting ack", p.cfg.ConsumerName, pending, ackPending), nil
+}
+
+func (p *pipeline) publish() (string, error) {
+	data, err := middleware.EncodeLogEntry(p.entry, middleware.LogFormat(p.cfg.LogFormat))
+	if err != nil {
+		return "", err
+	}
+	ack, err := p.broker.Publish(p.subject, data)
+	if err != nil {
+		return "", fmt.Errorf("publish to %s: %w", p.subject, err)
+	}
+	if ack.Stream != p.cfg.NatsStreamName {
+		return "", fmt.Errorf("%s is captured by stream %s, not %s", p.subject, ack.Stream, p.cfg.NatsStreamName)
+	}
+
+	p.seq = ack.Sequence
+	return fmt.Sprintf("sequence %d on %s", ack.Sequence, p.subject), nil
+}
+
+// consume reads the published message back by sequence. A running log consumer may have
+// acknowledged it already, which removes it from the work-queue stream; that counts as
+// delivered.
+func (p *pipeline) consume() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	msg, err := p.broker.GetMsg(ctx, p.cfg.NatsStreamName, p.seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return "already consumed and acknowledged by " + p.cfg.ConsumerName, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	entry, err := middleware.DecodeLogEntry(msg.Data)
+	if err != nil {
+		return "", fmt.Errorf("decode: %w", err)
+	}
+	if entry.TraceID != p.entry.TraceID {
+		return "", fmt.Errorf("read back trace %s, want %s", entry.TraceID, p.entry.TraceID)
+	}
+	return "read back and decoded", nil
+}
+
+// newLokiClient builds the Loki client the push and query stages use, configured as the
+// consumer's
+func newLokiClient(cfg *config.Config) *loki.Client {
+	client := loki.NewClient(cfg.LokiURL)
+	client.Encoding = loki.Encoding(cfg.LokiEncoding)
+	client.Method = cfg.LokiPushMethod
+	client.LabelPrefix = cfg.LokiLabelPrefix
+	client.QueryURL = cfg.LokiQueryURL
+	client.DefaultTenant = cfg.LokiDefaultTenant
+	client.HTTPClient.Timeout = cfg.LokiTimeout
+	return client
+}
+
+func (p *pipeline) push() (string, error) {
+	if err := p.pusher.SendLog(p.entry); err != nil {
+		return "", err
+	}
+	return "pushed to " + p.cfg.LokiURL, nil
+}
+
+// query polls Loki until the entry is queryable or the wait runs out
+func (p *pipeline) query() (string, error) {
+	labels := p.finder.DefaultLabels(p.entry)
+	deadline := time.Now().Add(p.wait)
+	for {
+		found, err := p.finder.HasTrace(p.cfg.LokiDefaultTenant, labels, p.entry.TraceID, p.entry.Timestamp)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return "found trace " + p.entry.TraceID, nil
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("trace %s not found after %s", p.entry.TraceID, p.wait)
+		}
+		time.Sleep(p.poll)
+	}
+}
+
+func (p *pipeline) close() {
+	if p.broker != nil {
+		p.broker.Close()
+	}
+}
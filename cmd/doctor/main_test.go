@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"logtrace/internal/config"
+	"logtrace/internal/middleware"
+	natsclient "logtrace/internal/nats"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeBroker stands in for NATS; each stage's call fails with its error when set
+type fakeBroker struct {
+	streamErr   error
+	consumerErr error
+	pendingErr  error
+	publishErr  error
+	getErr      error
+
+	// ackStream is the stream the publish ack names; "" names the logs stream
+	ackStream string
+	// stored is the message GetMsg returns; nil returns what was published
+	stored    []byte
+	published []byte
+}
+
+func (b *fakeBroker) ConnectedURL() string { return "nats://fake:4222" }
+func (b *fakeBroker) Close()               {}
+
+func (b *fakeBroker) StreamInfo(_ context.Context, name string) (*jetstream.StreamInfo, error) {
+	if b.streamErr != nil {
+		return nil, b.streamErr
+	}
+	return &jetstream.StreamInfo{Config: jetstream.StreamConfig{Name: name}, State: jetstream.StreamState{Msgs: 7}}, nil
+}
+
+func (b *fakeBroker) CreatePullConsumer(string, []string) (jetstream.Consumer, error) {
+	return nil, b.consumerErr
+}
+
+func (b *fakeBroker) ConsumerPending(string, string) (uint64, int, uint64, error) {
+	return 3, 1, 0, b.pendingErr
+}
+
+func (b *fakeBroker) Publish(_ string, data []byte) (*jetstream.PubAck, error) {
+	if b.publishErr != nil {
+		return nil, b.publishErr
+	}
+	b.published = data
+	stream := b.ackStream
+	if stream == "" {
+		stream = "logs"
+	}
+	return &jetstream.PubAck{Stream: stream, Sequence: 42}, nil
+}
+
+func (b *fakeBroker) GetMsg(_ context.Context, _ string, seq uint64) (*jetstream.RawStreamMsg, error) {
+	if b.getErr != nil {
+		return nil, b.getErr
+	}
+	data := b.stored
+	if data == nil {
+		data = b.published
+	}
+	return &jetstream.RawStreamMsg{Sequence: seq, Data: data}, nil
+}
+
+// fakeLoki stands in for Loki, finding the trace on the foundAfter-th query
+type fakeLoki struct {
+	sendErr    error
+	queryErr   error
+	foundAfter int
+	queries    int
+}
+
+func (l *fakeLoki) SendLog(middleware.LogEntry) error { return l.sendErr }
+
+func (l *fakeLoki) DefaultLabels(middleware.LogEntry) map[string]string {
+	return map[string]string{"service": doctorService}
+}
+
+func (l *fakeLoki) HasTrace(string, map[string]string, string, time.Time) (bool, error) {
+	l.queries++
+	if l.queryErr != nil {
+		return false, l.queryErr
+	}
+	return l.foundAfter > 0 && l.queries >= l.foundAfter, nil
+}
+
+// testConfig loads the default configuration, ignoring any config file
+func testConfig(t *testing.T) *config.Config {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("NATS_STREAM", "logs")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newTestPipeline returns a pipeline over the fakes, connected as if the nats stage passed
+func newTestPipeline(t *testing.T) (*pipeline, *fakeBroker, *fakeLoki) {
+	t.Helper()
+	nats, lokiFake := &fakeBroker{}, &fakeLoki{foundAfter: 1}
+	p := &pipeline{
+		cfg:     testConfig(t),
+		subject: "logs." + doctorService,
+		wait:    time.Second,
+		poll:    time.Millisecond,
+		dial:    func(natsclient.Config) (broker, error) { return nats, nil },
+		broker:  nats,
+		pusher:  lokiFake,
+		finder:  lokiFake,
+	}
+	p.newEntry()
+	return p, nats, lokiFake
+}
+
+func TestStages(t *testing.T) {
+	errDown := errors.New("connection refused")
+	tests := []struct {
+		name  string
+		stage func(p *pipeline) func() (string, error)
+		// setup arranges the fakes; the stage fails with wantErr, or passes with wantDetail
+		setup      func(p *pipeline, b *fakeBroker, l *fakeLoki)
+		wantErr    string
+		wantDetail string
+	}{
+		{
+			name:       "config passes",
+			stage:      func(p *pipeline) func() (string, error) { return p.checkConfig },
+			wantDetail: "stream logs",
+		},
+		{
+			name:    "config fails",
+			stage:   func(p *pipeline) func() (string, error) { return p.checkConfig },
+			setup:   func(p *pipeline, _ *fakeBroker, _ *fakeLoki) { p.cfg.LokiURL = "not a url" },
+			wantErr: "LOKI_URL",
+		},
+		{
+			name:       "nats passes",
+			stage:      func(p *pipeline) func() (string, error) { return p.connect },
+			setup:      func(p *pipeline, _ *fakeBroker, _ *fakeLoki) { p.broker = nil },
+			wantDetail: "connected to nats://fake:4222",
+		},
+		{
+			name:  "nats fails",
+			stage: func(p *pipeline) func() (string, error) { return p.connect },
+			setup: func(p *pipeline, _ *fakeBroker, _ *fakeLoki) {
+				p.dial = func(natsclient.Config) (broker, error) { return nil, errDown }
+			},
+			wantErr: "connection refused",
+		},
+		{
+			name:       "stream passes",
+			stage:      func(p *pipeline) func() (string, error) { return p.checkStream },
+			wantDetail: "logs has 7 messages",
+		},
+		{
+			name:    "stream fails",
+			stage:   func(p *pipeline) func() (string, error) { return p.checkStream },
+			setup:   func(_ *pipeline, b *fakeBroker, _ *fakeLoki) { b.streamErr = jetstream.ErrStreamNotFound },
+			wantErr: "stream logs",
+		},
+		{
+			name:       "consumer passes",
+			stage:      func(p *pipeline) func() (string, error) { return p.checkConsumer },
+			wantDetail: "3 pending, 1 awaiting ack",
+		},
+		{
+			name:  "consumer fails to provision",
+			stage: func(p *pipeline) func() (string, error) { return p.checkConsumer },
+			setup: func(_ *pipeline, b *fakeBroker, _ *fakeLoki) {
+				b.consumerErr = errors.New("consumer is a push consumer")
+			},
+			wantErr: "push consumer",
+		},
+		{
+			name:    "consumer fails to report",
+			stage:   func(p *pipeline) func() (string, error) { return p.checkConsumer },
+			setup:   func(_ *pipeline, b *fakeBroker, _ *fakeLoki) { b.pendingErr = errDown },
+			wantErr: "connection refused",
+		},
+		{
+			name:       "publish passes",
+			stage:      func(p *pipeline) func() (string, error) { return p.publish },
+			wantDetail: "sequence 42 on logs." + doctorService,
+		},
+		{
+			name:    "publish fails",
+			stage:   func(p *pipeline) func() (string, error) { return p.publish },
+			setup:   func(_ *pipeline, b *fakeBroker, _ *fakeLoki) { b.publishErr = errDown },
+			wantErr: "publish to logs." + doctorService,
+		},
+		{
+			name:    "publish lands in another stream",
+			stage:   func(p *pipeline) func() (string, error) { return p.publish },
+			setup:   func(_ *pipeline, b *fakeBroker, _ *fakeLoki) { b.ackStream = "audit" },
+			wantErr: "captured by stream audit",
+		},
+		{
+			name:       "consume passes",
+			stage:      func(p *pipeline) func() (string, error) { return p.consume },
+			setup:      func(p *pipeline, _ *fakeBroker, _ *fakeLoki) { p.publish() },
+			wantDetail: "read back and decoded",
+		},
+		{
+			name:       "consume passes once a consumer acked it",
+			stage:      func(p *pipeline) func() (string, error) { return p.consume },
+			setup:      func(_ *pipeline, b *fakeBroker, _ *fakeLoki) { b.getErr = jetstream.ErrMsgNotFound },
+			wantDetail: "already consumed",
+		},
+		{
+			name:    "consume fails",
+			stage:   func(p *pipeline) func() (string, error) { return p.consume },
+			setup:   func(_ *pipeline, b *fakeBroker, _ *fakeLoki) { b.getErr = errDown },
+			wantErr: "connection refused",
+		},
+		{
+			name:    "consume reads garbage",
+			stage:   func(p *pipeline) func() (string, error) { return p.consume },
+			setup:   func(_ *pipeline, b *fakeBroker, _ *fakeLoki) { b.stored = []byte("not an entry") },
+			wantErr: "decode",
+		},
+		{
+			name:  "consume reads another trace",
+			stage: func(p *pipeline) func() (string, error) { return p.consume },
+			setup: func(_ *pipeline, b *fakeBroker, _ *fakeLoki) {
+				b.stored, _ = middleware.EncodeLogEntry(middleware.LogEntry{TraceID: "other"}, middleware.FormatJSON)
+			},
+			wantErr: "read back trace other",
+		},
+		{
+			name:       "loki push passes",
+			stage:      func(p *pipeline) func() (string, error) { return p.push },
+			wantDetail: "pushed to ",
+		},
+		{
+			name:    "loki push fails",
+			stage:   func(p *pipeline) func() (string, error) { return p.push },
+			setup:   func(_ *pipeline, _ *fakeBroker, l *fakeLoki) { l.sendErr = errors.New("loki: 400 Bad Request") },
+			wantErr: "400",
+		},
+		{
+			name:       "loki query passes after polling",
+			stage:      func(p *pipeline) func() (string, error) { return p.query },
+			setup:      func(_ *pipeline, _ *fakeBroker, l *fakeLoki) { l.foundAfter = 3 },
+			wantDetail: "found trace",
+		},
+		{
+			name:    "loki query fails",
+			stage:   func(p *pipeline) func() (string, error) { return p.query },
+			setup:   func(_ *pipeline, _ *fakeBroker, l *fakeLoki) { l.queryErr = errDown },
+			wantErr: "connection refused",
+		},
+		{
+			name:  "loki query times out",
+			stage: func(p *pipeline) func() (string, error) { return p.query },
+			setup: func(p *pipeline, _ *fakeBroker, l *fakeLoki) {
+				p.wait = 20 * time.Millisecond
+				l.foundAfter = 0
+			},
+			wantErr: "not found after 20ms",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, nats, lokiFake := newTestPipeline(t)
+			if tt.setup != nil {
+				tt.setup(p, nats, lokiFake)
+			}
+
+			detail, err := tt.stage(p)()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("stage error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stage failed: %v", err)
+			}
+			if !strings.Contains(detail, tt.wantDetail) {
+				t.Errorf("stage detail = %q, want it to mention %q", detail, tt.wantDetail)
+			}
+		})
+	}
+}
+
+func TestRunStagesStopsAtFirstFailure(t *testing.T) {
+	var ran []string
+	stageFunc := func(name string, err error) stage {
+		return stage{name, func() (string, error) {
+			ran = append(ran, name)
+			return "ok", err
+		}}
+	}
+
+	if !runStages([]stage{stageFunc("a", nil), stageFunc("b", nil)}) {
+		t.Error("runStages failed with every stage passing")
+	}
+	ran = nil
+	if runStages([]stage{stageFunc("a", nil), stageFunc("b", errors.New("down")), stageFunc("c", nil)}) {
+		t.Error("runStages passed with a failing stage")
+	}
+	if strings.Join(ran, ",") != "a,b" {
+		t.Errorf("ran %v, want a and b only", ran)
+	}
+}
@@ -16,6 +16,33 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/api/v1/status": {
+            "get": {
+                "description": "Summarizes log pipeline health: NATS connectivity, consumer lag, last Loki push and publish error rate",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Pipeline status",
+                "operationId": "status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Status"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.Status"
+                        }
+                    }
+                }
+            }
+        },
         "/ping": {
             "get": {
                 "description": "This endpoint checks the health of the service",
@@ -29,6 +56,7 @@ const docTemplate = `{
                     "health"
                 ],
                 "summary": "Ping service",
+                "operationId": "ping",
                 "responses": {
                     "200": {
                         "description": "pong",
@@ -38,6 +66,166 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Reports whether NATS, and Loki when READY_CHECK_LOKI is set, are reachable",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
+                "operationId": "ready",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Readiness"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.Readiness"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "health.ConsumerStatus": {
+            "type": "object",
+            "properties": {
+                "ack_pending": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "last_loki_push": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "pending": {
+                    "type": "integer"
+                },
+                "seconds_since_push": {
+                    "type": "number"
+                }
+            }
+        },
+        "health.DependencyStatus": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "up": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "health.NATSStatus": {
+            "type": "object",
+            "properties": {
+                "active_cluster": {
+                    "type": "string"
+                },
+                "connected": {
+                    "type": "boolean"
+                },
+                "server_url": {
+                    "type": "string"
+                }
+            }
+        },
+        "health.PublisherStatus": {
+            "type": "object",
+            "properties": {
+                "drop_rate": {
+                    "type": "number"
+                },
+                "error_rate": {
+                    "type": "number"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "published": {
+                    "type": "integer"
+                },
+                "recent_dropped": {
+                    "description": "RecentDropped and DropRate cover entries dropped on a full async queue within\nThresholds.DropRateWindow",
+                    "type": "integer"
+                }
+            }
+        },
+        "health.Readiness": {
+            "type": "object",
+            "properties": {
+                "loki": {
+                    "description": "Loki is only checked when ReadinessChecker.LokiReadyURL is set",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/health.DependencyStatus"
+                        }
+                    ]
+                },
+                "nats": {
+                    "$ref": "#/definitions/health.DependencyStatus"
+                },
+                "ready": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "health.State": {
+            "type": "string",
+            "enum": [
+                "healthy",
+                "degraded",
+                "down"
+            ],
+            "x-enum-varnames": [
+                "StateHealthy",
+                "StateDegraded",
+                "StateDown"
+            ]
+        },
+        "health.Status": {
+            "type": "object",
+            "properties": {
+                "checked_at": {
+                    "type": "string"
+                },
+                "consumer": {
+                    "$ref": "#/definitions/health.ConsumerStatus"
+                },
+                "issues": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "nats": {
+                    "$ref": "#/definitions/health.NATSStatus"
+                },
+                "publisher": {
+                    "$ref": "#/definitions/health.PublisherStatus"
+                },
+                "status": {
+                    "$ref": "#/definitions/health.State"
+                },
+                "stream": {
+                    "description": "Stream is a snapshot of the logs stream and all its consumers, omitted when\nit couldn't be read",
+                    "type": "object"
+                }
+            }
         }
     }
 }`
@@ -52,6 +240,8 @@ var SwaggerInfo = &swag.Spec{
 	Description:      "",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
+	LeftDelim:        "{{",
+	RightDelim:       "}}",
 }
 
 func init() {
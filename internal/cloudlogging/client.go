@@ -0,0 +1,217 @@
+// Package cloudlogging sends log entries to Google Cloud Logging through its REST API.
+package cloudlogging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"logtrace/internal/middleware"
+	"net/http"
+	"time"
+)
+
+// DefaultEndpoint is the Cloud Logging entries:write endpoint
+const DefaultEndpoint = "https://logging.googleapis.com/v2/entries:write"
+
+// CloudLoggingSink writes log entries to a Cloud Logging log
+type CloudLoggingSink struct {
+	ProjectID  string
+	LogName    string
+	Endpoint   string
+	HTTPClient *http.Client
+
+	// SyntheticLabel adds synthetic="true" to the labels of synthetic entries, so a log
+	// router can send them to a short-retention bucket
+	SyntheticLabel bool
+
+	// Token returns the OAuth2 access token for each request; defaults to the GCE metadata server
+	Token func(ctx context.Context) (string, error)
+}
+
+// WriteError describes a failed entries:write request
+type WriteError struct {
+	// StatusCode is the response status, or 0 when the request never got a response
+	StatusCode int
+	Body       string
+	Err        error
+}
+
+func (e *WriteError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("failed to send request to Cloud Logging: %v", e.Err)
+	}
+	return fmt.Sprintf("Cloud Logging returned error status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the write may succeed if sent again
+func (e *WriteError) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// NewCloudLoggingSink creates a sink writing to projects/<projectID>/logs/<logName>
+func NewCloudLoggingSink(projectID, logName string) *CloudLoggingSink {
+	s := &CloudLoggingSink{
+		ProjectID: projectID,
+		LogName:   logName,
+		Endpoint:  DefaultEndpoint,
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+	s.Token = newMetadataTokenSource(s.HTTPClient).Token
+	return s
+}
+
+// writeRequest is the entries:write request body
+type writeRequest struct {
+	LogName        string            `json:"logName"`
+	Resource       monitoredResource `json:"resource"`
+	Entries        []logEntry        `json:"entries"`
+	PartialSuccess bool              `json:"partialSuccess"`
+}
+
+type monitoredResource struct {
+	Type string `json:"type"`
+}
+
+// logEntry is a Cloud Logging LogEntry
+type logEntry struct {
+	Timestamp   string              `json:"timestamp"`
+	Severity    string              `json:"severity"`
+	Trace       string              `json:"trace,omitempty"`
+	SpanID      string              `json:"spanId,omitempty"`
+	Labels      map[string]string   `json:"labels,omitempty"`
+	HTTPRequest *httpRequest        `json:"httpRequest,omitempty"`
+	JSONPayload middleware.LogEntry `json:"jsonPayload"`
+}
+
+type httpRequest struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	Status        int    `json:"status"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	Latency       string `json:"latency"`
+}
+
+// Severity maps an entry to a Cloud Logging severity, from the entry's own severity
+// when it has one and otherwise from its status class
+func Severity(entry middleware.LogEntry) string {
+	switch entry.Severity {
+	case middleware.SeverityTrace, middleware.SeverityDebug:
+		return "DEBUG"
+	case middleware.SeverityInfo:
+		return "INFO"
+	case middleware.SeverityWarn:
+		return "WARNING"
+	case middleware.SeverityError:
+		return "ERROR"
+	case middleware.SeverityFatal:
+		return "CRITICAL"
+	}
+
+	switch {
+	case entry.Status >= 500:
+		return "ERROR"
+	case entry.Error != "":
+		return "ERROR"
+	case entry.Status >= 400:
+		return "WARNING"
+	default:
+		return "INFO"
+	}
+}
+
+// TraceName formats a trace ID as Cloud Logging's trace resource name
+func TraceName(projectID, traceID string) string {
+	if traceID == "" {
+		return ""
+	}
+	return fmt.Sprintf("projects/%s/traces/%s", projectID, traceID)
+}
+
+// toLogEntry maps a LogEntry to a Cloud Logging entry
+func (s *CloudLoggingSink) toLogEntry(entry middleware.LogEntry) logEntry {
+	labels := map[string]string{
+		"service":     entry.LabelService(),
+		"environment": entry.Environment,
+	}
+	if s.SyntheticLabel && entry.Synthetic {
+		labels["synthetic"] = "true"
+	}
+
+	return logEntry{
+		Timestamp: entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		Severity:  Severity(entry),
+		Trace:     TraceName(s.ProjectID, entry.TraceID),
+		SpanID:    entry.SpanID,
+		Labels:    labels,
+		HTTPRequest: &httpRequest{
+			RequestMethod: entry.Method,
+			RequestURL:    entry.Path,
+			Status:        entry.Status,
+			UserAgent:     entry.UserAgent,
+			RemoteIP:      entry.ClientIP,
+			Latency:       fmt.Sprintf("%.6fs", entry.Latency/1000),
+		},
+		JSONPayload: entry,
+	}
+}
+
+// SendLogContext writes a single entry
+func (s *CloudLoggingSink) SendLogContext(ctx context.Context, entry middleware.LogEntry) error {
+	return s.SendBatchLogsContext(ctx, []middleware.LogEntry{entry})
+}
+
+// SendBatchLogsContext writes a batch of entries in one entries:write request
+func (s *CloudLoggingSink) SendBatchLogsContext(ctx context.Context, entries []middleware.LogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	req := writeRequest{
+		LogName:        fmt.Sprintf("projects/%s/logs/%s", s.ProjectID, s.LogName),
+		Resource:       monitoredResource{Type: "global"},
+		Entries:        make([]logEntry, 0, len(entries)),
+		PartialSuccess: true,
+	}
+	for _, entry := range entries {
+		req.Entries = append(req.Entries, s.toLogEntry(entry))
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Cloud Logging request: %w", err)
+	}
+
+	token, err := s.Token(ctx)
+	if err != nil {
+		return &WriteError{Err: fmt.Errorf("failed to get access token: %w", err)}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.Endpoint, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := s.HTTPClient.Do(httpReq)
+	if err != nil {
+		return &WriteError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return &WriteError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return nil
+}
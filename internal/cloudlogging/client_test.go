@@ -0,0 +1,175 @@
+package cloudlogging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+)
+
+func TestSeverity(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry middleware.LogEntry
+		want  string
+	}{
+		{"success", middleware.LogEntry{Status: http.StatusOK}, "INFO"},
+		{"redirect", middleware.LogEntry{Status: http.StatusFound}, "INFO"},
+		{"client error", middleware.LogEntry{Status: http.StatusNotFound}, "WARNING"},
+		{"server error", middleware.LogEntry{Status: http.StatusServiceUnavailable}, "ERROR"},
+		{"handler error", middleware.LogEntry{Status: http.StatusOK, Error: "cache miss"}, "ERROR"},
+		{"trace severity", middleware.LogEntry{Status: http.StatusInternalServerError, Severity: middleware.SeverityTrace}, "DEBUG"},
+		{"debug severity", middleware.LogEntry{Status: http.StatusOK, Severity: middleware.SeverityDebug}, "DEBUG"},
+		{"info severity", middleware.LogEntry{Status: http.StatusBadGateway, Severity: middleware.SeverityInfo}, "INFO"},
+		{"warn severity", middleware.LogEntry{Status: http.StatusOK, Severity: middleware.SeverityWarn}, "WARNING"},
+		{"error severity", middleware.LogEntry{Status: http.StatusOK, Severity: middleware.SeverityError}, "ERROR"},
+		{"fatal severity", middleware.LogEntry{Status: http.StatusOK, Severity: middleware.SeverityFatal}, "CRITICAL"},
+		{"unknown severity", middleware.LogEntry{Status: http.StatusConflict, Severity: "NOTICE"}, "WARNING"},
+	}
+	for _, tt := range tests {
+		if got := Severity(tt.entry); got != tt.want {
+			t.Errorf("%s: Severity = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTraceName(t *testing.T) {
+	if got := TraceName("my-project", "4bf92f3577b34da6a3ce929d0e0e4736"); got != "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceName = %q", got)
+	}
+	if got := TraceName("my-project", ""); got != "" {
+		t.Errorf("TraceName without a trace ID = %q, want empty", got)
+	}
+}
+
+// newTestSink returns a sink writing to a server that records each request and
+// responds with status
+func newTestSink(t *testing.T, status int) (*CloudLoggingSink, *[]*http.Request, *[]writeRequest) {
+	t.Helper()
+	var requests []*http.Request
+	var bodies []writeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body writeRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	sink := NewCloudLoggingSink("my-project", "requests")
+	sink.Endpoint = server.URL
+	sink.Token = func(context.Context) (string, error) { return "test-token", nil }
+	return sink, &requests, &bodies
+}
+
+func TestSendBatchLogs(t *testing.T) {
+	sink, requests, bodies := newTestSink(t, http.StatusOK)
+	sink.SyntheticLabel = true
+
+	entries := []middleware.LogEntry{
+		{
+			Timestamp:   time.Date(2026, 3, 1, 12, 30, 0, 500, time.FixedZone("CET", 3600)),
+			TraceID:     "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanID:      "00f067aa0ba902b7",
+			ServiceName: "orders",
+			Environment: "prod",
+			Method:      http.MethodGet,
+			Path:        "/orders/7",
+			Status:      http.StatusNotFound,
+			Latency:     12.5,
+			ClientIP:    "81.2.69.142",
+		},
+		{
+			Timestamp:   time.Date(2026, 3, 1, 12, 30, 1, 0, time.UTC),
+			ServiceName: "orders",
+			Environment: "prod",
+			Status:      http.StatusBadGateway,
+			Synthetic:   true,
+		},
+	}
+	if err := sink.SendBatchLogsContext(context.Background(), entries); err != nil {
+		t.Fatalf("SendBatchLogsContext: %v", err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("sent %d requests, want one for the batch", len(*requests))
+	}
+	if auth := (*requests)[0].Header.Get("Authorization"); auth != "Bearer test-token" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	body := (*bodies)[0]
+	if body.LogName != "projects/my-project/logs/requests" || !body.PartialSuccess {
+		t.Errorf("logName %q, partialSuccess %t", body.LogName, body.PartialSuccess)
+	}
+	if len(body.Entries) != 2 {
+		t.Fatalf("wrote %d entries, want 2", len(body.Entries))
+	}
+
+	first := body.Entries[0]
+	if first.Trace != "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736" || first.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("trace %q, spanId %q", first.Trace, first.SpanID)
+	}
+	if first.Severity != "WARNING" || first.Timestamp != "2026-03-01T11:30:00.0000005Z" {
+		t.Errorf("severity %s, timestamp %s", first.Severity, first.Timestamp)
+	}
+	if req := first.HTTPRequest; req.Status != http.StatusNotFound || req.Latency != "0.012500s" || req.RemoteIP != "81.2.69.142" {
+		t.Errorf("httpRequest = %+v", req)
+	}
+	if first.JSONPayload.TraceID != entries[0].TraceID {
+		t.Errorf("jsonPayload = %+v, want the entry", first.JSONPayload)
+	}
+	if _, ok := first.Labels["synthetic"]; ok {
+		t.Errorf("labels = %v, want no synthetic label", first.Labels)
+	}
+
+	second := body.Entries[1]
+	if second.Trace != "" || second.Severity != "ERROR" {
+		t.Errorf("entry without a trace: trace %q, severity %s", second.Trace, second.Severity)
+	}
+	if second.Labels["synthetic"] != "true" || second.Labels["service"] != "orders" {
+		t.Errorf("labels = %v", second.Labels)
+	}
+}
+
+func TestSendBatchLogsErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusForbidden, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, tt := range tests {
+		sink, _, _ := newTestSink(t, tt.status)
+		err := sink.SendLogContext(context.Background(), middleware.LogEntry{Status: http.StatusOK})
+
+		var writeErr *WriteError
+		if !errors.As(err, &writeErr) {
+			t.Fatalf("status %d: error %v, want a *WriteError", tt.status, err)
+		}
+		if writeErr.StatusCode != tt.status || writeErr.Retryable() != tt.retryable {
+			t.Errorf("status %d: got status %d, retryable %t, want retryable %t", tt.status, writeErr.StatusCode, writeErr.Retryable(), tt.retryable)
+		}
+	}
+
+	sink, requests, _ := newTestSink(t, http.StatusOK)
+	sink.Token = func(context.Context) (string, error) { return "", errors.New("metadata server unreachable") }
+	err := sink.SendLogContext(context.Background(), middleware.LogEntry{})
+	var writeErr *WriteError
+	if !errors.As(err, &writeErr) || !writeErr.Retryable() {
+		t.Errorf("token failure: error %v, want a retryable *WriteError", err)
+	}
+	if len(*requests) != 0 {
+		t.Errorf("sent %d requests without a token", len(*requests))
+	}
+}
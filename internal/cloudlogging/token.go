@@ -0,0 +1,67 @@
+package cloudlogging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metadataTokenURL serves the default service account's access token on GCE, GKE and Cloud Run
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// tokenExpiryMargin refreshes tokens this long before they expire
+const tokenExpiryMargin = time.Minute
+
+// metadataTokenSource fetches and caches access tokens from the metadata server
+type metadataTokenSource struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newMetadataTokenSource(client *http.Client) *metadataTokenSource {
+	return &metadataTokenSource{client: client}
+}
+
+// Token returns a cached access token, fetching a new one when it is about to expire
+func (m *metadataTokenSource) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && time.Now().Before(m.expires) {
+		return m.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to query metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode metadata token: %w", err)
+	}
+
+	m.token = body.AccessToken
+	m.expires = time.Now().Add(time.Duration(body.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return m.token, nil
+}
@@ -1,11 +1,19 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/nats-io/nats.go"
+	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 // Config stores all application configuration
@@ -17,47 +25,638 @@ type Config struct {
 
 	// NATS settings
 	NatsURL         string
+	NatsServers     []string
 	NatsStreamName  string
 	NatsSubjects    []string
-	NatsStorageType nats.StorageType
+	NatsStorageType jetstream.StorageType
 	NatsMaxAge      time.Duration
 	NatsReplicas    int
+	NatsJSDomain    string
+	NatsJSAPIPrefix string
+
+	// NATS TLS and credentials
+	NatsTLSCAFile   string
+	NatsTLSCertFile string
+	NatsTLSKeyFile  string
+	NatsCredsFile   string
+	NatsUser        string
+	NatsPassword    string
+	NatsNKeySeed    string
+
+	// Secondary NATS cluster used for failover publishing
+	NatsSecondaryURL      string
+	NatsSecondaryServers  []string
+	NatsFailoverThreshold int
+
+	// Dead-letter stream for entries Loki permanently rejects
+	NatsDLQStream  string
+	NatsDLQSubject string
+
+	// Consumer settings
+	ConsumerName          string
+	ConsumerSink          string
+	ConsumerPushFallback  bool
+	ConsumerSubjectLabels bool
+	ConsumerMaxAckPending int
+	ConsumerAckWait       time.Duration
+	ConsumerBatchSize     int
+	ConsumerBatchTimeout  time.Duration
+	ConsumerWorkers       int
+	ConsumerLogLevel      string
+	ConsumerLogFormat     string
+	ConsumerLagInterval   time.Duration
+	ConsumerMetricsAddr   string
+
+	// ConsumerPriority sends error entries ahead of others; an entry passed over for
+	// ConsumerPriorityMaxWait goes first
+	ConsumerPriority        bool
+	ConsumerPriorityMaxWait time.Duration
+
+	// ConsumerPushFlowControl has the server pace the push fallback's delivery to what the
+	// consumer keeps up with; ConsumerPushHeartbeat is its idle heartbeat, which detects a
+	// stalled delivery and which flow control relies on
+	ConsumerPushFlowControl bool
+	ConsumerPushHeartbeat   time.Duration
+
+	// ConsumerSynthetic is what the consumer does with synthetic entries: keep, drop, or
+	// route them to their own stream with a synthetic="true" label
+	ConsumerSynthetic string
+
+	// ConsumerOrderWindow holds each batch at least this long and sends it in event-time
+	// order, so entries from different services interleave correctly; zero disables it
+	ConsumerOrderWindow time.Duration
+
+	// ConsumerCoalesceWindow holds each batch at least this long and sends identical error
+	// entries within it as one entry with an occurrences count; zero disables it
+	ConsumerCoalesceWindow time.Duration
+
+	// GeoIPDBPath is a MaxMind City database used to add country and city to entries;
+	// empty disables enrichment
+	GeoIPDBPath string
+
+	// Google Cloud Logging sink
+	GCPProjectID string
+	GCPLogName   string
+
+	// ReadyCheckLoki makes the API's readiness probe also require Loki's /ready endpoint,
+	// which LokiReadyURL sets when it can't be derived from LokiURL
+	ReadyCheckLoki bool
+	LokiReadyURL   string
+
+	// StatusMaxDropRate is the ratio of log entries dropped by the async logger within
+	// StatusDropWindow above which the status endpoint reports degraded; zero disables it
+	StatusMaxDropRate float64
+	StatusDropWindow  time.Duration
+	// StatusErrorWindow is the window the publish error rate is measured over
+	StatusErrorWindow time.Duration
 
 	// Tracing settings
 	JaegerURL string
+	// TracingSampleRatio is the fraction of root spans sampled; child spans follow their parent
+	TracingSampleRatio float64
 
 	// Loki settings
-	LokiURL string
+	LokiURL           string
+	LokiEncoding      string
+	LokiLabelPrefix   string
+	LokiQueryURL      string
+	LokiMaxAttempts   int
+	LokiRetryDelay    time.Duration
+	LokiTimeout       time.Duration
+	LokiVerifyRate    float64
+	LokiVerifyDelay   time.Duration
+	LokiTenantField   string
+	LokiDefaultTenant string
+	LokiOutOfOrder    bool
+	LokiPushMethod    string
+	LokiMaxLabelValue int
+
+	// Resource label derived from a URL path segment, bounded to an allowlist
+	LokiResourceSegment   int
+	LokiResourceAllowlist []string
+
+	// Labels taken from request headers, bounded to the allowed values of each label
+	LokiHeaderLabels map[string][]string
+
+	// Circuit breaker failing pushes fast after LokiBreakerThreshold consecutive failures
+	LokiBreakerThreshold int
+	LokiBreakerCooldown  time.Duration
+
+	// Logger middleware settings
+	LogFormat                string
+	LogSpanEvents            bool
+	LogTenantBaggageKey      string
+	LogBaggageKeys           []string
+	LogBaggageAll            bool
+	LogSyntheticHeader       string
+	LogSyntheticUserAgents   []string
+	LogHeaderLabels          map[string]string
+	LogRetryHeader           string
+	LogRequestIDHeader       string
+	LogSkipPaths             []string
+	LogSampleRate            float64
+	LogSampler               string
+	LogSampleLimit           int
+	LogTrailingSlash         string
+	LogFallbackIDFormat      string
+	LogRedactHeaders         []string
+	LogRedactAll             bool
+	LogAllowHeaders          []string
+	LogMaskBodyFields        []string
+	LogResponseBodyTypes     []string
+	LogMaxHeaderBytes        int
+	LogMaxRequestBodyBytes   int
+	LogMaxResponseBodyBytes  int
+	LogMaxMessageBytes       int
+	LogReportInterval        time.Duration
+	LogAsync                 bool
+	LogAsyncBufferSize       int
+	LogAsyncWorkers          int
+	LogPublishMaxAttempts    int
+	LogPublishBaseDelay      time.Duration
+	LogMaxConcurrentCaptures int
+
+	// LogDiskBufferPath is a local file holding entries NATS couldn't take until it is back,
+	// capped at LogDiskBufferMaxBytes; empty disables it
+	LogDiskBufferPath     string
+	LogDiskBufferMaxBytes int
+
+	// parseErrs holds environment variables that were set but couldn't be parsed;
+	// Validate reports them
+	parseErrs []error
 }
 
-func Load() *Config {
-	// Set defaults
+// Load reads the configuration from the environment and, when CONFIG_FILE names one, a
+// dotenv, YAML or JSON file; variables set in the environment take precedence over the
+// file. A file that can't be read or parsed is an error. The file is re-read on every
+// Load so a reload picks up edits.
+func Load() (*Config, error) {
+	file := os.Getenv("CONFIG_FILE")
+	if file == "" {
+		return load(&envParser{}), nil
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".json":
+		return LoadFromFile(file)
+	default:
+		values, err := godotenv.Read(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", file, err)
+		}
+		return load(&envParser{file: values}), nil
+	}
+}
+
+// load builds the configuration from the parser's variables
+func load(env *envParser) *Config {
+	// Set defaults, collecting variables that are set but can't be parsed
 	config := &Config{
-		ServiceName:     getEnv("SERVICE_NAME", "microservice"),
-		Environment:     getEnv("ENVIRONMENT", "development"),
-		Port:            getEnvAsInt("PORT", 8080),
-		NatsURL:         getEnv("NATS_URL", "nats://localhost:4222"),
-		NatsStreamName:  getEnv("NATS_STREAM", "logs"),
-		NatsSubjects:    []string{getEnv("NATS_SUBJECT", "logs.>")},
-		NatsStorageType: nats.FileStorage,
-		NatsMaxAge:      getEnvAsDuration("NATS_MAX_AGE", 7*24*time.Hour), // 7 days
-		NatsReplicas:    getEnvAsInt("NATS_REPLICAS", 1),
-		JaegerURL:       getEnv("JAEGER_URL", "localhost:4317"),
-		LokiURL:         getEnv("LOKI_URL", "http://localhost:3100/loki/api/v1/push"),
+		ServiceName:     env.getEnv("SERVICE_NAME", "microservice"),
+		Environment:     env.getEnv("ENVIRONMENT", "development"),
+		Port:            env.getEnvAsInt("PORT", 8080),
+		NatsURL:         env.getEnv("NATS_URL", "nats://localhost:4222"),
+		NatsServers:     env.getEnvAsSlice("NATS_SERVERS", nil),
+		NatsStreamName:  env.getEnv("NATS_STREAM", "logs"),
+		NatsSubjects:    env.getEnvAsSlice("NATS_SUBJECTS", []string{env.getEnv("NATS_SUBJECT", "logs.>")}),
+		NatsStorageType: jetstream.FileStorage,
+		NatsMaxAge:      env.getEnvAsDuration("NATS_MAX_AGE", 7*24*time.Hour), // 7 days
+		NatsReplicas:    env.getEnvAsInt("NATS_REPLICAS", 1),
+		NatsJSDomain:    env.getEnv("NATS_JS_DOMAIN", ""),
+		NatsJSAPIPrefix: env.getEnv("NATS_JS_API_PREFIX", ""),
+		JaegerURL:       env.getEnv("JAEGER_URL", "localhost:4317"),
+		ReadyCheckLoki:  env.getEnvAsBool("READY_CHECK_LOKI", false),
+		LokiURL:         env.getEnv("LOKI_URL", "http://localhost:3100/loki/api/v1/push"),
+		LokiReadyURL:    env.getEnv("LOKI_READY_URL", ""),
+
+		TracingSampleRatio: env.getEnvAsFloat("OTEL_TRACES_SAMPLER_ARG", 1.0),
+		StatusMaxDropRate:  env.getEnvAsFloat("STATUS_MAX_DROP_RATE", 0.01),
+		StatusDropWindow:   env.getEnvAsDuration("STATUS_DROP_WINDOW", 5*time.Minute),
+		StatusErrorWindow:  env.getEnvAsDuration("STATUS_ERROR_WINDOW", 5*time.Minute),
+
+		NatsTLSCAFile:   env.getEnv("NATS_TLS_CA_FILE", ""),
+		NatsTLSCertFile: env.getEnv("NATS_TLS_CERT_FILE", ""),
+		NatsTLSKeyFile:  env.getEnv("NATS_TLS_KEY_FILE", ""),
+		NatsCredsFile:   env.getEnv("NATS_CREDS_FILE", ""),
+		NatsUser:        env.getEnv("NATS_USER", ""),
+		NatsPassword:    env.getEnv("NATS_PASSWORD", ""),
+		NatsNKeySeed:    env.getEnv("NATS_NKEY_SEED", ""),
+
+		NatsSecondaryURL:      env.getEnv("NATS_SECONDARY_URL", ""),
+		NatsSecondaryServers:  env.getEnvAsSlice("NATS_SECONDARY_SERVERS", nil),
+		NatsFailoverThreshold: env.getEnvAsInt("NATS_FAILOVER_THRESHOLD", 3),
+
+		NatsDLQStream:  env.getEnv("NATS_DLQ_STREAM", "logs_dlq"),
+		NatsDLQSubject: env.getEnv("NATS_DLQ_SUBJECT", "dlq.logs"),
+
+		ConsumerName:          env.getEnv("CONSUMER_NAME", "loki-consumer"),
+		ConsumerSink:          env.getEnv("CONSUMER_SINK", "loki"),
+		ConsumerPushFallback:  env.getEnvAsBool("CONSUMER_PUSH_FALLBACK", true),
+		ConsumerSubjectLabels: env.getEnvAsBool("CONSUMER_SERVICE_FROM_SUBJECT", false),
+		ConsumerLogLevel:      env.getEnv("CONSUMER_LOG_LEVEL", "info"),
+		ConsumerLogFormat:     env.getEnv("CONSUMER_LOG_FORMAT", "json"),
+		ConsumerAckWait:       env.getEnvAsDuration("CONSUMER_ACK_WAIT", time.Minute),
+		ConsumerBatchSize:     env.getEnvAsInt("CONSUMER_BATCH_SIZE", 100),
+		ConsumerBatchTimeout:  env.getEnvAsDuration("CONSUMER_BATCH_TIMEOUT", time.Second),
+		ConsumerWorkers:       env.getEnvAsInt("CONSUMER_WORKERS", 1),
+		ConsumerLagInterval:   env.getEnvAsDuration("CONSUMER_LAG_INTERVAL", 30*time.Second),
+		ConsumerMetricsAddr:   env.getEnv("CONSUMER_METRICS_ADDR", ""),
+
+		ConsumerPriority:        env.getEnvAsBool("CONSUMER_PRIORITY", false),
+		ConsumerPriorityMaxWait: env.getEnvAsDuration("CONSUMER_PRIORITY_MAX_WAIT", 10*time.Second),
+
+		ConsumerPushFlowControl: env.getEnvAsBool("CONSUMER_PUSH_FLOW_CONTROL", true),
+		ConsumerPushHeartbeat:   env.getEnvAsDuration("CONSUMER_PUSH_HEARTBEAT", 15*time.Second),
+
+		ConsumerSynthetic: env.getEnv("CONSUMER_SYNTHETIC", "keep"),
+
+		ConsumerOrderWindow: env.getEnvAsDuration("CONSUMER_ORDER_WINDOW", 0),
+
+		ConsumerCoalesceWindow: env.getEnvAsDuration("CONSUMER_COALESCE_WINDOW", 0),
+
+		GeoIPDBPath: env.getEnv("GEOIP_DB_PATH", ""),
+
+		GCPProjectID: env.getEnv("GCP_PROJECT_ID", ""),
+		GCPLogName:   env.getEnv("GCP_LOG_NAME", "logtrace"),
+
+		LokiEncoding:      env.getEnv("LOKI_ENCODING", "json"),
+		LokiLabelPrefix:   env.getEnv("LOKI_LABEL_PREFIX", ""),
+		LokiQueryURL:      env.getEnv("LOKI_QUERY_URL", ""),
+		LokiMaxAttempts:   env.getEnvAsInt("LOKI_MAX_ATTEMPTS", 3),
+		LokiRetryDelay:    env.getEnvAsDuration("LOKI_RETRY_BASE_DELAY", 500*time.Millisecond),
+		LokiTimeout:       env.getEnvAsDuration("LOKI_TIMEOUT", 10*time.Second),
+		LokiVerifyRate:    env.getEnvAsFloat("LOKI_VERIFY_SAMPLE_RATE", 0),
+		LokiVerifyDelay:   env.getEnvAsDuration("LOKI_VERIFY_DELAY", 30*time.Second),
+		LokiTenantField:   env.getEnv("LOKI_TENANT_FIELD", ""),
+		LokiDefaultTenant: env.getEnv("LOKI_DEFAULT_TENANT", ""),
+		LokiOutOfOrder:    env.getEnvAsBool("LOKI_OUT_OF_ORDER", false),
+		LokiPushMethod:    env.getEnv("LOKI_PUSH_METHOD", http.MethodPost),
+		LokiMaxLabelValue: env.getEnvAsInt("LOKI_MAX_LABEL_VALUE_LENGTH", 2048),
+
+		LokiResourceSegment:   env.getEnvAsInt("LOKI_RESOURCE_SEGMENT", 2),
+		LokiResourceAllowlist: env.getEnvAsSlice("LOKI_RESOURCE_ALLOWLIST", nil),
+
+		LokiHeaderLabels: labelValues(env.getEnvAsMap("LOKI_HEADER_LABELS")),
+
+		LokiBreakerThreshold: env.getEnvAsInt("LOKI_BREAKER_THRESHOLD", 5),
+		LokiBreakerCooldown:  env.getEnvAsDuration("LOKI_BREAKER_COOLDOWN", 30*time.Second),
+
+		LogFormat:                env.getEnv("LOG_FORMAT", "json"),
+		LogSpanEvents:            env.getEnvAsBool("LOG_SPAN_EVENTS", false),
+		LogTenantBaggageKey:      env.getEnv("LOG_TENANT_BAGGAGE_KEY", ""),
+		LogBaggageKeys:           env.getEnvAsSlice("LOG_BAGGAGE_KEYS", nil),
+		LogBaggageAll:            env.getEnvAsBool("LOG_BAGGAGE_ALL", false),
+		LogSyntheticHeader:       env.getEnv("LOG_SYNTHETIC_HEADER", "X-Synthetic"),
+		LogSyntheticUserAgents:   env.getEnvAsSlice("LOG_SYNTHETIC_USER_AGENTS", []string{"kube-probe/", "ELB-HealthChecker/", "GoogleHC/"}),
+		LogHeaderLabels:          env.getEnvAsMap("LOG_HEADER_LABELS"),
+		LogRetryHeader:           env.getEnv("LOG_RETRY_HEADER", "X-Retry-Attempt"),
+		LogRequestIDHeader:       env.getEnv("LOG_REQUEST_ID_HEADER", "X-Request-ID"),
+		LogSkipPaths:             env.getEnvAsSlice("LOG_SKIP_PATHS", []string{"/ping", "/readyz"}),
+		LogSampler:               env.getEnv("LOG_SAMPLER", "rate"),
+		LogSampleLimit:           env.getEnvAsInt("LOG_SAMPLE_LIMIT", 100),
+		LogTrailingSlash:         env.getEnv("LOG_TRAILING_SLASH", "keep"),
+		LogFallbackIDFormat:      env.getEnv("LOG_FALLBACK_ID_FORMAT", "otel"),
+		LogRedactHeaders:         env.getEnvAsSlice("LOG_REDACT_HEADERS", nil),
+		LogRedactAll:             env.getEnvAsBool("LOG_REDACT_ALL", false),
+		LogAllowHeaders:          env.getEnvAsSlice("LOG_ALLOW_HEADERS", nil),
+		LogMaskBodyFields:        env.getEnvAsSlice("LOG_MASK_BODY_FIELDS", nil),
+		LogResponseBodyTypes:     env.getEnvAsSlice("LOG_RESPONSE_BODY_TYPES", nil),
+		LogMaxHeaderBytes:        env.getEnvAsInt("LOG_MAX_HEADER_BYTES", 10000),
+		LogMaxRequestBodyBytes:   env.getEnvAsInt("LOG_MAX_REQUEST_BODY_BYTES", 10000),
+		LogMaxResponseBodyBytes:  env.getEnvAsInt("LOG_MAX_RESPONSE_BODY_BYTES", 10000),
+		LogMaxMessageBytes:       env.getEnvAsInt("LOG_MAX_MESSAGE_BYTES", 900*1024),
+		LogReportInterval:        env.getEnvAsDuration("LOG_REPORT_INTERVAL", time.Minute),
+		LogAsync:                 env.getEnvAsBool("LOG_ASYNC", false),
+		LogAsyncBufferSize:       env.getEnvAsInt("LOG_ASYNC_BUFFER_SIZE", 1024),
+		LogAsyncWorkers:          env.getEnvAsInt("LOG_ASYNC_WORKERS", 2),
+		LogPublishMaxAttempts:    env.getEnvAsInt("LOG_PUBLISH_MAX_ATTEMPTS", 3),
+		LogPublishBaseDelay:      env.getEnvAsDuration("LOG_PUBLISH_BASE_DELAY", 50*time.Millisecond),
+		LogMaxConcurrentCaptures: env.getEnvAsInt("LOG_MAX_CONCURRENT_CAPTURES", 0),
+
+		LogDiskBufferPath:     env.getEnv("LOG_DISK_BUFFER_PATH", ""),
+		LogDiskBufferMaxBytes: env.getEnvAsInt("LOG_DISK_BUFFER_MAX_BYTES", 64<<20),
 	}
 
+	// Allow two batches per worker in flight unless set explicitly
+	config.ConsumerMaxAckPending = env.getEnvAsInt("CONSUMER_MAX_ACK_PENDING", 2*config.ConsumerBatchSize*max(config.ConsumerWorkers, 1))
+
+	// Sample according to the environment unless a rate is set explicitly
+	config.LogSampleRate = env.getEnvAsFloat("LOG_SAMPLE_RATE", defaultSampleRate(config.Environment))
+
 	// Parse storage type
-	storageTypeStr := getEnv("NATS_STORAGE_TYPE", "file")
-	if storageTypeStr == "memory" {
-		config.NatsStorageType = nats.MemoryStorage
+	switch storageTypeStr := env.getEnv("NATS_STORAGE_TYPE", "file"); storageTypeStr {
+	case "file":
+	case "memory":
+		config.NatsStorageType = jetstream.MemoryStorage
+	default:
+		env.errs = append(env.errs, fmt.Errorf("NATS_STORAGE_TYPE: %q is not file or memory", storageTypeStr))
 	}
 
+	config.parseErrs = env.errs
 	return config
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
+// Validate reports every configuration value that can't be used, including environment
+// variables Load couldn't parse
+func (c *Config) Validate() error {
+	errs := slices.Clone(c.parseErrs)
+
+	if c.NatsURL == "" {
+		errs = append(errs, fmt.Errorf("NATS_URL is required"))
+	}
+	for _, server := range c.NatsServers {
+		if err := validateNATSURL(server); err != nil {
+			errs = append(errs, fmt.Errorf("NATS_SERVERS: %w", err))
+		}
+	}
+	if len(c.NatsSecondaryServers) > 0 && c.NatsSecondaryURL == "" {
+		errs = append(errs, fmt.Errorf("NATS_SECONDARY_SERVERS: set NATS_SECONDARY_URL to enable the secondary cluster"))
+	}
+	for _, server := range c.NatsSecondaryServers {
+		if err := validateNATSURL(server); err != nil {
+			errs = append(errs, fmt.Errorf("NATS_SECONDARY_SERVERS: %w", err))
+		}
+	}
+	if err := validateURL(c.LokiURL); err != nil {
+		errs = append(errs, fmt.Errorf("LOKI_URL: %w", err))
+	}
+	if c.LokiReadyURL != "" {
+		if err := validateURL(c.LokiReadyURL); err != nil {
+			errs = append(errs, fmt.Errorf("LOKI_READY_URL: %w", err))
+		}
+	}
+	if c.LokiPushMethod != http.MethodPost && c.LokiPushMethod != http.MethodPut {
+		errs = append(errs, fmt.Errorf("LOKI_PUSH_METHOD: %q is not POST or PUT", c.LokiPushMethod))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT: %d is not between 1 and 65535", c.Port))
+	}
+	if c.NatsReplicas < 1 {
+		errs = append(errs, fmt.Errorf("NATS_REPLICAS: %d must be at least 1", c.NatsReplicas))
+	}
+	if c.ConsumerBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("CONSUMER_BATCH_SIZE: %d must be at least 1", c.ConsumerBatchSize))
+	}
+	if c.ConsumerWorkers < 1 {
+		errs = append(errs, fmt.Errorf("CONSUMER_WORKERS: %d must be at least 1", c.ConsumerWorkers))
+	}
+	if c.ConsumerPushHeartbeat < 500*time.Millisecond || c.ConsumerPushHeartbeat > 30*time.Second {
+		errs = append(errs, fmt.Errorf("CONSUMER_PUSH_HEARTBEAT: %s is not between 500ms and 30s", c.ConsumerPushHeartbeat))
+	}
+	if c.ConsumerAckWait <= 0 {
+		errs = append(errs, fmt.Errorf("CONSUMER_ACK_WAIT: %s must be positive", c.ConsumerAckWait))
+	}
+	if c.LokiBreakerThreshold < 0 {
+		errs = append(errs, fmt.Errorf("LOKI_BREAKER_THRESHOLD: %d must not be negative", c.LokiBreakerThreshold))
+	}
+	if c.LokiBreakerThreshold > 0 && c.LokiBreakerCooldown <= 0 {
+		errs = append(errs, fmt.Errorf("LOKI_BREAKER_COOLDOWN: %s must be positive", c.LokiBreakerCooldown))
+	}
+	if c.LokiMaxLabelValue < 1 {
+		errs = append(errs, fmt.Errorf("LOKI_MAX_LABEL_VALUE_LENGTH: %d must be at least 1", c.LokiMaxLabelValue))
+	}
+	if c.LokiTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("LOKI_TIMEOUT: %s must be positive", c.LokiTimeout))
+	}
+	if c.ConsumerBatchTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("CONSUMER_BATCH_TIMEOUT: %s must be positive", c.ConsumerBatchTimeout))
+	}
+	if c.ConsumerOrderWindow < 0 {
+		errs = append(errs, fmt.Errorf("CONSUMER_ORDER_WINDOW: %s must not be negative", c.ConsumerOrderWindow))
+	}
+	if c.ConsumerCoalesceWindow < 0 {
+		errs = append(errs, fmt.Errorf("CONSUMER_COALESCE_WINDOW: %s must not be negative", c.ConsumerCoalesceWindow))
+	}
+	if c.ConsumerPriority && c.ConsumerPriorityMaxWait <= 0 {
+		errs = append(errs, fmt.Errorf("CONSUMER_PRIORITY_MAX_WAIT: %s must be positive", c.ConsumerPriorityMaxWait))
+	}
+	switch c.ConsumerSynthetic {
+	case "keep", "drop", "route":
+	default:
+		errs = append(errs, fmt.Errorf("CONSUMER_SYNTHETIC: %q is not keep, drop or route", c.ConsumerSynthetic))
+	}
+	switch c.LokiTenantField {
+	case "", "tenant", "environment", "service", "service_name":
+	default:
+		errs = append(errs, fmt.Errorf("LOKI_TENANT_FIELD: %q is not tenant, environment, service or service_name", c.LokiTenantField))
+	}
+	switch c.LogSampler {
+	case "rate", "always", "never", "errors", "ratelimit":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_SAMPLER: %q is not rate, always, never, errors or ratelimit", c.LogSampler))
+	}
+	if c.LogDiskBufferPath != "" && c.LogDiskBufferMaxBytes < 1<<20 {
+		errs = append(errs, fmt.Errorf("LOG_DISK_BUFFER_MAX_BYTES: %d must be at least 1MB", c.LogDiskBufferMaxBytes))
+	}
+	if c.LogSampler == "ratelimit" && c.LogSampleLimit < 1 {
+		errs = append(errs, fmt.Errorf("LOG_SAMPLE_LIMIT: %d must be at least 1", c.LogSampleLimit))
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("OTEL_TRACES_SAMPLER_ARG: %g is not between 0 and 1", c.TracingSampleRatio))
+	}
+	if c.StatusMaxDropRate < 0 || c.StatusMaxDropRate > 1 {
+		errs = append(errs, fmt.Errorf("STATUS_MAX_DROP_RATE: %g is not between 0 and 1", c.StatusMaxDropRate))
+	}
+	// The logger keeps drop counts for the last 10 minutes (middleware.MaxDropWindow)
+	if c.StatusDropWindow <= 0 || c.StatusDropWindow > 10*time.Minute {
+		errs = append(errs, fmt.Errorf("STATUS_DROP_WINDOW: %s must be positive and at most 10m", c.StatusDropWindow))
+	}
+	if c.StatusErrorWindow <= 0 || c.StatusErrorWindow > 10*time.Minute {
+		errs = append(errs, fmt.Errorf("STATUS_ERROR_WINDOW: %s must be positive and at most 10m", c.StatusErrorWindow))
+	}
+
+	for label := range c.LokiHeaderLabels {
+		if reservedLabels[label] {
+			errs = append(errs, fmt.Errorf("LOKI_HEADER_LABELS: %q is a built-in label", label))
+		}
+	}
+	for header, label := range c.LogHeaderLabels {
+		if reservedLabels[label] {
+			errs = append(errs, fmt.Errorf("LOG_HEADER_LABELS: %s maps to the built-in label %q", header, label))
+		}
+	}
+
+	if len(c.NatsSubjects) == 0 {
+		errs = append(errs, fmt.Errorf("no NATS subjects configured"))
+	}
+	for _, subject := range c.NatsSubjects {
+		if err := validateSubject(subject); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Warnings reports usable settings that are likely to misbehave
+func (c *Config) Warnings() []string {
+	var warnings []string
+
+	// An entry must be acked before AckWait runs out, or NATS redelivers it while the
+	// push is still going and the redeliveries pile onto a Loki that is already slow
+	if c.ConsumerSink != "cloudlogging" {
+		if worst := c.consumerAckDeadline(); c.ConsumerAckWait < worst {
+			warnings = append(warnings, fmt.Sprintf(
+				"CONSUMER_ACK_WAIT (%s) is below the worst-case time to send a batch (%s: batching plus %d Loki attempts of LOKI_TIMEOUT and their backoff); slow pushes will be redelivered",
+				c.ConsumerAckWait, worst, max(c.LokiMaxAttempts, 1)))
+		}
+	}
+
+	// The ready and query endpoints are derived by replacing the push path, which only
+	// works when LOKI_URL ends with it
+	if c.ConsumerSink != "cloudlogging" || c.ReadyCheckLoki {
+		if warning := lokiURLWarning(c.LokiURL, c.LokiReadyURL, c.LokiQueryURL); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	return warnings
+}
+
+// lokiPushPath is the path of Loki's push endpoint
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiURLWarning warns when pushURL doesn't end with Loki's push path, e.g. a proxy
+// rewriting it, naming the derived endpoints that aren't set explicitly
+func lokiURLWarning(pushURL, readyURL, queryURL string) string {
+	u, err := url.Parse(pushURL)
+	if err != nil || strings.HasSuffix(strings.TrimSuffix(u.Path, "/"), lokiPushPath) {
+		return ""
+	}
+
+	warning := fmt.Sprintf("LOKI_URL (%s) doesn't end with %s; check it points at Loki's push endpoint", pushURL, lokiPushPath)
+	var derived []string
+	if readyURL == "" {
+		derived = append(derived, "LOKI_READY_URL")
+	}
+	if queryURL == "" {
+		derived = append(derived, "LOKI_QUERY_URL")
+	}
+	if len(derived) > 0 {
+		warning += ", and set " + strings.Join(derived, " and ") + " if a proxy rewrites the path"
+	}
+	return warning
+}
+
+// consumerAckDeadline estimates the longest an entry waits between delivery and its ack:
+// the batching wait, then every Loki attempt timing out with the largest backoff between
+func (c *Config) consumerAckDeadline() time.Duration {
+	wait := max(c.ConsumerBatchTimeout, c.ConsumerOrderWindow, c.ConsumerCoalesceWindow)
+	if c.ConsumerPriority {
+		wait = max(wait, c.ConsumerPriorityMaxWait)
+	}
+
+	attempts := max(c.LokiMaxAttempts, 1)
+	push := time.Duration(attempts) * c.LokiTimeout
+	for attempt := 1; attempt < attempts; attempt++ {
+		// Backoff doubles per attempt and adds up to 50% jitter
+		push += c.LokiRetryDelay << (attempt - 1) * 3 / 2
+	}
+	return wait + push
+}
+
+// validateURL checks rawURL is an absolute http(s) URL
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", rawURL)
+	}
+	return nil
+}
+
+// validateNATSURL checks rawURL is a NATS server URL (nats, tls, ws or wss)
+func validateNATSURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "nats", "tls", "ws", "wss":
+	default:
+		return fmt.Errorf("%q is not a nats://, tls://, ws:// or wss:// URL", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", rawURL)
+	}
+	return nil
+}
+
+// reservedLabels are the Loki labels the consumer sets itself
+var reservedLabels = map[string]bool{"service": true, "environment": true, "resource": true, "synthetic": true}
+
+// labelValues splits each label's |-separated allowed values, lower-cased to match the
+// header values the Logger records
+func labelValues(labels map[string]string) map[string][]string {
+	if labels == nil {
+		return nil
+	}
+	values := make(map[string][]string, len(labels))
+	for label, allowed := range labels {
+		for _, value := range strings.Split(allowed, "|") {
+			if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+				values[label] = append(values[label], value)
+			}
+		}
+	}
+	return values
+}
+
+// validateSubject checks a NATS subject (with optional * and > wildcards) is well formed
+func validateSubject(subject string) error {
+	if strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid NATS subject %q: contains whitespace", subject)
+	}
+
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		switch {
+		case token == "":
+			return fmt.Errorf("invalid NATS subject %q: empty token", subject)
+		case token == ">" && i != len(tokens)-1:
+			return fmt.Errorf("invalid NATS subject %q: > must be the last token", subject)
+		case token != "*" && token != ">" && strings.ContainsAny(token, "*>"):
+			return fmt.Errorf("invalid NATS subject %q: wildcards must be whole tokens", subject)
+		}
+	}
+	return nil
+}
+
+// defaultSampleRate returns the log sample rate for an environment: everything in
+// development, half in staging and a tenth in production
+func defaultSampleRate(environment string) float64 {
+	switch strings.ToLower(environment) {
+	case "staging", "stage":
+		return 0.5
+	case "production", "prod":
+		return 0.1
+	default:
+		return 1
+	}
+}
+
+// envParser reads typed environment variables, recording those that are set but malformed
+// instead of silently using the default
+type envParser struct {
+	// file holds values from a config file by variable name, used when the
+	// environment doesn't set them
+	file map[string]string
+
+	errs []error
+}
+
+// invalid records a malformed variable; the caller falls back to its default
+func (p *envParser) invalid(key, value, kind string) {
+	p.errs = append(p.errs, fmt.Errorf("%s: %q is not a valid %s", key, value, kind))
+}
+
+// getEnv gets an environment variable, falling back to the config file, or returns a
+// default value
+func (p *envParser) getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
+	if value == "" {
+		value = p.file[key]
+	}
 	if value == "" {
 		return defaultValue
 	}
@@ -65,28 +664,94 @@ func getEnv(key, defaultValue string) string {
 }
 
 // getEnvAsInt gets an environment variable as an integer or returns a default value
-func getEnvAsInt(key string, defaultValue int) int {
-	valueStr := getEnv(key, "")
+func (p *envParser) getEnvAsInt(key string, defaultValue int) int {
+	valueStr := p.getEnv(key, "")
 	if valueStr == "" {
 		return defaultValue
 	}
 
 	value, err := strconv.Atoi(valueStr)
 	if err != nil {
+		p.invalid(key, valueStr, "integer")
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsFloat gets an environment variable as a float or returns a default value
+func (p *envParser) getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := p.getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		p.invalid(key, valueStr, "number")
 		return defaultValue
 	}
 	return value
 }
 
+// getEnvAsBool gets an environment variable as a boolean or returns a default value
+func (p *envParser) getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := p.getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		p.invalid(key, valueStr, "boolean")
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsSlice gets a comma-separated environment variable as a slice or returns a default value
+func (p *envParser) getEnvAsSlice(key string, defaultValue []string) []string {
+	valueStr := p.getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	var values []string
+	for _, v := range strings.Split(valueStr, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// getEnvAsMap gets a comma-separated list of name=value pairs as a map, or nil when unset
+func (p *envParser) getEnvAsMap(key string) map[string]string {
+	var values map[string]string
+	for _, pair := range p.getEnvAsSlice(key, nil) {
+		name, value, ok := strings.Cut(pair, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || value == "" {
+			p.invalid(key, pair, "name=value pair")
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[name] = value
+	}
+	return values
+}
+
 // getEnvAsDuration gets an environment variable as a duration or returns a default value
-func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
-	valueStr := getEnv(key, "")
+func (p *envParser) getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := p.getEnv(key, "")
 	if valueStr == "" {
 		return defaultValue
 	}
 
 	value, err := time.ParseDuration(valueStr)
 	if err != nil {
+		p.invalid(key, valueStr, "duration")
 		return defaultValue
 	}
 	return value
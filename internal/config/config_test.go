@@ -0,0 +1,387 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a config file named name and points CONFIG_FILE at it
+func writeConfigFile(t *testing.T, name, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+}
+
+// unsetEnv clears variables for the test; empty counts as unset
+func unsetEnv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "")
+	}
+}
+
+// loadWith loads the configuration with vars set in the environment, ignoring any config file
+func loadWith(t *testing.T, vars map[string]string) *Config {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for key, value := range vars {
+		t.Setenv(key, value)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return cfg
+}
+
+func TestLoadYAMLFile(t *testing.T) {
+	writeConfigFile(t, "config.yaml", `
+service_name: from-file
+nats_stream: from-file
+nats_subjects: [logs.>, audit.>]
+nats_max_age: 72h
+nats_replicas: 3
+`)
+	unsetEnv(t, "NATS_STREAM", "NATS_SUBJECTS", "NATS_SUBJECT", "NATS_MAX_AGE")
+	t.Setenv("SERVICE_NAME", "from-env")
+	t.Setenv("NATS_REPLICAS", "5")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := []string{"logs.>", "audit.>"}; !slices.Equal(cfg.NatsSubjects, want) {
+		t.Errorf("NatsSubjects = %v, want %v", cfg.NatsSubjects, want)
+	}
+	if cfg.NatsMaxAge != 72*time.Hour {
+		t.Errorf("NatsMaxAge = %s, want 72h", cfg.NatsMaxAge)
+	}
+	if cfg.NatsStreamName != "from-file" {
+		t.Errorf("NatsStreamName = %q, want the file's value", cfg.NatsStreamName)
+	}
+	// The environment wins over the file
+	if cfg.ServiceName != "from-env" || cfg.NatsReplicas != 5 {
+		t.Errorf("ServiceName = %q and NatsReplicas = %d, want the environment's from-env and 5", cfg.ServiceName, cfg.NatsReplicas)
+	}
+}
+
+func TestLoadDotenvFile(t *testing.T) {
+	writeConfigFile(t, "logtrace.env", "SERVICE_NAME=from-file\nNATS_STREAM=from-file\n")
+	unsetEnv(t, "NATS_STREAM")
+	t.Setenv("SERVICE_NAME", "from-env")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.ServiceName != "from-env" || cfg.NatsStreamName != "from-file" {
+		t.Errorf("ServiceName = %q and NatsStreamName = %q, want from-env and from-file", cfg.ServiceName, cfg.NatsStreamName)
+	}
+	if got := os.Getenv("NATS_STREAM"); got != "" {
+		t.Errorf("Load set NATS_STREAM=%q in the environment", got)
+	}
+}
+
+func TestLoadRejectsUnreadableFile(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{"yaml", "config.yaml", "nats_subjects: [logs.>\n"},
+		{"json", "config.json", `{"nats_subjects": ["logs.>"]`},
+		{"unsupported value", "config.yaml", "nats_subjects:\n  stream: logs\n"},
+		{"dotenv", "logtrace.env", "SERVICE_NAME=\"unterminated\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, tt.file, tt.content)
+			if cfg, err := Load(); err == nil {
+				t.Errorf("Load succeeded with %+v", cfg)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.env"))
+		if _, err := Load(); err == nil {
+			t.Error("Load succeeded without the config file")
+		}
+	})
+}
+
+func TestNatsServers(t *testing.T) {
+	cfg := loadWith(t, map[string]string{
+		"NATS_SERVERS":           "nats://a:4222, tls://b:4222",
+		"NATS_SECONDARY_URL":     "nats://standby:4222",
+		"NATS_SECONDARY_SERVERS": "nats://standby-2:4222",
+	})
+	if want := []string{"nats://a:4222", "tls://b:4222"}; !slices.Equal(cfg.NatsServers, want) {
+		t.Errorf("NatsServers = %v, want %v", cfg.NatsServers, want)
+	}
+	if want := []string{"nats://standby-2:4222"}; !slices.Equal(cfg.NatsSecondaryServers, want) {
+		t.Errorf("NatsSecondaryServers = %v, want %v", cfg.NatsSecondaryServers, want)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestNatsSubjects(t *testing.T) {
+	tests := []struct {
+		name     string
+		subjects string
+		subject  string
+		want     []string
+	}{
+		{name: "default", want: []string{"logs.>"}},
+		{name: "one subject", subjects: "logs.api", want: []string{"logs.api"}},
+		{name: "comma separated", subjects: "logs.api,audit.>", want: []string{"logs.api", "audit.>"}},
+		{name: "whitespace", subjects: " logs.api ,\taudit.> ", want: []string{"logs.api", "audit.>"}},
+		{name: "empty items", subjects: "logs.api,,audit.>,", want: []string{"logs.api", "audit.>"}},
+		{name: "only commas", subjects: " , ,", want: nil},
+		{name: "NATS_SUBJECT fallback", subject: "events.>", want: []string{"events.>"}},
+		{name: "NATS_SUBJECTS wins", subjects: "logs.api", subject: "events.>", want: []string{"logs.api"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadWith(t, map[string]string{"NATS_SUBJECTS": tt.subjects, "NATS_SUBJECT": tt.subject})
+			if !slices.Equal(cfg.NatsSubjects, tt.want) {
+				t.Errorf("NatsSubjects = %q, want %q", cfg.NatsSubjects, tt.want)
+			}
+			if err := cfg.Validate(); (err == nil) != (tt.want != nil) {
+				t.Errorf("Validate = %v with subjects %q", err, cfg.NatsSubjects)
+			}
+		})
+	}
+}
+
+func TestValidateTenantField(t *testing.T) {
+	for _, field := range []string{"", "tenant", "environment", "service", "service_name"} {
+		cfg := loadWith(t, map[string]string{"LOKI_TENANT_FIELD": field})
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("LOKI_TENANT_FIELD=%q: %v", field, err)
+		}
+	}
+	cfg := loadWith(t, map[string]string{"LOKI_TENANT_FIELD": "customer"})
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "LOKI_TENANT_FIELD") {
+		t.Errorf("LOKI_TENANT_FIELD=customer: Validate = %v, want it rejected", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		// modify changes what can't be set through the environment
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{name: "defaults"},
+		{name: "unparsable integer", vars: map[string]string{"PORT": "eighty"}, wantErr: "PORT"},
+		{name: "unparsable duration", vars: map[string]string{"NATS_MAX_AGE": "a week"}, wantErr: "NATS_MAX_AGE"},
+		{name: "unparsable boolean", vars: map[string]string{"LOG_ASYNC": "maybe"}, wantErr: "LOG_ASYNC"},
+		{name: "unparsable number", vars: map[string]string{"LOG_SAMPLE_RATE": "half"}, wantErr: "LOG_SAMPLE_RATE"},
+		{name: "malformed pair", vars: map[string]string{"LOG_HEADER_LABELS": "X-Canary"}, wantErr: "LOG_HEADER_LABELS"},
+		{name: "storage type", vars: map[string]string{"NATS_STORAGE_TYPE": "tape"}, wantErr: "NATS_STORAGE_TYPE"},
+		{name: "missing NATS URL", modify: func(c *Config) { c.NatsURL = "" }, wantErr: "NATS_URL"},
+		{name: "NATS seed server", vars: map[string]string{"NATS_SERVERS": "http://a:4222"}, wantErr: "NATS_SERVERS"},
+		{name: "secondary seeds without a secondary", vars: map[string]string{"NATS_SECONDARY_SERVERS": "nats://b:4222"}, wantErr: "NATS_SECONDARY_SERVERS"},
+		{name: "Loki URL", vars: map[string]string{"LOKI_URL": "loki:3100"}, wantErr: "LOKI_URL"},
+		{name: "Loki ready URL", vars: map[string]string{"LOKI_READY_URL": "/ready"}, wantErr: "LOKI_READY_URL"},
+		{name: "Loki push method", vars: map[string]string{"LOKI_PUSH_METHOD": "PATCH"}, wantErr: "LOKI_PUSH_METHOD"},
+		{name: "port", vars: map[string]string{"PORT": "70000"}, wantErr: "PORT"},
+		{name: "replicas", vars: map[string]string{"NATS_REPLICAS": "0"}, wantErr: "NATS_REPLICAS"},
+		{name: "batch size", vars: map[string]string{"CONSUMER_BATCH_SIZE": "0"}, wantErr: "CONSUMER_BATCH_SIZE"},
+		{name: "workers", vars: map[string]string{"CONSUMER_WORKERS": "0"}, wantErr: "CONSUMER_WORKERS"},
+		{name: "push heartbeat", vars: map[string]string{"CONSUMER_PUSH_HEARTBEAT": "1m"}, wantErr: "CONSUMER_PUSH_HEARTBEAT"},
+		{name: "ack wait", vars: map[string]string{"CONSUMER_ACK_WAIT": "-1s"}, wantErr: "CONSUMER_ACK_WAIT"},
+		{name: "breaker threshold", vars: map[string]string{"LOKI_BREAKER_THRESHOLD": "-1"}, wantErr: "LOKI_BREAKER_THRESHOLD"},
+		{name: "breaker cooldown", vars: map[string]string{"LOKI_BREAKER_COOLDOWN": "-1s"}, wantErr: "LOKI_BREAKER_COOLDOWN"},
+		{name: "breaker cooldown with the breaker off", vars: map[string]string{"LOKI_BREAKER_THRESHOLD": "0", "LOKI_BREAKER_COOLDOWN": "-1s"}},
+		{name: "label value length", vars: map[string]string{"LOKI_MAX_LABEL_VALUE_LENGTH": "0"}, wantErr: "LOKI_MAX_LABEL_VALUE_LENGTH"},
+		{name: "Loki timeout", vars: map[string]string{"LOKI_TIMEOUT": "-1s"}, wantErr: "LOKI_TIMEOUT"},
+		{name: "batch timeout", vars: map[string]string{"CONSUMER_BATCH_TIMEOUT": "-1s"}, wantErr: "CONSUMER_BATCH_TIMEOUT"},
+		{name: "order window", vars: map[string]string{"CONSUMER_ORDER_WINDOW": "-1s"}, wantErr: "CONSUMER_ORDER_WINDOW"},
+		{name: "coalesce window", vars: map[string]string{"CONSUMER_COALESCE_WINDOW": "-1s"}, wantErr: "CONSUMER_COALESCE_WINDOW"},
+		{name: "priority max wait", vars: map[string]string{"CONSUMER_PRIORITY": "true", "CONSUMER_PRIORITY_MAX_WAIT": "-1s"}, wantErr: "CONSUMER_PRIORITY_MAX_WAIT"},
+		{name: "priority max wait without priority", vars: map[string]string{"CONSUMER_PRIORITY_MAX_WAIT": "-1s"}},
+		{name: "synthetic", vars: map[string]string{"CONSUMER_SYNTHETIC": "hide"}, wantErr: "CONSUMER_SYNTHETIC"},
+		{name: "tenant field", vars: map[string]string{"LOKI_TENANT_FIELD": "customer"}, wantErr: "LOKI_TENANT_FIELD"},
+		{name: "sampler", vars: map[string]string{"LOG_SAMPLER": "sometimes"}, wantErr: "LOG_SAMPLER"},
+		{name: "disk buffer size", vars: map[string]string{"LOG_DISK_BUFFER_PATH": "/tmp/logs.buf", "LOG_DISK_BUFFER_MAX_BYTES": "1024"}, wantErr: "LOG_DISK_BUFFER_MAX_BYTES"},
+		{name: "disk buffer size without a buffer", vars: map[string]string{"LOG_DISK_BUFFER_MAX_BYTES": "1024"}},
+		{name: "sample limit", vars: map[string]string{"LOG_SAMPLER": "ratelimit", "LOG_SAMPLE_LIMIT": "0"}, wantErr: "LOG_SAMPLE_LIMIT"},
+		{name: "sample limit with another sampler", vars: map[string]string{"LOG_SAMPLE_LIMIT": "0"}},
+		{name: "trace sample ratio", vars: map[string]string{"OTEL_TRACES_SAMPLER_ARG": "1.5"}, wantErr: "OTEL_TRACES_SAMPLER_ARG"},
+		{name: "status drop rate", vars: map[string]string{"STATUS_MAX_DROP_RATE": "-0.1"}, wantErr: "STATUS_MAX_DROP_RATE"},
+		{name: "status drop window", vars: map[string]string{"STATUS_DROP_WINDOW": "11m"}, wantErr: "STATUS_DROP_WINDOW"},
+		{name: "status error window", vars: map[string]string{"STATUS_ERROR_WINDOW": "0s"}, wantErr: "STATUS_ERROR_WINDOW"},
+		{name: "header label name", vars: map[string]string{"LOKI_HEADER_LABELS": "service=api|web"}, wantErr: "LOKI_HEADER_LABELS"},
+		{name: "header label mapping", vars: map[string]string{"LOG_HEADER_LABELS": "X-Env=environment"}, wantErr: "LOG_HEADER_LABELS"},
+		{name: "no subjects", modify: func(c *Config) { c.NatsSubjects = nil }, wantErr: "no NATS subjects"},
+		{name: "subject with whitespace", vars: map[string]string{"NATS_SUBJECTS": "logs. api"}, wantErr: "whitespace"},
+		{name: "subject with an empty token", vars: map[string]string{"NATS_SUBJECTS": "logs..api"}, wantErr: "empty token"},
+		{name: "subject with > in the middle", vars: map[string]string{"NATS_SUBJECTS": "logs.>.api"}, wantErr: "last token"},
+		{name: "subject with a partial wildcard", vars: map[string]string{"NATS_SUBJECTS": "logs.api*"}, wantErr: "whole tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadWith(t, tt.vars)
+			if tt.modify != nil {
+				tt.modify(cfg)
+			}
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	cfg := loadWith(t, map[string]string{"PORT": "0", "NATS_REPLICAS": "0", "LOG_SAMPLER": "sometimes"})
+	err := cfg.Validate()
+	for _, want := range []string{"PORT", "NATS_REPLICAS", "LOG_SAMPLER"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to report %s", err, want)
+		}
+	}
+}
+
+func TestDefaultSampleRate(t *testing.T) {
+	tests := []struct {
+		environment, rate string
+		want              float64
+	}{
+		{"production", "", 0.1},
+		{"prod", "", 0.1},
+		{"Production", "", 0.1},
+		{"staging", "", 0.5},
+		{"stage", "", 0.5},
+		{"development", "", 1},
+		{"", "", 1},
+		{"qa", "", 1},
+		{"production", "0.25", 0.25},
+		{"staging", "1", 1},
+		{"development", "0", 0},
+	}
+	for _, tt := range tests {
+		cfg := loadWith(t, map[string]string{"ENVIRONMENT": tt.environment, "LOG_SAMPLE_RATE": tt.rate})
+		if cfg.LogSampleRate != tt.want {
+			t.Errorf("ENVIRONMENT=%q LOG_SAMPLE_RATE=%q: LogSampleRate = %g, want %g", tt.environment, tt.rate, cfg.LogSampleRate, tt.want)
+		}
+	}
+}
+
+// hasWarning reports whether one of warnings starts with prefix and contains every one of parts
+func hasWarning(warnings []string, prefix string, parts ...string) bool {
+	for _, warning := range warnings {
+		if !strings.HasPrefix(warning, prefix) {
+			continue
+		}
+		matched := true
+		for _, part := range parts {
+			matched = matched && strings.Contains(warning, part)
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAckWaitWarning(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want bool
+	}{
+		// 1s of batching plus 3 attempts of 10s with 0.75s and 1.5s of backoff is 33.25s
+		{"defaults", nil, false},
+		{"ack wait below the deadline", map[string]string{"CONSUMER_ACK_WAIT": "33s"}, true},
+		{"ack wait at the deadline", map[string]string{"CONSUMER_ACK_WAIT": "33250ms"}, false},
+		{"long order window", map[string]string{"CONSUMER_ORDER_WINDOW": "30s"}, true},
+		{"long coalesce window", map[string]string{"CONSUMER_COALESCE_WINDOW": "30s"}, true},
+		{"long priority wait", map[string]string{"CONSUMER_PRIORITY": "true", "CONSUMER_PRIORITY_MAX_WAIT": "30s"}, true},
+		{"priority wait with priority off", map[string]string{"CONSUMER_PRIORITY_MAX_WAIT": "30s"}, false},
+		{"more Loki attempts", map[string]string{"LOKI_MAX_ATTEMPTS": "6"}, true},
+		{"Cloud Logging sink", map[string]string{"CONSUMER_SINK": "cloudlogging", "CONSUMER_ACK_WAIT": "1s"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := loadWith(t, tt.vars).Warnings()
+			if got := hasWarning(warnings, "CONSUMER_ACK_WAIT"); got != tt.want {
+				t.Errorf("Warnings = %q, want an ack wait warning: %t", warnings, tt.want)
+			}
+		})
+	}
+
+	warnings := loadWith(t, map[string]string{"CONSUMER_ACK_WAIT": "10s"}).Warnings()
+	if !hasWarning(warnings, "CONSUMER_ACK_WAIT", "(10s)", "(33.25s", "3 Loki attempts") {
+		t.Errorf("Warnings = %q, want the ack wait, the deadline and the attempts", warnings)
+	}
+}
+
+func TestLokiURLWarning(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		// want are the settings the warning suggests; nil expects no warning
+		want []string
+	}{
+		{name: "push path", vars: map[string]string{"LOKI_URL": "http://loki:3100/loki/api/v1/push"}},
+		{name: "push path with a trailing slash", vars: map[string]string{"LOKI_URL": "http://loki:3100/loki/api/v1/push/"}},
+		{name: "push path behind a prefix", vars: map[string]string{"LOKI_URL": "https://gw/tenant-a/loki/api/v1/push"}},
+		{name: "rewritten path", vars: map[string]string{"LOKI_URL": "https://gw/push"}, want: []string{"LOKI_READY_URL and LOKI_QUERY_URL"}},
+		{name: "ready URL set", vars: map[string]string{"LOKI_URL": "https://gw/push", "LOKI_READY_URL": "https://gw/ready"}, want: []string{"set LOKI_QUERY_URL"}},
+		{
+			name: "both set",
+			vars: map[string]string{"LOKI_URL": "https://gw/push", "LOKI_READY_URL": "https://gw/ready", "LOKI_QUERY_URL": "https://gw/query"},
+			want: []string{"push endpoint"},
+		},
+		{name: "Cloud Logging sink", vars: map[string]string{"LOKI_URL": "https://gw/push", "CONSUMER_SINK": "cloudlogging"}},
+		{
+			name: "Cloud Logging sink with a Loki readiness check",
+			vars: map[string]string{"LOKI_URL": "https://gw/push", "CONSUMER_SINK": "cloudlogging", "READY_CHECK_LOKI": "true"},
+			want: []string{"LOKI_READY_URL"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings := loadWith(t, tt.vars).Warnings()
+			got := hasWarning(warnings, "LOKI_URL", tt.want...)
+			if got != (tt.want != nil) {
+				t.Errorf("Warnings = %q, want a LOKI_URL warning mentioning %q: %t", warnings, tt.want, tt.want != nil)
+			}
+		})
+	}
+
+	warnings := loadWith(t, map[string]string{"LOKI_URL": "https://gw/push", "LOKI_READY_URL": "https://gw/ready", "LOKI_QUERY_URL": "https://gw/query"}).Warnings()
+	if hasWarning(warnings, "LOKI_URL", "and set") {
+		t.Errorf("Warnings = %q, want no settings suggested once both are set", warnings)
+	}
+}
+
+func TestLogSkipPaths(t *testing.T) {
+	unsetEnv(t, "LOG_SKIP_PATHS")
+	if got, want := loadWith(t, nil).LogSkipPaths, []string{"/ping", "/readyz"}; !slices.Equal(got, want) {
+		t.Errorf("default LogSkipPaths = %q, want %q", got, want)
+	}
+	got := loadWith(t, map[string]string{"LOG_SKIP_PATHS": " /healthz, /internal/* ,"}).LogSkipPaths
+	if want := []string{"/healthz", "/internal/*"}; !slices.Equal(got, want) {
+		t.Errorf("LogSkipPaths = %q, want %q", got, want)
+	}
+}
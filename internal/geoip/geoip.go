@@ -0,0 +1,56 @@
+// Package geoip enriches log entries with the approximate location of the client IP.
+package geoip
+
+import (
+	"fmt"
+	"net"
+
+	"logtrace/internal/middleware"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Enricher looks up client IPs in a MaxMind GeoIP2/GeoLite2 City database
+type Enricher struct {
+	db *geoip2.Reader
+}
+
+// Open opens the .mmdb database at path
+func Open(path string) (*Enricher, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	return &Enricher{db: db}, nil
+}
+
+// Close releases the database
+func (e *Enricher) Close() error {
+	if e == nil {
+		return nil
+	}
+	return e.db.Close()
+}
+
+// Lookup returns the ISO country code and English city name for ip. Invalid, private,
+// loopback and unlisted addresses return empty strings.
+func (e *Enricher) Lookup(ip string) (country, city string) {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return "", ""
+	}
+
+	record, err := e.db.City(addr)
+	if err != nil {
+		return "", ""
+	}
+	return record.Country.IsoCode, record.City.Names["en"]
+}
+
+// Enrich sets the entry's country and city from its client IP; a nil Enricher does nothing
+func (e *Enricher) Enrich(entry *middleware.LogEntry) {
+	if e == nil {
+		return
+	}
+	entry.Country, entry.City = e.Lookup(entry.ClientIP)
+}
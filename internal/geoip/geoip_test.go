@@ -0,0 +1,127 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"logtrace/internal/middleware"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// writeTestDB builds a small City database locating 81.2.69.0/24 in London and
+// 8.8.8.0/24 in the US without a city
+func writeTestDB(t *testing.T) string {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-City", RecordSize: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]mmdbtype.Map{
+		"81.2.69.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+			"city":    mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("London")}},
+		},
+		"8.8.8.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+		},
+	}
+	for cidr, record := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(network, record); err != nil {
+			t.Fatalf("insert %s: %v", cidr, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := tree.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func openTestDB(t *testing.T) *Enricher {
+	t.Helper()
+	enricher, err := Open(writeTestDB(t))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { enricher.Close() })
+	return enricher
+}
+
+func TestLookup(t *testing.T) {
+	enricher := openTestDB(t)
+
+	tests := []struct {
+		ip      string
+		country string
+		city    string
+	}{
+		{"81.2.69.142", "GB", "London"},
+		{"8.8.8.8", "US", ""},
+		{"::ffff:81.2.69.142", "GB", "London"}, // IPv4-mapped
+		{"1.1.1.1", "", ""},                    // not in the database
+		{"10.0.0.1", "", ""},
+		{"192.168.1.20", "", ""},
+		{"127.0.0.1", "", ""},
+		{"::1", "", ""},
+		{"169.254.10.1", "", ""},
+		{"fe80::1", "", ""},
+		{"0.0.0.0", "", ""},
+		{"", "", ""},
+		{"not-an-ip", "", ""},
+		{"81.2.69.142:443", "", ""},
+	}
+	for _, tt := range tests {
+		country, city := enricher.Lookup(tt.ip)
+		if country != tt.country || city != tt.city {
+			t.Errorf("Lookup(%q) = %q, %q, want %q, %q", tt.ip, country, city, tt.country, tt.city)
+		}
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	enricher := openTestDB(t)
+
+	entry := middleware.LogEntry{ClientIP: "81.2.69.142"}
+	enricher.Enrich(&entry)
+	if entry.Country != "GB" || entry.City != "London" {
+		t.Errorf("Enrich set country %q and city %q, want GB and London", entry.Country, entry.City)
+	}
+
+	private := middleware.LogEntry{ClientIP: "10.1.2.3"}
+	enricher.Enrich(&private)
+	if private.Country != "" || private.City != "" {
+		t.Errorf("Enrich located a private IP in %q, %q", private.Country, private.City)
+	}
+
+	// Enrichment is off when no database is configured
+	var disabled *Enricher
+	untouched := middleware.LogEntry{ClientIP: "81.2.69.142"}
+	disabled.Enrich(&untouched)
+	if untouched.Country != "" || untouched.City != "" {
+		t.Errorf("nil Enricher set country %q and city %q", untouched.Country, untouched.City)
+	}
+	if err := disabled.Close(); err != nil {
+		t.Errorf("Close on a nil Enricher: %v", err)
+	}
+}
+
+func TestOpenMissingDatabase(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Fatal("Open succeeded without a database file")
+	}
+}
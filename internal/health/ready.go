@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	natsclient "logtrace/internal/nats"
+)
+
+// Readiness reports whether the API can serve traffic, and which dependency is down if not
+type Readiness struct {
+	Ready bool             `json:"ready"`
+	NATS  DependencyStatus `json:"nats"`
+	// Loki is only checked when ReadinessChecker.LokiReadyURL is set
+	Loki *DependencyStatus `json:"loki,omitempty"`
+}
+
+// DependencyStatus is the outcome of one dependency check
+type DependencyStatus struct {
+	Up    bool   `json:"up"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReadinessChecker checks the dependencies a pod needs before it receives traffic
+type ReadinessChecker struct {
+	Client *natsclient.NatsClient
+
+	// LokiReadyURL is Loki's /ready endpoint; empty skips the Loki check, since the API
+	// only reaches Loki through the consumer
+	LokiReadyURL string
+	HTTPClient   *http.Client
+}
+
+// LokiReadyURL derives Loki's /ready endpoint from its push URL
+func LokiReadyURL(pushURL string) string {
+	return strings.TrimSuffix(pushURL, "/loki/api/v1/push") + "/ready"
+}
+
+// Check runs the readiness checks
+func (r *ReadinessChecker) Check(ctx context.Context) Readiness {
+	readiness := Readiness{Ready: true}
+
+	if r.Client != nil && r.Client.Conn != nil && r.Client.Conn.IsConnected() {
+		readiness.NATS.Up = true
+	} else {
+		readiness.NATS.Error = "NATS is not connected"
+		readiness.Ready = false
+	}
+
+	if r.LokiReadyURL != "" {
+		loki := DependencyStatus{Up: true}
+		if err := r.checkLoki(ctx); err != nil {
+			loki = DependencyStatus{Error: err.Error()}
+			readiness.Ready = false
+		}
+		readiness.Loki = &loki
+	}
+
+	return readiness
+}
+
+// checkLoki expects a 200 from Loki's /ready endpoint
+func (r *ReadinessChecker) checkLoki(ctx context.Context) error {
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 2 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", r.LokiReadyURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Loki is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Loki is not ready: status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package health
+
+import (
+	"time"
+
+	"logtrace/internal/middleware"
+	natsclient "logtrace/internal/nats"
+)
+
+// State is the overall pipeline health
+type State string
+
+const (
+	StateHealthy  State = "healthy"
+	StateDegraded State = "degraded"
+	StateDown     State = "down"
+)
+
+// Status is the structured pipeline health returned by the status endpoint
+type Status struct {
+	Status    State           `json:"status"`
+	CheckedAt time.Time       `json:"checked_at"`
+	NATS      NATSStatus      `json:"nats"`
+	Consumer  ConsumerStatus  `json:"consumer"`
+	Publisher PublisherStatus `json:"publisher"`
+	Issues    []string        `json:"issues,omitempty"`
+
+	// Stream is a snapshot of the logs stream and all its consumers, omitted when
+	// it couldn't be read
+	Stream *natsclient.StreamMetrics `json:"stream,omitempty" swaggertype:"object"`
+}
+
+// NATSStatus describes the API's connection to NATS
+type NATSStatus struct {
+	Connected     bool   `json:"connected"`
+	ServerURL     string `json:"server_url,omitempty"`
+	ActiveCluster string `json:"active_cluster"`
+}
+
+// ConsumerStatus describes the Loki consumer as seen from JetStream. LastLokiPush is the
+// last successful sink push the consumer recorded in natsclient.StatusBucket.
+type ConsumerStatus struct {
+	Name             string     `json:"name"`
+	Pending          uint64     `json:"pending"`
+	AckPending       int        `json:"ack_pending"`
+	LastLokiPush     *time.Time `json:"last_loki_push,omitempty"`
+	SecondsSincePush float64    `json:"seconds_since_push,omitempty"`
+	Error            string     `json:"error,omitempty"`
+}
+
+// PublisherStatus summarizes the Logger middleware's publish results
+type PublisherStatus struct {
+	// Published and Failed count publishes since the API started
+	Published int64 `json:"published"`
+	Failed    int64 `json:"failed"`
+
+	// RecentFailed and ErrorRate cover publishes within Thresholds.ErrorRateWindow, so an
+	// outage stops degrading the status once it is over
+	RecentFailed int64   `json:"recent_failed"`
+	ErrorRate    float64 `json:"error_rate"`
+
+	// RecentDropped and DropRate cover entries dropped on a full async queue within
+	// Thresholds.DropRateWindow
+	RecentDropped int64   `json:"recent_dropped"`
+	DropRate      float64 `json:"drop_rate"`
+}
+
+// Thresholds decide when the pipeline counts as degraded
+type Thresholds struct {
+	// MaxPending is the consumer lag above which the pipeline is degraded
+	MaxPending uint64
+	// MaxPushAge is how stale the last Loki push may be while logs are pending
+	MaxPushAge time.Duration
+	// MaxErrorRate is the publish error ratio within ErrorRateWindow above which the
+	// pipeline is degraded
+	MaxErrorRate    float64
+	ErrorRateWindow time.Duration
+	// MaxDropRate is the ratio of entries dropped by the async logger within DropRateWindow
+	// above which the pipeline is degraded
+	MaxDropRate    float64
+	DropRateWindow time.Duration
+}
+
+// DefaultThresholds returns the thresholds used when none are configured
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		MaxPending: 10000,
+		MaxPushAge: 5 * time.Minute,
+
+		MaxErrorRate:    0.05,
+		ErrorRateWindow: 5 * time.Minute,
+
+		MaxDropRate:    0.01,
+		DropRateWindow: 5 * time.Minute,
+	}
+}
+
+// Checker aggregates NATS, consumer and publisher signals into a Status
+type Checker struct {
+	Client       *natsclient.NatsClient
+	ConsumerName string
+	Thresholds   Thresholds
+}
+
+// Check collects the current pipeline health
+func (c *Checker) Check() Status {
+	status := Status{
+		Status:    StateHealthy,
+		CheckedAt: time.Now(),
+		Consumer:  ConsumerStatus{Name: c.ConsumerName},
+	}
+
+	// NATS connectivity
+	if c.Client != nil && c.Client.Conn != nil && c.Client.Conn.IsConnected() {
+		status.NATS.Connected = true
+		status.NATS.ServerURL = c.Client.Conn.ConnectedUrl()
+	}
+	status.NATS.ActiveCluster = "primary"
+	if middleware.ActiveCluster() != 0 {
+		status.NATS.ActiveCluster = "secondary"
+	}
+
+	// Publisher error rate over the recent window
+	published, failed := middleware.PublishStats()
+	status.Publisher = PublisherStatus{Published: published, Failed: failed}
+	recentFailed, attempted := middleware.RecentPublishStats(c.Thresholds.ErrorRateWindow)
+	status.Publisher.RecentFailed = recentFailed
+	if attempted > 0 {
+		status.Publisher.ErrorRate = float64(recentFailed) / float64(attempted)
+	}
+	dropped, handled := middleware.DropStats(c.Thresholds.DropRateWindow)
+	status.Publisher.RecentDropped = dropped
+	if handled > 0 {
+		status.Publisher.DropRate = float64(dropped) / float64(handled)
+	}
+
+	// Consumer lag, and the last successful push the consumer recorded
+	if status.NATS.Connected {
+		info, err := c.Client.ConsumerInfo(c.ConsumerName)
+		if err != nil {
+			status.Consumer.Error = err.Error()
+		} else {
+			status.Consumer.Pending = info.NumPending
+			status.Consumer.AckPending = info.NumAckPending
+		}
+		if last, err := c.Client.LastPush(c.ConsumerName); err != nil {
+			if status.Consumer.Error == "" {
+				status.Consumer.Error = err.Error()
+			}
+		} else if !last.IsZero() {
+			status.Consumer.LastLokiPush = &last
+			status.Consumer.SecondsSincePush = time.Since(last).Seconds()
+		}
+
+		if c.Client.StreamCfg != nil {
+			if metrics, err := c.Client.StreamMetrics(c.Client.StreamCfg.Name); err == nil {
+				status.Stream = metrics
+			}
+		}
+	}
+
+	status.Status, status.Issues = evaluate(status, c.Thresholds)
+	return status
+}
+
+// evaluate derives the overall state from the collected signals
+func evaluate(status Status, t Thresholds) (State, []string) {
+	if !status.NATS.Connected {
+		return StateDown, []string{"NATS is not connected"}
+	}
+
+	var issues []string
+	if status.Consumer.Error != "" {
+		issues = append(issues, "consumer info unavailable: "+status.Consumer.Error)
+	}
+	if t.MaxPending > 0 && status.Consumer.Pending > t.MaxPending {
+		issues = append(issues, "consumer lag above threshold")
+	}
+	if t.MaxPushAge > 0 && status.Consumer.Pending > 0 && status.Consumer.LastLokiPush != nil &&
+		time.Since(*status.Consumer.LastLokiPush) > t.MaxPushAge {
+		issues = append(issues, "no recent Loki push while logs are pending")
+	}
+	if t.MaxErrorRate > 0 && status.Publisher.ErrorRate > t.MaxErrorRate {
+		issues = append(issues, "publish error rate above threshold")
+	}
+	if t.MaxDropRate > 0 && status.Publisher.DropRate > t.MaxDropRate {
+		issues = append(issues, "logger drop rate above threshold")
+	}
+	if status.NATS.ActiveCluster != "primary" {
+		issues = append(issues, "publishing to secondary NATS cluster")
+	}
+
+	if len(issues) > 0 {
+		return StateDegraded, issues
+	}
+	return StateHealthy, nil
+}
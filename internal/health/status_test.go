@@ -0,0 +1,176 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+	natsclient "logtrace/internal/nats"
+	"logtrace/internal/natstest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestEvaluate(t *testing.T) {
+	thresholds := DefaultThresholds()
+	recent := time.Now().Add(-time.Minute)
+	stale := time.Now().Add(-time.Hour)
+
+	healthy := func() Status {
+		return Status{
+			NATS:     NATSStatus{Connected: true, ActiveCluster: "primary"},
+			Consumer: ConsumerStatus{Pending: 10, LastLokiPush: &recent},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(s *Status)
+		want   State
+	}{
+		{"healthy", func(s *Status) {}, StateHealthy},
+		{"nats disconnected", func(s *Status) { s.NATS.Connected = false }, StateDown},
+		{"consumer info unavailable", func(s *Status) { s.Consumer.Error = "no consumer" }, StateDegraded},
+		{"lag at threshold", func(s *Status) { s.Consumer.Pending = thresholds.MaxPending }, StateHealthy},
+		{"lag above threshold", func(s *Status) { s.Consumer.Pending = thresholds.MaxPending + 1 }, StateDegraded},
+		{"stale push with pending logs", func(s *Status) { s.Consumer.LastLokiPush = &stale }, StateDegraded},
+		{"stale push with nothing pending", func(s *Status) {
+			s.Consumer.LastLokiPush = &stale
+			s.Consumer.Pending = 0
+		}, StateHealthy},
+		{"error rate at threshold", func(s *Status) { s.Publisher.ErrorRate = thresholds.MaxErrorRate }, StateHealthy},
+		{"error rate above threshold", func(s *Status) { s.Publisher.ErrorRate = thresholds.MaxErrorRate + 0.01 }, StateDegraded},
+		{"drop rate above threshold", func(s *Status) { s.Publisher.DropRate = thresholds.MaxDropRate + 0.01 }, StateDegraded},
+		{"secondary cluster", func(s *Status) { s.NATS.ActiveCluster = "secondary" }, StateDegraded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := healthy()
+			tt.change(&status)
+			state, issues := evaluate(status, thresholds)
+			if state != tt.want {
+				t.Errorf("state = %s (%v), want %s", state, issues, tt.want)
+			}
+			if (state == StateHealthy) != (len(issues) == 0) {
+				t.Errorf("state %s with issues %v", state, issues)
+			}
+		})
+	}
+}
+
+// newTestClient connects to a fresh embedded server with a logs stream and a log-consumer
+func newTestClient(t *testing.T) *natsclient.NatsClient {
+	t.Helper()
+	s := natstest.RunServer(t)
+	client, err := natsclient.NewClient(natsclient.Config{
+		URL:             s.ClientURL(),
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>"},
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     jetstream.MemoryStorage,
+		Replicas:        1,
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.>"}); err != nil {
+		t.Fatalf("CreatePullConsumer: %v", err)
+	}
+	return client
+}
+
+func TestCheckReportsRecordedPush(t *testing.T) {
+	client := newTestClient(t)
+
+	checker := &Checker{Client: client, ConsumerName: "log-consumer", Thresholds: DefaultThresholds()}
+
+	status := checker.Check()
+	if status.Status != StateHealthy {
+		t.Fatalf("status = %s (%v), want healthy", status.Status, status.Issues)
+	}
+	if status.Consumer.LastLokiPush != nil {
+		t.Errorf("last push = %v before any push", status.Consumer.LastLokiPush)
+	}
+
+	pushed := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := client.RecordPush("log-consumer", pushed); err != nil {
+		t.Fatalf("RecordPush: %v", err)
+	}
+
+	status = checker.Check()
+	if status.Consumer.LastLokiPush == nil || !status.Consumer.LastLokiPush.Equal(pushed) {
+		t.Fatalf("last push = %v, want %v", status.Consumer.LastLokiPush, pushed)
+	}
+
+	// A stale push only matters while logs are waiting
+	if _, err := client.Publish("logs.api", []byte(`{}`)); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	status = checker.Check()
+	if status.Status != StateDegraded || status.Consumer.Pending != 1 {
+		t.Errorf("status = %s with %d pending, want degraded with 1", status.Status, status.Consumer.Pending)
+	}
+}
+
+// stalledJS blocks every publish until release is closed, so the async logger's queue fills
+type stalledJS struct {
+	jetstream.JetStream
+	release chan struct{}
+}
+
+func (s *stalledJS) Publish(context.Context, string, []byte, ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	<-s.release
+	return &jetstream.PubAck{Stream: "logs"}, nil
+}
+
+// TestCheckReportsHighDropRate runs last in this file: drops stay in the window for
+// every later check in the process
+func TestCheckReportsHighDropRate(t *testing.T) {
+	checker := &Checker{Client: newTestClient(t), ConsumerName: "log-consumer", Thresholds: DefaultThresholds()}
+	if status := checker.Check(); status.Status != StateHealthy {
+		t.Fatalf("status = %s (%v) before any drop, want healthy", status.Status, status.Issues)
+	}
+
+	// With the one worker stalled and one entry queued, the other 8 requests are dropped
+	gin.SetMode(gin.TestMode)
+	js := &stalledJS{release: make(chan struct{})}
+	logger := middleware.NewRequestLogger(middleware.LoggerConfig{
+		JS:              js,
+		Subject:         "logs.test",
+		Async:           true,
+		AsyncBufferSize: 1,
+		AsyncWorkers:    1,
+	})
+	r := gin.New()
+	r.Use(logger.Handler())
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for range 10 {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items", nil))
+	}
+	close(js.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := logger.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	status := checker.Check()
+	if status.Status != StateDegraded || !slices.Contains(status.Issues, "logger drop rate above threshold") {
+		t.Errorf("status = %s (%v), want degraded by the drop rate", status.Status, status.Issues)
+	}
+	if status.Publisher.RecentDropped < 8 || status.Publisher.DropRate < 0.8 {
+		t.Errorf("recent dropped %d at rate %g, want at least 8 at 0.8", status.Publisher.RecentDropped, status.Publisher.DropRate)
+	}
+
+	// A threshold above the rate keeps the pipeline healthy
+	checker.Thresholds.MaxDropRate = 0.9
+	if status := checker.Check(); status.Status != StateHealthy {
+		t.Errorf("status = %s (%v) with the drop rate below threshold, want healthy", status.Status, status.Issues)
+	}
+}
@@ -0,0 +1,119 @@
+package loki
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the cause of a PushError returned without contacting Loki because
+// the circuit breaker is open
+var ErrCircuitOpen = errors.New("loki circuit breaker is open")
+
+// defaultBreakerCooldown is used when Client.BreakerCooldown is unset
+const defaultBreakerCooldown = 30 * time.Second
+
+// BreakerState is the state of the Loki client's circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed sends every push
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails pushes fast until the cooldown has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe push through to decide whether to close again
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker opens after threshold consecutive failed sends, so a Loki that is down
+// isn't hammered with retries for every batch
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a send may go to Loki, moving an open breaker to half-open once
+// the cooldown has passed; while half-open only one probe is let through at a time
+func (b *circuitBreaker) allow(cooldown time.Duration, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= cooldown {
+		b.state = BreakerHalfOpen
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a send allow let through
+func (b *circuitBreaker) record(err error, threshold int, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+
+	switch {
+	case err == nil || !IsRetryable(err):
+		// Loki answered, even if it rejected the entries
+		b.state = BreakerClosed
+		b.failures = 0
+	case errors.Is(err, context.Canceled):
+		// The caller gave up, which says nothing about Loki
+	default:
+		b.failures++
+		if b.state == BreakerHalfOpen || b.failures >= threshold {
+			b.state = BreakerOpen
+			b.openedAt = now
+		}
+	}
+}
+
+func (b *circuitBreaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// BreakerState returns the state of the client's circuit breaker; it stays closed when
+// BreakerThreshold is unset
+func (c *Client) BreakerState() BreakerState {
+	return c.breaker.current()
+}
+
+func (c *Client) breakerCooldown() time.Duration {
+	if c.BreakerCooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+	return c.BreakerCooldown
+}
+
+// clock returns the current time for the breaker
+func (c *Client) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,145 @@
+package loki
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable clock for the breaker
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newBreakerClient returns a client with a breaker on a fake clock, pushing to a Loki
+// that answers with the status held in status
+func newBreakerClient(t *testing.T, status *atomic.Int32) (*Client, *fakeLoki, *fakeClock) {
+	t.Helper()
+	loki := newFakeLoki(t, func(w http.ResponseWriter, p push) {
+		w.WriteHeader(int(status.Load()))
+	})
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	client := NewClient(loki.URL)
+	client.BreakerThreshold = 2
+	client.BreakerCooldown = 30 * time.Second
+	client.now = clock.now
+	return client, loki, clock
+}
+
+func TestBreakerOpensAndRecovers(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	client, loki, clock := newBreakerClient(t, &status)
+
+	// Closed: failures are sent until the threshold is reached
+	for i := range 2 {
+		if err := sendOne(context.Background(), client); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("send %d failed fast before the threshold", i)
+		}
+	}
+	if state := client.BreakerState(); state != BreakerOpen {
+		t.Fatalf("state after 2 failures = %s, want open", state)
+	}
+
+	// Open: sends fail fast without reaching Loki until the cooldown has passed
+	clock.advance(29 * time.Second)
+	err := sendOne(context.Background(), client)
+	if !errors.Is(err, ErrCircuitOpen) || !IsRetryable(err) {
+		t.Fatalf("send while open: err = %v, want retryable ErrCircuitOpen", err)
+	}
+	if n := len(loki.received()); n != 2 {
+		t.Errorf("%d pushes reached Loki, want 2", n)
+	}
+
+	// Half-open: one probe goes through, and its success closes the breaker
+	clock.advance(time.Second)
+	status.Store(http.StatusNoContent)
+	if err := sendOne(context.Background(), client); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if state := client.BreakerState(); state != BreakerClosed {
+		t.Fatalf("state after a successful probe = %s, want closed", state)
+	}
+	if n := len(loki.received()); n != 3 {
+		t.Errorf("%d pushes reached Loki, want 3", n)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	client, loki, clock := newBreakerClient(t, &status)
+
+	sendOne(context.Background(), client)
+	sendOne(context.Background(), client)
+	clock.advance(30 * time.Second)
+
+	// A single failed probe reopens the breaker, whatever the threshold
+	if err := sendOne(context.Background(), client); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe: err = %v, want Loki's 503", err)
+	}
+	if state := client.BreakerState(); state != BreakerOpen {
+		t.Fatalf("state after a failed probe = %s, want open", state)
+	}
+
+	// The cooldown starts over from the failed probe
+	clock.advance(29 * time.Second)
+	if err := sendOne(context.Background(), client); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("send within the new cooldown: err = %v, want ErrCircuitOpen", err)
+	}
+	if n := len(loki.received()); n != 3 {
+		t.Errorf("%d pushes reached Loki, want 3", n)
+	}
+}
+
+func TestBreakerLetsOneProbeThrough(t *testing.T) {
+	var b circuitBreaker
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := &PushError{StatusCode: http.StatusServiceUnavailable}
+
+	b.record(err, 1, now)
+	if b.allow(time.Minute, now.Add(59*time.Second)) {
+		t.Fatal("open breaker allowed a send")
+	}
+
+	later := now.Add(time.Minute)
+	if !b.allow(time.Minute, later) {
+		t.Fatal("breaker didn't allow a probe after the cooldown")
+	}
+	if b.current() != BreakerHalfOpen {
+		t.Fatalf("state = %s, want half-open", b.current())
+	}
+	if b.allow(time.Minute, later) {
+		t.Error("second probe allowed while the first is running")
+	}
+}
+
+func TestBreakerIgnoresRejectionsAndCancellations(t *testing.T) {
+	var b circuitBreaker
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	unavailable := &PushError{StatusCode: http.StatusServiceUnavailable}
+
+	// A rejection shows Loki is up, so it resets the count of failures
+	b.record(unavailable, 2, now)
+	b.record(&PushError{StatusCode: http.StatusBadRequest}, 2, now)
+	b.record(unavailable, 2, now)
+	if b.current() != BreakerClosed {
+		t.Fatalf("state = %s after failures split by a rejection, want closed", b.current())
+	}
+
+	// A canceled send says nothing about Loki
+	b.record(&PushError{Err: context.Canceled}, 2, now)
+	if b.current() != BreakerClosed {
+		t.Fatalf("state = %s after a canceled send, want closed", b.current())
+	}
+	b.record(unavailable, 2, now)
+	if b.current() != BreakerOpen {
+		t.Errorf("state = %s after 2 failures, want open", b.current())
+	}
+}
@@ -2,19 +2,103 @@ package loki
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"logtrace/internal/middleware"
+	"logtrace/internal/sink"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type Client struct {
 	URL        string
 	HTTPClient *http.Client
+
+	// Method is the HTTP method of pushes, for proxies that expect something other than
+	// Loki's POST; empty uses POST
+	Method string
+
+	// QueryURL is Loki's query_range endpoint; empty derives it from URL
+	QueryURL string
+
+	// Encoding selects the push payload format; defaults to EncodingJSON
+	Encoding Encoding
+
+	// MaxAttempts bounds push attempts for retryable failures (429, 5xx and network errors);
+	// zero or one disables retries
+	MaxAttempts int
+	// RetryBaseDelay is the first backoff delay when Loki sends no Retry-After
+	RetryBaseDelay time.Duration
+
+	// BreakerThreshold opens the circuit breaker after that many consecutive sends fail
+	// with retryable errors, failing sends fast with ErrCircuitOpen for BreakerCooldown
+	// before a single probe decides whether to close it; zero disables the breaker
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker stays open; defaults to 30s
+	BreakerCooldown time.Duration
+	breaker         circuitBreaker
+	// now is the breaker's clock; nil uses time.Now
+	now func() time.Time
+
+	// LabelBuilder chooses which entry fields become stream labels; nil uses DefaultLabels.
+	// Keep it to low-cardinality fields: trace and span IDs belong in the log line.
+	LabelBuilder func(entry middleware.LogEntry) map[string]string
+
+	// LabelPrefix is prepended to every stream label name (e.g. "lt_" gives lt_service),
+	// avoiding collisions in a shared Loki
+	LabelPrefix string
+
+	// TenantField names the LogEntry field used as the Loki tenant (X-Scope-OrgID),
+	// e.g. "tenant", "environment" or "service_name"; empty sends every entry as DefaultTenant
+	TenantField string
+	// DefaultTenant is used when TenantField is unset or the entry's field is empty
+	DefaultTenant string
+	// TenantFunc, when set, maps each entry to its tenant instead of TenantField,
+	// e.g. to fan environments out to differently named tenants
+	TenantFunc func(entry middleware.LogEntry) string
+
+	// ResourceSegment is the zero-based path segment used for the "resource" label,
+	// e.g. 2 maps /api/v1/users/123 to "users"
+	ResourceSegment int
+	// ResourceAllowlist bounds the resource label's values; segments not listed are
+	// labelled "other" and an empty allowlist disables the label
+	ResourceAllowlist map[string]bool
+
+	// HeaderLabels bounds the labels taken from LogEntry.Labels (see
+	// middleware.LoggerConfig.HeaderLabels) to the allowed values of each name: values not
+	// listed are labelled "other" and names not listed are ignored, so a client sending
+	// arbitrary headers can't create new streams. Names of other labels aren't overridden.
+	HeaderLabels map[string]map[string]bool
+
+	// SyntheticLabel adds synthetic="true" to the streams of synthetic entries, so Loki can
+	// give them a shorter retention (retention_stream) and queries can exclude them
+	SyntheticLabel bool
+
+	// OutOfOrder sends batched stream values in arrival order, for Loki deployments accepting
+	// out-of-order writes. Otherwise values are sorted by timestamp and entries sharing a
+	// nanosecond are spread 1ns apart, since Loki rejects a stream that goes back in time.
+	OutOfOrder bool
+
+	// MaxLabelValueLength cuts longer label values, in bytes; zero uses
+	// DefaultMaxLabelValueLength
+	MaxLabelValueLength int
+	// OnLabelFixed, when set, is called for each label renamed, dropped for an empty value
+	// or cut to MaxLabelValueLength before a push
+	OnLabelFixed func(name, problem string)
 }
 
+// DefaultMaxLabelValueLength matches Loki's default max_label_value_length
+const DefaultMaxLabelValueLength = 2048
+
 type PushRequest struct {
 	Streams []Stream `json:"streams"`
 }
@@ -34,6 +118,11 @@ func NewClient(url string) *Client {
 }
 
 func (c *Client) SendLog(entry middleware.LogEntry) error {
+	return c.SendLogContext(context.Background(), entry)
+}
+
+// SendLogContext sends a single entry, giving up when ctx is done
+func (c *Client) SendLogContext(ctx context.Context, entry middleware.LogEntry) error {
 	logLine, err := json.Marshal(entry)
 	if err != nil {
 		return fmt.Errorf("failed to marshal log entry: %w", err)
@@ -42,20 +131,11 @@ func (c *Client) SendLog(entry middleware.LogEntry) error {
 	timestampNano := entry.Timestamp.UnixNano()
 	timestampStr := fmt.Sprintf("%d", timestampNano)
 
-	// Create labels for the log stream
-	labels := map[string]string{
-		"service":     entry.ServiceName,
-		"environment": entry.Environment,
-		"trace_id":    entry.TraceID,
-		"method":      entry.Method,
-		"status":      fmt.Sprintf("%d", entry.Status),
-	}
-
 	// Create Loki push request
 	req := PushRequest{
 		Streams: []Stream{
 			{
-				Stream: labels,
+				Stream: c.labelsOf(entry),
 				Values: [][]string{
 					{timestampStr, string(logLine)},
 				},
@@ -63,70 +143,320 @@ func (c *Client) SendLog(entry middleware.LogEntry) error {
 		},
 	}
 
-	return c.sendToLoki(req)
+	return c.sendToLoki(ctx, req, c.tenantOf(entry))
+}
+
+// DefaultLabels labels streams by service and environment, plus the resource when
+// ResourceAllowlist is set, synthetic for synthetic entries when SyntheticLabel is set and
+// the entry's labels allowed by HeaderLabels. The service is taken from SubjectService when the consumer set it.
+func (c *Client) DefaultLabels(entry middleware.LogEntry) map[string]string {
+	labels := map[string]string{
+		"service":     entry.LabelService(),
+		"environment": entry.Environment,
+	}
+	if resource := c.resourceOf(entry); resource != "" {
+		labels["resource"] = resource
+	}
+	if c.SyntheticLabel && entry.Synthetic {
+		labels["synthetic"] = "true"
+	}
+	for name, value := range entry.Labels {
+		allowed, ok := c.HeaderLabels[name]
+		if _, taken := labels[name]; !ok || taken {
+			continue
+		}
+		if !allowed[value] {
+			value = "other"
+		}
+		labels[name] = value
+	}
+	return labels
+}
+
+// labelsOf returns the stream labels for an entry, made acceptable to Loki
+func (c *Client) labelsOf(entry middleware.LogEntry) map[string]string {
+	if c.LabelBuilder != nil {
+		return c.sanitizeLabels(c.LabelBuilder(entry))
+	}
+	return c.sanitizeLabels(c.DefaultLabels(entry))
+}
+
+// sanitizeLabels rewrites invalid label names, drops labels with empty values and cuts
+// over-long values, since Loki rejects the whole push with a 400 otherwise
+func (c *Client) sanitizeLabels(labels map[string]string) map[string]string {
+	maxLength := c.MaxLabelValueLength
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLabelValueLength
+	}
+
+	sanitized := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if name == "" || value == "" {
+			c.labelFixed(name, "dropped, empty name or value")
+			continue
+		}
+
+		valid := labelName(name)
+		if valid != name {
+			c.labelFixed(name, "renamed to "+valid)
+		}
+		if len(value) > maxLength {
+			value = truncateValue(value, maxLength)
+			c.labelFixed(name, fmt.Sprintf("value cut to %d bytes", maxLength))
+		}
+		sanitized[valid] = value
+	}
+	return sanitized
+}
+
+func (c *Client) labelFixed(name, problem string) {
+	if c.OnLabelFixed != nil {
+		c.OnLabelFixed(name, problem)
+	}
+}
+
+// truncateValue cuts value to at most n bytes without splitting a UTF-8 character
+func truncateValue(value string, n int) string {
+	for n > 0 && !utf8.RuneStart(value[n]) {
+		n--
+	}
+	return value[:n]
+}
+
+// resourceOf derives the resource label from the configured path segment
+func (c *Client) resourceOf(entry middleware.LogEntry) string {
+	if len(c.ResourceAllowlist) == 0 {
+		return ""
+	}
+
+	segments := strings.Split(strings.Trim(entry.Path, "/"), "/")
+	if c.ResourceSegment < 0 || c.ResourceSegment >= len(segments) {
+		return "other"
+	}
+
+	segment := strings.ToLower(segments[c.ResourceSegment])
+	if !c.ResourceAllowlist[segment] {
+		return "other"
+	}
+	return segment
+}
+
+// tenantOf returns the Loki tenant an entry belongs to
+func (c *Client) tenantOf(entry middleware.LogEntry) string {
+	var tenant string
+	switch {
+	case c.TenantFunc != nil:
+		tenant = c.TenantFunc(entry)
+	case c.TenantField == "tenant":
+		tenant = entry.Tenant
+	case c.TenantField == "environment":
+		tenant = entry.Environment
+	case c.TenantField == "service", c.TenantField == "service_name":
+		tenant = entry.ServiceName
+	}
+
+	if tenant == "" {
+		return c.DefaultTenant
+	}
+	return tenant
+}
+
+// prefixLabels returns the request with LabelPrefix applied to every stream label name
+func (c *Client) prefixLabels(req PushRequest) PushRequest {
+	if c.LabelPrefix == "" {
+		return req
+	}
+
+	streams := make([]Stream, len(req.Streams))
+	for i, stream := range req.Streams {
+		labels := make(map[string]string, len(stream.Stream))
+		for name, value := range stream.Stream {
+			labels[labelName(c.LabelPrefix+name)] = value
+		}
+		streams[i] = Stream{Stream: labels, Values: stream.Values}
+	}
+	return PushRequest{Streams: streams}
+}
+
+// labelName makes name a valid Prometheus label name ([a-zA-Z_][a-zA-Z0-9_]*)
+// by replacing invalid characters with underscores
+func labelName(name string) string {
+	var sb strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
 }
 
-// sendToLoki sends the push request to Loki
-func (c *Client) sendToLoki(req PushRequest) error {
-	payload, err := json.Marshal(req)
+// sendToLoki sends the push request to Loki on behalf of the given tenant, retrying
+// retryable failures up to MaxAttempts while ctx is not done
+func (c *Client) sendToLoki(ctx context.Context, req PushRequest, tenant string) (err error) {
+	encoded, err := encode(c.prefixLabels(req), c.Encoding)
 	if err != nil {
-		return fmt.Errorf("failed to marshal Loki request: %w", err)
+		return err
+	}
+
+	// Fail fast while the breaker is open
+	if c.BreakerThreshold > 0 {
+		if !c.breaker.allow(c.breakerCooldown(), c.clock()) {
+			return &PushError{Err: ErrCircuitOpen}
+		}
+		defer func() { c.breaker.record(err, c.BreakerThreshold, c.clock()) }()
+	}
+
+	ctx, span := c.startPushSpan(ctx, req, encoded, tenant)
+
+	attempts := max(c.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		status, err := c.push(ctx, encoded, tenant)
+		if err == nil {
+			endPushSpan(span, attempt, status, nil)
+			return nil
+		}
+
+		var pushErr *PushError
+		if !errors.As(err, &pushErr) || !pushErr.Retryable() || attempt >= attempts || ctx.Err() != nil {
+			endPushSpan(span, attempt, status, err)
+			return err
+		}
+
+		timer := time.NewTimer(c.retryDelay(pushErr, attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err := &PushError{Err: ctx.Err()}
+			endPushSpan(span, attempt, status, err)
+			return err
+		case <-timer.C:
+		}
 	}
+}
 
+// push makes a single push request, returning the response status code (zero when there
+// was no response)
+func (c *Client) push(ctx context.Context, encoded encodedRequest, tenant string) (int, error) {
 	// Create HTTP request
-	httpReq, err := http.NewRequest("POST", c.URL, bytes.NewBuffer(payload))
+	method := c.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.URL, bytes.NewBuffer(encoded.body))
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return 0, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Content-Type", encoded.contentType)
+	if encoded.contentEncoding != "" {
+		httpReq.Header.Set("Content-Encoding", encoded.contentEncoding)
+	}
+	if tenant != "" {
+		httpReq.Header.Set("X-Scope-OrgID", tenant)
+	}
+
+	// Pass the trace on (traceparent) so a tracing proxy in front of Loki can continue it
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
 
 	// Send request
 	resp, err := c.HTTPClient.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("failed to send request to Loki: %w", err)
+		return 0, &PushError{Err: err}
 	}
 	defer resp.Body.Close()
 
 	// Check response
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("Loki returned error status: %d, body: %s", resp.StatusCode, string(body))
+		return resp.StatusCode, &PushError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
 
 func (c *Client) SendBatchLogs(entries []middleware.LogEntry) error {
+	return c.SendBatchLogsContext(context.Background(), entries)
+}
+
+// SendBatchLogsContext sends a batch of entries, one push per tenant, giving up when ctx
+// is done. When some tenants' pushes fail and others succeed, the error is a
+// *sink.PartialError naming the entries that weren't sent.
+func (c *Client) SendBatchLogsContext(ctx context.Context, entries []middleware.LogEntry) error {
 	if len(entries) == 0 {
 		return nil
 	}
 
-	// Group logs by labels
+	// Group logs by tenant so each push carries a single X-Scope-OrgID
+	tenantMap := make(map[string][]int)
+	for i, entry := range entries {
+		tenant := c.tenantOf(entry)
+		tenantMap[tenant] = append(tenantMap[tenant], i)
+	}
+
+	if len(tenantMap) == 1 {
+		for tenant := range tenantMap {
+			return c.sendTenantBatch(ctx, tenant, entries)
+		}
+	}
+
+	// Report which tenants failed, so the entries Loki took aren't sent twice
+	var partial sink.PartialError
+	for tenant, indexes := range tenantMap {
+		group := make([]middleware.LogEntry, len(indexes))
+		for j, i := range indexes {
+			group[j] = entries[i]
+		}
+		if err := c.sendTenantBatch(ctx, tenant, group); err != nil {
+			partial.Failed = append(partial.Failed, sink.FailedGroup{
+				Indexes: indexes,
+				Err:     fmt.Errorf("tenant %q: %w", tenant, err),
+			})
+		}
+	}
+
+	if len(partial.Failed) > 0 {
+		return &partial
+	}
+	return nil
+}
+
+// sendTenantBatch pushes a batch of entries belonging to one tenant
+func (c *Client) sendTenantBatch(ctx context.Context, tenant string, entries []middleware.LogEntry) error {
+	// Group logs by their label set
 	streamMap := make(map[string][]middleware.LogEntry)
+	streamLabels := make(map[string]map[string]string)
 	for _, entry := range entries {
-		// Create a key for grouping similar logs
-		key := fmt.Sprintf("%s-%s-%s", entry.ServiceName, entry.Environment, entry.TraceID)
+		labels := c.labelsOf(entry)
+		key := formatLabels(labels)
 		streamMap[key] = append(streamMap[key], entry)
+		streamLabels[key] = labels
 	}
 
 	// Create streams for each group
 	var streams []Stream
-	for _, group := range streamMap {
-		if len(group) == 0 {
-			continue
-		}
-
-		// Use labels from the first entry
-		first := group[0]
-		labels := map[string]string{
-			"service":     first.ServiceName,
-			"environment": first.Environment,
-			"trace_id":    first.TraceID,
+	for key, group := range streamMap {
+		// Entries from concurrent requests can arrive out of timestamp order
+		if !c.OutOfOrder {
+			slices.SortStableFunc(group, func(a, b middleware.LogEntry) int {
+				return a.Timestamp.Compare(b.Timestamp)
+			})
 		}
 
 		// Create values for this stream
 		var values [][]string
+		var last int64
 		for _, entry := range group {
 			logLine, err := json.Marshal(entry)
 			if err != nil {
@@ -134,12 +464,17 @@ func (c *Client) SendBatchLogs(entries []middleware.LogEntry) error {
 			}
 
 			timestampNano := entry.Timestamp.UnixNano()
+			if !c.OutOfOrder && len(values) > 0 && timestampNano <= last {
+				timestampNano = last + 1
+			}
+			last = timestampNano
+
 			timestampStr := fmt.Sprintf("%d", timestampNano)
 			values = append(values, []string{timestampStr, string(logLine)})
 		}
 
 		streams = append(streams, Stream{
-			Stream: labels,
+			Stream: streamLabels[key],
 			Values: values,
 		})
 	}
@@ -149,5 +484,5 @@ func (c *Client) SendBatchLogs(entries []middleware.LogEntry) error {
 		Streams: streams,
 	}
 
-	return c.sendToLoki(req)
+	return c.sendToLoki(ctx, req, tenant)
 }
@@ -0,0 +1,571 @@
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+	"logtrace/internal/sink"
+)
+
+// push is one request received by a fakeLoki
+type push struct {
+	tenant  string
+	header  http.Header
+	body    []byte
+	request PushRequest // decoded when the body is uncompressed JSON
+}
+
+// fakeLoki records pushes and answers each with the status respond returns
+type fakeLoki struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	pushes []push
+}
+
+func newFakeLoki(t *testing.T, respond func(w http.ResponseWriter, p push)) *fakeLoki {
+	t.Helper()
+	f := &fakeLoki{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading push body: %v", err)
+		}
+		p := push{tenant: r.Header.Get("X-Scope-OrgID"), header: r.Header.Clone(), body: body}
+		if r.Header.Get("Content-Type") == "application/json" && r.Header.Get("Content-Encoding") == "" {
+			if err := json.Unmarshal(body, &p.request); err != nil {
+				t.Errorf("decoding push body: %v", err)
+			}
+		}
+
+		f.mu.Lock()
+		f.pushes = append(f.pushes, p)
+		f.mu.Unlock()
+
+		if respond == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		respond(w, p)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeLoki) received() []push {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.pushes)
+}
+
+// entries returns the log lines of an uncompressed JSON push, decoded back into entries
+func (p push) entries(t *testing.T) []middleware.LogEntry {
+	t.Helper()
+	var entries []middleware.LogEntry
+	for _, stream := range p.request.Streams {
+		for _, value := range stream.Values {
+			var entry middleware.LogEntry
+			if err := json.Unmarshal([]byte(value[1]), &entry); err != nil {
+				t.Fatalf("decoding log line %q: %v", value[1], err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func testEntry(traceID, tenant, environment string) middleware.LogEntry {
+	return middleware.LogEntry{
+		Timestamp:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		TraceID:     traceID,
+		ServiceName: "api",
+		Environment: environment,
+		Tenant:      tenant,
+	}
+}
+
+func TestSendBatchLogsReportsFailedTenant(t *testing.T) {
+	loki := newFakeLoki(t, func(w http.ResponseWriter, p push) {
+		if p.tenant == "acme" {
+			http.Error(w, "ingester unavailable", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	client := NewClient(loki.URL)
+	client.TenantField = "tenant"
+
+	batch := []middleware.LogEntry{
+		testEntry("t0", "acme", "prod"),
+		testEntry("t1", "globex", "prod"),
+		testEntry("t2", "acme", "prod"),
+		testEntry("t3", "globex", "prod"),
+	}
+	err := client.SendBatchLogsContext(context.Background(), batch)
+
+	var partial *sink.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("err = %v, want a *sink.PartialError", err)
+	}
+	if len(partial.Failed) != 1 {
+		t.Fatalf("failed groups = %+v, want one", partial.Failed)
+	}
+	failed := partial.Failed[0]
+	if !slices.Equal(failed.Indexes, []int{0, 2}) {
+		t.Errorf("failed indexes = %v, want [0 2]", failed.Indexes)
+	}
+	var pushErr *PushError
+	if !errors.As(failed.Err, &pushErr) || pushErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("group error = %v, want the 500 PushError", failed.Err)
+	}
+	if !sink.IsRetryable(failed.Err) {
+		t.Errorf("a 500 should be retryable")
+	}
+
+	// globex's push went through and must not be resent
+	var sent []string
+	for _, p := range loki.received() {
+		if p.tenant == "globex" {
+			for _, entry := range p.entries(t) {
+				sent = append(sent, entry.TraceID)
+			}
+		}
+	}
+	slices.Sort(sent)
+	if !slices.Equal(sent, []string{"t1", "t3"}) {
+		t.Errorf("globex received %v, want [t1 t3]", sent)
+	}
+}
+
+func TestSendBatchLogsSingleTenantFailureIsWhole(t *testing.T) {
+	loki := newFakeLoki(t, func(w http.ResponseWriter, p push) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	})
+	client := NewClient(loki.URL)
+
+	err := client.SendBatchLogsContext(context.Background(), []middleware.LogEntry{testEntry("t0", "", "prod")})
+
+	var partial *sink.PartialError
+	if err == nil || errors.As(err, &partial) {
+		t.Fatalf("err = %v, want the push error itself", err)
+	}
+	if sink.IsRetryable(err) {
+		t.Errorf("a 400 should not be retryable")
+	}
+}
+
+func TestTenantHeader(t *testing.T) {
+	batch := []middleware.LogEntry{
+		testEntry("t0", "", "prod"),
+		testEntry("t1", "", "staging"),
+		testEntry("t2", "", "prod"),
+	}
+
+	tests := []struct {
+		name      string
+		configure func(c *Client)
+		want      map[string][]string // trace IDs by X-Scope-OrgID
+	}{
+		{
+			name:      "no tenant",
+			configure: func(c *Client) {},
+			want:      map[string][]string{"": {"t0", "t1", "t2"}},
+		},
+		{
+			name:      "static default tenant",
+			configure: func(c *Client) { c.DefaultTenant = "logtrace" },
+			want:      map[string][]string{"logtrace": {"t0", "t1", "t2"}},
+		},
+		{
+			name:      "tenant field",
+			configure: func(c *Client) { c.TenantField = "environment" },
+			want:      map[string][]string{"prod": {"t0", "t2"}, "staging": {"t1"}},
+		},
+		{
+			name: "tenant function",
+			configure: func(c *Client) {
+				c.DefaultTenant = "fallback"
+				c.TenantFunc = func(entry middleware.LogEntry) string {
+					if entry.Environment == "prod" {
+						return "team-prod"
+					}
+					return "" // falls back to DefaultTenant
+				}
+			},
+			want: map[string][]string{"team-prod": {"t0", "t2"}, "fallback": {"t1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loki := newFakeLoki(t, nil)
+			client := NewClient(loki.URL)
+			tt.configure(client)
+
+			if err := client.SendBatchLogsContext(context.Background(), batch); err != nil {
+				t.Fatalf("SendBatchLogsContext: %v", err)
+			}
+
+			got := make(map[string][]string)
+			for _, p := range loki.received() {
+				if _, ok := got[p.tenant]; ok {
+					t.Errorf("tenant %q pushed twice", p.tenant)
+				}
+				if _, set := p.header["X-Scope-Orgid"]; set != (p.tenant != "") {
+					t.Errorf("X-Scope-OrgID set to %q", p.tenant)
+				}
+				for _, entry := range p.entries(t) {
+					got[p.tenant] = append(got[p.tenant], entry.TraceID)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("pushes by tenant = %v, want %v", got, tt.want)
+			}
+			for tenant, want := range tt.want {
+				// Streams, one per label set, arrive in any order
+				slices.Sort(got[tenant])
+				if !slices.Equal(got[tenant], want) {
+					t.Errorf("tenant %q received %v, want %v", tenant, got[tenant], want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamValuesAreOrdered(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// at returns an entry of the api stream logged offset after base
+	at := func(traceID string, offset time.Duration) middleware.LogEntry {
+		entry := testEntry(traceID, "", "prod")
+		entry.Timestamp = base.Add(offset)
+		return entry
+	}
+	batch := []middleware.LogEntry{
+		at("c", 3*time.Millisecond),
+		at("a", time.Millisecond),
+		at("b1", 2*time.Millisecond),
+		at("b2", 2*time.Millisecond),
+		at("b3", 2*time.Millisecond),
+	}
+	ms := int64(time.Millisecond)
+	start := base.UnixNano()
+
+	tests := []struct {
+		name       string
+		outOfOrder bool
+		wantIDs    []string
+		wantTimes  []int64
+	}{
+		{
+			name: "sorted with ties spread 1ns apart",
+			// Ties keep their arrival order
+			wantIDs:   []string{"a", "b1", "b2", "b3", "c"},
+			wantTimes: []int64{start + ms, start + 2*ms, start + 2*ms + 1, start + 2*ms + 2, start + 3*ms},
+		},
+		{
+			name:       "out of order accepted",
+			outOfOrder: true,
+			wantIDs:    []string{"c", "a", "b1", "b2", "b3"},
+			wantTimes:  []int64{start + 3*ms, start + ms, start + 2*ms, start + 2*ms, start + 2*ms},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loki := newFakeLoki(t, nil)
+			client := NewClient(loki.URL)
+			client.OutOfOrder = tt.outOfOrder
+			if err := client.SendBatchLogsContext(context.Background(), slices.Clone(batch)); err != nil {
+				t.Fatalf("SendBatchLogsContext: %v", err)
+			}
+
+			pushes := loki.received()
+			if len(pushes) != 1 || len(pushes[0].request.Streams) != 1 {
+				t.Fatalf("got %d pushes, want one with a single stream", len(pushes))
+			}
+			var ids []string
+			var times []int64
+			for i, value := range pushes[0].request.Streams[0].Values {
+				ns, err := strconv.ParseInt(value[0], 10, 64)
+				if err != nil {
+					t.Fatalf("value %d has timestamp %q", i, value[0])
+				}
+				times = append(times, ns)
+			}
+			for _, entry := range pushes[0].entries(t) {
+				ids = append(ids, entry.TraceID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("pushed %v, want %v", ids, tt.wantIDs)
+			}
+			if !slices.Equal(times, tt.wantTimes) {
+				t.Errorf("pushed timestamps %v, want %v", times, tt.wantTimes)
+			}
+		})
+	}
+}
+
+func TestStreamsAreOrderedIndependently(t *testing.T) {
+	loki := newFakeLoki(t, nil)
+	client := NewClient(loki.URL)
+
+	// Equal timestamps in different streams aren't bumped, since each stream is ordered alone
+	web, api := testEntry("web", "", "prod"), testEntry("api", "", "prod")
+	web.ServiceName = "web"
+	if err := client.SendBatchLogsContext(context.Background(), []middleware.LogEntry{web, api}); err != nil {
+		t.Fatalf("SendBatchLogsContext: %v", err)
+	}
+
+	streams := loki.received()[0].request.Streams
+	if len(streams) != 2 {
+		t.Fatalf("pushed %d streams, want 2", len(streams))
+	}
+	want := strconv.FormatInt(web.Timestamp.UnixNano(), 10)
+	for _, stream := range streams {
+		if got := stream.Values[0][0]; got != want {
+			t.Errorf("stream %v pushed at %s, want %s", stream.Stream, got, want)
+		}
+	}
+}
+
+func TestLabelName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"service", "service"},
+		{"Service_Name2", "Service_Name2"},
+		{"_private", "_private"},
+		{"http.method", "http_method"},
+		{"x-request-kind", "x_request_kind"},
+		{"k8s/namespace", "k8s_namespace"},
+		{"2xx", "_2xx"},
+		{"9", "_9"},
+		{"région", "r_gion"},
+		{"a b", "a_b"},
+	}
+	for _, tt := range tests {
+		if got := labelName(tt.name); got != tt.want {
+			t.Errorf("labelName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		maxLength int
+		want      map[string]string
+		wantFixed []string
+	}{
+		{
+			name:   "valid labels untouched",
+			labels: map[string]string{"service": "api", "environment": "prod"},
+			want:   map[string]string{"service": "api", "environment": "prod"},
+		},
+		{
+			name:      "invalid characters",
+			labels:    map[string]string{"http.method": "GET"},
+			want:      map[string]string{"http_method": "GET"},
+			wantFixed: []string{"http.method: renamed to http_method"},
+		},
+		{
+			name:      "leading digit",
+			labels:    map[string]string{"5xx": "true"},
+			want:      map[string]string{"_5xx": "true"},
+			wantFixed: []string{"5xx: renamed to _5xx"},
+		},
+		{
+			name:      "empty value",
+			labels:    map[string]string{"service": "api", "environment": ""},
+			want:      map[string]string{"service": "api"},
+			wantFixed: []string{"environment: dropped, empty name or value"},
+		},
+		{
+			name:      "empty name",
+			labels:    map[string]string{"": "api"},
+			want:      map[string]string{},
+			wantFixed: []string{": dropped, empty name or value"},
+		},
+		{
+			name:      "long value",
+			labels:    map[string]string{"service": "checkout-api"},
+			maxLength: 8,
+			want:      map[string]string{"service": "checkout"},
+			wantFixed: []string{"service: value cut to 8 bytes"},
+		},
+		{
+			name:      "long value cut on a character boundary",
+			labels:    map[string]string{"city": "Zürich"},
+			maxLength: 2,
+			want:      map[string]string{"city": "Z"},
+			wantFixed: []string{"city: value cut to 2 bytes"},
+		},
+		{
+			name:   "default max length",
+			labels: map[string]string{"service": strings.Repeat("a", DefaultMaxLabelValueLength)},
+			want:   map[string]string{"service": strings.Repeat("a", DefaultMaxLabelValueLength)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fixed []string
+			client := NewClient("http://loki")
+			client.MaxLabelValueLength = tt.maxLength
+			client.OnLabelFixed = func(name, problem string) { fixed = append(fixed, name+": "+problem) }
+
+			got := client.sanitizeLabels(tt.labels)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("sanitizeLabels = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(fixed, tt.wantFixed) {
+				t.Errorf("fixed %q, want %q", fixed, tt.wantFixed)
+			}
+		})
+	}
+}
+
+func TestLabelPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"environment", "resource", "service"}},
+		{"lt_", []string{"lt_environment", "lt_resource", "lt_service"}},
+		// The prefix is sanitized along with the name
+		{"lt-", []string{"lt_environment", "lt_resource", "lt_service"}},
+		{"1x", []string{"_1xenvironment", "_1xresource", "_1xservice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			loki := newFakeLoki(t, nil)
+			client := NewClient(loki.URL)
+			client.LabelPrefix = tt.prefix
+			client.ResourceSegment = 0
+			client.ResourceAllowlist = map[string]bool{"users": true}
+			entry := testEntry("t0", "", "prod")
+			entry.Path = "/users/7"
+
+			if err := client.SendLog(entry); err != nil {
+				t.Fatalf("SendLog: %v", err)
+			}
+			if err := client.SendBatchLogs([]middleware.LogEntry{entry}); err != nil {
+				t.Fatalf("SendBatchLogs: %v", err)
+			}
+
+			for _, p := range loki.received() {
+				stream := p.request.Streams[0].Stream
+				if got := slices.Sorted(maps.Keys(stream)); !slices.Equal(got, tt.want) {
+					t.Errorf("pushed labels %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceLabel(t *testing.T) {
+	client := NewClient("http://loki.invalid")
+	client.ResourceSegment = 2
+	client.ResourceAllowlist = map[string]bool{"users": true, "orders": true}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v1/users/123", "users"},
+		{"/api/v1/orders", "orders"},
+		{"/api/v1/orders/", "orders"},
+		{"/api/v1/Users/123", "users"},
+		{"/api/v1/invoices/9", "other"}, // not allowlisted
+		{"/api/v1/users-export", "other"},
+		{"/api/v1", "other"}, // too few segments
+		{"/", "other"},
+		{"", "other"},
+	}
+	for _, tt := range tests {
+		entry := testEntry("t0", "", "prod")
+		entry.Path = tt.path
+		if got := client.DefaultLabels(entry)["resource"]; got != tt.want {
+			t.Errorf("resource label for %q = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	client.ResourceSegment = 0
+	entry := testEntry("t0", "", "prod")
+	entry.Path = "/users/7"
+	if got := client.DefaultLabels(entry)["resource"]; got != "users" {
+		t.Errorf("resource label with segment 0 = %q, want users", got)
+	}
+
+	client.ResourceAllowlist = nil
+	if labels := client.DefaultLabels(entry); labels["resource"] != "" {
+		t.Errorf("labels = %v, want no resource label without an allowlist", labels)
+	}
+}
+
+func TestSingleAndBatchSendsShareLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(client *Client)
+		want      map[string]string
+	}{
+		{
+			name: "default labels",
+			configure: func(client *Client) {
+				client.ResourceSegment = 2
+				client.ResourceAllowlist = map[string]bool{"users": true}
+				client.SyntheticLabel = true
+				client.HeaderLabels = map[string]map[string]bool{"client": {"ios": true}}
+			},
+			want: map[string]string{"service": "api", "environment": "prod", "resource": "users", "synthetic": "true", "client": "other"},
+		},
+		{
+			name: "label builder",
+			configure: func(client *Client) {
+				client.LabelBuilder = func(entry middleware.LogEntry) map[string]string {
+					return map[string]string{"app": entry.ServiceName, "http.method": entry.Method}
+				}
+			},
+			want: map[string]string{"app": "api", "http_method": "GET"},
+		},
+	}
+	entry := testEntry("trace-1", "", "prod")
+	entry.SpanID = "span-1"
+	entry.Method = http.MethodGet
+	entry.Path = "/api/v1/users/7"
+	entry.Status = http.StatusNotFound
+	entry.Synthetic = true
+	entry.Labels = map[string]string{"client": "android"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loki := newFakeLoki(t, nil)
+			client := NewClient(loki.URL)
+			tt.configure(client)
+			if err := client.SendLog(entry); err != nil {
+				t.Fatalf("SendLog: %v", err)
+			}
+			if err := client.SendBatchLogs([]middleware.LogEntry{entry}); err != nil {
+				t.Fatalf("SendBatchLogs: %v", err)
+			}
+
+			pushes := loki.received()
+			if len(pushes) != 2 {
+				t.Fatalf("got %d pushes, want 2", len(pushes))
+			}
+			single, batch := pushes[0].request.Streams[0].Stream, pushes[1].request.Streams[0].Stream
+			if !maps.Equal(single, tt.want) || !maps.Equal(batch, tt.want) {
+				t.Errorf("SendLog labels %v, SendBatchLogs labels %v, want %v for both", single, batch, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package loki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Encoding selects how push requests are sent to Loki
+type Encoding string
+
+const (
+	// EncodingJSON sends uncompressed JSON (the default)
+	EncodingJSON Encoding = "json"
+	// EncodingGzip sends gzip-compressed JSON
+	EncodingGzip Encoding = "gzip"
+	// EncodingSnappyProto sends a Snappy-compressed logproto.PushRequest
+	EncodingSnappyProto Encoding = "snappy-proto"
+)
+
+// encodedRequest is a push request body with the headers describing it
+type encodedRequest struct {
+	body            []byte
+	contentType     string
+	contentEncoding string
+}
+
+// encode serializes a push request using the given encoding
+func encode(req PushRequest, encoding Encoding) (encodedRequest, error) {
+	switch encoding {
+	case "", EncodingJSON:
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return encodedRequest{}, fmt.Errorf("failed to marshal Loki request: %w", err)
+		}
+		return encodedRequest{body: payload, contentType: "application/json"}, nil
+
+	case EncodingGzip:
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return encodedRequest{}, fmt.Errorf("failed to marshal Loki request: %w", err)
+		}
+
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(payload); err != nil {
+			return encodedRequest{}, fmt.Errorf("failed to gzip Loki request: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return encodedRequest{}, fmt.Errorf("failed to gzip Loki request: %w", err)
+		}
+		return encodedRequest{body: buf.Bytes(), contentType: "application/json", contentEncoding: "gzip"}, nil
+
+	case EncodingSnappyProto:
+		payload, err := marshalPushRequest(req)
+		if err != nil {
+			return encodedRequest{}, fmt.Errorf("failed to marshal Loki protobuf request: %w", err)
+		}
+		return encodedRequest{
+			body:            snappy.Encode(nil, payload),
+			contentType:     "application/x-protobuf",
+			contentEncoding: "snappy",
+		}, nil
+	}
+
+	return encodedRequest{}, fmt.Errorf("unknown Loki encoding %q", encoding)
+}
+
+// marshalPushRequest encodes a push request as a logproto.PushRequest:
+//
+//	PushRequest   { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter  { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func marshalPushRequest(req PushRequest) ([]byte, error) {
+	var b []byte
+	for _, stream := range req.Streams {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.BytesType)
+		sb = protowire.AppendString(sb, formatLabels(stream.Stream))
+
+		for _, value := range stream.Values {
+			if len(value) != 2 {
+				return nil, fmt.Errorf("log value must be [timestamp, line], got %d elements", len(value))
+			}
+			nanos, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q: %w", value[0], err)
+			}
+
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(nanos/1e9))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(nanos%1e9))
+
+			var eb []byte
+			eb = protowire.AppendTag(eb, 1, protowire.BytesType)
+			eb = protowire.AppendBytes(eb, ts)
+			eb = protowire.AppendTag(eb, 2, protowire.BytesType)
+			eb = protowire.AppendString(eb, value[1])
+
+			sb = protowire.AppendTag(sb, 2, protowire.BytesType)
+			sb = protowire.AppendBytes(sb, eb)
+		}
+
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+	return b, nil
+}
+
+// formatLabels renders labels in the Prometheus selector form Loki expects, e.g. {a="1", b="2"}
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[k]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
@@ -0,0 +1,272 @@
+package loki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodedStream is a logproto.StreamAdapter read back from the wire
+type decodedStream struct {
+	Labels  string
+	Entries []decodedEntry
+}
+
+// decodedEntry is a logproto.EntryAdapter read back from the wire
+type decodedEntry struct {
+	Timestamp time.Time
+	Line      string
+}
+
+// decodePushRequest parses a logproto.PushRequest field by field, independently of
+// marshalPushRequest
+func decodePushRequest(t *testing.T, b []byte) []decodedStream {
+	t.Helper()
+	var streams []decodedStream
+	eachField(t, b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		if num != 1 || typ != protowire.BytesType {
+			t.Fatalf("PushRequest: unexpected field %d of type %d", num, typ)
+		}
+		var s decodedStream
+		eachField(t, v, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+			switch {
+			case num == 1 && typ == protowire.BytesType:
+				s.Labels = string(v)
+			case num == 2 && typ == protowire.BytesType:
+				s.Entries = append(s.Entries, decodeEntry(t, v))
+			default:
+				t.Fatalf("StreamAdapter: unexpected field %d of type %d", num, typ)
+			}
+		})
+		streams = append(streams, s)
+	})
+	return streams
+}
+
+func decodeEntry(t *testing.T, b []byte) decodedEntry {
+	t.Helper()
+	var e decodedEntry
+	eachField(t, b, func(num protowire.Number, typ protowire.Type, v []byte, _ uint64) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var secs, nanos int64
+			eachField(t, v, func(num protowire.Number, typ protowire.Type, _ []byte, n uint64) {
+				switch {
+				case num == 1 && typ == protowire.VarintType:
+					secs = int64(n)
+				case num == 2 && typ == protowire.VarintType:
+					nanos = int64(n)
+				default:
+					t.Fatalf("Timestamp: unexpected field %d of type %d", num, typ)
+				}
+			})
+			e.Timestamp = time.Unix(secs, nanos).UTC()
+		case num == 2 && typ == protowire.BytesType:
+			e.Line = string(v)
+		default:
+			t.Fatalf("EntryAdapter: unexpected field %d of type %d", num, typ)
+		}
+	})
+	return e
+}
+
+// eachField calls fn with each field of a message: its bytes for length-delimited fields,
+// its value for varints
+func eachField(t *testing.T, b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, v, 0)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("bad field %d: %v", num, protowire.ParseError(n))
+			}
+			fn(num, typ, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("field %d has unexpected type %d", num, typ)
+		}
+	}
+}
+
+func testPushRequest() PushRequest {
+	return PushRequest{Streams: []Stream{
+		{
+			Stream: map[string]string{"service": "api", "environment": "prod"},
+			Values: [][]string{
+				{"1767225600000000001", `{"trace_id":"a"}`},
+				{"1767225601500000000", `{"trace_id":"b"}`},
+			},
+		},
+		{
+			Stream: map[string]string{"service": "web"},
+			Values: [][]string{{"1767225602000000000", "line with \"quotes\" and ünïcode"}},
+		},
+	}}
+}
+
+func TestSnappyProtoRoundTrip(t *testing.T) {
+	req := testPushRequest()
+	encoded, err := encode(req, EncodingSnappyProto)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if encoded.contentType != "application/x-protobuf" || encoded.contentEncoding != "snappy" {
+		t.Errorf("headers = %q, %q", encoded.contentType, encoded.contentEncoding)
+	}
+
+	payload, err := snappy.Decode(nil, encoded.body)
+	if err != nil {
+		t.Fatalf("body is not snappy block format: %v", err)
+	}
+
+	var want []decodedStream
+	for _, stream := range req.Streams {
+		s := decodedStream{Labels: formatLabels(stream.Stream)}
+		for _, value := range stream.Values {
+			nanos, _ := strconv.ParseInt(value[0], 10, 64)
+			s.Entries = append(s.Entries, decodedEntry{Timestamp: time.Unix(0, nanos).UTC(), Line: value[1]})
+		}
+		want = append(want, s)
+	}
+	if got := decodePushRequest(t, payload); !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v\nwant    %+v", got, want)
+	}
+	if want[0].Labels != `{environment="prod", service="api"}` {
+		t.Errorf("labels = %s", want[0].Labels)
+	}
+}
+
+func TestPushRequestGoldenBytes(t *testing.T) {
+	// {a="1"} with one entry "x" at 1s+2ns, encoded by hand:
+	// streams(1) { labels(1) "{a=\"1\"}", entries(2) { timestamp(1) { seconds 1, nanos 2 }, line(2) "x" } }
+	const golden = "0a14" + "0a07" + "7b613d2231227d" + "1209" + "0a04" + "08011002" + "1201" + "78"
+
+	b, err := marshalPushRequest(PushRequest{Streams: []Stream{{
+		Stream: map[string]string{"a": "1"},
+		Values: [][]string{{"1000000002", "x"}},
+	}}})
+	if err != nil {
+		t.Fatalf("marshalPushRequest: %v", err)
+	}
+	if got := hex.EncodeToString(b); got != golden {
+		t.Errorf("encoded %s\nwant    %s", got, golden)
+	}
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	req := testPushRequest()
+	encoded, err := encode(req, EncodingGzip)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if encoded.contentType != "application/json" || encoded.contentEncoding != "gzip" {
+		t.Errorf("headers = %q, %q", encoded.contentType, encoded.contentEncoding)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(encoded.body))
+	if err != nil {
+		t.Fatalf("body is not gzip: %v", err)
+	}
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	var got PushRequest
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatalf("decoding JSON: %v", err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Errorf("decoded %+v\nwant    %+v", got, req)
+	}
+}
+
+func TestMarshalPushRequestRejectsBadValues(t *testing.T) {
+	for _, value := range [][]string{{"1"}, {"not-a-number", "line"}} {
+		req := PushRequest{Streams: []Stream{{Stream: map[string]string{"a": "1"}, Values: [][]string{value}}}}
+		if _, err := marshalPushRequest(req); err == nil {
+			t.Errorf("value %q accepted", value)
+		}
+	}
+}
+
+func TestClientSendsEncodedBody(t *testing.T) {
+	entry := middleware.LogEntry{Timestamp: time.Unix(1767225600, 5).UTC(), TraceID: "t0", ServiceName: "api", Environment: "prod"}
+
+	for _, encoding := range []Encoding{EncodingSnappyProto, EncodingGzip} {
+		t.Run(string(encoding), func(t *testing.T) {
+			loki := newFakeLoki(t, nil)
+			client := NewClient(loki.URL)
+			client.Encoding = encoding
+
+			if err := client.SendBatchLogsContext(context.Background(), []middleware.LogEntry{entry}); err != nil {
+				t.Fatalf("SendBatchLogsContext: %v", err)
+			}
+			pushes := loki.received()
+			if len(pushes) != 1 {
+				t.Fatalf("%d pushes, want 1", len(pushes))
+			}
+			p := pushes[0]
+
+			var line string
+			switch encoding {
+			case EncodingSnappyProto:
+				if p.header.Get("Content-Encoding") != "snappy" {
+					t.Errorf("Content-Encoding = %q", p.header.Get("Content-Encoding"))
+				}
+				payload, err := snappy.Decode(nil, p.body)
+				if err != nil {
+					t.Fatalf("snappy: %v", err)
+				}
+				streams := decodePushRequest(t, payload)
+				if len(streams) != 1 || len(streams[0].Entries) != 1 {
+					t.Fatalf("decoded %+v", streams)
+				}
+				if !streams[0].Entries[0].Timestamp.Equal(entry.Timestamp) {
+					t.Errorf("timestamp = %v, want %v", streams[0].Entries[0].Timestamp, entry.Timestamp)
+				}
+				line = streams[0].Entries[0].Line
+			case EncodingGzip:
+				zr, err := gzip.NewReader(bytes.NewReader(p.body))
+				if err != nil {
+					t.Fatalf("gzip: %v", err)
+				}
+				var req PushRequest
+				if err := json.NewDecoder(zr).Decode(&req); err != nil {
+					t.Fatalf("JSON: %v", err)
+				}
+				line = req.Streams[0].Values[0][1]
+			}
+
+			var got middleware.LogEntry
+			if err := json.Unmarshal([]byte(line), &got); err != nil || got.TraceID != "t0" {
+				t.Errorf("log line %q decodes to %+v, %v", line, got, err)
+			}
+		})
+	}
+}
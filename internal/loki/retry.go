@@ -0,0 +1,87 @@
+package loki
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryDelay caps the delay between push attempts, including Loki's Retry-After
+const maxRetryDelay = 30 * time.Second
+
+// defaultRetryBaseDelay is used when Client.RetryBaseDelay is unset
+const defaultRetryBaseDelay = 500 * time.Millisecond
+
+// PushError describes a failed push to Loki
+type PushError struct {
+	// StatusCode is Loki's response status, or 0 when the request never got a response
+	StatusCode int
+	// Body is Loki's error response
+	Body string
+	// RetryAfter is the delay Loki asked for with a Retry-After header
+	RetryAfter time.Duration
+	// Err is the transport error when the request failed before a response
+	Err error
+}
+
+func (e *PushError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("failed to send request to Loki: %v", e.Err)
+	}
+	return fmt.Sprintf("Loki returned error status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the push may succeed if sent again: network errors,
+// 429 rate limiting and 5xx responses are retryable, other 4xx responses are not
+func (e *PushError) Retryable() bool {
+	return e.StatusCode == 0 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// IsRetryable reports whether err is a push failure that may succeed if retried
+func IsRetryable(err error) bool {
+	var pushErr *PushError
+	return errors.As(err, &pushErr) && pushErr.Retryable()
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// retryDelay returns how long to wait before the next attempt, preferring Loki's Retry-After
+// over exponential backoff with jitter
+func (c *Client) retryDelay(err *PushError, attempt int) time.Duration {
+	if err.RetryAfter > 0 {
+		return min(err.RetryAfter, maxRetryDelay)
+	}
+
+	base := c.RetryBaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+
+	delay := base << (attempt - 1)
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
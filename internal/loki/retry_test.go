@@ -0,0 +1,202 @@
+package loki
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+	"logtrace/internal/sink"
+)
+
+// respondInTurn answers successive pushes with the given statuses, then 204s; a status
+// of 429 or 503 asks for retryAfter when it is set
+func respondInTurn(retryAfter string, statuses ...int) func(w http.ResponseWriter, p push) {
+	var n atomic.Int32
+	return func(w http.ResponseWriter, p push) {
+		i := int(n.Add(1)) - 1
+		if i >= len(statuses) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		http.Error(w, http.StatusText(statuses[i]), statuses[i])
+	}
+}
+
+func sendOne(ctx context.Context, client *Client) error {
+	return client.SendBatchLogsContext(ctx, []middleware.LogEntry{testEntry("t0", "", "prod")})
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	loki := newFakeLoki(t, respondInTurn("1", http.StatusTooManyRequests))
+	client := NewClient(loki.URL)
+	client.MaxAttempts = 3
+	client.RetryBaseDelay = time.Millisecond
+
+	start := time.Now()
+	if err := sendOne(context.Background(), client); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want Loki's Retry-After of 1s", elapsed)
+	}
+	if n := len(loki.received()); n != 2 {
+		t.Errorf("%d pushes, want 2", n)
+	}
+}
+
+func TestRetryBacksOffOnServerErrors(t *testing.T) {
+	loki := newFakeLoki(t, respondInTurn("", http.StatusServiceUnavailable, http.StatusServiceUnavailable))
+	client := NewClient(loki.URL)
+	client.MaxAttempts = 3
+	client.RetryBaseDelay = 20 * time.Millisecond
+
+	start := time.Now()
+	if err := sendOne(context.Background(), client); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	// Backoff doubles from the base delay: at least 20ms then 40ms
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("three attempts took %s, want at least 60ms of backoff", elapsed)
+	}
+	if n := len(loki.received()); n != 3 {
+		t.Errorf("%d pushes, want 3", n)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	loki := newFakeLoki(t, respondInTurn("", http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable))
+	client := NewClient(loki.URL)
+	client.MaxAttempts = 3
+	client.RetryBaseDelay = time.Millisecond
+
+	err := sendOne(context.Background(), client)
+	var pushErr *PushError
+	if !errors.As(err, &pushErr) || pushErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want the 503", err)
+	}
+	if !sink.IsRetryable(err) {
+		t.Error("exhausted 503 not retryable, so the consumer wouldn't redeliver it")
+	}
+	if n := len(loki.received()); n != 3 {
+		t.Errorf("%d pushes, want 3", n)
+	}
+}
+
+func TestRetrySkipsClientErrors(t *testing.T) {
+	loki := newFakeLoki(t, respondInTurn("", http.StatusBadRequest))
+	client := NewClient(loki.URL)
+	client.MaxAttempts = 3
+	client.RetryBaseDelay = time.Millisecond
+
+	err := sendOne(context.Background(), client)
+	var pushErr *PushError
+	if !errors.As(err, &pushErr) || pushErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("err = %v, want the 400", err)
+	}
+	if sink.IsRetryable(err) {
+		t.Error("400 reported retryable")
+	}
+	if n := len(loki.received()); n != 1 {
+		t.Errorf("%d pushes, want 1", n)
+	}
+}
+
+func TestRetryStopsWhenContextCanceled(t *testing.T) {
+	pushed := make(chan struct{}, 1)
+	loki := newFakeLoki(t, func(w http.ResponseWriter, p push) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		pushed <- struct{}{}
+	})
+	client := NewClient(loki.URL)
+	client.MaxAttempts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-pushed
+		time.Sleep(20 * time.Millisecond) // let the client start waiting
+		cancel()
+	}()
+
+	start := time.Now()
+	err := sendOne(ctx, client)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("returned after %s, not when canceled", elapsed)
+	}
+	if n := len(loki.received()); n != 1 {
+		t.Errorf("%d pushes, want 1", n)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelayIsCapped(t *testing.T) {
+	client := &Client{RetryBaseDelay: time.Second}
+	if got := client.retryDelay(&PushError{RetryAfter: time.Hour}, 1); got != maxRetryDelay {
+		t.Errorf("Retry-After of an hour waits %s, want %s", got, maxRetryDelay)
+	}
+	for attempt := 1; attempt < 70; attempt++ {
+		if got := client.retryDelay(&PushError{StatusCode: 503}, attempt); got <= 0 || got > maxRetryDelay*3/2 {
+			t.Fatalf("attempt %d waits %s", attempt, got)
+		}
+	}
+}
+
+func TestCancelInterruptsHungPush(t *testing.T) {
+	pushed := make(chan struct{}, 1)
+	release := make(chan struct{})
+	loki := newFakeLoki(t, func(w http.ResponseWriter, p push) {
+		pushed <- struct{}{}
+		<-release
+	})
+	// Cleanups run last first, so the handler returns before the server closes
+	t.Cleanup(func() { close(release) })
+	client := NewClient(loki.URL)
+	client.MaxAttempts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-pushed
+		cancel()
+	}()
+
+	start := time.Now()
+	err := sendOne(ctx, client)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %s, not when canceled", elapsed)
+	}
+	if n := len(loki.received()); n != 1 {
+		t.Errorf("%d pushes, want 1 with no retry after the cancel", n)
+	}
+}
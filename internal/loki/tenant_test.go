@@ -0,0 +1,91 @@
+package loki
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"logtrace/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// capturedJS keeps what the Logger publishes in place of JetStream
+type capturedJS struct {
+	jetstream.JetStream
+
+	mu   sync.Mutex
+	msgs [][]byte
+}
+
+func (c *capturedJS) Publish(_ context.Context, _ string, data []byte, _ ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.msgs = append(c.msgs, data)
+	return &jetstream.PubAck{Stream: "logs"}, nil
+}
+
+func TestBaggageTenantBecomesScopeOrgID(t *testing.T) {
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	// The API logs each request with the tenant from its baggage
+	gin.SetMode(gin.TestMode)
+	js := &capturedJS{}
+	r := gin.New()
+	r.Use(middleware.Tracing("api"))
+	r.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
+		JS:               js,
+		Subject:          "logs.api",
+		ServiceName:      "api",
+		TenantBaggageKey: "tenant.id",
+	}))
+	r.GET("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tenant := range []string{"acme", "globex", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		if tenant != "" {
+			req.Header.Set("baggage", "tenant.id="+tenant)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The consumer decodes the entries and pushes each tenant's with its X-Scope-OrgID
+	var entries []middleware.LogEntry
+	for _, data := range js.msgs {
+		entry, err := middleware.DecodeLogEntry(data)
+		if err != nil {
+			t.Fatalf("DecodeLogEntry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	loki := newFakeLoki(t, nil)
+	client := NewClient(loki.URL)
+	client.TenantField = "tenant"
+	client.DefaultTenant = "shared"
+	if err := client.SendBatchLogsContext(context.Background(), entries); err != nil {
+		t.Fatalf("SendBatchLogsContext: %v", err)
+	}
+
+	got := make(map[string][]string)
+	for _, p := range loki.received() {
+		for _, entry := range p.entries(t) {
+			got[p.tenant] = append(got[p.tenant], entry.Tenant)
+		}
+	}
+	want := map[string][]string{"acme": {"acme"}, "globex": {"globex"}, "shared": {""}}
+	if len(got) != len(want) {
+		t.Fatalf("pushed tenants %v, want %v", got, want)
+	}
+	for tenant, tenants := range want {
+		if len(got[tenant]) != 1 || got[tenant][0] != tenants[0] {
+			t.Errorf("X-Scope-OrgID %q carried entries with tenants %q, want %q", tenant, got[tenant], tenants)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package loki
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the Loki client's spans
+const tracerName = "logtrace/loki"
+
+// startPushSpan starts the span covering one push request to Loki, retries included
+func (c *Client) startPushSpan(ctx context.Context, req PushRequest, encoded encodedRequest, tenant string) (context.Context, trace.Span) {
+	entries := 0
+	for _, stream := range req.Streams {
+		entries += len(stream.Values)
+	}
+
+	encoding := c.Encoding
+	if encoding == "" {
+		encoding = EncodingJSON
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, "loki.push",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.Int("batch.size", entries),
+			attribute.Int("batch.streams", len(req.Streams)),
+			attribute.Int("http.request.body.size", len(encoded.body)),
+			attribute.String("loki.encoding", string(encoding)),
+			attribute.String("loki.tenant", tenant),
+		),
+	)
+}
+
+// endPushSpan records the attempts made and the outcome, then ends the span; status is
+// the last response's status code, zero when Loki never answered
+func endPushSpan(span trace.Span, attempts, status int, err error) {
+	span.SetAttributes(attribute.Int("push.attempts", attempts))
+	if status != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
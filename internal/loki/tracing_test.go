@@ -0,0 +1,117 @@
+package loki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that records every ended span and the W3C trace
+// context propagator, for the test's duration
+func recordSpans(t *testing.T) (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+		provider.Shutdown(context.Background())
+	})
+	return recorder, provider
+}
+
+func TestPushSpan(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int64
+		wantStatus   int64
+		wantErr      bool
+	}{
+		{name: "first attempt", wantAttempts: 1, wantStatus: http.StatusNoContent},
+		{name: "retried", statuses: []int{http.StatusServiceUnavailable}, wantAttempts: 2, wantStatus: http.StatusNoContent},
+		{name: "rejected", statuses: []int{http.StatusBadRequest}, wantAttempts: 1, wantStatus: http.StatusBadRequest, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, provider := recordSpans(t)
+			loki := newFakeLoki(t, respondInTurn("", tt.statuses...))
+			client := NewClient(loki.URL)
+			client.TenantField = "tenant"
+			client.MaxAttempts = 3
+			client.RetryBaseDelay = time.Millisecond
+
+			ctx, parent := provider.Tracer("test").Start(context.Background(), "loki.send")
+			err := client.SendBatchLogsContext(ctx, []middleware.LogEntry{
+				testEntry("t1", "acme", "prod"),
+				testEntry("t2", "acme", "prod"),
+			})
+			parent.End()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SendBatchLogsContext = %v, want an error: %t", err, tt.wantErr)
+			}
+
+			var span sdktrace.ReadOnlySpan
+			for _, s := range recorder.Ended() {
+				if s.Name() == "loki.push" {
+					span = s
+				}
+			}
+			if span == nil {
+				t.Fatal("no loki.push span recorded")
+			}
+			if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+				t.Errorf("loki.push parent = %s, want the send span %s", span.Parent().SpanID(), parent.SpanContext().SpanID())
+			}
+
+			pushes := loki.received()
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, kv := range span.Attributes() {
+				attrs[kv.Key] = kv.Value
+			}
+			want := map[attribute.Key]any{
+				"batch.size":                int64(2),
+				"batch.streams":             int64(1),
+				"http.request.body.size":    int64(len(pushes[0].body)),
+				"loki.encoding":             string(EncodingJSON),
+				"loki.tenant":               "acme",
+				"push.attempts":             tt.wantAttempts,
+				"http.response.status_code": tt.wantStatus,
+			}
+			for key, value := range want {
+				if got := attrs[key].AsInterface(); got != value {
+					t.Errorf("%s = %v, want %v", key, got, value)
+				}
+			}
+			if got := span.Status().Code == codes.Error; got != tt.wantErr {
+				t.Errorf("span status = %v, want an error: %t", span.Status().Code, tt.wantErr)
+			}
+
+			// Every attempt carries the loki.push span as the traceparent
+			sc := span.SpanContext()
+			traceparent := fmt.Sprintf("00-%s-%s-01", sc.TraceID(), sc.SpanID())
+			if len(pushes) != int(tt.wantAttempts) {
+				t.Fatalf("Loki got %d pushes, want %d", len(pushes), tt.wantAttempts)
+			}
+			for i, p := range pushes {
+				if got := p.header.Get("traceparent"); got != traceparent {
+					t.Errorf("push %d: traceparent = %q, want %q", i+1, got, traceparent)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package loki
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"logtrace/internal/middleware"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Read-back counts since the process started, exported by the consumer
+var verifyChecked, verifyMissing, verifyErrors atomic.Int64
+
+// maxPendingChecks bounds the entries awaiting read-back; samples beyond it are skipped
+const maxPendingChecks = 1000
+
+// queryWindow is how far around an entry's timestamp the read-back query looks
+const queryWindow = time.Minute
+
+// pendingCheck is a pushed entry waiting to be read back
+type pendingCheck struct {
+	traceID   string
+	tenant    string
+	labels    map[string]string
+	timestamp time.Time
+	pushedAt  time.Time
+}
+
+// Verifier reads back a sample of pushed entries to detect silent ingestion gaps
+type Verifier struct {
+	client *Client
+	rate   float64
+	delay  time.Duration
+
+	// OnMissing is called for each sampled entry Loki can't find
+	OnMissing func(traceID string)
+	// OnError is called when a read-back query fails
+	OnError func(traceID string, err error)
+
+	mu      sync.Mutex
+	pending []pendingCheck
+}
+
+// NewVerifier samples rate (0-1) of pushed entries and checks for them once delay has passed
+func NewVerifier(client *Client, rate float64, delay time.Duration) *Verifier {
+	return &Verifier{
+		client: client,
+		rate:   rate,
+		delay:  delay,
+	}
+}
+
+// VerifyStats returns how many sampled entries were checked, how many were missing and
+// how many checks failed to query Loki
+func VerifyStats() (checked, missing, errors int64) {
+	return verifyChecked.Load(), verifyMissing.Load(), verifyErrors.Load()
+}
+
+// Sample records a random subset of successfully pushed entries for read-back
+func (v *Verifier) Sample(entries []middleware.LogEntry) {
+	if v == nil || v.rate <= 0 {
+		return
+	}
+
+	now := time.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, entry := range entries {
+		if len(v.pending) >= maxPendingChecks {
+			return
+		}
+		if rand.Float64() >= v.rate {
+			continue
+		}
+		v.pending = append(v.pending, pendingCheck{
+			traceID:   entry.TraceID,
+			tenant:    v.client.tenantOf(entry),
+			labels:    v.client.labelsOf(entry),
+			timestamp: entry.Timestamp,
+			pushedAt:  now,
+		})
+	}
+}
+
+// Run checks due samples every delay until stop is closed
+func (v *Verifier) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(v.delay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			v.checkDue(time.Now())
+		}
+	}
+}
+
+// checkDue reads back every sample pushed at least delay before now
+func (v *Verifier) checkDue(now time.Time) {
+	v.mu.Lock()
+	var due []pendingCheck
+	remaining := v.pending[:0]
+	for _, check := range v.pending {
+		if now.Sub(check.pushedAt) >= v.delay {
+			due = append(due, check)
+		} else {
+			remaining = append(remaining, check)
+		}
+	}
+	v.pending = remaining
+	v.mu.Unlock()
+
+	for _, check := range due {
+		found, err := v.client.HasTrace(check.tenant, check.labels, check.traceID, check.timestamp)
+		if err != nil {
+			verifyErrors.Add(1)
+			if v.OnError != nil {
+				v.OnError(check.traceID, err)
+			}
+			continue
+		}
+
+		verifyChecked.Add(1)
+		if !found {
+			verifyMissing.Add(1)
+			if v.OnMissing != nil {
+				v.OnMissing(check.traceID)
+			}
+		}
+	}
+}
+
+// queryResponse is the part of a Loki query_range response needed to detect results
+type queryResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Result []json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// HasTrace reports whether the stream with the given labels has an entry for traceID
+// around the given timestamp
+func (c *Client) HasTrace(tenant string, labels map[string]string, traceID string, timestamp time.Time) (bool, error) {
+	selector := c.prefixLabels(PushRequest{Streams: []Stream{{Stream: labels}}}).Streams[0].Stream
+
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("%s |= %s", formatLabels(selector), strconv.Quote(traceID)))
+	query.Set("start", strconv.FormatInt(timestamp.Add(-queryWindow).UnixNano(), 10))
+	query.Set("end", strconv.FormatInt(timestamp.Add(queryWindow).UnixNano(), 10))
+	query.Set("limit", "1")
+
+	httpReq, err := http.NewRequest("GET", c.queryURL()+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if tenant != "" {
+		httpReq.Header.Set("X-Scope-OrgID", tenant)
+	}
+
+	resp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return false, fmt.Errorf("failed to query Loki: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read Loki response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return false, fmt.Errorf("Loki returned error status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var result queryResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return false, fmt.Errorf("failed to decode Loki response: %w", err)
+	}
+	return len(result.Data.Result) > 0, nil
+}
+
+// queryURL derives the query_range endpoint from the push URL
+func (c *Client) queryURL() string {
+	if c.QueryURL != "" {
+		return c.QueryURL
+	}
+	return strings.TrimSuffix(c.URL, "/push") + "/query_range"
+}
@@ -0,0 +1,158 @@
+package loki
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"logtrace/internal/middleware"
+)
+
+// fakeQuerier answers Loki query_range requests, finding the trace IDs in stored and
+// failing queries for the trace IDs in broken
+type fakeQuerier struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	queries []url.Values
+	tenants []string
+}
+
+func newFakeQuerier(t *testing.T, stored, broken []string) *fakeQuerier {
+	t.Helper()
+	f := &fakeQuerier{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/query_range") {
+			t.Errorf("queried %s, want the query_range endpoint", r.URL.Path)
+		}
+		query := r.URL.Query()
+		f.mu.Lock()
+		f.queries = append(f.queries, query)
+		f.tenants = append(f.tenants, r.Header.Get("X-Scope-OrgID"))
+		f.mu.Unlock()
+
+		matches := func(traceIDs []string) bool {
+			return slices.ContainsFunc(traceIDs, func(id string) bool {
+				return strings.HasSuffix(query.Get("query"), `|= "`+id+`"`)
+			})
+		}
+		switch {
+		case matches(broken):
+			http.Error(w, "query timeout", http.StatusInternalServerError)
+		case matches(stored):
+			w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[{"stream":{},"values":[]}]}}`))
+		default:
+			w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestVerifierCountsMissingEntries(t *testing.T) {
+	loki := newFakeQuerier(t, []string{"t-found"}, []string{"t-broken"})
+	verifier := NewVerifier(NewClient(loki.URL+"/loki/api/v1/push"), 1, time.Minute)
+	var missing, failed []string
+	verifier.OnMissing = func(traceID string) { missing = append(missing, traceID) }
+	verifier.OnError = func(traceID string, err error) { failed = append(failed, traceID) }
+
+	checked0, missing0, errors0 := VerifyStats()
+	verifier.Sample([]middleware.LogEntry{
+		testEntry("t-found", "", "prod"),
+		testEntry("t-lost", "", "prod"),
+		testEntry("t-broken", "", "prod"),
+	})
+
+	// Nothing is read back before the delay has passed
+	verifier.checkDue(time.Now())
+	if len(loki.queries) != 0 {
+		t.Fatalf("queried Loki %d times before the delay passed", len(loki.queries))
+	}
+
+	verifier.checkDue(time.Now().Add(time.Minute))
+	checked, missed, errs := VerifyStats()
+	if checked-checked0 != 2 || missed-missing0 != 1 || errs-errors0 != 1 {
+		t.Errorf("VerifyStats moved by checked %d, missing %d, errors %d; want 2, 1, 1",
+			checked-checked0, missed-missing0, errs-errors0)
+	}
+	if !slices.Equal(missing, []string{"t-lost"}) {
+		t.Errorf("OnMissing called for %v, want [t-lost]", missing)
+	}
+	if !slices.Equal(failed, []string{"t-broken"}) {
+		t.Errorf("OnError called for %v, want [t-broken]", failed)
+	}
+
+	// Checked samples are not read back again
+	verifier.checkDue(time.Now().Add(time.Hour))
+	if len(loki.queries) != 3 {
+		t.Errorf("queried Loki %d times, want each sample once", len(loki.queries))
+	}
+}
+
+func TestVerifierSample(t *testing.T) {
+	entries := []middleware.LogEntry{testEntry("t1", "", "prod"), testEntry("t2", "", "prod")}
+
+	var nilVerifier *Verifier
+	nilVerifier.Sample(entries)
+
+	off := NewVerifier(NewClient("http://loki"), 0, time.Minute)
+	off.Sample(entries)
+	if len(off.pending) != 0 {
+		t.Errorf("rate 0 sampled %d entries", len(off.pending))
+	}
+
+	full := NewVerifier(NewClient("http://loki"), 1, time.Minute)
+	for range maxPendingChecks {
+		full.Sample(entries[:1])
+	}
+	full.Sample(entries[1:])
+	if len(full.pending) != maxPendingChecks {
+		t.Errorf("pending checks = %d, want them capped at %d", len(full.pending), maxPendingChecks)
+	}
+}
+
+func TestHasTraceQuery(t *testing.T) {
+	loki := newFakeQuerier(t, []string{"t1"}, nil)
+	client := NewClient(loki.URL + "/loki/api/v1/push")
+	client.LabelPrefix = "lt_"
+	timestamp := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	found, err := client.HasTrace("acme", map[string]string{"service_name": "api"}, "t1", timestamp)
+	if err != nil || !found {
+		t.Fatalf("HasTrace = %t, %v; want the stored trace found", found, err)
+	}
+	found, err = client.HasTrace("", map[string]string{"service_name": "api"}, "t2", timestamp)
+	if err != nil || found {
+		t.Fatalf("HasTrace = %t, %v; want an unknown trace not found", found, err)
+	}
+
+	query := loki.queries[0]
+	if got, want := query.Get("query"), `{lt_service_name="api"} |= "t1"`; got != want {
+		t.Errorf("query = %s, want %s", got, want)
+	}
+	if got, want := query.Get("start"), "1767268740000000000"; got != want {
+		t.Errorf("start = %s, want a minute before the entry (%s)", got, want)
+	}
+	if got, want := query.Get("end"), "1767268860000000000"; got != want {
+		t.Errorf("end = %s, want a minute after the entry (%s)", got, want)
+	}
+	if query.Get("limit") != "1" {
+		t.Errorf("limit = %s, want 1", query.Get("limit"))
+	}
+	if !slices.Equal(loki.tenants, []string{"acme", ""}) {
+		t.Errorf("X-Scope-OrgID = %q, want the tenant only when set", loki.tenants)
+	}
+
+	client.QueryURL = loki.URL + "/custom/query_range"
+	if _, err := client.HasTrace("", nil, "t1", timestamp); err != nil {
+		t.Errorf("HasTrace with QueryURL: %v", err)
+	}
+	if _, err := NewClient("http://127.0.0.1:1/push").HasTrace("", nil, "t1", timestamp); err == nil {
+		t.Error("HasTrace succeeded with Loki unreachable")
+	}
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pendingEntry is an encoded entry waiting to be published
+type pendingEntry struct {
+	entry   LogEntry
+	subject string
+	data    []byte
+
+	// spanCtx is the request's span, parent of the publish span
+	spanCtx trace.SpanContext
+}
+
+// asyncQueue publishes entries from a bounded buffer on background workers so a slow
+// NATS connection never adds latency to requests
+type asyncQueue struct {
+	publish func(p pendingEntry)
+	msgs    chan pendingEntry
+
+	// mu guards closed against concurrent enqueue and close
+	mu     sync.RWMutex
+	closed bool
+
+	// pendingMu guards pending and idle; idle is closed whenever pending is zero, so
+	// flush can wait on it while enqueue keeps adding entries
+	pendingMu sync.Mutex
+	pending   int
+	idle      chan struct{}
+
+	workers sync.WaitGroup
+}
+
+func newAsyncQueue(bufferSize, workers int, publish func(p pendingEntry)) *asyncQueue {
+	if bufferSize <= 0 {
+		bufferSize = 1024
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	q := &asyncQueue{
+		publish: publish,
+		msgs:    make(chan pendingEntry, bufferSize),
+		idle:    make(chan struct{}),
+	}
+	close(q.idle)
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+func (q *asyncQueue) work() {
+	defer q.workers.Done()
+	for p := range q.msgs {
+		q.publish(p)
+		q.done()
+	}
+}
+
+// add counts an entry as pending, opening a new idle channel when the queue was idle
+func (q *asyncQueue) add() {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	if q.pending == 0 {
+		q.idle = make(chan struct{})
+	}
+	q.pending++
+}
+
+// done counts a pending entry as finished, waking flush when it was the last
+func (q *asyncQueue) done() {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		close(q.idle)
+	}
+}
+
+// enqueue queues an entry without blocking, reporting false when it was dropped
+func (q *asyncQueue) enqueue(p pendingEntry) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return false
+	}
+
+	q.add()
+	select {
+	case q.msgs <- p:
+		return true
+	default:
+		q.done()
+		return false
+	}
+}
+
+// flush waits until every queued entry has been published or ctx is done
+func (q *asyncQueue) flush(ctx context.Context) error {
+	q.pendingMu.Lock()
+	idle := q.idle
+	q.pendingMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting entries and waits for the workers to drain the queue or ctx to end
+func (q *asyncQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.msgs)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// stalledJS blocks every publish until release is closed, as a stalled NATS connection would
+type stalledJS struct {
+	fakeJS
+	release chan struct{}
+}
+
+func (s *stalledJS) Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	<-s.release
+	return s.fakeJS.Publish(ctx, subject, data, opts...)
+}
+
+// newAsyncRouter returns a router logging asynchronously to a stalled JetStream, and its logger
+func newAsyncRouter(conf LoggerConfig) (*gin.Engine, *RequestLogger, *stalledJS) {
+	js := &stalledJS{release: make(chan struct{})}
+	conf.JS = js
+	conf.Subject = "logs.test"
+	conf.Async = true
+	logger := NewRequestLogger(conf)
+
+	r := gin.New()
+	r.Use(logger.Handler())
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r, logger, js
+}
+
+func TestAsyncRequestsDontWaitForPublish(t *testing.T) {
+	r, logger, js := newAsyncRouter(LoggerConfig{AsyncBufferSize: 10, AsyncWorkers: 2})
+
+	done := make(chan struct{})
+	go func() {
+		for range 5 {
+			serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("requests blocked on a stalled publish")
+	}
+
+	close(js.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := logger.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if n := len(js.entries(t)); n != 5 {
+		t.Errorf("published %d entries after Flush, want 5", n)
+	}
+}
+
+func TestAsyncDropsWhenBufferIsFull(t *testing.T) {
+	var mu sync.Mutex
+	var dropped []error
+	r, logger, js := newAsyncRouter(LoggerConfig{
+		AsyncBufferSize: 2,
+		AsyncWorkers:    1,
+		OnDropEntry: func(_ LogEntry, err error) {
+			mu.Lock()
+			dropped = append(dropped, err)
+			mu.Unlock()
+		},
+	})
+
+	// One entry held by the worker, two buffered, the rest dropped
+	for range 10 {
+		serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+	}
+	close(js.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := logger.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	published := len(js.entries(t))
+	mu.Lock()
+	defer mu.Unlock()
+	if published+len(dropped) != 10 || published > 3 {
+		t.Errorf("published %d and dropped %d of 10 entries, want at most 3 published", published, len(dropped))
+	}
+	for _, err := range dropped {
+		if !errors.Is(err, ErrQueueFull) {
+			t.Errorf("entry dropped with %v, want ErrQueueFull", err)
+		}
+	}
+}
+
+func TestAsyncFlushHonorsContext(t *testing.T) {
+	r, logger, js := newAsyncRouter(LoggerConfig{})
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := logger.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Flush with a stalled publish = %v, want DeadlineExceeded", err)
+	}
+	close(js.release)
+}
+
+func TestAsyncFlushWhileEnqueueing(t *testing.T) {
+	q := newAsyncQueue(16, 4, func(pendingEntry) {})
+	defer q.close(context.Background())
+
+	// Flush must not race with entries enqueued while it waits for the queue to empty
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				q.enqueue(pendingEntry{})
+			}
+		}()
+	}
+	for range 100 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := q.flush(ctx); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		cancel()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	if q.pending != 0 {
+		t.Errorf("%d entries pending after flush", q.pending)
+	}
+}
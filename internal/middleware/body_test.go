@@ -0,0 +1,436 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPooledBufferDoesNotLeakIntoNextRequest(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{MaxResponseBodyBytes: 1 << 20}, func(r *gin.Engine) {
+		r.GET("/big/:size", func(c *gin.Context) {
+			size := map[string]int{"pooled": maxPooledBufferSize / 2, "oversized": 2 * maxPooledBufferSize}[c.Param("size")]
+			c.String(http.StatusOK, strings.Repeat("secret-", size/7))
+		})
+		r.GET("/small", func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		})
+	})
+
+	// Large responses, one small enough for its buffer to go back to the pool, each
+	// followed by small ones that may reuse it
+	for _, path := range []string{"/big/pooled", "/small", "/small", "/big/oversized", "/small", "/small"} {
+		serve(r, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	entries := js.entries(t)
+	if len(entries) != 6 {
+		t.Fatalf("published %d entries, want 6", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Path == "/small" && entry.ResponseBody != "ok" {
+			t.Errorf("small response logged with %d-byte body %.40q...", len(entry.ResponseBody), entry.ResponseBody)
+		}
+	}
+}
+
+func TestBodyBufferPoolResetsBuffers(t *testing.T) {
+	for range 10 {
+		buf := getBodyBuffer()
+		if buf.Len() != 0 {
+			t.Fatalf("pooled buffer holds %d bytes", buf.Len())
+		}
+		buf.WriteString(strings.Repeat("x", 4096))
+		putBodyBuffer(buf)
+	}
+}
+
+func TestPerFieldLimits(t *testing.T) {
+	conf := LoggerConfig{MaxHeaderBytes: 10, MaxRequestBodyBytes: 20, MaxResponseBodyBytes: 5}
+	r, js := newTestRouter(conf, func(r *gin.Engine) {
+		r.POST("/items", func(c *gin.Context) { c.String(http.StatusOK, strings.Repeat("r", 30)) })
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(strings.Repeat("b", 30)))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Long", strings.Repeat("h", 30))
+	req.Header.Set("X-Short", strings.Repeat("h", 10))
+	serve(r, req)
+
+	// Each field is cut at its own limit and marked
+	entry := onlyEntry(t, js)
+	if want := strings.Repeat("h", 10) + truncatedMarker; entry.Headers["X-Long"] != want {
+		t.Errorf("X-Long = %q, want %q", entry.Headers["X-Long"], want)
+	}
+	if want := strings.Repeat("h", 10); entry.Headers["X-Short"] != want {
+		t.Errorf("X-Short = %q, want it whole at the limit", entry.Headers["X-Short"])
+	}
+	if want := strings.Repeat("b", 20) + truncatedMarker; entry.RequestBody != want || !entry.RequestBodyTruncated {
+		t.Errorf("RequestBody = %q (truncated %t), want %q", entry.RequestBody, entry.RequestBodyTruncated, want)
+	}
+	if want := strings.Repeat("r", 5) + truncatedMarker; entry.ResponseBody != want || !entry.ResponseBodyTruncated {
+		t.Errorf("ResponseBody = %q (truncated %t), want %q", entry.ResponseBody, entry.ResponseBodyTruncated, want)
+	}
+}
+
+func TestHeaderLimitDefault(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("X-Long", strings.Repeat("h", defaultMaxFieldBytes+1))
+	serve(r, req)
+
+	if got := onlyEntry(t, js).Headers["X-Long"]; got != strings.Repeat("h", defaultMaxFieldBytes)+truncatedMarker {
+		t.Errorf("X-Long logged with %d bytes, want it cut at the default %d", len(got), defaultMaxFieldBytes)
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	large := strings.Repeat("x", 3*defaultMaxFieldBytes)
+	tests := []struct {
+		name          string
+		limit         int
+		wantBody      string
+		wantTruncated bool
+	}{
+		{name: "zero disables capture", limit: 0, wantBody: ""},
+		{name: "cut at the limit", limit: 100, wantBody: large[:100] + truncatedMarker, wantTruncated: true},
+		{name: "unlimited", limit: UnlimitedBodyBytes, wantBody: large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, js := newTestRouter(LoggerConfig{MaxRequestBodyBytes: tt.limit, MaxResponseBodyBytes: tt.limit}, func(r *gin.Engine) {
+				r.POST("/items", func(c *gin.Context) {
+					body, _ := io.ReadAll(c.Request.Body)
+					c.Data(http.StatusOK, "text/plain", body)
+				})
+			})
+			req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(large))
+			req.Header.Set("Content-Type", "text/plain")
+			w := serve(r, req)
+
+			// The handler and client always see the whole body
+			if w.Body.String() != large {
+				t.Fatalf("handler echoed %d bytes, want %d", w.Body.Len(), len(large))
+			}
+			entry := onlyEntry(t, js)
+			if entry.RequestBody != tt.wantBody || entry.RequestBodyTruncated != tt.wantTruncated {
+				t.Errorf("RequestBody has %d bytes (truncated %t), want %d (truncated %t)",
+					len(entry.RequestBody), entry.RequestBodyTruncated, len(tt.wantBody), tt.wantTruncated)
+			}
+			if entry.ResponseBody != tt.wantBody || entry.ResponseBodyTruncated != tt.wantTruncated {
+				t.Errorf("ResponseBody has %d bytes (truncated %t), want %d (truncated %t)",
+					len(entry.ResponseBody), entry.ResponseBodyTruncated, len(tt.wantBody), tt.wantTruncated)
+			}
+			if entry.BodyCaptureSkipped {
+				t.Error("BodyCaptureSkipped set without a capture limit")
+			}
+		})
+	}
+}
+
+func TestLoggerDefaultsCaptureBodies(t *testing.T) {
+	js := &fakeJS{}
+	r := gin.New()
+	r.Use(Logger(js, "test", "dev", "logs.test"))
+	r.POST("/items", func(c *gin.Context) { c.String(http.StatusOK, strings.Repeat("r", 2*defaultMaxFieldBytes)) })
+
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(`{"name":"widget"}`))
+	req.Header.Set("Content-Type", "application/json")
+	serve(r, req)
+
+	entry := onlyEntry(t, js)
+	if entry.RequestBody != `{"name":"widget"}` || entry.RequestBodyTruncated {
+		t.Errorf("RequestBody = %q (truncated %t), want it whole", entry.RequestBody, entry.RequestBodyTruncated)
+	}
+	if len(entry.ResponseBody) != defaultMaxFieldBytes+len(truncatedMarker) || !entry.ResponseBodyTruncated {
+		t.Errorf("ResponseBody has %d bytes (truncated %t), want it cut at %d", len(entry.ResponseBody), entry.ResponseBodyTruncated, defaultMaxFieldBytes)
+	}
+}
+
+func TestOversizedEntryIsReduced(t *testing.T) {
+	conf := LoggerConfig{MaxMessageBytes: 2000, MaxRequestBodyBytes: UnlimitedBodyBytes, MaxResponseBodyBytes: UnlimitedBodyBytes}
+	r, js := newTestRouter(conf, func(r *gin.Engine) {
+		r.POST("/items/:id", func(c *gin.Context) {
+			body, _ := io.ReadAll(c.Request.Body)
+			c.Data(http.StatusCreated, "text/plain", body)
+		})
+	})
+
+	for _, body := range []string{"small", strings.Repeat("x", 5000)} {
+		req := httptest.NewRequest(http.MethodPost, "/items/7", strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Request-Source", "test")
+		serve(r, req)
+	}
+
+	entries := js.entries(t)
+	if len(entries) != 2 {
+		t.Fatalf("published %d entries, want 2", len(entries))
+	}
+	small, large := entries[0], entries[1]
+	if small.Oversized || small.RequestBody != "small" || small.Headers == nil {
+		t.Errorf("entry within the limit reduced: oversized %t, body %q, headers %v", small.Oversized, small.RequestBody, small.Headers)
+	}
+
+	// The oversized entry keeps the request record but loses its bodies and headers
+	if !large.Oversized || large.RequestBody != "" || large.ResponseBody != "" || large.Headers != nil {
+		t.Errorf("oversized entry: oversized %t, %d and %d body bytes, headers %v; want bodies and headers dropped",
+			large.Oversized, len(large.RequestBody), len(large.ResponseBody), large.Headers)
+	}
+	if large.Path != "/items/7" || large.Route != "/items/:id" || large.Status != http.StatusCreated || large.TraceID == "" {
+		t.Errorf("oversized entry lost the request: %s %s status %d trace %q", large.Path, large.Route, large.Status, large.TraceID)
+	}
+	if n := len(js.msgs[1]); n > conf.MaxMessageBytes {
+		t.Errorf("published %d bytes, over the %d-byte limit", n, conf.MaxMessageBytes)
+	}
+}
+
+func TestContentTypeFields(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{MaxRequestBodyBytes: 1 << 10, MaxResponseBodyBytes: 1 << 10}, func(r *gin.Engine) {
+		r.POST("/items", func(c *gin.Context) { c.JSON(http.StatusCreated, gin.H{"id": 1}) })
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(`{"name":"widget"}`))
+	req.Header.Set("Content-Type", "application/json")
+	serve(r, req)
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	entries := js.entries(t)
+	if len(entries) != 2 {
+		t.Fatalf("published %d entries, want 2", len(entries))
+	}
+	if got := entries[0]; got.RequestContentType != "application/json" || got.ResponseContentType != "application/json; charset=utf-8" {
+		t.Errorf("content types = %q in, %q out; want application/json both ways", got.RequestContentType, got.ResponseContentType)
+	}
+
+	// Neither is sent when there is no content
+	if got := entries[1]; got.RequestContentType != "" || got.ResponseContentType != "" {
+		t.Errorf("content types = %q in, %q out; want none", got.RequestContentType, got.ResponseContentType)
+	}
+	if data := string(js.msgs[1]); strings.Contains(data, "content_type") {
+		t.Errorf("entry without content types encodes them: %s", data)
+	}
+}
+
+func TestResponseBodyTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		types       []string
+		contentType string
+		want        bool
+	}{
+		{"no allowlist", nil, "application/xml", true},
+		{"listed type", []string{"application/json", "text/*"}, "application/json; charset=utf-8", true},
+		{"listed case-insensitively", []string{"Application/JSON"}, "application/json", true},
+		{"wildcard", []string{"application/json", "text/*"}, "text/html", true},
+		{"not listed", []string{"application/json", "text/*"}, "application/xml", false},
+		{"binary even when listed", []string{"image/*"}, "image/png", false},
+		{"binary without an allowlist", nil, "application/octet-stream", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := LoggerConfig{MaxResponseBodyBytes: 1 << 10, ResponseBodyTypes: tt.types}
+			r, js := newTestRouter(conf, func(r *gin.Engine) {
+				r.GET("/items", func(c *gin.Context) { c.Data(http.StatusOK, tt.contentType, []byte("body")) })
+			})
+			w := serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+			if w.Body.String() != "body" {
+				t.Fatalf("client got %q, want the whole response", w.Body.String())
+			}
+			entry := onlyEntry(t, js)
+			if got := entry.ResponseBody == "body"; got != tt.want {
+				t.Errorf("ResponseBody = %q, want it captured: %t", entry.ResponseBody, tt.want)
+			}
+			if entry.ResponseContentType != tt.contentType {
+				t.Errorf("ResponseContentType = %q, want %q either way", entry.ResponseContentType, tt.contentType)
+			}
+		})
+	}
+}
+
+func TestContentTypeSetAfterWrite(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	r, js := newTestRouter(LoggerConfig{MaxResponseBodyBytes: 1 << 10}, func(r *gin.Engine) {
+		// Headers set after the first write never reach the client
+		r.GET("/image", func(c *gin.Context) {
+			c.Writer.Write(png)
+			c.Header("Content-Type", "application/json")
+		})
+		r.GET("/text", func(c *gin.Context) {
+			c.Writer.WriteString("plain text")
+			c.Header("Content-Type", "image/png")
+		})
+		r.GET("/json", func(c *gin.Context) {
+			c.Header("Content-Type", "application/json")
+			c.Writer.WriteString(`{"ok":true}`)
+		})
+	})
+
+	tests := []struct {
+		path        string
+		contentType string
+		body        string
+	}{
+		{"/image", "image/png", ""},
+		{"/text", "text/plain; charset=utf-8", "plain text"},
+		{"/json", "application/json", `{"ok":true}`},
+	}
+	for _, tt := range tests {
+		serve(r, httptest.NewRequest(http.MethodGet, tt.path, nil))
+	}
+
+	// The logged type and body follow what was sent, not the late header
+	entries := js.entries(t)
+	if len(entries) != len(tests) {
+		t.Fatalf("published %d entries, want %d", len(entries), len(tests))
+	}
+	for i, tt := range tests {
+		if entries[i].ResponseContentType != tt.contentType || entries[i].ResponseBody != tt.body {
+			t.Errorf("%s: logged %q with body %q, want %q with %q",
+				tt.path, entries[i].ResponseContentType, entries[i].ResponseBody, tt.contentType, tt.body)
+		}
+	}
+}
+
+func TestConcurrentCaptureLimit(t *testing.T) {
+	const limit, requests = 2, 5
+	started := make(chan struct{}, requests)
+	release := make(chan struct{})
+	conf := LoggerConfig{MaxRequestBodyBytes: 1 << 10, MaxResponseBodyBytes: 1 << 10, MaxConcurrentCaptures: limit}
+	r, js := newTestRouter(conf, func(r *gin.Engine) {
+		r.POST("/items", func(c *gin.Context) {
+			body, _ := io.ReadAll(c.Request.Body)
+			if c.Query("hold") != "" {
+				started <- struct{}{}
+				<-release
+			}
+			c.Data(http.StatusOK, "text/plain", body)
+		})
+	})
+
+	// Hold every request in its handler at once, so only limit of them get a capture slot
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, requests)
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/items?hold=1", strings.NewReader(fmt.Sprintf("body-%d", i)))
+			req.Header.Set("Content-Type", "text/plain")
+			responses[i] = serve(r, req)
+		}()
+	}
+	for range requests {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("requests didn't reach the handler; capture must never block them")
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	for i, w := range responses {
+		if w.Code != http.StatusOK || w.Body.String() != fmt.Sprintf("body-%d", i) {
+			t.Errorf("request %d got %d %q, want its body echoed", i, w.Code, w.Body.String())
+		}
+	}
+	var captured, skipped int
+	for _, entry := range js.entries(t) {
+		switch {
+		case entry.BodyCaptureSkipped && entry.RequestBody == "" && entry.ResponseBody == "":
+			skipped++
+		case !entry.BodyCaptureSkipped && entry.RequestBody != "" && entry.RequestBody == entry.ResponseBody:
+			captured++
+		default:
+			t.Errorf("entry skipped %t with bodies %q and %q", entry.BodyCaptureSkipped, entry.RequestBody, entry.ResponseBody)
+		}
+	}
+	if captured != limit || skipped != requests-limit {
+		t.Errorf("captured %d and skipped %d bodies, want %d and %d", captured, skipped, limit, requests-limit)
+	}
+
+	// The slots are free again once the requests finish
+	req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader("after"))
+	req.Header.Set("Content-Type", "text/plain")
+	serve(r, req)
+	entries := js.entries(t)
+	if last := entries[len(entries)-1]; last.BodyCaptureSkipped || last.RequestBody != "after" {
+		t.Errorf("request after the burst: skipped %t, body %q", last.BodyCaptureSkipped, last.RequestBody)
+	}
+}
+
+func TestHijackedRequest(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{MaxRequestBodyBytes: 1 << 10, MaxResponseBodyBytes: 1 << 10}, func(r *gin.Engine) {
+		r.POST("/ws", func(c *gin.Context) {
+			conn, rw, err := c.Writer.Hijack()
+			if err != nil {
+				t.Errorf("Hijack: %v", err)
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi")
+			rw.Flush()
+		})
+	})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/ws", "text/plain", strings.NewReader("upgrade me"))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hi" {
+		t.Fatalf("client got %q from the hijacked connection", body)
+	}
+
+	// The entry is published once the handler returns, after the client has its response
+	deadline := time.Now().Add(5 * time.Second)
+	for len(js.entries(t)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	entry := onlyEntry(t, js)
+	if !entry.Hijacked || entry.Status != 0 {
+		t.Errorf("entry hijacked %t with status %d, want hijacked without a status", entry.Hijacked, entry.Status)
+	}
+	if entry.RequestBody != "" || entry.ResponseBody != "" || entry.ResponseContentType != "" {
+		t.Errorf("hijacked entry logged bodies %q and %q, response type %q", entry.RequestBody, entry.ResponseBody, entry.ResponseContentType)
+	}
+	if entry.Path != "/ws" || entry.Route != "/ws" || entry.Method != http.MethodPost {
+		t.Errorf("hijacked entry lost the request: %s %s (%s)", entry.Method, entry.Path, entry.Route)
+	}
+}
+
+func BenchmarkLogger(b *testing.B) {
+	// The bodies Logger captures by default
+	conf := LoggerConfig{MaxRequestBodyBytes: defaultMaxFieldBytes, MaxResponseBodyBytes: defaultMaxFieldBytes}
+	r, js := newTestRouter(conf, func(r *gin.Engine) {
+		r.POST("/api/v1/users/:id", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "name": "Ada Lovelace", "roles": []string{"admin", "dev"}})
+		})
+	})
+	js.discard = true
+	body := `{"name":"Ada Lovelace","email":"ada@example.com"}`
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/42", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		serve(r, req)
+	}
+}
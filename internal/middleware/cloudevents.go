@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogFormat selects how log entries are encoded on the wire
+type LogFormat string
+
+const (
+	// FormatJSON publishes the LogEntry as plain JSON
+	FormatJSON LogFormat = "json"
+	// FormatCloudEvents wraps the LogEntry in a CloudEvents 1.0 structured envelope
+	FormatCloudEvents LogFormat = "cloudevents"
+)
+
+// CloudEventType is the CloudEvents type used for log entries
+const CloudEventType = "com.logtrace.log.entry"
+
+// CloudEvent is a CloudEvents 1.0 structured-mode envelope carrying a LogEntry
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// EncodeLogEntry marshals an entry in the given format
+func EncodeLogEntry(entry LogEntry, format LogFormat) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if format != FormatCloudEvents {
+		return data, nil
+	}
+
+	return json.Marshal(CloudEvent{
+		SpecVersion:     "1.0",
+		Type:            CloudEventType,
+		Source:          "/" + entry.ServiceName,
+		ID:              uuid.New().String(),
+		Time:            entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	})
+}
+
+// DecodeLogEntry unmarshals an entry that is either plain JSON or a CloudEvents envelope
+func DecodeLogEntry(data []byte) (LogEntry, error) {
+	var entry LogEntry
+
+	var envelope struct {
+		SpecVersion string          `json:"specversion"`
+		Data        json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return entry, err
+	}
+
+	if envelope.SpecVersion == "" {
+		err := json.Unmarshal(data, &entry)
+		return entry, err
+	}
+
+	if len(envelope.Data) == 0 {
+		return entry, fmt.Errorf("cloudevent has no data")
+	}
+	err := json.Unmarshal(envelope.Data, &entry)
+	return entry, err
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestCloudEventEnvelope(t *testing.T) {
+	entry := LogEntry{
+		Timestamp:   time.Date(2026, 3, 1, 12, 30, 0, 123456789, time.FixedZone("CET", 3600)),
+		TraceID:     "4bf92f3577b34da6a3ce929d0e0e4736",
+		ServiceName: "orders",
+		Method:      http.MethodPost,
+		Path:        "/orders",
+		Status:      http.StatusCreated,
+	}
+	data, err := EncodeLogEntry(entry, FormatCloudEvents)
+	if err != nil {
+		t.Fatalf("EncodeLogEntry: %v", err)
+	}
+
+	var event CloudEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatalf("envelope is not JSON: %v", err)
+	}
+	if event.SpecVersion != "1.0" || event.Type != CloudEventType || event.Source != "/orders" {
+		t.Errorf("specversion %q, type %q, source %q, want 1.0, %s, /orders", event.SpecVersion, event.Type, event.Source, CloudEventType)
+	}
+	if _, err := uuid.Parse(event.ID); err != nil {
+		t.Errorf("id = %q, want a UUID", event.ID)
+	}
+	if event.Time != "2026-03-01T11:30:00.123456789Z" {
+		t.Errorf("time = %q, want the entry timestamp in UTC", event.Time)
+	}
+	if event.DataContentType != "application/json" {
+		t.Errorf("datacontenttype = %q", event.DataContentType)
+	}
+	var inner LogEntry
+	if err := json.Unmarshal(event.Data, &inner); err != nil || inner.TraceID != entry.TraceID {
+		t.Errorf("data = %s, want the entry (%v)", event.Data, err)
+	}
+
+	again, _ := EncodeLogEntry(entry, FormatCloudEvents)
+	var second CloudEvent
+	_ = json.Unmarshal(again, &second)
+	if second.ID == event.ID {
+		t.Errorf("two events share id %s", event.ID)
+	}
+}
+
+func TestDecodeLogEntry(t *testing.T) {
+	entry := LogEntry{
+		Timestamp:   time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+		TraceID:     "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:      "00f067aa0ba902b7",
+		ServiceName: "orders",
+		Path:        "/orders/7",
+		Status:      http.StatusNotFound,
+		Headers:     map[string]string{"Accept": "application/json"},
+		Custom:      map[string]any{"order_id": "7"},
+	}
+	for _, format := range []LogFormat{FormatJSON, FormatCloudEvents, ""} {
+		data, err := EncodeLogEntry(entry, format)
+		if err != nil {
+			t.Fatalf("EncodeLogEntry(%q): %v", format, err)
+		}
+		got, err := DecodeLogEntry(data)
+		if err != nil {
+			t.Fatalf("DecodeLogEntry(%q): %v", format, err)
+		}
+		if !reflect.DeepEqual(got, entry) {
+			t.Errorf("format %q round-tripped to %+v, want %+v", format, got, entry)
+		}
+	}
+
+	for _, data := range []string{
+		`{`,
+		`{"specversion":"1.0","type":"` + CloudEventType + `","id":"1"}`,
+		`{"specversion":"1.0","data":"not an entry"}`,
+	} {
+		if _, err := DecodeLogEntry([]byte(data)); err == nil {
+			t.Errorf("DecodeLogEntry(%s) succeeded, want an error", data)
+		}
+	}
+}
+
+func TestLoggerPublishesCloudEvents(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{Format: FormatCloudEvents}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	if len(js.msgs) != 1 {
+		t.Fatalf("published %d messages, want 1", len(js.msgs))
+	}
+	var event CloudEvent
+	if err := json.Unmarshal(js.msgs[0], &event); err != nil || event.SpecVersion != "1.0" {
+		t.Fatalf("published %s, want a CloudEvent (%v)", js.msgs[0], err)
+	}
+	if entry := onlyEntry(t, js); entry.Path != "/items" || entry.Status != http.StatusOK {
+		t.Errorf("decoded entry %s %d, want /items 200", entry.Path, entry.Status)
+	}
+}
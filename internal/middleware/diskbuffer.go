@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// defaultDiskBufferMaxBytes is used when LoggerConfig.DiskBufferMaxBytes is unset
+const defaultDiskBufferMaxBytes = 64 << 20
+
+// bufferedEntry is one line of the disk buffer
+type bufferedEntry struct {
+	Subject string `json:"subject"`
+	Data    []byte `json:"data"`
+}
+
+// diskBuffer keeps entries that couldn't be published in an append-only file of JSON lines.
+// The file rotates to path.1 at half the cap, replacing the previous path.1, so the oldest
+// entries are dropped and the buffer never takes more than the cap on disk.
+type diskBuffer struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// replaying serializes replays so entries aren't published twice
+	replaying sync.Mutex
+}
+
+func newDiskBuffer(path string, maxBytes int64) *diskBuffer {
+	if maxBytes <= 0 {
+		maxBytes = defaultDiskBufferMaxBytes
+	}
+	return &diskBuffer{path: path, maxBytes: maxBytes}
+}
+
+// append writes an entry to the buffer, rotating it first when it would pass half the cap
+func (b *diskBuffer) append(subject string, data []byte) error {
+	line, err := json.Marshal(bufferedEntry{Subject: subject, Data: data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.file == nil {
+		if err := b.open(); err != nil {
+			return err
+		}
+	}
+	if b.size > 0 && b.size+int64(len(line)) > b.maxBytes/2 {
+		if err := b.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := b.file.Write(line)
+	b.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write log buffer %s: %w", b.path, err)
+	}
+	logsBuffered.Inc()
+	return nil
+}
+
+// open opens the buffer file for appending; b.mu must be held
+func (b *diskBuffer) open() error {
+	file, err := os.OpenFile(b.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open log buffer: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log buffer: %w", err)
+	}
+	b.file, b.size = file, info.Size()
+	return nil
+}
+
+// rotate moves the buffer file to path.1, dropping the entries already there; b.mu must be held
+func (b *diskBuffer) rotate() error {
+	b.file.Close()
+	b.file = nil
+
+	if dropped, err := countLines(b.path + ".1"); err == nil && dropped > 0 {
+		bufferDropped.Add(float64(dropped))
+		log.Printf("Log buffer %s is full, dropping its %d oldest entries", b.path, dropped)
+	}
+	if err := os.Rename(b.path, b.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate log buffer: %w", err)
+	}
+	return b.open()
+}
+
+// take hands the buffered files to a replay, oldest first, by renaming them so new
+// entries start a fresh file. Files left by an interrupted replay come first.
+func (b *diskBuffer) take() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+
+	var taken []string
+	for _, name := range []string{b.path + ".1", b.path} {
+		replay := name + ".replay"
+		if _, err := os.Stat(replay); err == nil {
+			// An interrupted replay still owns this name; its file goes now, this one next time
+			taken = append(taken, replay)
+			continue
+		}
+		if err := os.Rename(name, replay); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return taken, fmt.Errorf("failed to take log buffer: %w", err)
+		}
+		taken = append(taken, replay)
+	}
+	return taken, nil
+}
+
+// replay publishes the buffered entries oldest first and deletes them. Entries that fail
+// to publish again go back into the buffer, so a replay during another outage loses nothing.
+func (b *diskBuffer) replay(publish func(ctx context.Context, subject string, data []byte) error) (int, error) {
+	b.replaying.Lock()
+	defer b.replaying.Unlock()
+
+	files, err := b.take()
+	replayed := 0
+	for _, name := range files {
+		n, replayErr := b.replayFile(name, publish)
+		replayed += n
+		err = errors.Join(err, replayErr)
+	}
+	return replayed, err
+}
+
+// replayFile publishes the entries of one taken file, then removes it
+func (b *diskBuffer) replayFile(name string, publish func(ctx context.Context, subject string, data []byte) error) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read log buffer: %w", err)
+	}
+	defer file.Close()
+
+	replayed := 0
+	failing := false
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry bufferedEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				// A line cut short by a crash mid-write can't be recovered
+				log.Printf("Skipping unreadable entry in log buffer %s: %v", name, jsonErr)
+			} else if !failing && publish(context.Background(), entry.Subject, entry.Data) == nil {
+				replayed++
+				logsReplayed.Inc()
+			} else {
+				// Stop publishing once NATS fails again, keeping the rest in order
+				failing = true
+				if appendErr := b.append(entry.Subject, entry.Data); appendErr != nil {
+					return replayed, appendErr
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read log buffer: %w", err)
+		}
+	}
+
+	if err := os.Remove(name); err != nil {
+		return replayed, fmt.Errorf("failed to remove replayed log buffer: %w", err)
+	}
+	return replayed, nil
+}
+
+// countLines counts the entries in a buffer file
+func countLines(name string) (int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var n int64
+	reader := bufio.NewReader(file)
+	for {
+		_, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,317 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	natsclient "logtrace/internal/nats"
+	"logtrace/internal/natstest"
+)
+
+// replayed collects what a replay publishes, failing every publish after the first failAfter
+type replayed struct {
+	data      []string
+	failAfter int
+}
+
+func (r *replayed) publish(_ context.Context, _ string, data []byte) error {
+	if r.failAfter > 0 && len(r.data) == r.failAfter {
+		return errors.New("nats: no responders")
+	}
+	r.data = append(r.data, string(data))
+	return nil
+}
+
+// appendEntries appends entries first to last-1 to the buffer
+func appendEntries(t *testing.T, b *diskBuffer, first, last int) {
+	t.Helper()
+	for i := first; i < last; i++ {
+		if err := b.append("logs.test", fmt.Appendf(nil, "entry-%03d", i)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+}
+
+// entryRange returns the data of entries first to last-1
+func entryRange(first, last int) []string {
+	var data []string
+	for i := first; i < last; i++ {
+		data = append(data, fmt.Sprintf("entry-%03d", i))
+	}
+	return data
+}
+
+func replayAll(t *testing.T, b *diskBuffer) []string {
+	t.Helper()
+	var r replayed
+	n, err := b.replay(r.publish)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if n != len(r.data) {
+		t.Errorf("replay reported %d entries, published %d", n, len(r.data))
+	}
+	return r.data
+}
+
+func TestDiskBufferReplaysInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.buf")
+	b := newDiskBuffer(path, 0)
+	appendEntries(t, b, 0, 5)
+
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(0, 5)) {
+		t.Errorf("replayed %v, want %v", got, entryRange(0, 5))
+	}
+	if got := replayAll(t, b); len(got) != 0 {
+		t.Errorf("second replay published %v", got)
+	}
+
+	// Entries appended after a replay start a fresh file
+	appendEntries(t, b, 5, 7)
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(5, 7)) {
+		t.Errorf("replayed %v, want %v", got, entryRange(5, 7))
+	}
+	if files, _ := filepath.Glob(path + "*"); len(files) != 0 {
+		t.Errorf("replays left %v behind", files)
+	}
+}
+
+func TestDiskBufferReopensExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.buf")
+	appendEntries(t, newDiskBuffer(path, 0), 0, 3)
+
+	// A restarted process appends after what the previous one left
+	b := newDiskBuffer(path, 0)
+	appendEntries(t, b, 3, 5)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.size != info.Size() {
+		t.Errorf("reopened buffer counts %d bytes, file has %d", b.size, info.Size())
+	}
+
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(0, 5)) {
+		t.Errorf("replayed %v, want %v", got, entryRange(0, 5))
+	}
+}
+
+func TestDiskBufferStaysUnderCap(t *testing.T) {
+	const maxBytes = 1000
+	path := filepath.Join(t.TempDir(), "logs.buf")
+	b := newDiskBuffer(path, maxBytes)
+	dropped := testutil.ToFloat64(bufferDropped)
+	appendEntries(t, b, 0, 100)
+
+	var size int64
+	for _, name := range []string{path, path + ".1"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	if size > maxBytes {
+		t.Errorf("buffer takes %d bytes on disk, cap is %d", size, maxBytes)
+	}
+
+	// What survives is the newest entries, oldest first and without gaps
+	got := replayAll(t, b)
+	if len(got) == 0 || len(got) == 100 {
+		t.Fatalf("replayed %d of 100 entries after rotation", len(got))
+	}
+	if want := entryRange(100-len(got), 100); !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if n := testutil.ToFloat64(bufferDropped) - dropped; n != float64(100-len(got)) {
+		t.Errorf("counted %g dropped entries, lost %d", n, 100-len(got))
+	}
+}
+
+func TestDiskBufferSkipsCorruptRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.buf")
+	b := newDiskBuffer(path, 0)
+	appendEntries(t, b, 0, 1)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A corrupt line, then a good one, then a line cut short by a crash mid-write
+	fmt.Fprintln(file, "not json")
+	fmt.Fprintln(file, `{"subject":"logs.test","data":"`+base64.StdEncoding.EncodeToString([]byte("entry-001"))+`"}`)
+	fmt.Fprint(file, `{"subject":"logs.test","da`)
+	file.Close()
+
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(0, 2)) {
+		t.Errorf("replayed %v, want %v", got, entryRange(0, 2))
+	}
+
+	// The truncated record is gone with its file, so later entries replay cleanly
+	appendEntries(t, b, 2, 3)
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(2, 3)) {
+		t.Errorf("replayed %v, want %v", got, entryRange(2, 3))
+	}
+}
+
+func TestDiskBufferKeepsEntriesFailingReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.buf")
+	b := newDiskBuffer(path, 0)
+	appendEntries(t, b, 0, 5)
+
+	r := replayed{failAfter: 2}
+	if n, err := b.replay(r.publish); err != nil || n != 2 {
+		t.Fatalf("replay during an outage = %d, %v; want 2, nil", n, err)
+	}
+	if !slices.Equal(r.data, entryRange(0, 2)) {
+		t.Errorf("replayed %v, want %v", r.data, entryRange(0, 2))
+	}
+
+	// The rest stay buffered ahead of entries appended later
+	appendEntries(t, b, 5, 6)
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(2, 6)) {
+		t.Errorf("replayed %v, want %v", got, entryRange(2, 6))
+	}
+}
+
+func TestDiskBufferResumesInterruptedReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs.buf")
+	b := newDiskBuffer(path, 0)
+	appendEntries(t, b, 0, 2)
+	if _, err := b.take(); err != nil {
+		t.Fatal(err)
+	}
+	// The process died mid-replay, then buffered more before the next one
+	appendEntries(t, b, 2, 4)
+
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(0, 2)) {
+		t.Errorf("first replay published %v, want the interrupted %v", got, entryRange(0, 2))
+	}
+	if got := replayAll(t, b); !slices.Equal(got, entryRange(2, 4)) {
+		t.Errorf("second replay published %v, want %v", got, entryRange(2, 4))
+	}
+}
+
+func TestRequestLoggerBuffersFailedPublishes(t *testing.T) {
+	conf := LoggerConfig{
+		DiskBufferPath: filepath.Join(t.TempDir(), "logs.buf"),
+		OnDropEntry: func(entry LogEntry, err error) {
+			t.Errorf("entry for %s dropped: %v", entry.Path, err)
+		},
+	}
+	js := &fakeJS{err: errors.New("nats: connection closed")}
+	conf.JS = js
+	conf.Subject = "logs.test"
+	logger := NewRequestLogger(conf)
+
+	r := gin.New()
+	r.Use(logger.Handler())
+	r.GET("/items/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	buffered, replayed := testutil.ToFloat64(logsBuffered), testutil.ToFloat64(logsReplayed)
+	for i := range 3 {
+		serve(r, httptest.NewRequest(http.MethodGet, "/items/"+strconv.Itoa(i), nil))
+	}
+	if len(js.entries(t)) != 0 {
+		t.Fatal("entries published during the outage")
+	}
+
+	js.mu.Lock()
+	js.err = nil
+	js.mu.Unlock()
+	n, err := logger.ReplayBuffer()
+	if err != nil || n != 3 {
+		t.Fatalf("ReplayBuffer = %d, %v; want 3, nil", n, err)
+	}
+	var paths []string
+	for _, entry := range js.entries(t) {
+		paths = append(paths, entry.Path)
+	}
+	if want := []string{"/items/0", "/items/1", "/items/2"}; !slices.Equal(paths, want) {
+		t.Errorf("replayed %v, want %v", paths, want)
+	}
+	if b, r := testutil.ToFloat64(logsBuffered)-buffered, testutil.ToFloat64(logsReplayed)-replayed; b != 3 || r != 3 {
+		t.Errorf("counted %g buffered and %g replayed entries, want 3 each", b, r)
+	}
+}
+
+func TestBufferReplaysWhenNATSReconnects(t *testing.T) {
+	storeDir := t.TempDir()
+	s := natstest.RunServer(t, func(opts *server.Options) { opts.StoreDir = storeDir })
+	port := s.Addr().(*net.TCPAddr).Port
+
+	bufferPath := filepath.Join(t.TempDir(), "logs.buf")
+	var logger *RequestLogger
+	replays := make(chan int, 10)
+	client, err := natsclient.NewClient(natsclient.Config{
+		URL:             s.ClientURL(),
+		ReconnectWait:   50 * time.Millisecond,
+		MaxReconnects:   -1,
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>"},
+		RetentionPolicy: jetstream.LimitsPolicy,
+		StorageType:     jetstream.FileStorage,
+		MaxAge:          time.Hour,
+		Replicas:        1,
+		// Wired as the API does
+		OnReconnect: func() {
+			n, err := logger.ReplayBuffer()
+			if err != nil {
+				t.Errorf("ReplayBuffer: %v", err)
+			}
+			replays <- n
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	logger = NewRequestLogger(LoggerConfig{JS: client.JS, DiskBufferPath: bufferPath})
+
+	s.Shutdown()
+	s.WaitForShutdown()
+	// Entries that failed while NATS was down
+	appendEntries(t, newDiskBuffer(bufferPath, 0), 0, 3)
+
+	natstest.RunServer(t, func(opts *server.Options) {
+		opts.Port = port
+		opts.StoreDir = storeDir
+	})
+	select {
+	case n := <-replays:
+		if n != 3 {
+			t.Fatalf("replayed %d entries on reconnect, want 3", n)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no replay after NATS came back")
+	}
+
+	stream, err := client.JS.Stream(context.Background(), "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range entryRange(0, 3) {
+		msg, err := stream.GetMsg(context.Background(), uint64(i+1))
+		if err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+		if string(msg.Data) != want {
+			t.Errorf("message %d = %q, want %q", i+1, msg.Data, want)
+		}
+	}
+}
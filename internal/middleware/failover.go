@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	clusterPrimary   = 0
+	clusterSecondary = 1
+
+	// publishTimeout bounds how long a single publish waits for the JetStream ack
+	publishTimeout = 5 * time.Second
+)
+
+// activeCluster is the NATS cluster the Logger publishes to (0 = primary, 1 = secondary),
+// exported as logger_active_nats_cluster
+var activeCluster atomic.Int64
+
+// ActiveCluster returns the cluster the Logger currently publishes to (0 = primary, 1 = secondary)
+func ActiveCluster() int64 {
+	return activeCluster.Load()
+}
+
+// failoverPublisher publishes to the primary JetStream context and switches to the
+// secondary after repeated failures, retrying the primary once the retry interval passes
+type failoverPublisher struct {
+	primary   jetstream.JetStream
+	secondary jetstream.JetStream
+	threshold int
+	retry     time.Duration
+
+	// now returns the current time; nil uses time.Now
+	now func() time.Time
+
+	mu         sync.Mutex
+	failures   int
+	active     int
+	switchedAt time.Time
+}
+
+func newFailoverPublisher(primary, secondary jetstream.JetStream, threshold int, retry time.Duration) *failoverPublisher {
+	if threshold <= 0 {
+		threshold = 3
+	}
+	if retry <= 0 {
+		retry = 30 * time.Second
+	}
+	return &failoverPublisher{
+		primary:   primary,
+		secondary: secondary,
+		threshold: threshold,
+		retry:     retry,
+	}
+}
+
+// Publish sends data to the active cluster, failing over when the primary keeps failing
+func (p *failoverPublisher) Publish(ctx context.Context, subject string, data []byte) (*jetstream.PubAck, error) {
+	if p.secondary == nil {
+		return publish(ctx, p.primary, subject, data)
+	}
+
+	p.mu.Lock()
+	// Give the primary another chance once the retry interval has elapsed
+	if p.active == clusterSecondary && p.clock().Sub(p.switchedAt) >= p.retry {
+		p.setActive(clusterPrimary)
+	}
+	active := p.active
+	p.mu.Unlock()
+
+	if active == clusterSecondary {
+		return publish(ctx, p.secondary, subject, data)
+	}
+
+	ack, err := publish(ctx, p.primary, subject, data)
+	if err == nil {
+		p.mu.Lock()
+		p.failures = 0
+		p.mu.Unlock()
+		return ack, nil
+	}
+
+	p.mu.Lock()
+	p.failures++
+	if p.failures >= p.threshold && p.active == clusterPrimary {
+		log.Printf("Primary NATS cluster failed %d times, failing over to secondary: %v", p.failures, err)
+		p.setActive(clusterSecondary)
+	}
+	p.mu.Unlock()
+
+	// Don't lose the current entry; send it to the secondary straight away
+	return publish(ctx, p.secondary, subject, data)
+}
+
+// publish sends data to a JetStream cluster and waits up to publishTimeout for the ack
+func publish(ctx context.Context, js jetstream.JetStream, subject string, data []byte) (*jetstream.PubAck, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	return js.Publish(ctx, subject, data)
+}
+
+func (p *failoverPublisher) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// setActive switches the active cluster; callers must hold p.mu
+func (p *failoverPublisher) setActive(cluster int) {
+	p.active = cluster
+	p.failures = 0
+	p.switchedAt = p.clock()
+	activeCluster.Store(int64(cluster))
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errPrimaryDown = errors.New("nats: no responders available for request")
+
+// newTestFailover returns a publisher over two fake clusters, switching after 3 failures
+// and retrying the primary after 30s on a clock the test moves
+func newTestFailover(t *testing.T) (p *failoverPublisher, primary, secondary *fakeJS, now *time.Time) {
+	t.Helper()
+	primary, secondary = &fakeJS{}, &fakeJS{}
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p = newFailoverPublisher(primary, secondary, 3, 30*time.Second)
+	p.now = func() time.Time { return clock }
+	// The active cluster is process-wide; leave it on the primary for other tests
+	t.Cleanup(func() { activeCluster.Store(clusterPrimary) })
+	return p, primary, secondary, &clock
+}
+
+// publishTo publishes one message, failing the test unless it was accepted
+func publishTo(t *testing.T, p *failoverPublisher, data string) {
+	t.Helper()
+	if _, err := p.Publish(context.Background(), "logs.test", []byte(data)); err != nil {
+		t.Fatalf("Publish(%s): %v", data, err)
+	}
+}
+
+func TestFailoverAfterThresholdFailures(t *testing.T) {
+	p, primary, secondary, _ := newTestFailover(t)
+	primary.err = errPrimaryDown
+
+	// Each failure still reaches the secondary, but the primary stays active until the third
+	for i, data := range []string{"a", "b"} {
+		publishTo(t, p, data)
+		if ActiveCluster() != clusterPrimary {
+			t.Fatalf("failed over after %d failures, threshold is 3", i+1)
+		}
+	}
+	publishTo(t, p, "c")
+	if ActiveCluster() != clusterSecondary {
+		t.Fatal("still on the primary after 3 failures")
+	}
+
+	// Once failed over, the primary isn't tried at all
+	publishTo(t, p, "d")
+	if primary.calls != 3 {
+		t.Errorf("primary tried %d times, want 3", primary.calls)
+	}
+	if got := len(secondary.msgs); got != 4 {
+		t.Errorf("secondary got %d messages, want all 4", got)
+	}
+}
+
+func TestFailoverResetsOnPrimarySuccess(t *testing.T) {
+	p, primary, _, _ := newTestFailover(t)
+
+	// Failures must be consecutive to fail over
+	for range 3 {
+		primary.err = errPrimaryDown
+		publishTo(t, p, "fails")
+		publishTo(t, p, "fails")
+		primary.err = nil
+		publishTo(t, p, "ok")
+	}
+	if ActiveCluster() != clusterPrimary {
+		t.Error("failed over on failures that weren't consecutive")
+	}
+}
+
+func TestFailbackAfterRetryInterval(t *testing.T) {
+	p, primary, secondary, now := newTestFailover(t)
+	primary.err = errPrimaryDown
+	for range 3 {
+		publishTo(t, p, "fails")
+	}
+	primary.err = nil
+
+	*now = now.Add(29 * time.Second)
+	publishTo(t, p, "before retry")
+	if ActiveCluster() != clusterSecondary || len(primary.msgs) != 0 {
+		t.Fatal("primary retried before the retry interval")
+	}
+
+	*now = now.Add(time.Second)
+	publishTo(t, p, "after retry")
+	if ActiveCluster() != clusterPrimary {
+		t.Fatal("no failback after the retry interval")
+	}
+	if len(primary.msgs) != 1 || len(secondary.msgs) != 4 {
+		t.Errorf("primary got %d and secondary %d messages, want 1 and 4", len(primary.msgs), len(secondary.msgs))
+	}
+
+	// A primary still down on retry fails over again after another threshold of failures
+	primary.err = errPrimaryDown
+	*now = now.Add(30 * time.Second)
+	for range 3 {
+		publishTo(t, p, "fails again")
+	}
+	if ActiveCluster() != clusterSecondary {
+		t.Error("no second failover")
+	}
+}
+
+func TestLoggerPublishesToSecondaryWhenPrimaryFails(t *testing.T) {
+	t.Cleanup(func() { activeCluster.Store(clusterPrimary) })
+	primary, secondary := &fakeJS{err: errPrimaryDown}, &fakeJS{}
+	logger := NewRequestLogger(LoggerConfig{
+		JS:                primary,
+		Secondary:         secondary,
+		Subject:           "logs.test",
+		FailoverThreshold: 2,
+		OnDropEntry: func(entry LogEntry, err error) {
+			t.Errorf("entry for %s dropped: %v", entry.Path, err)
+		},
+	})
+	r := gin.New()
+	r.Use(logger.Handler())
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for range 3 {
+		serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+	}
+	if n := len(secondary.entries(t)); n != 3 {
+		t.Errorf("secondary got %d entries, want 3", n)
+	}
+	if primary.calls != 2 {
+		t.Errorf("primary tried %d times, want 2 before failing over", primary.calls)
+	}
+	if ActiveCluster() != clusterSecondary {
+		t.Error("logger didn't fail over")
+	}
+}
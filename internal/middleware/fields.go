@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+const customFieldsKey = "log_custom_fields"
+
+// reservedFields are the JSON names of LogEntry's own fields, which custom fields may not
+// use so a flattened entry (e.g. LogQL's | json) can't be confused about which one it sees
+var reservedFields = jsonFieldNames(reflect.TypeOf(LogEntry{}))
+
+// AddLogField attaches a field to the request's log entry, logged under "custom". Later
+// calls with the same key overwrite earlier ones; keys naming one of LogEntry's own fields
+// (e.g. "status", "trace_id") are ignored. Like the Gin context, it must not be called
+// from several goroutines at once.
+func AddLogField(c *gin.Context, key string, value any) {
+	if key == "" || reservedFields[key] {
+		return
+	}
+
+	fields, _ := c.Value(customFieldsKey).(map[string]any)
+	if fields == nil {
+		fields = make(map[string]any)
+		c.Set(customFieldsKey, fields)
+	}
+	fields[key] = value
+}
+
+// customFields returns the request's baggage members selected by keys (every member with
+// all) merged with the fields added with AddLogField, which win over baggage; nil when
+// there are none
+func customFields(c *gin.Context, keys []string, all bool) map[string]any {
+	added, _ := c.Value(customFieldsKey).(map[string]any)
+	if len(keys) == 0 && !all {
+		return added
+	}
+
+	fields := baggageFields(c.Request.Context(), keys, all)
+	if len(fields) == 0 {
+		return added
+	}
+	for key, value := range added {
+		fields[key] = value
+	}
+	return fields
+}
+
+// baggageFields copies baggage members into custom fields, skipping reserved keys
+func baggageFields(ctx context.Context, keys []string, all bool) map[string]any {
+	bag := baggage.FromContext(ctx)
+	fields := make(map[string]any)
+	if all {
+		for _, member := range bag.Members() {
+			if !reservedFields[member.Key()] {
+				fields[member.Key()] = member.Value()
+			}
+		}
+		return fields
+	}
+	for _, key := range keys {
+		if member := bag.Member(key); member.Key() != "" && !reservedFields[key] {
+			fields[key] = member.Value()
+		}
+	}
+	return fields
+}
+
+// jsonFieldNames returns the JSON names of a struct's serialized fields
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
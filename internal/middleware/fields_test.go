@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+func TestAddLogField(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/orders/:id", func(c *gin.Context) {
+			AddLogField(c, "order_id", c.Param("id"))
+			AddLogField(c, "items", 3)
+			AddLogField(c, "user", map[string]any{"id": "u-1", "admin": false})
+			AddLogField(c, "step", "reserve")
+			AddLogField(c, "step", "charge")
+			// Reserved and empty keys are ignored
+			AddLogField(c, "status", "faked")
+			AddLogField(c, "trace_id", "faked")
+			AddLogField(c, "", "faked")
+			c.Status(http.StatusAccepted)
+		})
+		r.GET("/plain", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/orders/42", nil))
+	serve(r, httptest.NewRequest(http.MethodGet, "/plain", nil))
+
+	entries := js.entries(t)
+	if len(entries) != 2 {
+		t.Fatalf("published %d entries, want 2", len(entries))
+	}
+	// Published entries are JSON, so numbers come back as float64
+	want := map[string]any{
+		"order_id": "42",
+		"items":    float64(3),
+		"user":     map[string]any{"id": "u-1", "admin": false},
+		"step":     "charge",
+	}
+	entry := entries[0]
+	if !reflect.DeepEqual(entry.Custom, want) {
+		t.Errorf("Custom = %v, want %v", entry.Custom, want)
+	}
+	if entry.Status != http.StatusAccepted || entry.TraceID == "faked" {
+		t.Errorf("custom fields overwrote the entry: status %d, trace ID %q", entry.Status, entry.TraceID)
+	}
+	if entries[1].Custom != nil {
+		t.Errorf("Custom = %v for a handler that added none, want it left out", entries[1].Custom)
+	}
+}
+
+func TestReservedFields(t *testing.T) {
+	for _, key := range []string{"trace_id", "span_id", "status", "latency_ms", "custom", "request_id"} {
+		if !reservedFields[key] {
+			t.Errorf("%q is not reserved", key)
+		}
+	}
+	for _, key := range []string{"order_id", "Custom", "-", ""} {
+		if reservedFields[key] {
+			t.Errorf("%q is reserved", key)
+		}
+	}
+}
+
+func TestBaggageFields(t *testing.T) {
+	bag, err := baggage.Parse("tenant.id=acme,plan=gold,status=faked,region=eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		conf LoggerConfig
+		want map[string]any
+	}{
+		{
+			name: "disabled by default",
+			want: map[string]any{"order_id": "42", "region": "us"},
+		},
+		{
+			name: "selected keys",
+			conf: LoggerConfig{BaggageKeys: []string{"tenant.id", "missing"}},
+			want: map[string]any{"tenant.id": "acme", "order_id": "42", "region": "us"},
+		},
+		{
+			name: "all members but the reserved ones",
+			conf: LoggerConfig{BaggageAll: true},
+			want: map[string]any{"tenant.id": "acme", "plan": "gold", "region": "us", "order_id": "42"},
+		},
+		{
+			name: "reserved key selected",
+			conf: LoggerConfig{BaggageKeys: []string{"status", "plan"}},
+			want: map[string]any{"plan": "gold", "order_id": "42", "region": "us"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, js := newTestRouter(tt.conf, func(r *gin.Engine) {
+				r.GET("/orders/:id", func(c *gin.Context) {
+					AddLogField(c, "order_id", c.Param("id"))
+					// Fields added by the handler win over baggage
+					AddLogField(c, "region", "us")
+					c.Status(http.StatusOK)
+				})
+			})
+			req := httptest.NewRequest(http.MethodGet, "/orders/42", nil)
+			serve(r, req.WithContext(baggage.ContextWithBaggage(req.Context(), bag)))
+
+			entry := onlyEntry(t, js)
+			if !reflect.DeepEqual(entry.Custom, tt.want) {
+				t.Errorf("Custom = %v, want %v", entry.Custom, tt.want)
+			}
+			if entry.Status != http.StatusOK {
+				t.Errorf("Status = %d, baggage overwrote it", entry.Status)
+			}
+		})
+	}
+}
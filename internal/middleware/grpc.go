@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ProtocolGRPC is the LogEntry.Protocol of calls logged by the gRPC interceptors
+const ProtocolGRPC = "grpc"
+
+// UnaryServerInterceptor logs each unary gRPC call like Handler logs HTTP requests: the
+// full method name stands in for method, path and route, and the gRPC code for the status.
+// Register it after tracing (e.g. an otelgrpc stats handler) so entries carry the call's
+// trace. SkipPaths match full method names, e.g. "/grpc.health.v1.Health/*".
+func (l *RequestLogger) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var resp any
+		err := l.logCall(ctx, info.FullMethod, func(ctx context.Context) error {
+			var err error
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor logs each streaming gRPC call once it ends, like
+// UnaryServerInterceptor; the latency covers the whole stream
+func (l *RequestLogger) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return l.logCall(ss.Context(), info.FullMethod, func(ctx context.Context) error {
+			return handler(srv, &loggedStream{ServerStream: ss, ctx: ctx})
+		})
+	}
+}
+
+// loggedStream passes the request ID the interceptor added to the handler
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
+
+// logCall runs a gRPC handler and publishes its log entry, re-raising a panic once the
+// call is logged as Internal
+func (l *RequestLogger) logCall(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	// Use one config snapshot for the whole call, even if it is reloaded meanwhile
+	state := l.state.Load().(*handlerState)
+	conf := state.conf
+
+	if shouldSkip(method, state.skipExact, state.skipPrefixes) {
+		return call(ctx)
+	}
+
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	spanCtx := trace.SpanContextFromContext(ctx)
+	traceID := spanCtx.TraceID().String()
+	spanID := spanCtx.SpanID().String()
+	if !spanCtx.HasTraceID() {
+		traceID, spanID = fallbackIDs(conf.FallbackIDFormat)
+	}
+
+	// Keep the caller's request ID or assign one, hand it to the handler and echo it with
+	// the trace ID in the response headers
+	idHeader := strings.ToLower(requestIDHeader(conf.RequestIDHeader))
+	reqID := requestID(firstValue(md, idHeader))
+	ctx = context.WithValue(ctx, requestIDContextKey{}, reqID)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(idHeader, reqID, "x-trace-id", traceID))
+
+	sampled := true
+	if conf.Sampler == nil {
+		sampled = sampleDecision(firstValue(md, strings.ToLower(SampledHeader)), traceID, conf.SampleRate)
+	}
+
+	recovered, stack, err := runCall(ctx, call)
+	if stack != "" {
+		defer panic(recovered)
+		err = status.Error(codes.Internal, fmt.Sprint(recovered))
+	}
+	code := status.Code(err)
+
+	// Drop sampled-out successful calls; always keep failures
+	if code == codes.OK && !sampled {
+		reportCounts.sampledOut.Add(1)
+		return err
+	}
+
+	entry := LogEntry{
+		TraceID:     traceID,
+		SpanID:      spanID,
+		RequestID:   reqID,
+		Timestamp:   time.Now(),
+		Method:      method,
+		Path:        method,
+		Route:       method,
+		Operation:   method,
+		Status:      int(code),
+		Severity:    grpcSeverity(code),
+		Latency:     float64(time.Since(start).Microseconds()) / 1000.0,
+		UserAgent:   firstValue(md, "user-agent"),
+		ServiceName: conf.ServiceName,
+		Environment: conf.Environment,
+		Protocol:    ProtocolGRPC,
+		Attempt:     retryAttempt(firstValue(md, strings.ToLower(retryHeader(conf.RetryHeader)))),
+	}
+	entry.HandlerLatency = entry.Latency
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		entry.ClientIP = hostOnly(p.Addr.String())
+	}
+	entry.Synthetic = state.synthetic.synthetic(firstValue(md, strings.ToLower(state.synthetic.header)), entry.UserAgent)
+	entry.Labels = headerLabels(conf.HeaderLabels, func(header string) string {
+		return firstValue(md, strings.ToLower(header))
+	})
+	if conf.TenantBaggageKey != "" {
+		entry.Tenant = baggage.FromContext(ctx).Member(conf.TenantBaggageKey).Value()
+	}
+	if len(conf.BaggageKeys) > 0 || conf.BaggageAll {
+		entry.Custom = baggageFields(ctx, conf.BaggageKeys, conf.BaggageAll)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if stack != "" {
+		entry.Error = "panic: " + fmt.Sprint(recovered)
+		entry.StackTrace = stack
+	}
+
+	if conf.Sampler != nil && !conf.Sampler.ShouldLog(&entry, func(name string) string {
+		return firstValue(md, strings.ToLower(name))
+	}) {
+		reportCounts.sampledOut.Add(1)
+		return err
+	}
+
+	if conf.SpanEvents {
+		LogEntryToSpanEvent(ctx, entry)
+	}
+	l.emit(state, entry, spanCtx)
+	return err
+}
+
+// runCall runs a gRPC handler, returning the recovered value and the stack trace if it
+// panicked; the stack is empty otherwise
+func runCall(ctx context.Context, call func(ctx context.Context) error) (recovered any, stack string, err error) {
+	defer func() {
+		if recovered = recover(); recovered != nil {
+			stack = string(debug.Stack())
+		}
+	}()
+	return nil, "", call(ctx)
+}
+
+// grpcSeverity maps a gRPC code to a severity the way HTTP statuses are mapped: codes
+// the server is responsible for are errors, those caused by the caller warnings
+func grpcSeverity(code codes.Code) Severity {
+	switch code {
+	case codes.OK:
+		return SeverityInfo
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal,
+		codes.Unavailable, codes.DataLoss:
+		return SeverityError
+	default:
+		return SeverityWarn
+	}
+}
+
+// firstValue returns the first metadata value for a lower-case key
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// hostOnly strips the port from a peer address
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
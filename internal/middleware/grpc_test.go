@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const healthCheck = "/grpc.health.v1.Health/Check"
+
+// newGRPCTestServer serves the health service over an in-memory connection behind the
+// logging interceptors, returning a client for it and the fake JetStream entries go to.
+// The health service knows only "logs".
+func newGRPCTestServer(t *testing.T, conf LoggerConfig) (healthpb.HealthClient, *fakeJS) {
+	t.Helper()
+	js := &fakeJS{}
+	conf.JS = js
+	conf.Subject = "logs.test"
+	conf.ServiceName = "test"
+	logger := NewRequestLogger(conf)
+
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(logger.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(logger.StreamServerInterceptor()),
+	)
+	healthSrv := health.NewServer()
+	healthSrv.SetServingStatus("logs", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, healthSrv)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn), js
+}
+
+// check calls Health/Check for service with the given metadata pairs
+func check(client healthpb.HealthClient, service string, kv ...string) error {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), kv...)
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	return err
+}
+
+func TestGRPCLogsUnaryCalls(t *testing.T) {
+	client, js := newGRPCTestServer(t, LoggerConfig{})
+
+	if err := check(client, "logs", "user-agent", "probe/1.0"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err := check(client, "missing"); status.Code(err) != codes.NotFound {
+		t.Fatalf("Check of an unknown service = %v, want NotFound", err)
+	}
+
+	entries := js.entries(t)
+	if len(entries) != 2 {
+		t.Fatalf("published %d entries, want 2", len(entries))
+	}
+	ok, failed := entries[0], entries[1]
+	if ok.Protocol != ProtocolGRPC || ok.Route != healthCheck || ok.Status != int(codes.OK) || ok.Severity != SeverityInfo {
+		t.Errorf("successful call logged as %s %s status %d severity %s", ok.Protocol, ok.Route, ok.Status, ok.Severity)
+	}
+	if failed.Status != int(codes.NotFound) || failed.Severity != SeverityWarn || failed.Error == "" {
+		t.Errorf("failed call logged with status %d, severity %s and error %q", failed.Status, failed.Severity, failed.Error)
+	}
+}
+
+func TestGRPCSamplerReadsMetadata(t *testing.T) {
+	var seen []string
+	sampler := SamplerFunc(func(entry *LogEntry, header func(string) string) bool {
+		seen = append(seen, entry.Route)
+		// Metadata keys are lower case; the sampler may ask in any case
+		return header("X-Debug") == "1"
+	})
+	client, js := newGRPCTestServer(t, LoggerConfig{Sampler: sampler})
+
+	if err := check(client, "logs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(client, "logs", "x-debug", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != 2 || seen[0] != healthCheck {
+		t.Errorf("sampler consulted for %v, want two %s calls", seen, healthCheck)
+	}
+	if entries := js.entries(t); len(entries) != 1 {
+		t.Errorf("published %d entries, want only the call with x-debug", len(entries))
+	}
+}
+
+func TestGRPCHonorsSampledMetadata(t *testing.T) {
+	// Without a Sampler, the caller's decision is honored and failures are always kept
+	client, js := newGRPCTestServer(t, LoggerConfig{})
+
+	check(client, "logs", SampledHeader, "0")
+	check(client, "missing", SampledHeader, "0")
+	check(client, "logs", SampledHeader, "1")
+
+	entries := js.entries(t)
+	if len(entries) != 2 || entries[0].Status != int(codes.NotFound) || entries[1].Status != int(codes.OK) {
+		t.Errorf("published %+v, want the failed call and the sampled-in one", entries)
+	}
+}
+
+func TestGRPCSamplerSeesStreams(t *testing.T) {
+	sampler := SamplerFunc(func(_ *LogEntry, header func(string) string) bool {
+		return header("x-debug") == "1"
+	})
+	client, js := newGRPCTestServer(t, LoggerConfig{Sampler: sampler})
+
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), "x-debug", "1"))
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	// The stream is logged once it ends
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(js.entries(t)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream not logged after it ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	entry := onlyEntry(t, js)
+	if entry.Route != "/grpc.health.v1.Health/Watch" || entry.Status != int(codes.Canceled) {
+		t.Errorf("stream logged as %s status %d", entry.Route, entry.Status)
+	}
+}
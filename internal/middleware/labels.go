@@ -0,0 +1,24 @@
+package middleware
+
+import "strings"
+
+// maxHeaderLabelBytes bounds the header values copied into LogEntry.Labels; the expected
+// values are short flags and names, so longer ones are dropped
+const maxHeaderLabelBytes = 64
+
+// headerLabels copies the request headers named in mapping into labels under their mapped
+// names, lower-cased; get returns a header's value
+func headerLabels(mapping map[string]string, get func(header string) string) map[string]string {
+	var labels map[string]string
+	for header, label := range mapping {
+		value := strings.ToLower(strings.TrimSpace(get(header)))
+		if value == "" || len(value) > maxHeaderLabelBytes {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string, len(mapping))
+		}
+		labels[label] = value
+	}
+	return labels
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHeaderLabels(t *testing.T) {
+	mapping := map[string]string{"X-Client": "client", "X-Canary": "canary"}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    map[string]string
+	}{
+		{name: "no headers", want: nil},
+		{
+			name:    "mapped names",
+			headers: map[string]string{"X-Client": "ios", "X-Canary": "true"},
+			want:    map[string]string{"client": "ios", "canary": "true"},
+		},
+		{
+			name:    "lower-cased and trimmed",
+			headers: map[string]string{"X-Client": "  iOS "},
+			want:    map[string]string{"client": "ios"},
+		},
+		{
+			name:    "unmapped headers ignored",
+			headers: map[string]string{"X-Client": "web", "X-Tenant": "acme"},
+			want:    map[string]string{"client": "web"},
+		},
+		{
+			name:    "blank value",
+			headers: map[string]string{"X-Client": "   "},
+			want:    nil,
+		},
+		{
+			name:    "value at the cap",
+			headers: map[string]string{"X-Client": strings.Repeat("a", maxHeaderLabelBytes)},
+			want:    map[string]string{"client": strings.Repeat("a", maxHeaderLabelBytes)},
+		},
+		{
+			name:    "value over the cap",
+			headers: map[string]string{"X-Client": strings.Repeat("a", maxHeaderLabelBytes+1), "X-Canary": "true"},
+			want:    map[string]string{"canary": "true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := headerLabels(mapping, func(header string) string { return tt.headers[header] })
+			if !maps.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("headerLabels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoggerRecordsHeaderLabels(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{HeaderLabels: map[string]string{"X-Client": "client"}}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+	req := httptest.NewRequest(http.MethodGet, "/items", nil)
+	req.Header.Set("x-client", "Android")
+
+	serve(r, req)
+
+	if got := onlyEntry(t, js).Labels; !maps.Equal(got, map[string]string{"client": "android"}) {
+		t.Errorf("Labels = %v, want client=android", got)
+	}
+}
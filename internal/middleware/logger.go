@@ -1,14 +1,24 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
-	"github.com/google/uuid"
-	"github.com/nats-io/nats.go"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/baggage"
 	"go.opentelemetry.io/otel/trace"
 	"io"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,34 +28,598 @@ import (
 type LogEntry struct {
 	TraceID      string            `json:"trace_id"`
 	SpanID       string            `json:"span_id"`
+	RequestID    string            `json:"request_id,omitempty"`
 	Timestamp    time.Time         `json:"timestamp"`
 	Method       string            `json:"method"`
 	Path         string            `json:"path"`
+	RawPath      string            `json:"raw_path,omitempty"`
+	Route        string            `json:"route,omitempty"`
+	Operation    string            `json:"operation,omitempty"`
 	Status       int               `json:"status"`
+	Severity     Severity          `json:"severity"`
 	Latency      float64           `json:"latency_ms"`
 	ClientIP     string            `json:"client_ip"`
 	UserAgent    string            `json:"user_agent"`
 	RequestBody  string            `json:"request_body,omitempty"`
 	ResponseBody string            `json:"response_body,omitempty"`
 	Headers      map[string]string `json:"headers,omitempty"`
+	AuthScheme   string            `json:"auth_scheme,omitempty"`
 	ServiceName  string            `json:"service_name"`
 	Environment  string            `json:"environment"`
+	Tenant       string            `json:"tenant,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
 	Error        string            `json:"error,omitempty"`
+
+	// RequestContentType and ResponseContentType are the Content-Type headers of each side,
+	// the response's as of the first body write
+	RequestContentType  string `json:"request_content_type,omitempty"`
+	ResponseContentType string `json:"response_content_type,omitempty"`
+
+	// RequestBytes is the request body size: its Content-Length, or the bytes actually
+	// read when the length is unknown (chunked)
+	RequestBytes int64 `json:"request_bytes"`
+
+	// HandlerLatency is the time spent in the handler chain alone, excluding the
+	// Logger's own body capture and publish overhead
+	HandlerLatency float64 `json:"handler_latency_ms"`
+
+	// Attempt numbers client retries of the same request, read from LoggerConfig.RetryHeader
+	Attempt int `json:"attempt"`
+
+	// TimeBudget is the time left before the request context's deadline when the request
+	// finished, negative once it passed; omitted when there is no deadline
+	TimeBudget float64 `json:"time_budget_ms,omitempty"`
+
+	// RequestBodyTruncated and ResponseBodyTruncated mark bodies cut at their limit
+	RequestBodyTruncated  bool `json:"request_body_truncated,omitempty"`
+	ResponseBodyTruncated bool `json:"response_body_truncated,omitempty"`
+
+	// Oversized marks entries whose bodies and headers were dropped to fit the NATS message limit
+	Oversized bool `json:"oversized,omitempty"`
+
+	// BodyCaptureSkipped marks entries logged without bodies because too many captures were in flight
+	BodyCaptureSkipped bool `json:"body_capture_skipped,omitempty"`
+
+	// SubjectService is the service named by the NATS subject the entry arrived on, set by
+	// the consumer when configured to trust subjects; it overrides ServiceName for the
+	// service label only and is never serialized
+	SubjectService string `json:"-"`
+
+	// Country (ISO code) and City locate the client IP; the consumer fills them in when
+	// GeoIP enrichment is enabled
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+
+	// Synthetic marks entries generated by tooling such as cmd/doctor, or requests from
+	// probes and synthetic checks (see LoggerConfig.SyntheticHeader), so they can be
+	// filtered out
+	Synthetic bool `json:"synthetic,omitempty"`
+
+	// Hijacked marks requests whose connection a handler took over (e.g. a WebSocket upgrade);
+	// they are logged without status or bodies, which Gin no longer tracks
+	Hijacked bool `json:"hijacked,omitempty"`
+
+	// Protocol is "grpc" for calls logged by the gRPC interceptors, whose Method, Path and
+	// Route hold the full method name and Status the gRPC code; empty for HTTP requests
+	Protocol string `json:"protocol,omitempty"`
+
+	// StackTrace is the handler's stack when it panicked; Error then holds the panic value
+	StackTrace string `json:"stack_trace,omitempty"`
+
+	// Occurrences counts identical failures the consumer coalesced into this entry, the
+	// first and last of which happened at FirstSeen and LastSeen; zero when not coalesced
+	Occurrences int        `json:"occurrences,omitempty"`
+	FirstSeen   *time.Time `json:"first_seen,omitempty"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
+
+	// RejectedBy and RejectionReason say which middleware rejected the request before the
+	// handler and why (see SetRejection), e.g. "auth" and "invalid_token"
+	RejectedBy      string `json:"rejected_by,omitempty"`
+	RejectionReason string `json:"rejection_reason,omitempty"`
+
+	// Custom holds domain fields handlers attached with AddLogField
+	Custom map[string]any `json:"custom,omitempty"`
+}
+
+// LabelService returns the service to label the entry with: SubjectService when set,
+// otherwise ServiceName
+func (e LogEntry) LabelService() string {
+	if e.SubjectService != "" {
+		return e.SubjectService
+	}
+	return e.ServiceName
 }
 
 // bodyLogWriter is a custom response writer that captures the response body
 type bodyLogWriter struct {
 	gin.ResponseWriter
 	body *bytes.Buffer
+
+	// contentType is the response content type as of the first body write,
+	// when headers are committed
+	contentType string
+	captured    bool
+
+	// types is the response body allowlist; bodies of other types aren't buffered
+	types   map[string]bool
+	discard bool
+}
+
+// hijackWriter records whether a handler hijacked the connection, after which the
+// writer's status and size no longer describe the response
+type hijackWriter struct {
+	gin.ResponseWriter
+	hijacked bool
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// countingBody counts the request body bytes the handler reads
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// maxPooledBufferSize keeps unusually large buffers from being retained by the pool
+const maxPooledBufferSize = 64 << 10
+
+// bodyBufferPool reuses response capture buffers across requests
+var bodyBufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBodyBuffer() *bytes.Buffer {
+	buf := bodyBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBodyBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bodyBufferPool.Put(buf)
 }
 
 func (w *bodyLogWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
+	w.captureContentType(b)
+	if !w.discard {
+		w.body.Write(b)
+	}
 	return w.ResponseWriter.Write(b)
 }
 
-func Logger(js nats.JetStreamContext, serviceName, environment, subject string) gin.HandlerFunc {
+func (w *bodyLogWriter) WriteString(str string) (int, error) {
+	if !w.captured {
+		w.captureContentType([]byte(str))
+	}
+	if !w.discard {
+		w.body.WriteString(str)
+	}
+	return w.ResponseWriter.WriteString(str)
+}
+
+// captureContentType records the content type on the first write, sniffing it like
+// net/http does when the handler hasn't set one yet
+func (w *bodyLogWriter) captureContentType(b []byte) {
+	if w.captured {
+		return
+	}
+	w.captured = true
+
+	w.contentType = w.Header().Get("Content-Type")
+	if w.contentType == "" && len(b) > 0 {
+		w.contentType = http.DetectContentType(b)
+	}
+	w.discard = !capturesType(w.contentType, w.types)
+}
+
+// ContentType returns the content type captured at the first write, or the current
+// header when nothing was written
+func (w *bodyLogWriter) ContentType() string {
+	if w.captured {
+		return w.contentType
+	}
+	return w.Header().Get("Content-Type")
+}
+
+// TrailingSlashMode controls how trailing slashes are normalized in logged paths
+type TrailingSlashMode string
+
+const (
+	// TrailingSlashKeep logs the path exactly as requested
+	TrailingSlashKeep TrailingSlashMode = "keep"
+	// TrailingSlashStrip removes a trailing slash, matching Gin's RedirectTrailingSlash
+	TrailingSlashStrip TrailingSlashMode = "strip"
+	// TrailingSlashAdd appends a trailing slash when one is missing
+	TrailingSlashAdd TrailingSlashMode = "add"
+)
+
+// LoggerConfig holds the settings for the Logger middleware
+type LoggerConfig struct {
+	JS          jetstream.JetStream
+	ServiceName string
+	Environment string
+	Subject     string
+
+	// Secondary is an optional JetStream context on another cluster used when the primary keeps failing
+	Secondary jetstream.JetStream
+	// FailoverThreshold is the number of consecutive primary failures before failing over; defaults to 3
+	FailoverThreshold int
+	// FailbackInterval is how long to stay on the secondary before retrying the primary; defaults to 30s
+	FailbackInterval time.Duration
+
+	// TenantBaggageKey names the OTel baggage member copied into LogEntry.Tenant; empty disables it
+	TenantBaggageKey string
+
+	// FallbackIDFormat selects how IDs are generated for requests without a valid span;
+	// defaults to FallbackIDOTel
+	FallbackIDFormat FallbackIDFormat
+
+	// SyntheticHeader marks a request as synthetic when set to a true value ("1", "true");
+	// empty uses DefaultSyntheticHeader. Requests whose User-Agent contains one of
+	// SyntheticUserAgents (case-insensitive, e.g. "kube-probe/") are synthetic too.
+	SyntheticHeader     string
+	SyntheticUserAgents []string
+
+	// HeaderLabels maps request header names to names in LogEntry.Labels, e.g. X-Canary to
+	// canary, so deployment metadata can become Loki labels. Values are lower-cased and
+	// those over 64 bytes dropped; the consumer only labels values it allows.
+	HeaderLabels map[string]string
+
+	// BaggageKeys lists OTel baggage members copied into LogEntry.Custom; BaggageAll copies
+	// every member instead. Copying all lets any upstream caller add arbitrary fields to
+	// every entry, so prefer listing keys.
+	BaggageKeys []string
+	BaggageAll  bool
+
+	// RetryHeader carries the client's retry attempt number; empty uses DefaultRetryHeader
+	RetryHeader string
+
+	// RequestIDHeader carries the correlation ID passed between services; empty uses
+	// DefaultRequestIDHeader. A missing ID is generated, and the ID is echoed in the response.
+	RequestIDHeader string
+
+	// Format selects the wire encoding; defaults to FormatJSON
+	Format LogFormat
+
+	// SpanEvents also records each entry as an event on the request's span
+	SpanEvents bool
+
+	// SkipPaths are not logged; entries ending in "*" match by prefix, e.g. "/healthz*".
+	// Skipping happens before sampling, so skipped paths are never published.
+	SkipPaths []string
+
+	// SampleRate is the fraction (0.0–1.0) of successful requests published, decided per
+	// trace ID. Requests with status >= 400 or recorded errors are always published.
+	// Zero disables sampling and publishes every request.
+	SampleRate float64
+
+	// Sampler, when set, replaces SampleRate: it alone decides which entries are published,
+	// so failures are kept only if it keeps them, and SampledHeader is neither read nor set
+	Sampler Sampler
+
+	// TrailingSlash normalizes the logged Path and Route; defaults to TrailingSlashKeep
+	TrailingSlash TrailingSlashMode
+
+	// RedactHeaders lists headers (case-insensitive) logged as RedactedValue; nil uses DefaultRedactHeaders
+	RedactHeaders []string
+	// RedactAll redacts every header except those in AllowHeaders
+	RedactAll    bool
+	AllowHeaders []string
+
+	// MaskBodyFields lists JSON body fields logged as MaskedValue, as dot-separated paths
+	// (e.g. "user.password") that also apply to each element of arrays along the way.
+	// Masking happens before truncation; bodies that aren't JSON are logged unchanged.
+	MaskBodyFields []string
+
+	// MaxHeaderBytes caps each logged header value; zero uses defaultMaxFieldBytes
+	MaxHeaderBytes int
+
+	// MaxRequestBodyBytes and MaxResponseBodyBytes cap the logged bodies; zero disables
+	// capture and UnlimitedBodyBytes logs them whole
+	MaxRequestBodyBytes  int
+	MaxResponseBodyBytes int
+
+	// ResponseBodyTypes, when set, limits response body capture to these media types
+	// (case-insensitive, e.g. "application/json"; "text/*" matches a whole type); other
+	// responses are logged without a body. Binary types are never captured either way.
+	ResponseBodyTypes []string
+
+	// MaxMessageBytes is the largest encoded entry published as-is; bigger entries are
+	// re-encoded without bodies and headers. Zero uses defaultMaxMessageBytes.
+	MaxMessageBytes int
+
+	// MaxConcurrentCaptures bounds in-flight body captures; requests beyond it are logged
+	// without bodies. Zero means unlimited.
+	MaxConcurrentCaptures int
+
+	// Async publishes entries from a buffer of AsyncBufferSize on AsyncWorkers background
+	// workers; entries are dropped and counted when the buffer is full
+	Async           bool
+	AsyncBufferSize int
+	AsyncWorkers    int
+
+	// PublishMaxAttempts and PublishBaseDelay control retries with exponential backoff and
+	// jitter. Retries only run on async workers; sync mode publishes once per request.
+	PublishMaxAttempts int
+	PublishBaseDelay   time.Duration
+
+	// DiskBufferPath, when set, appends entries whose publish attempts all failed to this
+	// file instead of dropping them; ReplayBuffer publishes them once NATS is back. The
+	// buffer keeps at most DiskBufferMaxBytes (zero uses 64MB), dropping the oldest entries.
+	DiskBufferPath     string
+	DiskBufferMaxBytes int64
+
+	// OnDropEntry is called with entries that could not be published, either because every
+	// attempt failed or because the async buffer was full (ErrQueueFull)
+	OnDropEntry func(LogEntry, error)
+}
+
+// ErrQueueFull is passed to OnDropEntry when the async buffer is full
+var ErrQueueFull = errors.New("log queue full")
+
+// defaultMaxFieldBytes is the truncation limit used for fields without an explicit limit
+const defaultMaxFieldBytes = 10000
+
+// UnlimitedBodyBytes disables body truncation when used as a body limit
+const UnlimitedBodyBytes = -1
+
+// defaultMaxMessageBytes stays safely below the default 1MB NATS max payload
+const defaultMaxMessageBytes = 900 * 1024
+
+// truncatedMarker is appended to any field cut at its limit
+const truncatedMarker = "... (truncated)"
+
+func Logger(js jetstream.JetStream, serviceName, environment, subject string) gin.HandlerFunc {
+	return LoggerWithConfig(LoggerConfig{
+		JS:          js,
+		ServiceName: serviceName,
+		Environment: environment,
+		Subject:     subject,
+
+		MaxRequestBodyBytes:  defaultMaxFieldBytes,
+		MaxResponseBodyBytes: defaultMaxFieldBytes,
+	})
+}
+
+// LoggerWithConfig returns a Logger middleware using the given config
+func LoggerWithConfig(conf LoggerConfig) gin.HandlerFunc {
+	return NewRequestLogger(conf).Handler()
+}
+
+// RequestLogger publishes request log entries; keep it to flush or close async publishing
+type RequestLogger struct {
+	conf      LoggerConfig
+	publisher *failoverPublisher
+	queue     *asyncQueue
+	buffer    *diskBuffer
+
+	// state holds the *handlerState used by Handler; Reload swaps it
+	state atomic.Value
+}
+
+// NewRequestLogger creates a RequestLogger, starting its async workers when enabled
+func NewRequestLogger(conf LoggerConfig) *RequestLogger {
+	l := &RequestLogger{
+		conf:      conf,
+		publisher: newFailoverPublisher(conf.JS, conf.Secondary, conf.FailoverThreshold, conf.FailbackInterval),
+	}
+	if conf.Async {
+		l.queue = newAsyncQueue(conf.AsyncBufferSize, conf.AsyncWorkers, l.publishWithRetry)
+	}
+	if conf.DiskBufferPath != "" {
+		l.buffer = newDiskBuffer(conf.DiskBufferPath, conf.DiskBufferMaxBytes)
+	}
+	l.state.Store(newHandlerState(conf))
+	return l
+}
+
+// Flush waits until all queued entries are published; it returns at once in sync mode
+func (l *RequestLogger) Flush(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.flush(ctx)
+}
+
+// Close stops accepting entries and drains the queue; call it during graceful shutdown
+func (l *RequestLogger) Close(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.close(ctx)
+}
+
+// ReplayBuffer publishes the entries buffered to disk while NATS was unavailable, oldest
+// first, returning how many were published. Entries failing again stay buffered, so call it
+// whenever NATS reconnects. It returns at once when there is no disk buffer.
+func (l *RequestLogger) ReplayBuffer() (int, error) {
+	if l.buffer == nil {
+		return 0, nil
+	}
+	return l.buffer.replay(func(ctx context.Context, subject string, data []byte) error {
+		_, err := l.publisher.Publish(ctx, subject, data)
+		return err
+	})
+}
+
+// publish hands an encoded entry to the async queue, or publishes it directly in sync mode
+func (l *RequestLogger) publish(p pendingEntry) {
+	if l.queue == nil {
+		recentDrops.add(time.Now(), false)
+		l.publishOnce(p)
+		return
+	}
+	if !l.queue.enqueue(p) {
+		totals.dropped.Add(1)
+		reportCounts.dropped.Add(1)
+		recentDrops.add(time.Now(), true)
+		l.drop(p.entry, ErrQueueFull)
+		return
+	}
+	recentDrops.add(time.Now(), false)
+}
+
+// publishOnce publishes an encoded entry to NATS JetStream and records the outcome
+func (l *RequestLogger) publishOnce(p pendingEntry) {
+	l.record(p, l.tracedPublish(p, 1))
+}
+
+// publishWithRetry publishes an entry, retrying with exponential backoff and jitter
+func (l *RequestLogger) publishWithRetry(p pendingEntry) {
+	attempts := l.conf.PublishMaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = l.tracedPublish(p, attempt); err == nil {
+			break
+		}
+		if attempt < attempts {
+			time.Sleep(backoff(l.conf.PublishBaseDelay, attempt))
+		}
+	}
+	l.record(p, err)
+}
+
+// record counts a publish outcome and buffers failed entries to disk when enabled,
+// handing them to OnDropEntry otherwise
+func (l *RequestLogger) record(p pendingEntry, err error) {
+	recentPublishes.add(time.Now(), err != nil)
+	if err != nil {
+		// Count the failure so it shows up in the pipeline status
+		totals.failed.Add(1)
+		reportCounts.failed.Add(1)
+
+		if l.buffer != nil {
+			bufferErr := l.buffer.append(p.subject, p.data)
+			if bufferErr == nil {
+				return
+			}
+			err = errors.Join(err, bufferErr)
+		}
+		l.drop(p.entry, err)
+		return
+	}
+	totals.published.Add(1)
+	reportCounts.logged.Add(1)
+}
+
+func (l *RequestLogger) drop(entry LogEntry, err error) {
+	if l.conf.OnDropEntry != nil {
+		l.conf.OnDropEntry(entry, err)
+	}
+}
+
+// maxBackoff caps the delay between publish attempts
+const maxBackoff = 5 * time.Second
+
+// backoff returns the delay before the next attempt: base * 2^(attempt-1) plus up to 50% jitter
+func backoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = 50 * time.Millisecond
+	}
+
+	delay := base << (attempt - 1)
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// handlerState is the Handler's view of a LoggerConfig, prepared once per (re)load
+type handlerState struct {
+	conf         LoggerConfig
+	maxHeader    int
+	maxMessage   int
+	redactor     *headerRedactor
+	masker       *bodyMasker
+	bodyTypes    map[string]bool
+	synthetic    *syntheticDetector
+	captureSem   chan struct{}
+	skipExact    map[string]bool
+	skipPrefixes []string
+}
+
+func newHandlerState(conf LoggerConfig) *handlerState {
+	s := &handlerState{
+		conf:       conf,
+		maxHeader:  limitOrDefault(conf.MaxHeaderBytes),
+		maxMessage: conf.MaxMessageBytes,
+		redactor:   newHeaderRedactor(conf.RedactHeaders, conf.AllowHeaders, conf.RedactAll),
+		masker:     newBodyMasker(conf.MaskBodyFields),
+		synthetic:  newSyntheticDetector(conf.SyntheticHeader, conf.SyntheticUserAgents),
+		skipExact:  make(map[string]bool),
+	}
+	if s.maxMessage <= 0 {
+		s.maxMessage = defaultMaxMessageBytes
+	}
+
+	if len(conf.ResponseBodyTypes) > 0 {
+		s.bodyTypes = lowerSet(conf.ResponseBodyTypes)
+	}
+
+	// Semaphore bounding concurrent body captures
+	if conf.MaxConcurrentCaptures > 0 {
+		s.captureSem = make(chan struct{}, conf.MaxConcurrentCaptures)
+	}
+
+	// Split skip paths into exact matches and prefixes
+	for _, p := range conf.SkipPaths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			s.skipPrefixes = append(s.skipPrefixes, prefix)
+		} else {
+			s.skipExact[p] = true
+		}
+	}
+	return s
+}
+
+// Reload swaps the configuration used by Handler for new requests; requests already in
+// flight finish with the configuration they started with. Publishing settings (JS,
+// Secondary, Async, retries and the disk buffer) and OnDropEntry keep the values from
+// NewRequestLogger.
+func (l *RequestLogger) Reload(conf LoggerConfig) {
+	l.state.Store(newHandlerState(conf))
+}
+
+// Handler returns the gin middleware. A panic in a later handler is recorded with its
+// stack trace and re-raised once the entry is published, so register the Logger after
+// gin.Recovery(): Recovery then writes the 500 and the Logger still logs the request.
+// Registered before Recovery, the Logger never sees the panic.
+func (l *RequestLogger) Handler() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Use one config snapshot for the whole request, even if it is reloaded meanwhile
+		state := l.state.Load().(*handlerState)
+		conf := state.conf
+		maxRequestBody := conf.MaxRequestBodyBytes
+		maxResponseBody := conf.MaxResponseBodyBytes
+		captureSem := state.captureSem
+
+		// Run skipped paths without logging them
+		if shouldSkip(c.Request.URL.Path, state.skipExact, state.skipPrefixes) {
+			c.Next()
+			return
+		}
+
 		// Start timer
 		start := time.Now()
 
@@ -55,95 +629,403 @@ func Logger(js nats.JetStreamContext, serviceName, environment, subject string)
 		spanID := spanCtx.SpanID().String()
 
 		// If no trace ID exists, create one
-		if traceID == "00000000000000000000000000000000" {
-			traceID = uuid.New().String()
+		if !spanCtx.HasTraceID() {
+			traceID, spanID = fallbackIDs(conf.FallbackIDFormat)
 			c.Set("trace_id", traceID)
 		}
 
 		// Set trace ID in response header
 		c.Header("X-Trace-ID", traceID)
 
+		// Keep the caller's request ID or assign one, and pass it on like the sampling decision
+		idHeader := requestIDHeader(conf.RequestIDHeader)
+		reqID := requestID(c.GetHeader(idHeader))
+		c.Set(requestIDKey, reqID)
+		c.Request.Header.Set(idHeader, reqID)
+		c.Header(idHeader, reqID)
+
+		// Decide sampling up front and pass the decision on, so handlers forwarding request
+		// headers propagate it downstream; a Sampler decides once the entry is built instead
+		sampled := true
+		if conf.Sampler == nil {
+			sampled = sampleDecision(c.GetHeader(SampledHeader), traceID, conf.SampleRate)
+			c.Request.Header.Set(SampledHeader, sampledValue(sampled))
+			c.Header(SampledHeader, sampledValue(sampled))
+		}
+
+		// Headers are sent before the handler finishes, so the header carries the budget on entry
+		deadline, hasDeadline := c.Request.Context().Deadline()
+		if hasDeadline {
+			c.Header("X-Deadline-Remaining", strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+		}
+
+		// Capture bodies only if enabled and a capture slot is free; never block the request
+		captureBodies := maxRequestBody != 0 || maxResponseBody != 0
+		if captureBodies && captureSem != nil {
+			select {
+			case captureSem <- struct{}{}:
+				defer func() { <-captureSem }()
+			default:
+				captureBodies = false
+			}
+		}
+
 		// Read request body if it's not a multipart form
 		var requestBodyBytes []byte
-		if c.Request.Body != nil && c.Request.Body != http.NoBody && !strings.Contains(c.GetHeader("Content-Type"), "multipart/form-data") {
+		if captureBodies && maxRequestBody != 0 && c.Request.Body != nil && c.Request.Body != http.NoBody && !strings.Contains(c.GetHeader("Content-Type"), "multipart/form-data") {
 			requestBodyBytes, _ = io.ReadAll(c.Request.Body)
 			// Restore the body so it can be read again in handlers
 			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBodyBytes))
 		}
 
-		// Create a response body writer
-		bodyWriter := &bodyLogWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
-		c.Writer = bodyWriter
+		// Count what the handler reads when the length isn't known up front and wasn't captured
+		var bodyCounter *countingBody
+		if c.Request.ContentLength < 0 && requestBodyBytes == nil && c.Request.Body != nil && c.Request.Body != http.NoBody {
+			bodyCounter = &countingBody{ReadCloser: c.Request.Body}
+			c.Request.Body = bodyCounter
+		}
+
+		// Track hijacking beneath the body writer, which forwards Hijack to it
+		hijacker := &hijackWriter{ResponseWriter: c.Writer}
+		c.Writer = hijacker
+
+		// Create a response body writer backed by a pooled buffer
+		var bodyWriter *bodyLogWriter
+		if captureBodies && maxResponseBody != 0 {
+			bodyWriter = &bodyLogWriter{body: getBodyBuffer(), ResponseWriter: c.Writer, types: state.bodyTypes}
+			c.Writer = bodyWriter
+			defer putBodyBuffer(bodyWriter.body)
+		}
+
+		// Process request, catching a panic so it is logged before being re-raised
+		handlerStart := time.Now()
+		recovered, stack := runHandlers(c)
+		handlerLatency := time.Since(handlerStart)
+		if stack != "" {
+			defer panic(recovered)
+		}
 
-		// Process request
-		c.Next()
+		// Size the request body and record it in the request size histogram
+		requestBytes := c.Request.ContentLength
+		if requestBytes < 0 {
+			requestBytes = 0
+			if requestBodyBytes != nil {
+				requestBytes = int64(len(requestBodyBytes))
+			} else if bodyCounter != nil {
+				requestBytes = bodyCounter.n
+			}
+		}
+		requestSizes.Observe(float64(requestBytes))
+
+		// A hijacked connection has no status Gin knows about
+		hijacked := hijacker.hijacked
+		status := 0
+		if !hijacked {
+			status = c.Writer.Status()
+			// Recovery writes the 500 after the Logger returns, unless a response was started
+			if stack != "" && !c.Writer.Written() {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		// Drop sampled-out successful requests; always keep failures
+		failed := status >= http.StatusBadRequest || len(c.Errors) > 0
+		if !failed && !sampled {
+			reportCounts.sampledOut.Add(1)
+			return
+		}
 
 		// Collect headers
 		headers := make(map[string]string)
 		for k, v := range c.Request.Header {
-			if len(v) > 0 {
-				headers[k] = v[0]
+			if len(v) == 0 {
+				continue
+			}
+			if strings.EqualFold(k, "Authorization") {
+				// Only the scheme is logged; the credential never leaves the request
+				continue
 			}
+			if state.redactor.redacts(k) {
+				headers[k] = RedactedValue
+				continue
+			}
+			headers[k], _ = truncate(v[0], state.maxHeader)
 		}
 
+		// Normalize path and route so /users and /users/ aggregate together
+		rawPath := c.Request.URL.Path
+		path := normalizeTrailingSlash(rawPath, conf.TrailingSlash)
+		route := normalizeTrailingSlash(c.FullPath(), conf.TrailingSlash)
+
 		// Create log entry
 		entry := LogEntry{
 			TraceID:     traceID,
 			SpanID:      spanID,
+			RequestID:   reqID,
 			Timestamp:   time.Now(),
 			Method:      c.Request.Method,
-			Path:        c.Request.URL.Path,
-			Status:      c.Writer.Status(),
+			Path:        path,
+			Route:       route,
+			Operation:   operation(c, c.Request.Method, route),
+			Status:      status,
+			Severity:    logSeverity(c, status),
 			Latency:     float64(time.Since(start).Microseconds()) / 1000.0, // Convert to ms
 			ClientIP:    c.ClientIP(),
 			UserAgent:   c.Request.UserAgent(),
 			Headers:     headers,
-			ServiceName: serviceName,
-			Environment: environment,
+			AuthScheme:  authScheme(c.GetHeader("Authorization")),
+			ServiceName: conf.ServiceName,
+			Environment: conf.Environment,
+			Hijacked:    hijacked,
+		}
+
+		entry.Synthetic = state.synthetic.synthetic(c.GetHeader(state.synthetic.header), entry.UserAgent)
+		entry.Labels = headerLabels(conf.HeaderLabels, c.GetHeader)
+
+		entry.RequestBytes = requestBytes
+
+		entry.HandlerLatency = float64(handlerLatency.Microseconds()) / 1000.0
+		if hasDeadline {
+			entry.TimeBudget = float64(time.Until(deadline).Microseconds()) / 1000.0
+		}
+
+		// Keep the raw path when normalization changed it
+		if path != rawPath {
+			entry.RawPath = rawPath
+		}
+
+		entry.Attempt = retryAttempt(c.GetHeader(retryHeader(conf.RetryHeader)))
+
+		// Take the tenant from OTel baggage when configured
+		if conf.TenantBaggageKey != "" {
+			entry.Tenant = baggage.FromContext(c.Request.Context()).Member(conf.TenantBaggageKey).Value()
 		}
 
+		// Capture why an upstream middleware rejected the request
+		entry.RejectedBy, entry.RejectionReason = getRejection(c)
+
+		// Merge the selected baggage and the fields handlers attached to the entry
+		entry.Custom = customFields(c, conf.BaggageKeys, conf.BaggageAll)
+
 		// Capture errors from gin context
 		if len(c.Errors) > 0 {
 			entry.Error = c.Errors.String()
 		}
 
-		// Include request body for non-binary content types
+		// Record the panic, ahead of any gin errors added before it
+		if stack != "" {
+			entry.Error = strings.TrimSpace(fmt.Sprintf("panic: %v\n%s", recovered, entry.Error))
+			entry.StackTrace = stack
+		}
+
+		// Consult the Sampler before the bodies are masked and attached
+		if conf.Sampler != nil && !conf.Sampler.ShouldLog(&entry, c.GetHeader) {
+			reportCounts.sampledOut.Add(1)
+			return
+		}
+
+		// Record both content types, the response's as of the first body write
 		contentType := c.GetHeader("Content-Type")
-		if !isBinaryContent(contentType) && len(requestBodyBytes) > 0 {
-			// Limit the size of logged request body
-			if len(requestBodyBytes) > 10000 {
-				entry.RequestBody = string(requestBodyBytes[:10000]) + "... (truncated)"
+		entry.RequestContentType = contentType
+		if !hijacked {
+			if bodyWriter != nil {
+				entry.ResponseContentType = bodyWriter.ContentType()
 			} else {
-				entry.RequestBody = string(requestBodyBytes)
+				entry.ResponseContentType = c.Writer.Header().Get("Content-Type")
+			}
+		}
+
+		// Include request body for non-binary content types; a hijacked request gets no bodies
+		if !hijacked && !isBinaryContent(contentType) && len(requestBodyBytes) > 0 {
+			// Limit the size of logged request body
+			entry.RequestBody, entry.RequestBodyTruncated = truncate(string(state.masker.mask(requestBodyBytes, contentType)), maxRequestBody)
+			if entry.RequestBodyTruncated {
+				countTruncation(route, "request")
 			}
 		}
 
 		// Include response body for non-binary content types
-		respContentType := bodyWriter.Header().Get("Content-Type")
-		if !isBinaryContent(respContentType) && bodyWriter.body.Len() > 0 {
-			// Limit the size of logged response body
-			responseBody := bodyWriter.body.String()
-			if len(responseBody) > 10000 {
-				entry.ResponseBody = responseBody[:10000] + "... (truncated)"
-			} else {
-				entry.ResponseBody = responseBody
+		if bodyWriter != nil && !hijacked {
+			respContentType := bodyWriter.ContentType()
+			if !isBinaryContent(respContentType) && capturesType(respContentType, state.bodyTypes) && bodyWriter.body.Len() > 0 {
+				// Limit the size of logged response body
+				entry.ResponseBody, entry.ResponseBodyTruncated = truncate(string(state.masker.mask(bodyWriter.body.Bytes(), respContentType)), maxResponseBody)
+				if entry.ResponseBodyTruncated {
+					countTruncation(route, "response")
+				}
 			}
 		}
+		entry.BodyCaptureSkipped = (maxRequestBody != 0 || maxResponseBody != 0) && !captureBodies
+
+		// Mirror the entry onto the active span so logs show up in Jaeger
+		if conf.SpanEvents {
+			LogEntryToSpanEvent(c.Request.Context(), entry)
+		}
+
+		l.emit(state, entry, spanCtx)
+	}
+}
 
-		// Marshal log entry to JSON
-		entryJSON, err := json.Marshal(entry)
+// emit encodes an entry and publishes it to NATS JetStream
+func (l *RequestLogger) emit(state *handlerState, entry LogEntry, spanCtx trace.SpanContext) {
+	conf := state.conf
+
+	// Marshal log entry in the configured format
+	entryJSON, err := EncodeLogEntry(entry, conf.Format)
+	if err != nil {
+		// Fall back to the core request record so the request is never lost
+		marshalErrors.Inc()
+		entryJSON, err = EncodeLogEntry(minimalEntry(entry, err), conf.Format)
 		if err != nil {
-			// If JSON marshaling fails, just log the error and continue
 			return
 		}
+	}
 
-		// Publish log entry to NATS JetStream
-		_, err = js.Publish(subject, entryJSON)
+	// Drop bodies and headers rather than letting NATS reject an oversized message
+	if len(entryJSON) > state.maxMessage {
+		entry.RequestBody = ""
+		entry.ResponseBody = ""
+		entry.Headers = nil
+		entry.Oversized = true
+
+		entryJSON, err = EncodeLogEntry(entry, conf.Format)
 		if err != nil {
-			// In a real implementation, you might want to handle this error
-			// For now, we'll just continue
+			return
 		}
 	}
+
+	// Publish log entry to NATS JetStream
+	l.publish(pendingEntry{entry: entry, subject: conf.Subject, data: entryJSON, spanCtx: spanCtx})
+}
+
+// runHandlers runs the rest of the chain, returning the recovered value and the stack
+// trace if a handler panicked; the stack is empty otherwise
+func runHandlers(c *gin.Context) (recovered any, stack string) {
+	defer func() {
+		if recovered = recover(); recovered != nil {
+			stack = string(debug.Stack())
+		}
+	}()
+	c.Next()
+	return nil, ""
+}
+
+// DefaultRetryHeader is read for the attempt number when LoggerConfig.RetryHeader is empty
+const DefaultRetryHeader = "X-Retry-Attempt"
+
+func retryHeader(name string) string {
+	if name == "" {
+		return DefaultRetryHeader
+	}
+	return name
+}
+
+// retryAttempt parses an attempt number, treating a missing or malformed value as the first attempt
+func retryAttempt(value string) int {
+	attempt, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || attempt < 1 {
+		return 1
+	}
+	return attempt
+}
+
+// minimalEntry keeps only the core request fields of an entry that failed to marshal
+func minimalEntry(entry LogEntry, marshalErr error) LogEntry {
+	return LogEntry{
+		TraceID:     entry.TraceID,
+		SpanID:      entry.SpanID,
+		RequestID:   entry.RequestID,
+		Timestamp:   entry.Timestamp,
+		Method:      entry.Method,
+		Path:        entry.Path,
+		Route:       entry.Route,
+		Status:      entry.Status,
+		Severity:    entry.Severity,
+		Latency:     entry.Latency,
+		ClientIP:    entry.ClientIP,
+		ServiceName: entry.ServiceName,
+		Environment: entry.Environment,
+		Tenant:      entry.Tenant,
+		Labels:      entry.Labels,
+		Hijacked:    entry.Hijacked,
+		Protocol:    entry.Protocol,
+		Synthetic:   entry.Synthetic,
+		StackTrace:  entry.StackTrace,
+		Error:       fmt.Sprintf("log entry marshal failed: %v", marshalErr),
+	}
+}
+
+// shouldSkip reports whether path matches an exact skip path or a skip prefix
+func shouldSkip(path string, exact map[string]bool, prefixes []string) bool {
+	if exact[path] {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitOrDefault returns limit, or defaultMaxFieldBytes when it isn't set
+func limitOrDefault(limit int) int {
+	if limit <= 0 {
+		return defaultMaxFieldBytes
+	}
+	return limit
+}
+
+// truncate cuts s to limit bytes, appends the truncation marker and reports whether it
+// cut anything; a negative limit leaves s whole
+func truncate(s string, limit int) (string, bool) {
+	if limit < 0 || len(s) <= limit {
+		return s, false
+	}
+	return s[:limit] + truncatedMarker, true
+}
+
+// normalizeTrailingSlash applies the trailing slash mode to a path, leaving "/" untouched.
+// A slash is never added after a route's catch-all parameter (/files/*path), which
+// already matches any suffix.
+func normalizeTrailingSlash(path string, mode TrailingSlashMode) string {
+	if path == "" || path == "/" {
+		return path
+	}
+
+	switch mode {
+	case TrailingSlashStrip:
+		if trimmed := strings.TrimRight(path, "/"); trimmed != "" {
+			return trimmed
+		}
+		return "/"
+	case TrailingSlashAdd:
+		if strings.HasSuffix(path, "/") {
+			return path
+		}
+		if last := path[strings.LastIndex(path, "/")+1:]; strings.HasPrefix(last, "*") {
+			return path
+		}
+		return path + "/"
+	}
+	return path
+}
+
+// capturesType reports whether a body of the content type may be logged under the
+// allowlist of media types; a nil allowlist allows every type
+func capturesType(contentType string, allow map[string]bool) bool {
+	if allow == nil {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if allow[mediaType] {
+		return true
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	return allow[major+"/*"]
 }
 
 func isBinaryContent(contentType string) bool {
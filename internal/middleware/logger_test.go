@@ -0,0 +1,424 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeJS records published messages in place of JetStream, failing while err is set
+type fakeJS struct {
+	jetstream.JetStream
+
+	mu  sync.Mutex
+	err error
+	// failFirst limits err to the first failFirst publishes; zero fails them all
+	failFirst int
+	subjects  []string
+	msgs      [][]byte
+	// calls counts publish attempts, failed ones included
+	calls int
+	// discard drops messages instead of recording them, for benchmarks
+	discard bool
+}
+
+func (f *fakeJS) Publish(_ context.Context, subject string, data []byte, _ ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil && (f.failFirst == 0 || f.calls <= f.failFirst) {
+		return nil, f.err
+	}
+	if f.discard {
+		return &jetstream.PubAck{Stream: "logs"}, nil
+	}
+	f.subjects = append(f.subjects, subject)
+	f.msgs = append(f.msgs, append([]byte(nil), data...))
+	return &jetstream.PubAck{Stream: "logs", Sequence: uint64(len(f.msgs))}, nil
+}
+
+// entries decodes every published entry
+func (f *fakeJS) entries(t testing.TB) []LogEntry {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries := make([]LogEntry, len(f.msgs))
+	for i, data := range f.msgs {
+		entry, err := DecodeLogEntry(data)
+		if err != nil {
+			t.Fatalf("published entry %d doesn't decode: %v", i, err)
+		}
+		entries[i] = entry
+	}
+	return entries
+}
+
+// newTestRouter returns a router logging to a fake JetStream with conf; routes registers the handlers
+func newTestRouter(conf LoggerConfig, routes func(r *gin.Engine)) (*gin.Engine, *fakeJS) {
+	js := &fakeJS{}
+	conf.JS = js
+	if conf.Subject == "" {
+		conf.Subject = "logs.test"
+	}
+	if conf.ServiceName == "" {
+		conf.ServiceName = "test"
+	}
+
+	r := gin.New()
+	r.Use(NewRequestLogger(conf).Handler())
+	routes(r)
+	return r, js
+}
+
+// serve sends req through the router and returns the recorded response
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// onlyEntry returns the single published entry, failing unless exactly one was published
+func onlyEntry(t *testing.T, js *fakeJS) LogEntry {
+	t.Helper()
+	entries := js.entries(t)
+	if len(entries) != 1 {
+		t.Fatalf("published %d entries, want 1", len(entries))
+	}
+	return entries[0]
+}
+
+func TestNormalizeTrailingSlash(t *testing.T) {
+	tests := []struct {
+		path string
+		mode TrailingSlashMode
+		want string
+	}{
+		{"", TrailingSlashStrip, ""},
+		{"/", TrailingSlashStrip, "/"},
+		{"/", TrailingSlashAdd, "/"},
+		{"//", TrailingSlashStrip, "/"},
+		{"//", TrailingSlashAdd, "//"},
+		{"//", TrailingSlashKeep, "//"},
+		{"/a/", TrailingSlashStrip, "/a"},
+		{"/a/", TrailingSlashAdd, "/a/"},
+		{"/a/", TrailingSlashKeep, "/a/"},
+		{"/a", TrailingSlashStrip, "/a"},
+		{"/a", TrailingSlashAdd, "/a/"},
+		{"/a//", TrailingSlashStrip, "/a"},
+		{"/users/:id/", TrailingSlashStrip, "/users/:id"},
+		{"/users/:id", TrailingSlashAdd, "/users/:id/"},
+		{"/files/*path", TrailingSlashAdd, "/files/*path"},
+		{"/files/*path", TrailingSlashStrip, "/files/*path"},
+		{"/a/", "", "/a/"},
+	}
+	for _, tt := range tests {
+		if got := normalizeTrailingSlash(tt.path, tt.mode); got != tt.want {
+			t.Errorf("normalizeTrailingSlash(%q, %q) = %q, want %q", tt.path, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestLoggerTrailingSlashVariantsLogTheSamePath(t *testing.T) {
+	for _, mode := range []TrailingSlashMode{TrailingSlashStrip, TrailingSlashAdd} {
+		t.Run(string(mode), func(t *testing.T) {
+			r, js := newTestRouter(LoggerConfig{TrailingSlash: mode}, func(r *gin.Engine) {
+				r.RedirectTrailingSlash = false
+				handler := func(c *gin.Context) { c.Status(http.StatusOK) }
+				r.GET("/users/:id", handler)
+				r.GET("/users/:id/", handler)
+			})
+
+			serve(r, httptest.NewRequest(http.MethodGet, "/users/7", nil))
+			serve(r, httptest.NewRequest(http.MethodGet, "/users/7/", nil))
+
+			entries := js.entries(t)
+			if len(entries) != 2 {
+				t.Fatalf("published %d entries, want 2", len(entries))
+			}
+			if entries[0].Path != entries[1].Path || entries[0].Route != entries[1].Route {
+				t.Errorf("variants logged as %q %q and %q %q", entries[0].Path, entries[0].Route, entries[1].Path, entries[1].Route)
+			}
+
+			// The raw path is kept when normalization changed it
+			for _, e := range entries {
+				if raw := e.RawPath; raw != "" && raw == e.Path {
+					t.Errorf("raw path %q kept although the path is unchanged", raw)
+				}
+			}
+			if entries[0].RawPath == "" && entries[1].RawPath == "" {
+				t.Error("neither variant kept its raw path")
+			}
+		})
+	}
+}
+
+// newRetryRouter returns a router logging through a JetStream that fails its first
+// failFirst publishes, with up to 3 attempts on an async worker, and the logger's drops
+func newRetryRouter(failFirst int, baseDelay time.Duration) (*gin.Engine, *RequestLogger, *fakeJS, *[]error) {
+	js := &fakeJS{}
+	if failFirst > 0 {
+		js.err, js.failFirst = errPrimaryDown, failFirst
+	}
+	var mu sync.Mutex
+	dropped := &[]error{}
+	logger := NewRequestLogger(LoggerConfig{
+		JS:                 js,
+		Subject:            "logs.test",
+		Async:              true,
+		PublishMaxAttempts: 3,
+		PublishBaseDelay:   baseDelay,
+		OnDropEntry: func(_ LogEntry, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			*dropped = append(*dropped, err)
+		},
+	})
+	r := gin.New()
+	r.Use(logger.Handler())
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r, logger, js, dropped
+}
+
+// flush waits for the logger's async publishes, failing the test if they take too long
+func flush(t *testing.T, logger *RequestLogger) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := logger.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+}
+
+func TestPublishRetriesWithBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		failFirst int
+		wantCalls int
+		wantDrop  bool
+	}{
+		{name: "first attempt", failFirst: 0, wantCalls: 1},
+		{name: "fails twice then succeeds", failFirst: 2, wantCalls: 3},
+		{name: "fails every attempt", failFirst: 3, wantCalls: 3, wantDrop: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, logger, js, dropped := newRetryRouter(tt.failFirst, time.Millisecond)
+
+			serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+			flush(t, logger)
+
+			if js.calls != tt.wantCalls {
+				t.Errorf("published %d times, want %d", js.calls, tt.wantCalls)
+			}
+			if n := len(js.msgs); n != map[bool]int{false: 1, true: 0}[tt.wantDrop] {
+				t.Errorf("%d entries published with a drop expected: %t", n, tt.wantDrop)
+			}
+			if tt.wantDrop != (len(*dropped) == 1) || (tt.wantDrop && !errors.Is((*dropped)[0], errPrimaryDown)) {
+				t.Errorf("dropped with %v, want a drop: %t", *dropped, tt.wantDrop)
+			}
+		})
+	}
+}
+
+func TestPublishRetriesOffTheRequestPath(t *testing.T) {
+	r, logger, js, _ := newRetryRouter(2, 200*time.Millisecond)
+
+	// Backoffs of at least 200ms and 400ms run on the worker, not in the request
+	start := time.Now()
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("request took %s waiting on publish retries", elapsed)
+	}
+
+	flush(t, logger)
+	if js.calls != 3 || len(js.entries(t)) != 1 {
+		t.Errorf("published %d entries in %d attempts, want 1 in 3", len(js.msgs), js.calls)
+	}
+}
+
+func TestSyncPublishDoesNotRetry(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{PublishMaxAttempts: 3, PublishBaseDelay: time.Millisecond}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+	js.err, js.failFirst = errPrimaryDown, 1
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	if js.calls != 1 || len(js.msgs) != 0 {
+		t.Errorf("published %d entries in %d attempts, want a single failed attempt", len(js.msgs), js.calls)
+	}
+}
+
+func TestTimeBudget(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/slow", func(c *gin.Context) {
+			time.Sleep(100 * time.Millisecond)
+			c.Status(http.StatusOK)
+		})
+	})
+
+	// The header carries the budget on entry, the entry what was left when the request ended
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+
+	remaining, err := strconv.Atoi(w.Header().Get("X-Deadline-Remaining"))
+	if err != nil || remaining <= 900 || remaining > 1000 {
+		t.Errorf("X-Deadline-Remaining = %q, want just under 1000", w.Header().Get("X-Deadline-Remaining"))
+	}
+	entry := onlyEntry(t, js)
+	if entry.TimeBudget <= 0 || entry.TimeBudget >= float64(remaining+1)-100 {
+		t.Errorf("TimeBudget = %gms, want what was left of %dms after the 100ms handler", entry.TimeBudget, remaining)
+	}
+
+	// Without a deadline neither is set
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if got := w.Header().Get("X-Deadline-Remaining"); got != "" {
+		t.Errorf("X-Deadline-Remaining = %q without a deadline", got)
+	}
+	if entries := js.entries(t); entries[1].TimeBudget != 0 {
+		t.Errorf("TimeBudget = %g without a deadline", entries[1].TimeBudget)
+	}
+}
+
+func TestSkipPaths(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{SkipPaths: []string{"/ping", "/readyz", "/internal/*"}}, func(r *gin.Engine) {
+		r.NoRoute(func(c *gin.Context) { c.String(http.StatusOK, c.Request.URL.Path) })
+	})
+
+	paths := []string{"/ping", "/readyz", "/internal/debug/vars", "/internal/", "/pingx", "/ping/", "/items"}
+	for _, path := range paths {
+		w := serve(r, httptest.NewRequest(http.MethodGet, path, nil))
+		// Skipped paths are still served, just not logged
+		if w.Code != http.StatusOK || w.Body.String() != path {
+			t.Errorf("GET %s = %d %q, want it served", path, w.Code, w.Body.String())
+		}
+	}
+
+	var logged []string
+	for _, entry := range js.entries(t) {
+		logged = append(logged, entry.Path)
+	}
+	if want := []string{"/pingx", "/ping/", "/items"}; !slices.Equal(logged, want) {
+		t.Errorf("logged %v, want %v", logged, want)
+	}
+}
+
+func TestRetryAttempt(t *testing.T) {
+	tests := []struct {
+		header string // the configured retry header; empty uses DefaultRetryHeader
+		sent   map[string]string
+		want   int
+	}{
+		{want: 1},
+		{sent: map[string]string{DefaultRetryHeader: "3"}, want: 3},
+		{sent: map[string]string{DefaultRetryHeader: " 2 "}, want: 2},
+		{sent: map[string]string{DefaultRetryHeader: "0"}, want: 1},
+		{sent: map[string]string{DefaultRetryHeader: "-2"}, want: 1},
+		{sent: map[string]string{DefaultRetryHeader: "second"}, want: 1},
+		{header: "X-Attempt", sent: map[string]string{"x-attempt": "4"}, want: 4},
+		{header: "X-Attempt", sent: map[string]string{DefaultRetryHeader: "4"}, want: 1},
+	}
+	for _, tt := range tests {
+		r, js := newTestRouter(LoggerConfig{RetryHeader: tt.header}, func(r *gin.Engine) {
+			r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+		})
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		for name, value := range tt.sent {
+			req.Header.Set(name, value)
+		}
+		serve(r, req)
+
+		if got := onlyEntry(t, js).Attempt; got != tt.want {
+			t.Errorf("header %q with %v: Attempt = %d, want %d", tt.header, tt.sent, got, tt.want)
+		}
+	}
+}
+
+func TestPanicIsLoggedAndReraised(t *testing.T) {
+	js := &fakeJS{}
+	r := gin.New()
+	r.Use(gin.RecoveryWithWriter(io.Discard), NewRequestLogger(LoggerConfig{JS: js, Subject: "logs.test"}).Handler())
+	r.GET("/boom", func(c *gin.Context) {
+		c.Error(errors.New("cache miss"))
+		panic("boom")
+	})
+	r.GET("/late", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("after writing")
+	})
+
+	// Recovery still answers with a 500, so the panic reached it
+	if w := serve(r, httptest.NewRequest(http.MethodGet, "/boom", nil)); w.Code != http.StatusInternalServerError {
+		t.Errorf("GET /boom = %d, want Recovery's 500", w.Code)
+	}
+	serve(r, httptest.NewRequest(http.MethodGet, "/late", nil))
+
+	entries := js.entries(t)
+	if len(entries) != 2 {
+		t.Fatalf("published %d entries, want 2", len(entries))
+	}
+	boom, late := entries[0], entries[1]
+	if boom.Status != http.StatusInternalServerError || boom.Severity != SeverityError {
+		t.Errorf("panicking request logged with status %d, severity %s; want 500 ERROR", boom.Status, boom.Severity)
+	}
+	if !strings.HasPrefix(boom.Error, "panic: boom") || !strings.Contains(boom.Error, "cache miss") {
+		t.Errorf("Error = %q, want the panic ahead of the handler's error", boom.Error)
+	}
+	if !strings.Contains(boom.StackTrace, "TestPanicIsLoggedAndReraised") {
+		t.Errorf("StackTrace doesn't show the panicking handler:\n%s", boom.StackTrace)
+	}
+
+	// Once a response was started its status stands
+	if late.Status != http.StatusOK || !strings.HasPrefix(late.Error, "panic: after writing") || late.StackTrace == "" {
+		t.Errorf("late panic logged with status %d, error %q and a %d-byte stack", late.Status, late.Error, len(late.StackTrace))
+	}
+}
+
+func TestHandlerLatency(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	r, js := newTestRouter(LoggerConfig{MaxRequestBodyBytes: 1 << 10, MaxResponseBodyBytes: 1 << 10}, func(r *gin.Engine) {
+		r.POST("/fast", func(c *gin.Context) { c.String(http.StatusOK, "done") })
+		r.POST("/slow", func(c *gin.Context) {
+			time.Sleep(delay)
+			c.String(http.StatusOK, "done")
+		})
+	})
+	serve(r, httptest.NewRequest(http.MethodPost, "/fast", strings.NewReader(`{"id":1}`)))
+	serve(r, httptest.NewRequest(http.MethodPost, "/slow", strings.NewReader(`{"id":1}`)))
+
+	entries := js.entries(t)
+	if len(entries) != 2 {
+		t.Fatalf("published %d entries, want 2", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.HandlerLatency < 0 || entry.HandlerLatency > entry.Latency {
+			t.Errorf("%s: HandlerLatency = %.3fms, want between 0 and Latency %.3fms", entry.Path, entry.HandlerLatency, entry.Latency)
+		}
+	}
+
+	slow := entries[1]
+	if slow.HandlerLatency < float64(delay.Milliseconds()) {
+		t.Errorf("slow handler: HandlerLatency = %.3fms, want at least %s", slow.HandlerLatency, delay)
+	}
+	// The Logger's own overhead is small next to a slow handler
+	if overhead := slow.Latency - slow.HandlerLatency; overhead > slow.HandlerLatency {
+		t.Errorf("slow handler: Logger overhead %.3fms exceeds HandlerLatency %.3fms", overhead, slow.HandlerLatency)
+	}
+}
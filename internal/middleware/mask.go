@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strings"
+)
+
+// MaskedValue replaces the value of masked body fields
+const MaskedValue = "[MASKED]"
+
+// bodyMasker masks named fields in JSON bodies
+type bodyMasker struct {
+	paths [][]string
+}
+
+// newBodyMasker returns a masker for dot-separated field paths such as "user.password",
+// or nil when there are none
+func newBodyMasker(fields []string) *bodyMasker {
+	var paths [][]string
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			paths = append(paths, strings.Split(field, "."))
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return &bodyMasker{paths: paths}
+}
+
+// mask returns body with the masked fields replaced by MaskedValue. Bodies that aren't
+// JSON, fail to parse or have none of the fields are returned unchanged.
+func (m *bodyMasker) mask(body []byte, contentType string) []byte {
+	if m == nil || len(body) == 0 || !isJSONContent(contentType) {
+		return body
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // Keep numbers exactly as sent
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return body
+	}
+
+	masked := false
+	for _, path := range m.paths {
+		masked = maskPath(doc, path) || masked
+	}
+	if !masked {
+		return body
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// maskPath masks the field at path below value, applying the rest of the path to every
+// element of arrays along the way; it reports whether anything was masked
+func maskPath(value any, path []string) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		child, ok := v[path[0]]
+		if !ok {
+			return false
+		}
+		if len(path) == 1 {
+			v[path[0]] = MaskedValue
+			return true
+		}
+		return maskPath(child, path[1:])
+	case []any:
+		masked := false
+		for _, item := range v {
+			masked = maskPath(item, path) || masked
+		}
+		return masked
+	default:
+		return false
+	}
+}
+
+// isJSONContent reports whether a content type is JSON, including +json types such as
+// application/problem+json
+func isJSONContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
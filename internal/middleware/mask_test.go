@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyMasker(t *testing.T) {
+	masker := newBodyMasker([]string{"password", " user.ssn ", "cards.number", ""})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name:        "top-level field",
+			contentType: "application/json",
+			body:        `{"name":"ada","password":"hunter2"}`,
+			want:        `{"name":"ada","password":"[MASKED]"}`,
+		},
+		{
+			name:        "nested path",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"user":{"name":"ada","ssn":"078-05-1120"},"ssn":"kept"}`,
+			want:        `{"ssn":"kept","user":{"name":"ada","ssn":"[MASKED]"}}`,
+		},
+		{
+			name:        "path through an array",
+			contentType: "application/json",
+			body:        `{"cards":[{"number":"4111111111111111","exp":"12/30"},{"number":"5500005555555559"},{"exp":"01/31"}]}`,
+			want:        `{"cards":[{"exp":"12/30","number":"[MASKED]"},{"number":"[MASKED]"},{"exp":"01/31"}]}`,
+		},
+		{
+			name:        "top-level array",
+			contentType: "application/json",
+			body:        `[{"password":"a"},{"password":"b"}]`,
+			want:        `[{"password":"[MASKED]"},{"password":"[MASKED]"}]`,
+		},
+		{
+			name:        "+json content type",
+			contentType: "application/problem+json",
+			body:        `{"title":"bad","password":"hunter2"}`,
+			want:        `{"password":"[MASKED]","title":"bad"}`,
+		},
+		{
+			name:        "numbers kept exactly",
+			contentType: "application/json",
+			body:        `{"id":12345678901234567890,"price":1.10,"password":"x"}`,
+			want:        `{"id":12345678901234567890,"password":"[MASKED]","price":1.10}`,
+		},
+		{
+			name:        "no masked field leaves the body as sent",
+			contentType: "application/json",
+			body:        `{ "name": "ada", "price": 1.10 }`,
+			want:        `{ "name": "ada", "price": 1.10 }`,
+		},
+		{
+			name:        "field on a non-object",
+			contentType: "application/json",
+			body:        `{"user":"ada"}`,
+			want:        `{"user":"ada"}`,
+		},
+		{
+			name:        "HTML not escaped",
+			contentType: "application/json",
+			body:        `{"note":"<b>&</b>","password":"x"}`,
+			want:        `{"note":"<b>&</b>","password":"[MASKED]"}`,
+		},
+		{
+			name:        "form body",
+			contentType: "application/x-www-form-urlencoded",
+			body:        `password=hunter2`,
+			want:        `password=hunter2`,
+		},
+		{
+			name:        "JSON sent as text",
+			contentType: "text/plain",
+			body:        `{"password":"hunter2"}`,
+			want:        `{"password":"hunter2"}`,
+		},
+		{
+			name:        "malformed JSON",
+			contentType: "application/json",
+			body:        `{"password":"hunter2"`,
+			want:        `{"password":"hunter2"`,
+		},
+		{
+			name:        "invalid content type",
+			contentType: "application/json; =",
+			body:        `{"password":"hunter2"}`,
+			want:        `{"password":"hunter2"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(masker.mask([]byte(tt.body), tt.contentType)); got != tt.want {
+				t.Errorf("mask(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+
+	if newBodyMasker([]string{" ", ""}) != nil {
+		t.Error("newBodyMasker built a masker without fields")
+	}
+	var none *bodyMasker
+	if got := string(none.mask([]byte(`{"password":"x"}`), "application/json")); got != `{"password":"x"}` {
+		t.Errorf("nil masker changed the body to %s", got)
+	}
+}
+
+func TestLoggerMasksBodies(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{
+		MaxRequestBodyBytes:  1 << 10,
+		MaxResponseBodyBytes: 1 << 10,
+		MaskBodyFields:       []string{"password", "token"},
+	}, func(r *gin.Engine) {
+		r.POST("/login", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"token": "secret-token", "user": "ada"})
+		})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"user":"ada","password":"hunter2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := serve(r, req)
+
+	entry := onlyEntry(t, js)
+	if entry.RequestBody != `{"password":"[MASKED]","user":"ada"}` {
+		t.Errorf("RequestBody = %s", entry.RequestBody)
+	}
+	if entry.ResponseBody != `{"token":"[MASKED]","user":"ada"}` {
+		t.Errorf("ResponseBody = %s", entry.ResponseBody)
+	}
+	// Only the log is masked, not what the client gets
+	if !strings.Contains(w.Body.String(), "secret-token") {
+		t.Errorf("response sent to the client = %s, want it unmasked", w.Body.String())
+	}
+}
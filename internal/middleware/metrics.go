@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// unmatchedRoute labels requests that matched no route, keeping raw paths out of label values
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route template and status.",
+	}, []string{"method", "path", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	logsPublished = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "logger_published_total",
+		Help: "Log entries the Logger published to NATS.",
+	}, func() float64 {
+		return float64(totals.published.Load())
+	})
+
+	publishFailures = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "logger_publish_failures_total",
+		Help: "Log entries the Logger failed to publish to NATS.",
+	}, func() float64 {
+		return float64(totals.failed.Load())
+	})
+
+	logsDropped = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "logger_dropped_total",
+		Help: "Log entries the Logger dropped because the async buffer was full.",
+	}, func() float64 {
+		return float64(totals.dropped.Load())
+	})
+
+	lastReport = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "logger_last_report",
+		Help: "Counts from the Logger's most recent periodic report (logged, sampled_out, dropped, publish_failed, truncated).",
+	}, []string{"count"})
+
+	bodyTruncations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "logtrace_body_truncated_total",
+		Help: "Logged bodies cut at their size limit, by route template and field (request or response).",
+	}, []string{"route", "field"})
+
+	marshalErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logger_marshal_errors_total",
+		Help: "Log entries that failed to marshal and were published as a minimal entry instead.",
+	})
+
+	requestSizes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "logger_request_bytes",
+		Help:    "Request body sizes seen by the Logger.",
+		Buckets: []float64{0, 1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20},
+	})
+
+	logsBuffered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logger_disk_buffered_total",
+		Help: "Log entries written to the disk buffer after failing to publish.",
+	})
+
+	logsReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logger_disk_replayed_total",
+		Help: "Log entries replayed from the disk buffer to NATS.",
+	})
+
+	bufferDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "logger_disk_buffer_rotated_total",
+		Help: "Buffered log entries lost when the disk buffer rotated past its cap.",
+	})
+
+	activeClusterGauge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "logger_active_nats_cluster",
+		Help: "NATS cluster the Logger publishes to: 0 primary, 1 secondary.",
+	}, func() float64 {
+		return float64(ActiveCluster())
+	})
+)
+
+// countTruncation records a body cut at its limit
+func countTruncation(route, field string) {
+	if route == "" {
+		route = unmatchedRoute
+	}
+	bodyTruncations.WithLabelValues(route, field).Inc()
+	reportCounts.truncated.Add(1)
+}
+
+// Metrics returns a middleware recording request counts and latencies in Prometheus,
+// labelled by the route template (c.FullPath()) so label cardinality stays bounded
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		path := c.FullPath()
+		if path == "" {
+			path = unmatchedRoute
+		}
+
+		httpRequests.WithLabelValues(c.Request.Method, path, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, path).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// histogramSamples returns how many observations the named histogram holds
+func histogramSamples(t *testing.T, name string) uint64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	t.Fatalf("no histogram %s registered", name)
+	return 0
+}
+
+// scrape fetches the default registry's /metrics, returning each series' value keyed by its
+// name and labels as exposed, e.g. logtrace_body_truncated_total{field="request",route="/items"}
+func scrape(t *testing.T) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d: %s", w.Code, w.Body.String())
+	}
+
+	series := make(map[string]float64)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("unparseable series %q: %v", line, err)
+		}
+		series[line[:i]] = value
+	}
+	return series
+}
+
+func TestRequestBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		// capture is MaxRequestBodyBytes; zero reads the body through the handler only
+		capture int
+		body    string
+		chunked bool
+		// unread leaves the body to the handler, which ignores it
+		unread bool
+		want   int64
+	}{
+		{name: "content length", capture: 100, body: `{"name":"widget"}`, want: 17},
+		{name: "content length beyond the capture limit", capture: 4, body: `{"name":"widget"}`, want: 17},
+		{name: "chunked and captured", capture: 100, body: "0123456789", chunked: true, want: 10},
+		{name: "chunked and read by the handler", body: "0123456789", chunked: true, want: 10},
+		{name: "chunked and never read", body: "0123456789", chunked: true, unread: true, want: 0},
+		{name: "content length the handler ignores", body: "0123456789", unread: true, want: 10},
+		{name: "no body", capture: 100, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, js := newTestRouter(LoggerConfig{MaxRequestBodyBytes: tt.capture}, func(r *gin.Engine) {
+				r.POST("/items", func(c *gin.Context) {
+					if !tt.unread {
+						io.Copy(io.Discard, c.Request.Body)
+					}
+					c.Status(http.StatusCreated)
+				})
+			})
+			req := httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+
+			before := histogramSamples(t, "logger_request_bytes")
+			serve(r, req)
+
+			if got := onlyEntry(t, js).RequestBytes; got != tt.want {
+				t.Errorf("RequestBytes = %d, want %d", got, tt.want)
+			}
+			if n := histogramSamples(t, "logger_request_bytes") - before; n != 1 {
+				t.Errorf("logger_request_bytes recorded %d observations, want 1", n)
+			}
+		})
+	}
+}
+
+func TestUnmarshalableEntryFallsBackToMinimalEntry(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/items/:id", func(c *gin.Context) {
+			AddLogField(c, "callback", func() {})
+			c.Status(http.StatusOK)
+		})
+	})
+	req := httptest.NewRequest(http.MethodGet, "/items/7", nil)
+	req.Header.Set("X-Request-ID", "req-7")
+
+	before := testutil.ToFloat64(marshalErrors)
+	serve(r, req)
+
+	entry := onlyEntry(t, js)
+	if entry.Path != "/items/7" || entry.Route != "/items/:id" || entry.Status != http.StatusOK || entry.RequestID != "req-7" {
+		t.Errorf("minimal entry lost the request: %s %s status %d request %q", entry.Path, entry.Route, entry.Status, entry.RequestID)
+	}
+	if entry.Custom != nil || entry.Headers != nil {
+		t.Errorf("minimal entry kept custom fields %v and headers %v", entry.Custom, entry.Headers)
+	}
+	if !strings.Contains(entry.Error, "marshal") {
+		t.Errorf("Error = %q, want the marshal failure", entry.Error)
+	}
+	if n := testutil.ToFloat64(marshalErrors) - before; n != 1 {
+		t.Errorf("logger_marshal_errors_total rose by %g, want 1", n)
+	}
+}
+
+func TestBodyTruncationMetric(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{MaxRequestBodyBytes: 16, MaxResponseBodyBytes: 16}, func(r *gin.Engine) {
+		r.POST("/items/:id", func(c *gin.Context) {
+			io.Copy(io.Discard, c.Request.Body)
+			c.Data(http.StatusOK, "application/json", []byte(c.Query("response")))
+		})
+	})
+	long := `{"name":"` + strings.Repeat("x", 32) + `"}`
+
+	before := scrape(t)
+	for _, tt := range []struct{ path, body, response string }{
+		{"/items/1", `{"name":"a"}`, `{"ok":true}`}, // within both limits
+		{"/items/2", long, `{"ok":true}`},
+		{"/items/3", long, long},
+		{"/no/such/route", long, ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tt.path+"?response="+url.QueryEscape(tt.response), strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		serve(r, req)
+	}
+	after := scrape(t)
+
+	// Counted by route template and field, never by raw path
+	want := map[string]float64{
+		`logtrace_body_truncated_total{field="request",route="/items/:id"}`:  2,
+		`logtrace_body_truncated_total{field="response",route="/items/:id"}`: 1,
+		`logtrace_body_truncated_total{field="request",route="unmatched"}`:   1,
+	}
+	for series, delta := range want {
+		if got := after[series] - before[series]; got != delta {
+			t.Errorf("%s rose by %g, want %g", series, got, delta)
+		}
+	}
+	for series := range after {
+		if strings.HasPrefix(series, "logtrace_body_truncated_total") && strings.Contains(series, "/no/such/route") {
+			t.Errorf("raw path in a label: %s", series)
+		}
+	}
+
+	truncated := 0
+	for _, entry := range js.entries(t) {
+		if entry.RequestBodyTruncated {
+			truncated++
+		}
+	}
+	if truncated != 3 {
+		t.Errorf("%d entries have a truncated request body, want 3", truncated)
+	}
+}
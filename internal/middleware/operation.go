@@ -0,0 +1,27 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+const operationKey = "log_operation"
+
+// Operation returns a handler naming the route's operation for the Logger, e.g. the
+// Swagger operationId. Register it ahead of the route's handler:
+//
+//	v1.GET("/users/:id", middleware.Operation("getUser"), getUser)
+func Operation(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(operationKey, name)
+	}
+}
+
+// operation returns the name set with Operation, falling back to the method and route;
+// empty when no route matched
+func operation(c *gin.Context, method, route string) string {
+	if name := c.GetString(operationKey); name != "" {
+		return name
+	}
+	if route == "" {
+		return ""
+	}
+	return method + " " + route
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOperation(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+		r.GET("/users/:id", Operation("getUser"), ok)
+		r.DELETE("/users/:id", Operation("deleteUser"), ok)
+		r.GET("/orders/:id", ok)
+		r.GET("/untagged", Operation(""), ok)
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/users/42", "getUser"},
+		{http.MethodDelete, "/users/42", "deleteUser"},
+		{http.MethodGet, "/orders/7", "GET /orders/:id"},
+		{http.MethodGet, "/untagged", "GET /untagged"},
+		{http.MethodGet, "/no/such/route", ""},
+	}
+	for _, tt := range tests {
+		serve(r, httptest.NewRequest(tt.method, tt.path, nil))
+	}
+
+	entries := js.entries(t)
+	if len(entries) != len(tests) {
+		t.Fatalf("published %d entries, want %d", len(entries), len(tests))
+	}
+	for i, tt := range tests {
+		if got := entries[i].Operation; got != tt.want {
+			t.Errorf("%s %s: Operation = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
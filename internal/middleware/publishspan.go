@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the middleware's own spans
+const tracerName = "logtrace/middleware"
+
+// tracedPublish publishes an entry inside a nats.publish span that is a child of the
+// request's span, recording the subject, payload size and the stream sequence or error.
+// Entries of requests without a span are published untraced.
+func (l *RequestLogger) tracedPublish(p pendingEntry, attempt int) error {
+	if !p.spanCtx.IsValid() {
+		_, err := l.publisher.Publish(context.Background(), p.subject, p.data)
+		return err
+	}
+
+	// Async publishes outlive the request, so only its span is carried over, not its context
+	ctx := trace.ContextWithSpanContext(context.Background(), p.spanCtx)
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "nats.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", p.subject),
+			attribute.Int("messaging.message.body.size", len(p.data)),
+			attribute.Int("publish.attempt", attempt),
+		),
+	)
+	defer span.End()
+
+	ack, err := l.publisher.Publish(ctx, p.subject, p.data)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetAttributes(
+		attribute.String("nats.stream", ack.Stream),
+		attribute.Int64("nats.sequence", int64(ack.Sequence)),
+	)
+	return nil
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that records every ended span, for the test's duration
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+// spansNamed returns the recorded spans with the given name, in the order they ended
+func spansNamed(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// spanAttributes returns the span's attributes by key
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestPublishSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	js := &fakeJS{err: errPrimaryDown, failFirst: 1}
+	logger := NewRequestLogger(LoggerConfig{
+		JS:                 js,
+		Subject:            "logs.test",
+		Async:              true,
+		PublishMaxAttempts: 3,
+		PublishBaseDelay:   time.Millisecond,
+	})
+	r := gin.New()
+	r.Use(Tracing("test"), logger.Handler())
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+	flush(t, logger)
+
+	requests := spansNamed(recorder, "/items")
+	if len(requests) != 1 {
+		t.Fatalf("recorded %d request spans, want 1", len(requests))
+	}
+	request := requests[0].SpanContext()
+
+	// The failed first attempt and the retry each get a span under the request's
+	publishes := spansNamed(recorder, "nats.publish")
+	if len(publishes) != 2 {
+		t.Fatalf("recorded %d nats.publish spans, want one per attempt", len(publishes))
+	}
+	for i, span := range publishes {
+		if span.Parent().SpanID() != request.SpanID() || span.SpanContext().TraceID() != request.TraceID() {
+			t.Errorf("attempt %d: span parent = %s, want the request span %s", i+1, span.Parent().SpanID(), request.SpanID())
+		}
+		if span.SpanKind() != trace.SpanKindProducer {
+			t.Errorf("attempt %d: span kind = %s, want producer", i+1, span.SpanKind())
+		}
+		attrs := spanAttributes(span)
+		if got := attrs["publish.attempt"].AsInt64(); got != int64(i+1) {
+			t.Errorf("attempt %d: publish.attempt = %d", i+1, got)
+		}
+		if got := attrs["messaging.destination.name"].AsString(); got != "logs.test" {
+			t.Errorf("attempt %d: messaging.destination.name = %q, want logs.test", i+1, got)
+		}
+		if got := attrs["messaging.system"].AsString(); got != "nats" {
+			t.Errorf("attempt %d: messaging.system = %q, want nats", i+1, got)
+		}
+		if got := attrs["messaging.message.body.size"].AsInt64(); got != int64(len(js.msgs[0])) {
+			t.Errorf("attempt %d: messaging.message.body.size = %d, want %d", i+1, got, len(js.msgs[0]))
+		}
+	}
+
+	failed, published := publishes[0], publishes[1]
+	if failed.Status().Code != codes.Error || len(failed.Events()) == 0 {
+		t.Errorf("failed attempt: status %v with %d events, want the error recorded", failed.Status().Code, len(failed.Events()))
+	}
+	if _, ok := spanAttributes(failed)["nats.sequence"]; ok {
+		t.Error("failed attempt carries a stream sequence")
+	}
+	attrs := spanAttributes(published)
+	if published.Status().Code == codes.Error || attrs["nats.stream"].AsString() != "logs" || attrs["nats.sequence"].AsInt64() != 1 {
+		t.Errorf("published attempt: status %v, stream %q, sequence %d; want logs sequence 1",
+			published.Status().Code, attrs["nats.stream"].AsString(), attrs["nats.sequence"].AsInt64())
+	}
+}
+
+func TestPublishWithoutRequestSpanIsUntraced(t *testing.T) {
+	recorder := recordSpans(t)
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	onlyEntry(t, js)
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Errorf("recorded %d spans for a request without one", len(spans))
+	}
+}
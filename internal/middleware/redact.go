@@ -0,0 +1,71 @@
+package middleware
+
+import "strings"
+
+// RedactedValue replaces the value of redacted headers
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactHeaders are redacted when LoggerConfig.RedactHeaders is nil
+var DefaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// unknownAuthScheme is logged when an Authorization header has no recognizable scheme
+const unknownAuthScheme = "unknown"
+
+// maxAuthSchemeLen bounds the logged scheme so a malformed header can't leak a credential
+const maxAuthSchemeLen = 32
+
+// authScheme returns the scheme of an Authorization header value (e.g. "Bearer", "Basic"),
+// "unknown" when the value has no scheme, or "" when there is no header
+func authScheme(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+
+	// A value without a separate credential may be a bare token, so its first word is not a scheme
+	scheme, credential, found := strings.Cut(value, " ")
+	if !found || strings.TrimSpace(credential) == "" || len(scheme) > maxAuthSchemeLen {
+		return unknownAuthScheme
+	}
+	for _, r := range scheme {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return unknownAuthScheme
+		}
+	}
+	return scheme
+}
+
+// headerRedactor decides which header values must not be logged
+type headerRedactor struct {
+	redact    map[string]bool
+	allow     map[string]bool
+	redactAll bool
+}
+
+func newHeaderRedactor(redactHeaders, allowHeaders []string, redactAll bool) *headerRedactor {
+	if redactHeaders == nil {
+		redactHeaders = DefaultRedactHeaders
+	}
+	return &headerRedactor{
+		redact:    lowerSet(redactHeaders),
+		allow:     lowerSet(allowHeaders),
+		redactAll: redactAll,
+	}
+}
+
+// redacts reports whether the named header's value must be replaced
+func (r *headerRedactor) redacts(name string) bool {
+	name = strings.ToLower(name)
+	if r.redactAll {
+		return !r.allow[name]
+	}
+	return r.redact[name]
+}
+
+func lowerSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(v)] = true
+	}
+	return set
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthScheme(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"Bearer eyJhbGciOiJIUzI1NiJ9.e30.sig", "Bearer"},
+		{"Basic dXNlcjpwYXNz", "Basic"},
+		{"ApiKey abc123", "ApiKey"},
+		{"  Bearer token  ", "Bearer"},
+		{"AWS4-HMAC-SHA256 Credential=x", "AWS4-HMAC-SHA256"},
+		{"eyJhbGciOiJIUzI1NiJ9.e30.sig", unknownAuthScheme},
+		{"Bearer ", unknownAuthScheme},
+		{strings.Repeat("a", maxAuthSchemeLen) + " token", strings.Repeat("a", maxAuthSchemeLen)},
+		{strings.Repeat("a", maxAuthSchemeLen+1) + " token", unknownAuthScheme},
+		{"Bea:rer token", unknownAuthScheme},
+		{"Bearer/1 token", unknownAuthScheme},
+	}
+	for _, tt := range tests {
+		if got := authScheme(tt.value); got != tt.want {
+			t.Errorf("authScheme(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoggerLogsOnlyTheAuthScheme(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("X-Request-Source", "test")
+	serve(r, req)
+
+	entry := onlyEntry(t, js)
+	if entry.AuthScheme != "Bearer" {
+		t.Errorf("AuthScheme = %q, want Bearer", entry.AuthScheme)
+	}
+	if value, ok := entry.Headers["Authorization"]; ok {
+		t.Errorf("Headers carry Authorization = %q, want it left out", value)
+	}
+	if entry.Headers["X-Request-Source"] != "test" {
+		t.Errorf("Headers = %v, want the other headers kept", entry.Headers)
+	}
+	if data := js.msgs[0]; strings.Contains(string(data), "secret-token") {
+		t.Errorf("published entry contains the credential: %s", data)
+	}
+}
+
+func TestHeaderRedaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     LoggerConfig
+		redacted []string
+		kept     []string
+	}{
+		{
+			name:     "defaults",
+			redacted: []string{"Cookie", "Proxy-Authorization"},
+			kept:     []string{"X-Api-Key", "Accept"},
+		},
+		{
+			name:     "custom list replaces the defaults",
+			conf:     LoggerConfig{RedactHeaders: []string{"x-api-key"}},
+			redacted: []string{"X-Api-Key"},
+			kept:     []string{"Cookie", "Accept"},
+		},
+		{
+			name: "empty list redacts nothing",
+			conf: LoggerConfig{RedactHeaders: []string{}},
+			kept: []string{"Cookie", "X-Api-Key", "Accept"},
+		},
+		{
+			name:     "redact all but the allowed",
+			conf:     LoggerConfig{RedactAll: true, AllowHeaders: []string{"accept"}},
+			redacted: []string{"Cookie", "X-Api-Key", "Proxy-Authorization"},
+			kept:     []string{"Accept"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, js := newTestRouter(tt.conf, func(r *gin.Engine) {
+				r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+			})
+			req := httptest.NewRequest(http.MethodGet, "/items", nil)
+			for _, name := range []string{"Cookie", "Proxy-Authorization", "X-Api-Key", "Accept"} {
+				req.Header.Set(name, "value-of-"+name)
+			}
+			req.Header.Set("Authorization", "Bearer secret-token")
+			serve(r, req)
+
+			headers := onlyEntry(t, js).Headers
+			for _, name := range tt.redacted {
+				if headers[name] != RedactedValue {
+					t.Errorf("%s = %q, want it redacted", name, headers[name])
+				}
+			}
+			for _, name := range tt.kept {
+				if headers[name] != "value-of-"+name {
+					t.Errorf("%s = %q, want it kept", name, headers[name])
+				}
+			}
+			// Authorization is left out whatever the configuration
+			if value, ok := headers["Authorization"]; ok {
+				t.Errorf("Authorization logged as %q", value)
+			}
+		})
+	}
+}
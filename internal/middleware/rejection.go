@@ -0,0 +1,20 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+const (
+	rejectedByKey      = "rejected_by"
+	rejectionReasonKey = "rejection_reason"
+)
+
+// SetRejection records why a middleware rejected a request so the Logger can include it.
+// Call it before aborting, e.g. SetRejection(c, "auth", "invalid_token").
+func SetRejection(c *gin.Context, rejectedBy, reason string) {
+	c.Set(rejectedByKey, rejectedBy)
+	c.Set(rejectionReasonKey, reason)
+}
+
+// getRejection returns the rejection recorded on the context, if any
+func getRejection(c *gin.Context) (rejectedBy, reason string) {
+	return c.GetString(rejectedByKey), c.GetString(rejectionReasonKey)
+}
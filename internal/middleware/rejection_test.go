@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoggerRecordsRejection(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		auth := func(c *gin.Context) {
+			if c.GetHeader("Authorization") == "" {
+				SetRejection(c, "auth", "missing_token")
+				c.AbortWithStatus(http.StatusUnauthorized)
+			}
+		}
+		r.GET("/private", auth, func(c *gin.Context) {
+			t.Error("handler ran for a rejected request")
+		})
+	})
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/private", nil))
+
+	entry := onlyEntry(t, js)
+	if entry.Status != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", entry.Status)
+	}
+	if entry.RejectedBy != "auth" || entry.RejectionReason != "missing_token" {
+		t.Errorf("rejection = %q/%q, want auth/missing_token", entry.RejectedBy, entry.RejectionReason)
+	}
+}
+
+func TestLoggerOmitsRejectionWhenAbsent(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if entry := onlyEntry(t, js); entry.RejectedBy != "" || entry.RejectionReason != "" {
+		t.Errorf("rejection = %q/%q, want none", entry.RejectedBy, entry.RejectionReason)
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if body := string(js.msgs[0]); strings.Contains(body, "rejected_by") || strings.Contains(body, "rejection_reason") {
+		t.Errorf("entry carries empty rejection fields: %s", body)
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// DefaultRequestIDHeader carries the request ID when LoggerConfig.RequestIDHeader is empty
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the Gin context key holding the request ID
+const requestIDKey = "request_id"
+
+// maxRequestIDLen bounds incoming IDs; longer ones are replaced rather than logged
+const maxRequestIDLen = 128
+
+func requestIDHeader(name string) string {
+	if name == "" {
+		return DefaultRequestIDHeader
+	}
+	return name
+}
+
+// requestID returns the incoming request ID, or a new UUID when it is missing or too long
+func requestID(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > maxRequestIDLen {
+		return uuid.New().String()
+	}
+	return value
+}
+
+// RequestID returns the ID the Logger assigned to the request, or "" when it didn't run
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestIDContextKey holds the request ID in a gRPC call's context
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the request ID the gRPC interceptors assigned to a call,
+// or "" outside one
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		incoming string
+		keep     bool
+	}{
+		{name: "incoming ID kept", incoming: "req-123", keep: true},
+		{name: "longest ID kept", incoming: strings.Repeat("a", maxRequestIDLen), keep: true},
+		{name: "missing ID generated"},
+		{name: "blank ID generated", incoming: "   "},
+		{name: "overlong ID replaced", incoming: strings.Repeat("a", maxRequestIDLen+1)},
+		{name: "custom header", header: "X-Correlation-ID", incoming: "corr-9", keep: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := requestIDHeader(tt.header)
+			var seen, forwarded string
+			r, js := newTestRouter(LoggerConfig{RequestIDHeader: tt.header}, func(r *gin.Engine) {
+				r.GET("/items", func(c *gin.Context) {
+					seen = RequestID(c)
+					forwarded = c.GetHeader(header)
+					c.Status(http.StatusOK)
+				})
+			})
+			req := httptest.NewRequest(http.MethodGet, "/items", nil)
+			if tt.incoming != "" {
+				req.Header.Set(header, tt.incoming)
+			}
+			w := serve(r, req)
+
+			id := onlyEntry(t, js).RequestID
+			if tt.keep && id != tt.incoming {
+				t.Errorf("RequestID = %q, want the incoming %q", id, tt.incoming)
+			}
+			if !tt.keep {
+				if _, err := uuid.Parse(id); err != nil {
+					t.Errorf("RequestID = %q, want a generated UUID", id)
+				}
+			}
+			if got := w.Header().Get(header); got != id {
+				t.Errorf("response %s = %q, want the logged %q", header, got, id)
+			}
+			if seen != id || forwarded != id {
+				t.Errorf("handler saw RequestID %q and header %q, want %q", seen, forwarded, id)
+			}
+		})
+	}
+}
+
+func TestRequestIDGeneratedPerRequest(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	entries := js.entries(t)
+	if len(entries) != 2 {
+		t.Fatalf("published %d entries, want 2", len(entries))
+	}
+	if entries[0].RequestID == entries[1].RequestID {
+		t.Errorf("both requests got RequestID %q", entries[0].RequestID)
+	}
+}
+
+func TestRequestIDWithoutLogger(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if got := RequestID(c); got != "" {
+		t.Errorf("RequestID = %q without the Logger, want empty", got)
+	}
+}
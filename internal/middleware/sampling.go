@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SampledHeader carries the sampling decision between services so every hop of a trace
+// logs consistently; "1" forces logging and "0" skips it
+const SampledHeader = "X-Log-Sampled"
+
+// sampleDecision honors an upstream decision from SampledHeader, falling back to the
+// trace-based decision when the header is absent or malformed
+func sampleDecision(header, traceID string, rate float64) bool {
+	if sampled, err := strconv.ParseBool(header); err == nil {
+		return sampled
+	}
+	return keepTrace(traceID, rate)
+}
+
+// sampledValue formats a sampling decision for SampledHeader
+func sampledValue(sampled bool) string {
+	if sampled {
+		return "1"
+	}
+	return "0"
+}
+
+// keepTrace decides deterministically from the trace ID whether a trace is sampled in,
+// so every service sharing the trace makes the same decision
+func keepTrace(traceID string, rate float64) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(traceID))
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// Sampler decides whether a call's entry is published, for HTTP requests and gRPC calls
+// alike. ShouldLog runs after the handler, with the entry filled in except for its bodies;
+// header returns a request header or, for gRPC, the first value of a metadata key, and ""
+// when it is absent.
+type Sampler interface {
+	ShouldLog(entry *LogEntry, header func(name string) string) bool
+}
+
+// SamplerFunc adapts a function to a Sampler, e.g. to sample by tenant
+type SamplerFunc func(entry *LogEntry, header func(name string) string) bool
+
+// ShouldLog calls f(entry, header)
+func (f SamplerFunc) ShouldLog(entry *LogEntry, header func(name string) string) bool {
+	return f(entry, header)
+}
+
+// AlwaysSample publishes every entry
+func AlwaysSample() Sampler {
+	return SamplerFunc(func(*LogEntry, func(string) string) bool { return true })
+}
+
+// NeverSample publishes no entries
+func NeverSample() Sampler {
+	return SamplerFunc(func(*LogEntry, func(string) string) bool { return false })
+}
+
+// ProbabilitySampler publishes the given fraction (0.0–1.0) of entries, decided per trace
+// ID so every service sharing a trace makes the same decision. Like SampleRate, a rate of
+// zero disables sampling and publishes every entry; use NeverSample to publish none.
+func ProbabilitySampler(rate float64) Sampler {
+	return SamplerFunc(func(entry *LogEntry, _ func(string) string) bool {
+		return keepTrace(entry.TraceID, rate)
+	})
+}
+
+// ErrorSampler publishes only failed requests: status >= 400 or a recorded error
+func ErrorSampler() Sampler {
+	return SamplerFunc(func(entry *LogEntry, _ func(string) string) bool {
+		return entry.Status >= http.StatusBadRequest || entry.Error != ""
+	})
+}
+
+// RateLimitSampler publishes at most perSecond entries in each second, dropping the rest
+func RateLimitSampler(perSecond int) Sampler {
+	return &rateLimitSampler{limit: perSecond}
+}
+
+type rateLimitSampler struct {
+	limit int
+	// now is the sampler's clock; nil uses time.Now
+	now func() time.Time
+
+	mu     sync.Mutex
+	window int64 // Unix second being counted
+	count  int
+}
+
+func (s *rateLimitSampler) ShouldLog(*LogEntry, func(string) string) bool {
+	clock := time.Now
+	if s.now != nil {
+		clock = s.now
+	}
+	now := clock().Unix()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now != s.window {
+		s.window = now
+		s.count = 0
+	}
+	if s.count >= s.limit {
+		return false
+	}
+	s.count++
+	return true
+}
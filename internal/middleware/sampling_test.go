@@ -0,0 +1,285 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func randomTraceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func TestKeepTraceIsDeterministic(t *testing.T) {
+	for range 1000 {
+		traceID := randomTraceID()
+		kept := keepTrace(traceID, 0.3)
+		for range 5 {
+			if keepTrace(traceID, 0.3) != kept {
+				t.Fatalf("trace %s decided differently on a later call", traceID)
+			}
+		}
+
+		// A trace kept at a rate is kept at every higher rate, so raising the rate
+		// never drops traces that were logged before
+		if kept && !keepTrace(traceID, 0.6) {
+			t.Fatalf("trace %s kept at 0.3 but not at 0.6", traceID)
+		}
+	}
+}
+
+func TestKeepTraceRate(t *testing.T) {
+	const n = 10000
+	for _, rate := range []float64{0.01, 0.1, 0.5, 0.9} {
+		kept := 0
+		for range n {
+			if keepTrace(randomTraceID(), rate) {
+				kept++
+			}
+		}
+		// Within five standard deviations of the binomial expectation
+		want := rate * n
+		tolerance := 5 * math.Sqrt(n*rate*(1-rate))
+		if got := float64(kept); got < want-tolerance || got > want+tolerance {
+			t.Errorf("rate %v kept %d of %d, want %.0f±%.0f", rate, kept, n, want, tolerance)
+		}
+	}
+
+	for _, rate := range []float64{0, 1} {
+		if !keepTrace(randomTraceID(), rate) {
+			t.Errorf("rate %v dropped a trace", rate)
+		}
+	}
+}
+
+func TestSampleRateKeepsEveryError(t *testing.T) {
+	const n = 10000
+	r, js := newTestRouter(LoggerConfig{SampleRate: 0.1}, func(r *gin.Engine) {
+		r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+		r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	})
+
+	for i := range n {
+		path := "/ok"
+		if i%10 == 0 {
+			path = "/fail"
+		}
+		serve(r, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var okLogged, failLogged int
+	for _, entry := range js.entries(t) {
+		if entry.Path == "/fail" {
+			failLogged++
+		} else {
+			okLogged++
+		}
+	}
+	if failLogged != n/10 {
+		t.Errorf("logged %d of %d failed requests, want all", failLogged, n/10)
+	}
+	// 9000 successful requests at 10%: 900 expected, 5 standard deviations is ±142
+	if okLogged < 758 || okLogged > 1042 {
+		t.Errorf("logged %d of %d successful requests, want about 900", okLogged, n-n/10)
+	}
+}
+
+// sampledRouter serves /ok and /fail through a logger using sampler
+func sampledRouter(sampler Sampler) (*gin.Engine, *fakeJS) {
+	return newTestRouter(LoggerConfig{Sampler: sampler}, func(r *gin.Engine) {
+		r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+		r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	})
+}
+
+func TestBuiltInSamplers(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampler Sampler
+		want    []string
+	}{
+		{"always", AlwaysSample(), []string{"/ok", "/fail"}},
+		{"never", NeverSample(), nil},
+		{"errors", ErrorSampler(), []string{"/fail"}},
+		{"probability 1", ProbabilitySampler(1), []string{"/ok", "/fail"}},
+		// Zero disables sampling, as SampleRate does
+		{"probability 0", ProbabilitySampler(0), []string{"/ok", "/fail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, js := sampledRouter(tt.sampler)
+			serve(r, httptest.NewRequest(http.MethodGet, "/ok", nil))
+			serve(r, httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+			var paths []string
+			for _, entry := range js.entries(t) {
+				paths = append(paths, entry.Path)
+			}
+			if !slices.Equal(paths, tt.want) {
+				t.Errorf("published %v, want %v", paths, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbabilitySamplerMatchesSampleRate(t *testing.T) {
+	for _, rate := range []float64{0, 0.25, 1} {
+		sampler := ProbabilitySampler(rate)
+		for range 1000 {
+			entry := LogEntry{TraceID: randomTraceID(), Status: http.StatusOK}
+			if got, want := sampler.ShouldLog(&entry, nil), sampleDecision("", entry.TraceID, rate); got != want {
+				t.Fatalf("rate %v: sampler kept trace %s: %t, SampleRate: %t", rate, entry.TraceID, got, want)
+			}
+		}
+	}
+}
+
+func TestRateLimitSampler(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sampler := &rateLimitSampler{limit: 3, now: func() time.Time { return now }}
+
+	kept := func(n int) int {
+		count := 0
+		for range n {
+			if sampler.ShouldLog(&LogEntry{}, nil) {
+				count++
+			}
+		}
+		return count
+	}
+	if got := kept(10); got != 3 {
+		t.Errorf("kept %d of 10 entries in one second, want 3", got)
+	}
+	now = now.Add(900 * time.Millisecond)
+	if got := kept(10); got != 0 {
+		t.Errorf("kept %d more entries within the same second", got)
+	}
+	now = now.Add(100 * time.Millisecond)
+	if got := kept(10); got != 3 {
+		t.Errorf("kept %d of 10 entries in the next second, want 3", got)
+	}
+}
+
+func TestCustomSampler(t *testing.T) {
+	sampler := SamplerFunc(func(entry *LogEntry, header func(string) string) bool {
+		return header("X-Debug") == "1" || entry.Status >= http.StatusInternalServerError
+	})
+	r, js := sampledRouter(sampler)
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/ok", nil))
+	debug := httptest.NewRequest(http.MethodGet, "/ok", nil)
+	debug.Header.Set("x-debug", "1")
+	serve(r, debug)
+	serve(r, httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	entries := js.entries(t)
+	if len(entries) != 2 || entries[0].Path != "/ok" || entries[1].Path != "/fail" {
+		t.Errorf("published %+v, want the debug request and the failure", entries)
+	}
+}
+
+// forwardingServices starts a downstream service and returns an upstream one whose /call
+// handler calls it, forwarding SampledHeader as a handler propagating headers would.
+// The upstream's /fail route fails after the call.
+func forwardingServices(t *testing.T, rate float64) (upstream *gin.Engine, upJS, downJS *fakeJS, forwarded *string) {
+	t.Helper()
+	down, downJS := newTestRouter(LoggerConfig{SampleRate: rate, ServiceName: "down"}, func(r *gin.Engine) {
+		r.GET("/work", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+	downstream := httptest.NewServer(down)
+	t.Cleanup(downstream.Close)
+
+	forwarded = new(string)
+	call := func(c *gin.Context) {
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, downstream.URL+"/work", nil)
+		*forwarded = c.Request.Header.Get(SampledHeader)
+		req.Header.Set(SampledHeader, *forwarded)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("downstream call: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get(SampledHeader); got != *forwarded {
+			t.Errorf("downstream answered %s %q to %q", SampledHeader, got, *forwarded)
+		}
+	}
+	upstream, upJS = newTestRouter(LoggerConfig{SampleRate: rate, ServiceName: "up"}, func(r *gin.Engine) {
+		r.GET("/call", func(c *gin.Context) {
+			call(c)
+			c.Status(http.StatusOK)
+		})
+		r.GET("/fail", func(c *gin.Context) {
+			call(c)
+			c.Status(http.StatusInternalServerError)
+		})
+	})
+	return upstream, upJS, downJS, forwarded
+}
+
+func TestSampledHeaderIsHonoredAndForwarded(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   float64
+		path   string
+		header string
+		// decision is what the upstream passes on; logged is whether each service logs
+		decision   string
+		upLogged   bool
+		downLogged bool
+	}{
+		{"sampled in despite the rate", 0.000001, "/call", "1", "1", true, true},
+		{"sampled out despite the rate", 1, "/call", "0", "0", false, false},
+		{"sampled out failure still logged", 1, "/fail", "0", "0", true, false},
+		{"malformed header uses the rate", 1, "/call", "maybe", "1", true, true},
+		{"no header uses the rate", 1, "/call", "", "1", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, upJS, downJS, forwarded := forwardingServices(t, tt.rate)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(SampledHeader, tt.header)
+			}
+			w := serve(upstream, req)
+
+			if got := w.Header().Get(SampledHeader); got != tt.decision {
+				t.Errorf("upstream answered %s %q, want %q", SampledHeader, got, tt.decision)
+			}
+			if *forwarded != tt.decision {
+				t.Errorf("handler forwarded %q, want %q", *forwarded, tt.decision)
+			}
+			if logged := len(upJS.entries(t)) == 1; logged != tt.upLogged {
+				t.Errorf("upstream logged: %t, want %t", logged, tt.upLogged)
+			}
+			if logged := len(downJS.entries(t)) == 1; logged != tt.downLogged {
+				t.Errorf("downstream logged: %t, want %t", logged, tt.downLogged)
+			}
+		})
+	}
+}
+
+func TestSampledDecisionIsConsistentAcrossServices(t *testing.T) {
+	// Without an incoming decision, the upstream's rate decides for the whole trace
+	upstream, upJS, downJS, _ := forwardingServices(t, 0.5)
+	for range 200 {
+		serve(upstream, httptest.NewRequest(http.MethodGet, "/call", nil))
+	}
+
+	up, down := len(upJS.entries(t)), len(downJS.entries(t))
+	if up != down {
+		t.Errorf("upstream logged %d calls, downstream %d", up, down)
+	}
+	if up == 0 || up == 200 {
+		t.Errorf("logged %d of 200 calls at rate 0.5", up)
+	}
+}
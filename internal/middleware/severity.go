@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Severity is an OpenTelemetry log severity text
+type Severity string
+
+const (
+	SeverityTrace Severity = "TRACE"
+	SeverityDebug Severity = "DEBUG"
+	SeverityInfo  Severity = "INFO"
+	SeverityWarn  Severity = "WARN"
+	SeverityError Severity = "ERROR"
+	SeverityFatal Severity = "FATAL"
+)
+
+const severityKey = "log_severity"
+
+// SetLogSeverity overrides the severity the Logger records for the request, e.g. to log
+// a handled failure that still returns 200 as SeverityError
+func SetLogSeverity(c *gin.Context, severity Severity) {
+	c.Set(severityKey, severity)
+}
+
+// logSeverity returns the severity set by a handler, or one derived from the outcome:
+// 5xx or handler errors are ERROR, 4xx is WARN and anything else INFO
+func logSeverity(c *gin.Context, status int) Severity {
+	if severity, ok := c.Value(severityKey).(Severity); ok && severity != "" {
+		return severity
+	}
+
+	switch {
+	case status >= http.StatusInternalServerError, len(c.Errors) > 0:
+		return SeverityError
+	case status >= http.StatusBadRequest:
+		return SeverityWarn
+	default:
+		return SeverityInfo
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLogSeverity(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		want    Severity
+	}{
+		{"success", func(c *gin.Context) { c.Status(http.StatusOK) }, SeverityInfo},
+		{"redirect", func(c *gin.Context) { c.Status(http.StatusFound) }, SeverityInfo},
+		{"client error", func(c *gin.Context) { c.Status(http.StatusNotFound) }, SeverityWarn},
+		{"server error", func(c *gin.Context) { c.Status(http.StatusBadGateway) }, SeverityError},
+		{"handler error with 200", func(c *gin.Context) {
+			c.Error(errors.New("cache refresh failed"))
+			c.Status(http.StatusOK)
+		}, SeverityError},
+		{"handler error with 4xx", func(c *gin.Context) {
+			c.Error(errors.New("invalid id"))
+			c.Status(http.StatusBadRequest)
+		}, SeverityError},
+		{"override raises a 200", func(c *gin.Context) {
+			SetLogSeverity(c, SeverityError)
+			c.Status(http.StatusOK)
+		}, SeverityError},
+		{"override lowers a 5xx", func(c *gin.Context) {
+			SetLogSeverity(c, SeverityDebug)
+			c.Status(http.StatusServiceUnavailable)
+		}, SeverityDebug},
+		{"override wins over handler errors", func(c *gin.Context) {
+			SetLogSeverity(c, SeverityWarn)
+			c.Error(errors.New("retrying"))
+			c.Status(http.StatusOK)
+		}, SeverityWarn},
+		{"empty override ignored", func(c *gin.Context) {
+			SetLogSeverity(c, "")
+			c.Status(http.StatusInternalServerError)
+		}, SeverityError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, js := newTestRouter(LoggerConfig{}, func(r *gin.Engine) {
+				r.GET("/items", tt.handler)
+			})
+			serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+			if got := onlyEntry(t, js).Severity; got != tt.want {
+				t.Errorf("Severity = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
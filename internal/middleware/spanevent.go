@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logEventName is the name of span events created from log entries
+const logEventName = "log"
+
+// LogEntryToSpanEvent records the entry's key fields as an event on the span in ctx.
+// It does nothing when ctx carries no recording span.
+func LogEntryToSpanEvent(ctx context.Context, entry LogEntry) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("log.trace_id", entry.TraceID),
+		attribute.String("http.method", entry.Method),
+		attribute.String("http.path", entry.Path),
+		attribute.Int("http.status_code", entry.Status),
+		attribute.String("log.severity", string(entry.Severity)),
+		attribute.Float64("http.latency_ms", entry.Latency),
+		attribute.String("client.ip", entry.ClientIP),
+		attribute.String("service.name", entry.ServiceName),
+		attribute.String("deployment.environment", entry.Environment),
+	}
+	if entry.RequestID != "" {
+		attrs = append(attrs, attribute.String("request.id", entry.RequestID))
+	}
+	if entry.Route != "" {
+		attrs = append(attrs, attribute.String("http.route", entry.Route))
+	}
+	if entry.Tenant != "" {
+		attrs = append(attrs, attribute.String("tenant", entry.Tenant))
+	}
+	if entry.Error != "" {
+		attrs = append(attrs, attribute.String("error", entry.Error))
+	}
+	if entry.RejectedBy != "" {
+		attrs = append(attrs,
+			attribute.String("rejected_by", entry.RejectedBy),
+			attribute.String("rejection_reason", entry.RejectionReason),
+		)
+	}
+
+	span.AddEvent(logEventName, trace.WithTimestamp(entry.Timestamp), trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestLogEntryToSpanEvent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+
+	timestamp := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	LogEntryToSpanEvent(ctx, LogEntry{
+		TraceID:         "4bf92f3577b34da6a3ce929d0e0e4736",
+		RequestID:       "req-1",
+		Timestamp:       timestamp,
+		Method:          http.MethodPost,
+		Path:            "/orders/42",
+		Route:           "/orders/:id",
+		Status:          http.StatusForbidden,
+		Severity:        SeverityWarn,
+		Latency:         12.5,
+		ClientIP:        "203.0.113.7",
+		ServiceName:     "api",
+		Environment:     "prod",
+		Tenant:          "acme",
+		Error:           "forbidden",
+		RejectedBy:      "authz",
+		RejectionReason: "missing scope",
+	})
+	// Optional fields are left out when empty
+	LogEntryToSpanEvent(ctx, LogEntry{Method: http.MethodGet, Path: "/ping", Status: http.StatusOK})
+	span.End()
+
+	events := recorder.Ended()[0].Events()
+	if len(events) != 2 {
+		t.Fatalf("span has %d events, want 2", len(events))
+	}
+	if events[0].Name != "log" || !events[0].Time.Equal(timestamp) {
+		t.Errorf("event = %s at %s, want log at the entry's timestamp %s", events[0].Name, events[0].Time, timestamp)
+	}
+
+	want := map[attribute.Key]any{
+		"log.trace_id":           "4bf92f3577b34da6a3ce929d0e0e4736",
+		"http.method":            http.MethodPost,
+		"http.path":              "/orders/42",
+		"http.status_code":       int64(http.StatusForbidden),
+		"log.severity":           string(SeverityWarn),
+		"http.latency_ms":        12.5,
+		"client.ip":              "203.0.113.7",
+		"service.name":           "api",
+		"deployment.environment": "prod",
+		"request.id":             "req-1",
+		"http.route":             "/orders/:id",
+		"tenant":                 "acme",
+		"error":                  "forbidden",
+		"rejected_by":            "authz",
+		"rejection_reason":       "missing scope",
+	}
+	got := make(map[attribute.Key]any)
+	for _, kv := range events[0].Attributes {
+		got[kv.Key] = kv.Value.AsInterface()
+	}
+	if len(got) != len(want) {
+		t.Errorf("event has %d attributes, want %d: %v", len(got), len(want), got)
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+
+	for _, kv := range events[1].Attributes {
+		switch kv.Key {
+		case "request.id", "http.route", "tenant", "error", "rejected_by", "rejection_reason":
+			t.Errorf("event for a plain entry carries %s = %v", kv.Key, kv.Value.AsInterface())
+		}
+	}
+
+	// Without a recording span there is nothing to add to, and no panic
+	LogEntryToSpanEvent(context.Background(), LogEntry{Path: "/ping"})
+}
+
+func TestLoggerSpanEvents(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		recorder := recordSpans(t)
+		js := &fakeJS{}
+		r := gin.New()
+		r.Use(Tracing("test"), NewRequestLogger(LoggerConfig{JS: js, Subject: "logs.test", SpanEvents: enabled}).Handler())
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+		var events int
+		for _, span := range spansNamed(recorder, "/items") {
+			for _, event := range span.Events() {
+				if event.Name == logEventName {
+					events++
+				}
+			}
+		}
+		if want := map[bool]int{false: 0, true: 1}[enabled]; events != want {
+			t.Errorf("SpanEvents %t: request span has %d log events, want %d", enabled, events, want)
+		}
+	}
+}
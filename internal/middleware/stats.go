@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// totals counts entries since the process started; metrics.go exports them
+	totals struct {
+		published atomic.Int64
+		failed    atomic.Int64
+		dropped   atomic.Int64
+	}
+
+	// recentDrops counts entries handed to publish and those dropped on a full queue,
+	// for the drop rate over a recent window
+	recentDrops = newRateWindow(5*time.Second, 120)
+
+	// recentPublishes counts publish attempts and those that failed, for the error rate
+	// over a recent window
+	recentPublishes = newRateWindow(5*time.Second, 120)
+)
+
+// MaxDropWindow is the longest window DropStats and RecentPublishStats cover
+const MaxDropWindow = 10 * time.Minute
+
+// rateWindow counts events, and how many of them went wrong, in fixed-width time buckets
+// kept in a ring
+type rateWindow struct {
+	mu      sync.Mutex
+	width   time.Duration
+	buckets []rateBucket
+}
+
+type rateBucket struct {
+	slot   int64 // start of the bucket in widths since the epoch
+	total  int64
+	failed int64
+}
+
+func newRateWindow(width time.Duration, n int) *rateWindow {
+	return &rateWindow{width: width, buckets: make([]rateBucket, n)}
+}
+
+// add counts one event at now, and whether it went wrong
+func (w *rateWindow) add(now time.Time, failed bool) {
+	slot := now.UnixNano() / int64(w.width)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := &w.buckets[slot%int64(len(w.buckets))]
+	if b.slot != slot {
+		// The bucket holds an older slot; reuse it
+		*b = rateBucket{slot: slot}
+	}
+	b.total++
+	if failed {
+		b.failed++
+	}
+}
+
+// stats sums the buckets within window of now, which the ring caps at its length
+func (w *rateWindow) stats(now time.Time, window time.Duration) (failed, total int64) {
+	current := now.UnixNano() / int64(w.width)
+	oldest := current - int64(min(window/w.width, time.Duration(len(w.buckets))-1))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, b := range w.buckets {
+		if b.slot >= oldest && b.slot <= current {
+			total += b.total
+			failed += b.failed
+		}
+	}
+	return failed, total
+}
+
+// reportCounts accumulates counts between periodic reports
+var reportCounts struct {
+	logged     atomic.Int64
+	sampledOut atomic.Int64
+	dropped    atomic.Int64
+	failed     atomic.Int64
+	truncated  atomic.Int64
+}
+
+// Report summarizes what the Logger did during one reporting interval
+type Report struct {
+	Logged        int64
+	SampledOut    int64
+	Dropped       int64
+	PublishFailed int64
+	// Truncated counts request and response bodies cut at their limit
+	Truncated int64
+	Interval  time.Duration
+}
+
+// PublishStats returns how many log entries the Logger published and how many failed
+// since the process started
+func PublishStats() (published, failed int64) {
+	return totals.published.Load(), totals.failed.Load()
+}
+
+// RecentPublishStats returns how many publishes failed and how many were attempted over
+// the recent window, at most MaxDropWindow, rounded up to 5s
+func RecentPublishStats(window time.Duration) (failed, total int64) {
+	return recentPublishes.stats(time.Now(), window)
+}
+
+// DropStats returns how many log entries the Logger dropped on a full async queue and
+// how many it handled in total over the recent window, at most MaxDropWindow, rounded up
+// to 5s
+func DropStats(window time.Duration) (dropped, total int64) {
+	return recentDrops.stats(time.Now(), window)
+}
+
+// StartReporter logs a summary of logged, sampled-out, dropped and failed entries every
+// interval, resetting the counts each time. Call the returned function to stop it.
+func StartReporter(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	stopReports := startReporter(time.Now(), ticker.C, emitReport)
+	return func() {
+		ticker.Stop()
+		stopReports()
+	}
+}
+
+// startReporter passes emit a report for each tick, covering the time since the previous
+// tick or start, until stopped
+func startReporter(start time.Time, ticks <-chan time.Time, emit func(Report)) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		last := start
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticks:
+				emit(takeReport(now.Sub(last)))
+				last = now
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// takeReport returns the counts since the previous report and resets them
+func takeReport(interval time.Duration) Report {
+	return Report{
+		Logged:        reportCounts.logged.Swap(0),
+		SampledOut:    reportCounts.sampledOut.Swap(0),
+		Dropped:       reportCounts.dropped.Swap(0),
+		PublishFailed: reportCounts.failed.Swap(0),
+		Truncated:     reportCounts.truncated.Swap(0),
+		Interval:      interval,
+	}
+}
+
+func emitReport(r Report) {
+	lastReport.WithLabelValues("logged").Set(float64(r.Logged))
+	lastReport.WithLabelValues("sampled_out").Set(float64(r.SampledOut))
+	lastReport.WithLabelValues("dropped").Set(float64(r.Dropped))
+	lastReport.WithLabelValues("publish_failed").Set(float64(r.PublishFailed))
+	lastReport.WithLabelValues("truncated").Set(float64(r.Truncated))
+
+	log.Printf("Logger report: interval=%s logged=%d sampled_out=%d dropped=%d publish_failed=%d truncated=%d",
+		r.Interval, r.Logged, r.SampledOut, r.Dropped, r.PublishFailed, r.Truncated)
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRateWindowForgetsOldFailures(t *testing.T) {
+	w := newRateWindow(5*time.Second, 120)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// An outage at the start, then a recovery six minutes later
+	for i := 0; i < 10; i++ {
+		w.add(start, true)
+	}
+	recovered := start.Add(6 * time.Minute)
+	for i := 0; i < 10; i++ {
+		w.add(recovered, false)
+	}
+
+	if failed, total := w.stats(start.Add(time.Second), 5*time.Minute); failed != 10 || total != 10 {
+		t.Errorf("during the outage: %d of %d failed, want 10 of 10", failed, total)
+	}
+	if failed, total := w.stats(recovered, 5*time.Minute); failed != 0 || total != 10 {
+		t.Errorf("after recovery: %d of %d failed, want 0 of 10", failed, total)
+	}
+	if failed, total := w.stats(recovered, 10*time.Minute); failed != 10 || total != 20 {
+		t.Errorf("over 10m: %d of %d failed, want 10 of 20", failed, total)
+	}
+}
+
+func TestReporterReportsAndResetsCounts(t *testing.T) {
+	takeReport(0) // start from zero whatever other tests logged
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ticks := make(chan time.Time)
+	reports := make(chan Report)
+	stop := startReporter(start, ticks, func(r Report) { reports <- r })
+	defer stop()
+
+	reportCounts.logged.Add(7)
+	reportCounts.sampledOut.Add(3)
+	reportCounts.dropped.Add(2)
+	reportCounts.failed.Add(1)
+	reportCounts.truncated.Add(4)
+
+	ticks <- start.Add(10 * time.Second)
+	want := Report{Logged: 7, SampledOut: 3, Dropped: 2, PublishFailed: 1, Truncated: 4, Interval: 10 * time.Second}
+	if got := <-reports; got != want {
+		t.Errorf("first report = %+v, want %+v", got, want)
+	}
+
+	// The counts start over, and a late tick covers the time since the last one
+	reportCounts.logged.Add(1)
+	ticks <- start.Add(25 * time.Second)
+	want = Report{Logged: 1, Interval: 15 * time.Second}
+	if got := <-reports; got != want {
+		t.Errorf("second report = %+v, want %+v", got, want)
+	}
+}
+
+func TestEmitReportPublishesLastReport(t *testing.T) {
+	emitReport(Report{Logged: 5, Dropped: 2, Interval: time.Minute})
+
+	for name, want := range map[string]float64{"logged": 5, "dropped": 2, "sampled_out": 0} {
+		if got := testutil.ToFloat64(lastReport.WithLabelValues(name)); got != want {
+			t.Errorf("logger_last_report{count=%q} = %g, want %g", name, got, want)
+		}
+	}
+}
+
+func TestPublishTotalsAreExported(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{OnDropEntry: func(LogEntry, error) {}}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+	published, failed := PublishStats()
+	publishedMetric, failedMetric := testutil.ToFloat64(logsPublished), testutil.ToFloat64(publishFailures)
+
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+	js.mu.Lock()
+	js.err = errors.New("nats: timeout")
+	js.mu.Unlock()
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+	serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	nowPublished, nowFailed := PublishStats()
+	if nowPublished-published != 1 || nowFailed-failed != 2 {
+		t.Errorf("PublishStats rose by %d published and %d failed, want 1 and 2", nowPublished-published, nowFailed-failed)
+	}
+	if got := testutil.ToFloat64(logsPublished) - publishedMetric; got != 1 {
+		t.Errorf("logger_published_total rose by %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(publishFailures) - failedMetric; got != 2 {
+		t.Errorf("logger_publish_failures_total rose by %g, want 2", got)
+	}
+}
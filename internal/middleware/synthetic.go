@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DefaultSyntheticHeader marks a request as synthetic when LoggerConfig.SyntheticHeader is empty
+const DefaultSyntheticHeader = "X-Synthetic"
+
+// syntheticDetector recognizes probe and synthetic-check requests
+type syntheticDetector struct {
+	header     string
+	userAgents []string
+}
+
+func newSyntheticDetector(header string, userAgents []string) *syntheticDetector {
+	if header == "" {
+		header = DefaultSyntheticHeader
+	}
+	d := &syntheticDetector{header: header}
+	for _, ua := range userAgents {
+		if ua = strings.ToLower(strings.TrimSpace(ua)); ua != "" {
+			d.userAgents = append(d.userAgents, ua)
+		}
+	}
+	return d
+}
+
+// synthetic reports whether the header is set to a true value or the user agent contains
+// one of the patterns (case-insensitive)
+func (d *syntheticDetector) synthetic(headerValue, userAgent string) bool {
+	if marked, err := strconv.ParseBool(strings.TrimSpace(headerValue)); err == nil && marked {
+		return true
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, pattern := range d.userAgents {
+		if strings.Contains(userAgent, pattern) {
+			return true
+		}
+	}
+	return false
+}
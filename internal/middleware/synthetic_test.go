@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSyntheticDetector(t *testing.T) {
+	detector := newSyntheticDetector("", []string{"kube-probe/", "  Datadog Synthetics ", ""})
+
+	tests := []struct {
+		header    string
+		userAgent string
+		want      bool
+	}{
+		{"", "Mozilla/5.0", false},
+		{"true", "Mozilla/5.0", true},
+		{"1", "", true},
+		{" TRUE ", "", true},
+		{"false", "", false},
+		{"0", "", false},
+		{"yes", "", false}, // not a boolean
+		{"", "kube-probe/1.29", true},
+		{"", "KUBE-PROBE/1.29", true},
+		{"", "Mozilla/5.0 (compatible; datadog synthetics)", true},
+		{"false", "kube-probe/1.29", true}, // either signal is enough
+		{"", "kube-probe", false},          // the pattern includes the slash
+	}
+	for _, tt := range tests {
+		if got := detector.synthetic(tt.header, tt.userAgent); got != tt.want {
+			t.Errorf("synthetic(%q, %q) = %t, want %t", tt.header, tt.userAgent, got, tt.want)
+		}
+	}
+
+	if detector.header != DefaultSyntheticHeader {
+		t.Errorf("header = %q, want the default %q", detector.header, DefaultSyntheticHeader)
+	}
+	if none := newSyntheticDetector("X-Probe", nil); none.synthetic("", "kube-probe/1.29") {
+		t.Error("matched a user agent without patterns")
+	}
+}
+
+func TestLoggerTagsSyntheticRequests(t *testing.T) {
+	r, js := newTestRouter(LoggerConfig{SyntheticHeader: "X-Probe", SyntheticUserAgents: []string{"kube-probe/"}}, func(r *gin.Engine) {
+		r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+	})
+
+	tests := []struct {
+		headers map[string]string
+		want    bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"x-probe": "true"}, true},
+		{map[string]string{DefaultSyntheticHeader: "true"}, false}, // replaced by X-Probe
+		{map[string]string{"User-Agent": "Kube-Probe/1.29"}, true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		serve(r, req)
+	}
+
+	entries := js.entries(t)
+	if len(entries) != len(tests) {
+		t.Fatalf("published %d entries, want %d", len(entries), len(tests))
+	}
+	for i, tt := range tests {
+		if entries[i].Synthetic != tt.want {
+			t.Errorf("request with %v: Synthetic = %t, want %t", tt.headers, entries[i].Synthetic, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/rand"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FallbackIDFormat selects how the Logger generates IDs for requests without a valid span
+type FallbackIDFormat string
+
+const (
+	// FallbackIDOTel generates a 32-hex trace ID and a 16-hex span ID, like OTel does, so
+	// the entries still correlate with traces in Tempo or Jaeger
+	FallbackIDOTel FallbackIDFormat = "otel"
+	// FallbackIDUUID generates a dashed UUID trace ID and no span ID, as earlier versions did
+	FallbackIDUUID FallbackIDFormat = "uuid"
+)
+
+// fallbackIDs generates a trace and span ID in the given format; unknown formats use FallbackIDOTel
+func fallbackIDs(format FallbackIDFormat) (traceID, spanID string) {
+	if format == FallbackIDUUID {
+		return uuid.New().String(), trace.SpanID{}.String()
+	}
+
+	var tid trace.TraceID
+	var sid trace.SpanID
+	// All zeros is an invalid ID; crypto/rand makes a retry practically unreachable
+	for !tid.IsValid() {
+		_, _ = rand.Read(tid[:])
+	}
+	for !sid.IsValid() {
+		_, _ = rand.Read(sid[:])
+	}
+	return tid.String(), sid.String()
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	otelTraceID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	otelSpanID  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+func TestFallbackIDs(t *testing.T) {
+	for _, format := range []FallbackIDFormat{"", FallbackIDOTel, "unknown"} {
+		traceID, spanID := fallbackIDs(format)
+		if !otelTraceID.MatchString(traceID) {
+			t.Errorf("format %q: trace ID %q is not 32 lowercase hex characters", format, traceID)
+		}
+		if !otelSpanID.MatchString(spanID) {
+			t.Errorf("format %q: span ID %q is not 16 lowercase hex characters", format, spanID)
+		}
+		if tid, err := trace.TraceIDFromHex(traceID); err != nil || !tid.IsValid() {
+			t.Errorf("format %q: trace ID %q is not a valid OTel trace ID: %v", format, traceID, err)
+		}
+		if sid, err := trace.SpanIDFromHex(spanID); err != nil || !sid.IsValid() {
+			t.Errorf("format %q: span ID %q is not a valid OTel span ID: %v", format, spanID, err)
+		}
+	}
+
+	first, _ := fallbackIDs(FallbackIDOTel)
+	second, _ := fallbackIDs(FallbackIDOTel)
+	if first == second {
+		t.Errorf("generated the same trace ID twice: %s", first)
+	}
+
+	traceID, spanID := fallbackIDs(FallbackIDUUID)
+	if _, err := uuid.Parse(traceID); err != nil || len(traceID) != 36 {
+		t.Errorf("UUID format trace ID = %q, want a dashed UUID", traceID)
+	}
+	if spanID != (trace.SpanID{}).String() {
+		t.Errorf("UUID format span ID = %q, want the zero span ID", spanID)
+	}
+}
+
+func TestLoggerFallbackIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		format FallbackIDFormat
+		valid  func(traceID, spanID string) bool
+	}{
+		{"default", "", func(traceID, spanID string) bool {
+			return otelTraceID.MatchString(traceID) && otelSpanID.MatchString(spanID)
+		}},
+		{"uuid", FallbackIDUUID, func(traceID, spanID string) bool {
+			_, err := uuid.Parse(traceID)
+			return err == nil && len(traceID) == 36 && spanID == (trace.SpanID{}).String()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, js := newTestRouter(LoggerConfig{FallbackIDFormat: tt.format}, func(r *gin.Engine) {
+				r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+			})
+			w := serve(r, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+			entry := onlyEntry(t, js)
+			if !tt.valid(entry.TraceID, entry.SpanID) {
+				t.Errorf("logged trace ID %q and span ID %q in the wrong format", entry.TraceID, entry.SpanID)
+			}
+			if got := w.Header().Get("X-Trace-ID"); got != entry.TraceID {
+				t.Errorf("X-Trace-ID = %q, want the logged %q", got, entry.TraceID)
+			}
+		})
+	}
+}
@@ -16,7 +16,13 @@ import (
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
-func InitTracer(serviceName, jaegerEndpoint string) (func(context.Context) error, error) {
+// InitTracer installs a global tracer provider exporting spans over OTLP/gRPC to
+// jaegerEndpoint, with W3C trace context and baggage propagation. Root spans are sampled
+// at sampleRatio (1 samples everything) and child spans follow their parent's decision,
+// so a trace sampled upstream stays whole. The exporter connects
+// lazily, so startup never waits for the collector; spans are dropped while it is
+// unreachable. The returned function flushes and shuts the provider down.
+func InitTracer(serviceName, jaegerEndpoint string, sampleRatio float64) (func(context.Context) error, error) {
 	ctx := context.Background()
 
 	traceExporter, err := otlptracegrpc.New(
@@ -40,7 +46,7 @@ func InitTracer(serviceName, jaegerEndpoint string) (func(context.Context) error
 
 	bsp := sdktrace.NewBatchSpanProcessor(traceExporter)
 	tracerProvider := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
 		sdktrace.WithResource(res),
 		sdktrace.WithSpanProcessor(bsp),
 	)
@@ -64,6 +70,7 @@ func InitTracer(serviceName, jaegerEndpoint string) (func(context.Context) error
 	}, nil
 }
 
+// Tracing starts a server span per request with otelgin, continuing incoming trace context
 func Tracing(serviceName string) gin.HandlerFunc {
 	return otelgin.Middleware(serviceName)
 }
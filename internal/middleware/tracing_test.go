@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// initTracer runs InitTracer against endpoint, restoring the global tracer provider and
+// propagator after the test
+func initTracer(t *testing.T, endpoint string, sampleRatio float64) func(context.Context) error {
+	t.Helper()
+	previous, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	shutdown, err := InitTracer("test", endpoint, sampleRatio)
+	if err != nil {
+		t.Fatalf("InitTracer: %v", err)
+	}
+	return shutdown
+}
+
+func TestInitTracerDoesNotWaitForTheCollector(t *testing.T) {
+	// Nothing listens on port 1, so every export fails
+	start := time.Now()
+	shutdown := initTracer(t, "127.0.0.1:1", 1)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("InitTracer took %s with the collector unreachable", elapsed)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "request")
+	span.End()
+
+	// Shutdown gives up on the undeliverable spans by the caller's deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %s with a 500ms deadline", elapsed)
+	}
+}
+
+func TestInitTracerSampleRatio(t *testing.T) {
+	// startRoot starts and ends n root spans, returning how many were sampled
+	startRoot := func(n int) int {
+		sampled := 0
+		for range n {
+			_, span := otel.Tracer("test").Start(context.Background(), "request")
+			if span.SpanContext().IsSampled() {
+				sampled++
+			}
+			span.End()
+		}
+		return sampled
+	}
+	// startChild reports whether a span under a remote parent with the given decision is sampled
+	startChild := func(parentSampled bool) bool {
+		parent := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+			SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+			Remote:  true,
+		})
+		if parentSampled {
+			parent = parent.WithTraceFlags(trace.FlagsSampled)
+		}
+		ctx := trace.ContextWithRemoteSpanContext(context.Background(), parent)
+		_, span := otel.Tracer("test").Start(ctx, "request")
+		defer span.End()
+		return span.SpanContext().IsSampled()
+	}
+
+	tests := []struct {
+		ratio      float64
+		minSampled int
+		maxSampled int
+	}{
+		{ratio: 0, minSampled: 0, maxSampled: 0},
+		{ratio: 0.5, minSampled: 400, maxSampled: 600},
+		{ratio: 1, minSampled: 1000, maxSampled: 1000},
+	}
+	for _, tt := range tests {
+		shutdown := initTracer(t, "127.0.0.1:1", tt.ratio)
+
+		if sampled := startRoot(1000); sampled < tt.minSampled || sampled > tt.maxSampled {
+			t.Errorf("ratio %g: sampled %d of 1000 root spans, want %d-%d", tt.ratio, sampled, tt.minSampled, tt.maxSampled)
+		}
+		// An upstream decision wins over the ratio, so traces stay whole
+		if !startChild(true) || startChild(false) {
+			t.Errorf("ratio %g: child spans don't follow their parent's sampling decision", tt.ratio)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		shutdown(ctx)
+		cancel()
+	}
+}
@@ -1,17 +1,41 @@
+// Package nats is the single NATS JetStream client shared by the API and consumer binaries.
 package nats
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
 )
 
+// requestTimeout bounds JetStream API requests made by methods without a context
+const requestTimeout = 10 * time.Second
+
+// NatsClient wraps a NATS connection and its JetStream interface, exposed as JS
 type NatsClient struct {
 	Conn      *nats.Conn
-	JS        nats.JetStreamContext
-	StreamCfg *nats.StreamConfig
+	JS        jetstream.JetStream
+	StreamCfg *jetstream.StreamConfig
+
+	// maxAckPending bounds unacknowledged messages on consumers this client creates
+	maxAckPending int
+	// ackWait is how long consumers this client creates wait for an ack before redelivering
+	ackWait time.Duration
+	// jsOpts select the same JetStream domain or API prefix for the legacy API, which
+	// push consumers need
+	jsOpts []nats.JSOpt
+
+	// status is StatusBucket, opened by the first RecordPush
+	statusMu sync.Mutex
+	status   jetstream.KeyValue
 }
 
 type Config struct {
@@ -21,13 +45,46 @@ type Config struct {
 	ConnectionName  string
 	StreamName      string
 	StreamSubjects  []string
-	RetentionPolicy nats.RetentionPolicy
-	StorageType     nats.StorageType
+	RetentionPolicy jetstream.RetentionPolicy
+	StorageType     jetstream.StorageType
 	MaxAge          time.Duration
 	Replicas        int
+
+	// JSDomain and JSAPIPrefix select JetStream in leaf-node or multi-domain deployments; set at most one
+	JSDomain    string
+	JSAPIPrefix string
+
+	// MaxAckPending bounds the in-flight unacknowledged messages per consumer; zero uses the server default
+	MaxAckPending int
+	// AckWait is how long a consumer waits for an ack before redelivering; zero uses the
+	// server default (30s)
+	AckWait time.Duration
+
+	// Servers lists additional seed URLs for the same cluster; NATS picks among them on (re)connect
+	Servers []string
+
+	// TLSConfig enables TLS to the servers; nil connects in plain text unless the URL is tls://
+	TLSConfig *tls.Config
+
+	// CredsFile is a .creds file holding the user JWT and NKey seed; it can't be combined
+	// with the inline credentials below
+	CredsFile string
+	// Username and Password authenticate with user/password
+	Username string
+	Password string
+	// NKeySeed is a user NKey seed (SU...) used to sign the server's nonce
+	NKeySeed string
+
+	// OnReconnect, when set, is called on its own goroutine each time the connection is
+	// re-established
+	OnReconnect func()
 }
 
 func NewClient(config Config) (*NatsClient, error) {
+	if config.JSDomain != "" && config.JSAPIPrefix != "" {
+		return nil, fmt.Errorf("JetStream domain and API prefix are mutually exclusive")
+	}
+
 	// Define connection options
 	opts := []nats.Option{
 		nats.Name(config.ConnectionName),
@@ -38,28 +95,62 @@ func NewClient(config Config) (*NatsClient, error) {
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
 			log.Printf("NATS reconnected to %s", nc.ConnectedUrl())
+			if config.OnReconnect != nil {
+				go config.OnReconnect()
+			}
 		}),
 		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
 			log.Printf("NATS error: %v", err)
 		}),
 	}
 
-	// Connect to NATS
-	nc, err := nats.Connect(config.URL, opts...)
+	// Add TLS and authentication options
+	securityOpts, err := securityOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, securityOpts...)
+
+	// Connect to NATS using every configured server URL
+	urls := config.URL
+	if len(config.Servers) > 0 {
+		servers := config.Servers
+		if config.URL != "" {
+			servers = append([]string{config.URL}, servers...)
+		}
+		urls = strings.Join(servers, ",")
+	}
+	nc, err := nats.Connect(urls, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
 
-	// Create JetStream context
-	js, err := nc.JetStream()
+	// Create JetStream interface
+	var js jetstream.JetStream
+	switch {
+	case config.JSDomain != "":
+		js, err = jetstream.NewWithDomain(nc, config.JSDomain)
+	case config.JSAPIPrefix != "":
+		js, err = jetstream.NewWithAPIPrefix(nc, config.JSAPIPrefix)
+	default:
+		js, err = jetstream.New(nc)
+	}
 	if err != nil {
 		nc.Close()
 		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
 	}
 
 	client := &NatsClient{
-		Conn: nc,
-		JS:   js,
+		Conn:          nc,
+		JS:            js,
+		maxAckPending: config.MaxAckPending,
+		ackWait:       config.AckWait,
+	}
+	switch {
+	case config.JSDomain != "":
+		client.jsOpts = []nats.JSOpt{nats.Domain(config.JSDomain)}
+	case config.JSAPIPrefix != "":
+		client.jsOpts = []nats.JSOpt{nats.APIPrefix(config.JSAPIPrefix)}
 	}
 
 	// Set up logs stream if configured
@@ -74,137 +165,302 @@ func NewClient(config Config) (*NatsClient, error) {
 	return client, nil
 }
 
-func (c *NatsClient) SetupStream(config Config) error {
-	// Check if stream exists
-	_, err := c.JS.StreamInfo(config.StreamName)
-	if err != nil {
-		// Stream doesn't exist, create it
-		streamConfig := &nats.StreamConfig{
-			Name:      config.StreamName,
-			Subjects:  config.StreamSubjects,
-			Retention: config.RetentionPolicy,
-			MaxAge:    config.MaxAge,
-			Storage:   config.StorageType,
-			Replicas:  config.Replicas,
-			NoAck:     false,
-			Discard:   nats.DiscardOld,
-			MaxMsgs:   -1,
-			MaxBytes:  -1,
-		}
+// securityOptions translates the TLS and credential settings into connection options
+func securityOptions(config Config) ([]nats.Option, error) {
+	inline := config.Username != "" || config.Password != "" || config.NKeySeed != ""
+	if config.CredsFile != "" && inline {
+		return nil, fmt.Errorf("NATS creds file and inline credentials (username/password or NKey seed) are mutually exclusive")
+	}
+	if config.NKeySeed != "" && (config.Username != "" || config.Password != "") {
+		return nil, fmt.Errorf("NATS NKey seed and username/password are mutually exclusive")
+	}
 
-		_, err = c.JS.AddStream(streamConfig)
+	var opts []nats.Option
+	if config.TLSConfig != nil {
+		opts = append(opts, nats.Secure(config.TLSConfig))
+	}
+
+	switch {
+	case config.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(config.CredsFile))
+	case config.NKeySeed != "":
+		kp, err := nkeys.FromSeed([]byte(config.NKeySeed))
 		if err != nil {
-			return fmt.Errorf("failed to create stream: %w", err)
-		}
-		log.Printf("Stream %s created", config.StreamName)
-		c.StreamCfg = streamConfig
-	} else {
-		// Stream exists, update it
-		streamConfig := &nats.StreamConfig{
-			Name:      config.StreamName,
-			Subjects:  config.StreamSubjects,
-			Retention: config.RetentionPolicy,
-			MaxAge:    config.MaxAge,
-			Storage:   config.StorageType,
-			Replicas:  config.Replicas,
-			NoAck:     false,
-			Discard:   nats.DiscardOld,
-			MaxMsgs:   -1,
-			MaxBytes:  -1,
+			return nil, fmt.Errorf("invalid NATS NKey seed: %w", err)
 		}
-
-		_, err = c.JS.UpdateStream(streamConfig)
+		pub, err := kp.PublicKey()
 		if err != nil {
-			return fmt.Errorf("failed to update stream: %w", err)
+			return nil, fmt.Errorf("invalid NATS NKey seed: %w", err)
 		}
-		log.Printf("Stream %s updated", config.StreamName)
-		c.StreamCfg = streamConfig
+		opts = append(opts, nats.Nkey(pub, kp.Sign))
+	case config.Username != "" || config.Password != "":
+		opts = append(opts, nats.UserInfo(config.Username, config.Password))
+	}
+	return opts, nil
+}
+
+// SetupStream creates the logs stream, or updates it to match the config
+func (c *NatsClient) SetupStream(config Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	streamConfig := &jetstream.StreamConfig{
+		Name:      config.StreamName,
+		Subjects:  config.StreamSubjects,
+		Retention: config.RetentionPolicy,
+		MaxAge:    config.MaxAge,
+		Storage:   config.StorageType,
+		Replicas:  config.Replicas,
+		NoAck:     false,
+		Discard:   jetstream.DiscardOld,
+		MaxMsgs:   -1,
+		MaxBytes:  -1,
+	}
+
+	if _, err := c.JS.CreateOrUpdateStream(ctx, *streamConfig); err != nil {
+		return fmt.Errorf("failed to create or update stream: %w", err)
 	}
+	log.Printf("Stream %s set up", config.StreamName)
+	c.StreamCfg = streamConfig
 
 	return nil
 }
 
-// Close gracefully shuts down the NATS connection
+// Close shuts down the NATS connection at once, abandoning pending messages; prefer Drain
+// during graceful shutdown
 func (c *NatsClient) Close() {
 	if c.Conn != nil {
 		c.Conn.Close()
 	}
 }
 
+// Drain lets subscriptions finish processing and flushes pending publishes and acks before
+// closing the connection. If ctx is done first, the connection is closed abruptly.
+func (c *NatsClient) Drain(ctx context.Context) error {
+	if c.Conn == nil || c.Conn.IsClosed() {
+		return nil
+	}
+	if err := c.Conn.Drain(); err != nil {
+		c.Conn.Close()
+		return fmt.Errorf("failed to drain NATS connection: %w", err)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for !c.Conn.IsClosed() {
+		select {
+		case <-ctx.Done():
+			c.Conn.Close()
+			return fmt.Errorf("NATS drain interrupted: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
 // Publish publishes a message to the specified subject
-func (c *NatsClient) Publish(subject string, data []byte) (*nats.PubAck, error) {
-	return c.JS.Publish(subject, data)
+func (c *NatsClient) Publish(subject string, data []byte) (*jetstream.PubAck, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return c.PublishContext(ctx, subject, data)
+}
+
+// PublishContext publishes a message to the specified subject, giving up when ctx is done.
+// The ctx's deadline, not requestTimeout, bounds the wait for the stream's ack.
+func (c *NatsClient) PublishContext(ctx context.Context, subject string, data []byte) (*jetstream.PubAck, error) {
+	return c.JS.Publish(ctx, subject, data)
+}
+
+// Dead-letter message headers describing why a message was rejected
+const (
+	DLQReasonHeader   = "Logtrace-DLQ-Reason"
+	DLQFailedAtHeader = "Logtrace-DLQ-Failed-At"
+)
+
+// maxDLQReasonLen bounds the reason header so large error bodies don't bloat the message
+const maxDLQReasonLen = 1024
+
+// SetupDLQStream creates the dead-letter stream capturing subject if it doesn't exist.
+// Its subject must not overlap the logs stream, or the log consumer would read it back.
+func (c *NatsClient) SetupDLQStream(name, subject string, maxAge time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if _, err := c.JS.Stream(ctx, name); err == nil {
+		return nil
+	}
+
+	_, err := c.JS.CreateStream(ctx, jetstream.StreamConfig{
+		Name:      name,
+		Subjects:  []string{subject},
+		Retention: jetstream.LimitsPolicy,
+		MaxAge:    maxAge,
+		Storage:   jetstream.FileStorage,
+		Discard:   jetstream.DiscardOld,
+		MaxMsgs:   -1,
+		MaxBytes:  -1,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create dead-letter stream: %w", err)
+	}
+	log.Printf("Dead-letter stream %s created", name)
+	return nil
 }
 
-// CreatePullConsumer creates a pull consumer if it doesn't already exist
-func (c *NatsClient) CreatePullConsumer(name string, filterSubject string) error {
+// PublishDLQ republishes the original message bytes to a dead-letter subject, with the
+// rejection reason and time in headers
+func (c *NatsClient) PublishDLQ(subject string, original []byte, reason string) error {
+	// Header values must be a single line
+	reason = strings.Join(strings.Fields(reason), " ")
+	if len(reason) > maxDLQReasonLen {
+		reason = reason[:maxDLQReasonLen]
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = original
+	msg.Header.Set(DLQReasonHeader, reason)
+	msg.Header.Set(DLQFailedAtHeader, time.Now().UTC().Format(time.RFC3339))
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if _, err := c.JS.PublishMsg(ctx, msg); err != nil {
+		return fmt.Errorf("failed to publish to dead-letter subject %s: %w", subject, err)
+	}
+	return nil
+}
+
+// setFilterSubjects filters a consumer on one subject, or on several with FilterSubjects
+func setFilterSubjects(cfg *jetstream.ConsumerConfig, subjects []string) {
+	cfg.FilterSubject = ""
+	cfg.FilterSubjects = nil
+	if len(subjects) == 1 {
+		cfg.FilterSubject = subjects[0]
+	} else {
+		cfg.FilterSubjects = subjects
+	}
+}
+
+// sameFilterSubjects reports whether a consumer already filters on exactly these subjects
+func sameFilterSubjects(cfg jetstream.ConsumerConfig, subjects []string) bool {
+	current := cfg.FilterSubjects
+	if cfg.FilterSubject != "" {
+		current = []string{cfg.FilterSubject}
+	}
+	return slices.Equal(current, subjects)
+}
+
+// CreatePullConsumer creates a durable pull consumer if it doesn't already exist, or brings
+// an existing one's subjects and pending limit in line with the config, and returns it
+func (c *NatsClient) CreatePullConsumer(name string, filterSubjects []string) (jetstream.Consumer, error) {
 	if c.StreamCfg == nil {
-		return fmt.Errorf("stream not set up; call SetupStream first")
+		return nil, fmt.Errorf("stream not set up; call SetupStream first")
+	}
+	if len(filterSubjects) == 0 {
+		return nil, fmt.Errorf("at least one filter subject is required")
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
 	// Check if consumer exists
-	_, err := c.JS.ConsumerInfo(c.StreamCfg.Name, name)
+	consumer, err := c.JS.Consumer(ctx, c.StreamCfg.Name, name)
 	if err != nil {
 		// Consumer doesn't exist, create it
-		_, err = c.JS.AddConsumer(c.StreamCfg.Name, &nats.ConsumerConfig{
+		consumerCfg := jetstream.ConsumerConfig{
 			Durable:       name,
-			AckPolicy:     nats.AckExplicitPolicy,
-			FilterSubject: filterSubject,
+			AckPolicy:     jetstream.AckExplicitPolicy,
 			MaxDeliver:    -1,
-		})
+			MaxAckPending: c.maxAckPending,
+			AckWait:       c.ackWait,
+		}
+		setFilterSubjects(&consumerCfg, filterSubjects)
+
+		consumer, err = c.JS.CreateConsumer(ctx, c.StreamCfg.Name, consumerCfg)
 		if err != nil {
-			return fmt.Errorf("failed to create consumer: %w", err)
+			return nil, fmt.Errorf("failed to create consumer: %w", err)
 		}
 		log.Printf("Consumer %s created", name)
+		return consumer, nil
 	}
 
-	return nil
-}
+	// A push consumer of the same name can't serve fetches
+	push, err := c.isPushConsumer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if push {
+		return nil, fmt.Errorf("consumer %s is a push consumer", name)
+	}
 
-func (c *NatsClient) CreatePushConsumer(name string, filterSubject string, handler nats.MsgHandler) (*nats.Subscription, error) {
-	if c.StreamCfg == nil {
-		return nil, fmt.Errorf("stream not set up; call SetupStream first")
+	// Consumer exists; bring its subjects, pending limit and ack wait in line with the config
+	consumerCfg := consumer.CachedInfo().Config
+	pendingChanged := c.maxAckPending > 0 && consumerCfg.MaxAckPending != c.maxAckPending
+	ackWaitChanged := c.ackWait > 0 && consumerCfg.AckWait != c.ackWait
+	subjectsChanged := !sameFilterSubjects(consumerCfg, filterSubjects)
+	if pendingChanged || ackWaitChanged || subjectsChanged {
+		if pendingChanged {
+			consumerCfg.MaxAckPending = c.maxAckPending
+		}
+		if ackWaitChanged {
+			consumerCfg.AckWait = c.ackWait
+		}
+		setFilterSubjects(&consumerCfg, filterSubjects)
+		consumer, err = c.JS.UpdateConsumer(ctx, c.StreamCfg.Name, consumerCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update consumer: %w", err)
+		}
+		log.Printf("Consumer %s updated with subjects %v, max ack pending %d and ack wait %s", name, filterSubjects, consumerCfg.MaxAckPending, consumerCfg.AckWait)
 	}
 
-	// Create a push subscription
-	sub, err := c.JS.Subscribe(
-		filterSubject,
-		handler,
-		nats.Durable(name),
-		nats.AckExplicit(),
-		nats.DeliverAll(),
-	)
+	return consumer, nil
+}
+
+// SubscribePull makes sure the durable consumer exists and returns it for Fetch
+func (c *NatsClient) SubscribePull(consumerName string, filterSubjects []string) (jetstream.Consumer, error) {
+	consumer, err := c.CreatePullConsumer(consumerName, filterSubjects)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create push subscription: %w", err)
+		return nil, err
 	}
 
-	log.Printf("Push consumer %s created", name)
-	return sub, nil
+	log.Printf("Pull subscription for consumer %s created", consumerName)
+	return consumer, nil
 }
 
-func (c *NatsClient) SubscribePull(consumerName string, filterSubject string) (*nats.Subscription, error) {
+// ConsumerInfo returns the current state of a consumer on the configured stream
+func (c *NatsClient) ConsumerInfo(name string) (*jetstream.ConsumerInfo, error) {
 	if c.StreamCfg == nil {
 		return nil, fmt.Errorf("stream not set up; call SetupStream first")
 	}
 
-	// Make sure the consumer exists
-	err := c.CreatePullConsumer(consumerName, filterSubject)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	consumer, err := c.JS.Consumer(ctx, c.StreamCfg.Name, name)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get consumer info: %w", err)
 	}
-
-	// Create pull subscription
-	sub, err := c.JS.PullSubscribe(
-		filterSubject,
-		consumerName,
-		nats.Bind(c.StreamCfg.Name, consumerName),
-	)
+	info, err := consumer.Info(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create pull subscription: %w", err)
+		return nil, fmt.Errorf("failed to get consumer info: %w", err)
 	}
+	return info, nil
+}
 
-	log.Printf("Pull subscription for consumer %s created", consumerName)
-	return sub, nil
+// ConsumerPending reports how far behind a consumer is: messages not yet delivered,
+// delivered but unacknowledged, and redelivered
+func (c *NatsClient) ConsumerPending(stream, consumer string) (numPending uint64, numAckPending int, redelivered uint64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	cons, err := c.JS.Consumer(ctx, stream, consumer)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	info, err := cons.Info(ctx)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	return info.NumPending, info.NumAckPending, uint64(info.NumRedelivered), nil
 }
 
 // RequestReply demonstrates standard NATS request-reply pattern (non-JetStream)
@@ -212,20 +468,83 @@ func (c *NatsClient) RequestReply(subject string, data []byte, timeout time.Dura
 	return c.Conn.Request(subject, data, timeout)
 }
 
-// ListStreams lists all streams in the JetStream server
-func (c *NatsClient) ListStreams() ([]*nats.StreamInfo, error) {
-	var results []*nats.StreamInfo
+// ListStreams lists the streams in the JetStream server, skipping the first offset and
+// returning at most limit of them (zero or less for no limit). Iteration stops as soon as
+// the limit is reached or ctx is done.
+func (c *NatsClient) ListStreams(ctx context.Context, offset, limit int) ([]*jetstream.StreamInfo, error) {
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Get a stream context
-	ctx := c.JS.StreamsInfo()
+	var results []*jetstream.StreamInfo
 
-	// Iterate through all streams
-	for info := range ctx {
-		if info == nil {
-			return nil, fmt.Errorf("error receiving stream info")
+	lister := c.JS.ListStreams(listCtx)
+	skipped := 0
+	for info := range lister.Info() {
+		if skipped < offset {
+			skipped++
+			continue
 		}
 		results = append(results, info)
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+	}
+
+	// Stop the lister and wait for it to finish before reading its error
+	cancel()
+	for range lister.Info() {
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("listing streams interrupted: %w", err)
+	}
+	if limit > 0 && len(results) >= limit {
+		return results, nil
+	}
+	if err := lister.Err(); err != nil {
+		return nil, fmt.Errorf("error receiving stream info: %w", err)
 	}
 
 	return results, nil
 }
+
+// DeleteStream deletes a stream and all its messages and consumers
+func (c *NatsClient) DeleteStream(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if err := c.JS.DeleteStream(ctx, name); err != nil {
+		return fmt.Errorf("failed to delete stream %s: %w", name, err)
+	}
+	log.Printf("Stream %s deleted", name)
+	return nil
+}
+
+// PurgeStream removes messages from a stream, all of them unless narrowed with options
+// such as jetstream.WithPurgeSubject, jetstream.WithPurgeSequence or jetstream.WithPurgeKeep
+func (c *NatsClient) PurgeStream(name string, opts ...jetstream.StreamPurgeOpt) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	stream, err := c.JS.Stream(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get stream %s: %w", name, err)
+	}
+	if err := stream.Purge(ctx, opts...); err != nil {
+		return fmt.Errorf("failed to purge stream %s: %w", name, err)
+	}
+	log.Printf("Stream %s purged", name)
+	return nil
+}
+
+// DeleteConsumer deletes a consumer from a stream
+func (c *NatsClient) DeleteConsumer(stream, consumer string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if err := c.JS.DeleteConsumer(ctx, stream, consumer); err != nil {
+		return fmt.Errorf("failed to delete consumer %s on stream %s: %w", consumer, stream, err)
+	}
+	log.Printf("Consumer %s deleted from stream %s", consumer, stream)
+	return nil
+}
@@ -0,0 +1,499 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"logtrace/internal/natstest"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// newTestClient connects to a fresh embedded server with a work-queue logs stream
+func newTestClient(t *testing.T, configure ...func(cfg *Config)) *NatsClient {
+	t.Helper()
+	s := natstest.RunServer(t)
+
+	cfg := Config{
+		URL:             s.ClientURL(),
+		ReconnectWait:   100 * time.Millisecond,
+		ConnectionName:  t.Name(),
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>"},
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     jetstream.MemoryStorage,
+		MaxAge:          time.Hour,
+		Replicas:        1,
+	}
+	for _, c := range configure {
+		c(&cfg)
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestNewClientSetsUpStream(t *testing.T) {
+	client := newTestClient(t)
+
+	if client.JS == nil || client.StreamCfg == nil {
+		t.Fatal("client has no JetStream context or stream config")
+	}
+	info, err := client.JS.Stream(context.Background(), "logs")
+	if err != nil {
+		t.Fatalf("stream not created: %v", err)
+	}
+	if info.CachedInfo().Config.Retention != jetstream.WorkQueuePolicy {
+		t.Errorf("retention = %v, want work queue", info.CachedInfo().Config.Retention)
+	}
+
+	// Setting up again with other subjects updates the stream in place
+	cfg := Config{
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>", "audit.>"},
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     jetstream.MemoryStorage,
+		Replicas:        1,
+	}
+	if err := client.SetupStream(cfg); err != nil {
+		t.Fatalf("SetupStream: %v", err)
+	}
+	stream, err := client.JS.Stream(context.Background(), "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stream.CachedInfo().Config.Subjects; !slices.Equal(got, cfg.StreamSubjects) {
+		t.Errorf("subjects = %v, want %v", got, cfg.StreamSubjects)
+	}
+}
+
+func TestNewClientRejectsDomainWithPrefix(t *testing.T) {
+	_, err := NewClient(Config{URL: "nats://127.0.0.1:1", JSDomain: "hub", JSAPIPrefix: "$JS.hub.API"})
+	if err == nil {
+		t.Fatal("domain and API prefix accepted together")
+	}
+}
+
+func TestNewClientTriesEveryServer(t *testing.T) {
+	client := newTestClient(t, func(cfg *Config) {
+		// The primary URL is down, so only the seed list can connect
+		cfg.Servers = []string{cfg.URL}
+		cfg.URL = "nats://127.0.0.1:1"
+	})
+	if !client.Conn.IsConnected() {
+		t.Fatal("client not connected through its seed servers")
+	}
+	if _, err := client.Publish("logs.test", []byte("{}")); err != nil {
+		t.Errorf("Publish: %v", err)
+	}
+}
+
+func TestPublishAndFetch(t *testing.T) {
+	client := newTestClient(t)
+
+	consumer, err := client.SubscribePull("log-consumer", []string{"logs.>"})
+	if err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+
+	for _, subject := range []string{"logs.api", "logs.web", "logs.api"} {
+		ack, err := client.Publish(subject, []byte(subject))
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+		if ack.Stream != "logs" {
+			t.Errorf("published to stream %q", ack.Stream)
+		}
+	}
+
+	batch, err := consumer.Fetch(10, jetstream.FetchMaxWait(time.Second))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	var got []string
+	for msg := range batch.Messages() {
+		got = append(got, string(msg.Data()))
+		if err := msg.Ack(); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	if want := []string{"logs.api", "logs.web", "logs.api"}; !slices.Equal(got, want) {
+		t.Errorf("fetched %v, want %v", got, want)
+	}
+
+	// Subscribing again finds the same durable, brought in line with new subjects
+	if _, err := client.SubscribePull("log-consumer", []string{"logs.api"}); err != nil {
+		t.Fatalf("SubscribePull again: %v", err)
+	}
+	info, err := client.ConsumerInfo("log-consumer")
+	if err != nil {
+		t.Fatalf("ConsumerInfo: %v", err)
+	}
+	if info.Config.FilterSubject != "logs.api" {
+		t.Errorf("filter subject = %q, want logs.api", info.Config.FilterSubject)
+	}
+}
+
+func TestListStreams(t *testing.T) {
+	client := newTestClient(t)
+	for _, name := range []string{"audit", "metrics"} {
+		if _, err := client.JS.CreateStream(context.Background(), jetstream.StreamConfig{
+			Name:     name,
+			Subjects: []string{name + ".>"},
+			Storage:  jetstream.MemoryStorage,
+		}); err != nil {
+			t.Fatalf("CreateStream: %v", err)
+		}
+	}
+
+	all, err := client.ListStreams(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("ListStreams: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("listed %d streams, want 3", len(all))
+	}
+
+	page, err := client.ListStreams(context.Background(), 1, 1)
+	if err != nil {
+		t.Fatalf("ListStreams page: %v", err)
+	}
+	if len(page) != 1 || page[0].Config.Name != all[1].Config.Name {
+		t.Errorf("page = %v, want the second stream %s", page, all[1].Config.Name)
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	client := newTestClient(t)
+
+	sub, err := client.Conn.Subscribe("echo", func(msg *nats.Msg) {
+		msg.Respond(append([]byte("echo: "), msg.Data...))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	reply, err := client.RequestReply("echo", []byte("hi"), time.Second)
+	if err != nil {
+		t.Fatalf("RequestReply: %v", err)
+	}
+	if string(reply.Data) != "echo: hi" {
+		t.Errorf("reply = %q", reply.Data)
+	}
+
+	if _, err := client.RequestReply("nobody.home", nil, 100*time.Millisecond); err == nil {
+		t.Error("request without a responder succeeded")
+	}
+}
+
+func TestDrainClosesConnection(t *testing.T) {
+	client := newTestClient(t)
+
+	if err := client.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if !client.Conn.IsClosed() {
+		t.Error("connection open after Drain")
+	}
+	if err := client.Drain(context.Background()); err != nil {
+		t.Errorf("second Drain: %v", err)
+	}
+}
+
+func TestConsumerPending(t *testing.T) {
+	client := newTestClient(t, func(cfg *Config) { cfg.AckWait = 100 * time.Millisecond })
+	consumer, err := client.SubscribePull("log-consumer", []string{"logs.>"})
+	if err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+	publish := func(n int) {
+		t.Helper()
+		for range n {
+			if _, err := client.Publish("logs.api", []byte("{}")); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+		}
+	}
+	expect := func(what string, wantPending uint64, wantAckPending int, wantRedelivered uint64) {
+		t.Helper()
+		pending, ackPending, redelivered, err := client.ConsumerPending("logs", "log-consumer")
+		if err != nil {
+			t.Fatalf("ConsumerPending: %v", err)
+		}
+		if pending != wantPending || ackPending != wantAckPending || redelivered != wantRedelivered {
+			t.Errorf("%s: pending %d, ack pending %d, redelivered %d; want %d, %d, %d",
+				what, pending, ackPending, redelivered, wantPending, wantAckPending, wantRedelivered)
+		}
+	}
+
+	publish(3)
+	expect("after publishing", 3, 0, 0)
+	publish(2)
+	expect("after publishing more", 5, 0, 0)
+
+	// Fetch 4, acking all but one, which is redelivered once its AckWait runs out
+	batch, err := consumer.Fetch(4, jetstream.FetchMaxWait(time.Second))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	var unacked int
+	for msg := range batch.Messages() {
+		if unacked == 0 {
+			unacked++
+			continue
+		}
+		if err := msg.DoubleAck(context.Background()); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	expect("after consuming", 1, 1, 0)
+
+	time.Sleep(200 * time.Millisecond)
+	batch, err = consumer.Fetch(2, jetstream.FetchMaxWait(time.Second))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	var msgs []jetstream.Msg
+	for msg := range batch.Messages() {
+		msgs = append(msgs, msg)
+	}
+	expect("after the redelivery", 0, 2, 1)
+	for _, msg := range msgs {
+		if err := msg.DoubleAck(context.Background()); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	expect("after consuming the rest", 0, 0, 0)
+
+	if _, _, _, err := client.ConsumerPending("logs", "missing"); err == nil {
+		t.Error("ConsumerPending of a missing consumer succeeded")
+	}
+}
+
+func TestNewClientUsesJetStreamDomain(t *testing.T) {
+	s := natstest.RunServer(t, func(opts *server.Options) { opts.JetStreamDomain = "hub" })
+
+	// newDomainClient connects without setting up a stream, which needs the right domain
+	newDomainClient := func(domain string) *NatsClient {
+		t.Helper()
+		client, err := NewClient(Config{URL: s.ClientURL(), JSDomain: domain})
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		t.Cleanup(client.Close)
+		if got := client.JS.Options().Domain; got != domain {
+			t.Errorf("JetStream domain = %q, want %s", got, domain)
+		}
+		return client
+	}
+	// accountInfo asks for the account info through both the jetstream and the legacy API
+	accountInfo := func(client *NatsClient) (err, legacyErr error) {
+		t.Helper()
+		_, err = client.JS.AccountInfo(context.Background())
+		js, jsErr := client.legacyJS()
+		if jsErr != nil {
+			t.Fatal(jsErr)
+		}
+		_, legacyErr = js.AccountInfo(nats.MaxWait(time.Second))
+		return err, legacyErr
+	}
+
+	if err, legacyErr := accountInfo(newDomainClient("hub")); err != nil || legacyErr != nil {
+		t.Errorf("AccountInfo in the hub domain: %v, legacy %v", err, legacyErr)
+	}
+	// Only the hub domain has JetStream, so another domain proves requests carry the domain
+	if err, legacyErr := accountInfo(newDomainClient("edge")); err == nil || legacyErr == nil {
+		t.Errorf("AccountInfo in a missing domain: %v, legacy %v; want both to fail", err, legacyErr)
+	}
+
+	// Setting up the stream goes through the domain too
+	client, err := NewClient(Config{
+		URL:             s.ClientURL(),
+		JSDomain:        "hub",
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>"},
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     jetstream.MemoryStorage,
+		Replicas:        1,
+	})
+	if err != nil {
+		t.Fatalf("NewClient with a stream: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Publish("logs.api", []byte("{}")); err != nil {
+		t.Errorf("Publish: %v", err)
+	}
+}
+
+func TestCreatePullConsumerUpdatesExisting(t *testing.T) {
+	client := newTestClient(t, func(cfg *Config) {
+		cfg.MaxAckPending = 10
+		cfg.AckWait = 30 * time.Second
+	})
+	// expect checks the consumer's config on the server, not just what CreatePullConsumer returned
+	expect := func(what string, maxAckPending int, ackWait time.Duration, subjects []string) {
+		t.Helper()
+		info, err := client.ConsumerInfo("log-consumer")
+		if err != nil {
+			t.Fatalf("ConsumerInfo: %v", err)
+		}
+		got := info.Config
+		if got.MaxAckPending != maxAckPending || got.AckWait != ackWait || !sameFilterSubjects(got, subjects) {
+			t.Errorf("%s: max ack pending %d, ack wait %s, subjects %q %q; want %d, %s, %q",
+				what, got.MaxAckPending, got.AckWait, got.FilterSubject, got.FilterSubjects, maxAckPending, ackWait, subjects)
+		}
+	}
+
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.api"}); err != nil {
+		t.Fatalf("CreatePullConsumer: %v", err)
+	}
+	expect("created", 10, 30*time.Second, []string{"logs.api"})
+
+	// A restart with new settings updates the consumer in place
+	client.maxAckPending, client.ackWait = 50, 5*time.Second
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.api", "logs.web"}); err != nil {
+		t.Fatalf("CreatePullConsumer with new settings: %v", err)
+	}
+	expect("updated", 50, 5*time.Second, []string{"logs.api", "logs.web"})
+
+	// Unset limits keep what the consumer has, while the subjects still follow the config
+	client.maxAckPending, client.ackWait = 0, 0
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.web"}); err != nil {
+		t.Fatalf("CreatePullConsumer without limits: %v", err)
+	}
+	expect("updated without limits", 50, 5*time.Second, []string{"logs.web"})
+
+	// The update takes effect: only logs.web is delivered
+	for _, subject := range []string{"logs.api", "logs.web"} {
+		if _, err := client.Publish(subject, []byte(subject)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	consumer, err := client.SubscribePull("log-consumer", []string{"logs.web"})
+	if err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+	batch, err := consumer.Fetch(10, jetstream.FetchMaxWait(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	var got []string
+	for msg := range batch.Messages() {
+		got = append(got, string(msg.Data()))
+	}
+	if !slices.Equal(got, []string{"logs.web"}) {
+		t.Errorf("fetched %q, want only logs.web", got)
+	}
+}
+
+func TestPurgeStream(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []jetstream.StreamPurgeOpt
+		// want are the messages left, in order
+		want []string
+	}{
+		{name: "everything", want: nil},
+		{name: "one subject", opts: []jetstream.StreamPurgeOpt{jetstream.WithPurgeSubject("logs.api")}, want: []string{"web-1", "web-2"}},
+		{name: "keep the newest", opts: []jetstream.StreamPurgeOpt{jetstream.WithPurgeKeep(2)}, want: []string{"api-2", "web-2"}},
+		{name: "up to a sequence", opts: []jetstream.StreamPurgeOpt{jetstream.WithPurgeSequence(3)}, want: []string{"api-2", "web-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, func(cfg *Config) { cfg.RetentionPolicy = jetstream.LimitsPolicy })
+			for _, msg := range []struct{ subject, data string }{
+				{"logs.api", "api-1"}, {"logs.web", "web-1"}, {"logs.api", "api-2"}, {"logs.web", "web-2"},
+			} {
+				if _, err := client.Publish(msg.subject, []byte(msg.data)); err != nil {
+					t.Fatalf("Publish: %v", err)
+				}
+			}
+
+			if err := client.PurgeStream("logs", tt.opts...); err != nil {
+				t.Fatalf("PurgeStream: %v", err)
+			}
+
+			consumer, err := client.SubscribePull("reader", []string{"logs.>"})
+			if err != nil {
+				t.Fatalf("SubscribePull: %v", err)
+			}
+			batch, err := consumer.Fetch(10, jetstream.FetchMaxWait(200*time.Millisecond))
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			var got []string
+			for msg := range batch.Messages() {
+				got = append(got, string(msg.Data()))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("left %q after purging, want %q", got, tt.want)
+			}
+		})
+	}
+
+	client := newTestClient(t)
+	if err := client.PurgeStream("missing"); err == nil {
+		t.Error("purging a missing stream succeeded")
+	}
+}
+
+func TestDeleteConsumerAndStream(t *testing.T) {
+	client := newTestClient(t)
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.>"}); err != nil {
+		t.Fatalf("CreatePullConsumer: %v", err)
+	}
+
+	if err := client.DeleteConsumer("logs", "log-consumer"); err != nil {
+		t.Fatalf("DeleteConsumer: %v", err)
+	}
+	if _, err := client.ConsumerInfo("log-consumer"); err == nil {
+		t.Error("consumer still exists after DeleteConsumer")
+	}
+	if err := client.DeleteConsumer("logs", "log-consumer"); err == nil {
+		t.Error("deleting a deleted consumer succeeded")
+	}
+
+	if err := client.DeleteStream("logs"); err != nil {
+		t.Fatalf("DeleteStream: %v", err)
+	}
+	if _, err := client.JS.Stream(context.Background(), "logs"); err == nil {
+		t.Error("stream still exists after DeleteStream")
+	}
+	if _, err := client.Publish("logs.api", []byte("{}")); err == nil {
+		t.Error("published to a deleted stream")
+	}
+	if err := client.DeleteStream("logs"); err == nil {
+		t.Error("deleting a deleted stream succeeded")
+	}
+}
+
+func TestPublishContextHonorsCancel(t *testing.T) {
+	client := newTestClient(t)
+
+	// A subscriber that never acks, outside any stream, stands in for a hung server
+	if _, err := client.Conn.Subscribe("stuck.>", func(*nats.Msg) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.PublishContext(ctx, "stuck.api", []byte("{}"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned after %s, not when canceled", elapsed)
+	}
+}
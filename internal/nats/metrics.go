@@ -0,0 +1,64 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+)
+
+// StreamMetrics is a snapshot of a stream and its consumers
+type StreamMetrics struct {
+	Stream    string            `json:"stream"`
+	Messages  uint64            `json:"messages"`
+	Bytes     uint64            `json:"bytes"`
+	FirstSeq  uint64            `json:"first_seq"`
+	LastSeq   uint64            `json:"last_seq"`
+	Consumers []ConsumerMetrics `json:"consumers"`
+}
+
+// ConsumerMetrics is a consumer's backlog within a StreamMetrics snapshot
+type ConsumerMetrics struct {
+	Name        string `json:"name"`
+	Pending     uint64 `json:"pending"`
+	AckPending  int    `json:"ack_pending"`
+	Redelivered int    `json:"redelivered"`
+}
+
+// StreamMetrics returns the message count, size and sequence range of a stream together
+// with each of its consumers' pending and ack-pending counts
+func (c *NatsClient) StreamMetrics(name string) (*StreamMetrics, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	stream, err := c.JS.Stream(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream %s: %w", name, err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stream info: %w", err)
+	}
+
+	metrics := &StreamMetrics{
+		Stream:    info.Config.Name,
+		Messages:  info.State.Msgs,
+		Bytes:     info.State.Bytes,
+		FirstSeq:  info.State.FirstSeq,
+		LastSeq:   info.State.LastSeq,
+		Consumers: []ConsumerMetrics{},
+	}
+
+	lister := stream.ListConsumers(ctx)
+	for consumer := range lister.Info() {
+		metrics.Consumers = append(metrics.Consumers, ConsumerMetrics{
+			Name:        consumer.Name,
+			Pending:     consumer.NumPending,
+			AckPending:  consumer.NumAckPending,
+			Redelivered: consumer.NumRedelivered,
+		})
+	}
+	if err := lister.Err(); err != nil {
+		return nil, fmt.Errorf("error receiving consumer info: %w", err)
+	}
+
+	return metrics, nil
+}
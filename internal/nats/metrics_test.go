@@ -0,0 +1,78 @@
+package nats
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestStreamMetrics(t *testing.T) {
+	client := newTestClient(t)
+	api, err := client.SubscribePull("api-consumer", []string{"logs.api"})
+	if err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+	if _, err := client.SubscribePull("web-consumer", []string{"logs.web"}); err != nil {
+		t.Fatalf("SubscribePull: %v", err)
+	}
+
+	empty, err := client.StreamMetrics("logs")
+	if err != nil {
+		t.Fatalf("StreamMetrics: %v", err)
+	}
+	if empty.Messages != 0 || empty.Bytes != 0 || len(empty.Consumers) != 2 {
+		t.Errorf("empty stream metrics = %+v", empty)
+	}
+
+	for _, subject := range []string{"logs.api", "logs.api", "logs.web"} {
+		if _, err := client.Publish(subject, []byte(`{"status":200}`)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	// api-consumer takes one message without acknowledging it
+	batch, err := api.Fetch(1, jetstream.FetchMaxWait(time.Second))
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	for range batch.Messages() {
+	}
+
+	metrics, err := client.StreamMetrics("logs")
+	if err != nil {
+		t.Fatalf("StreamMetrics: %v", err)
+	}
+	if metrics.Stream != "logs" || metrics.Messages != 3 || metrics.Bytes == 0 || metrics.FirstSeq != 1 || metrics.LastSeq != 3 {
+		t.Errorf("stream metrics = %+v, want logs with 3 messages at sequences 1-3", metrics)
+	}
+	slices.SortFunc(metrics.Consumers, func(a, b ConsumerMetrics) int { return strings.Compare(a.Name, b.Name) })
+	want := []ConsumerMetrics{
+		{Name: "api-consumer", Pending: 1, AckPending: 1},
+		{Name: "web-consumer", Pending: 1},
+	}
+	if !slices.Equal(metrics.Consumers, want) {
+		t.Errorf("consumers = %+v, want %+v", metrics.Consumers, want)
+	}
+
+	// The status endpoint serves the snapshot as JSON
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"stream", "messages", "bytes", "first_seq", "last_seq", "consumers"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("StreamMetrics JSON %s has no %q", data, key)
+		}
+	}
+
+	if _, err := client.StreamMetrics("missing"); err == nil {
+		t.Error("StreamMetrics of a missing stream succeeded")
+	}
+}
@@ -0,0 +1,223 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// PushConfig tunes how the server delivers to a push consumer
+type PushConfig struct {
+	// FlowControl has the server pause delivery until the subscriber has caught up with
+	// what it was sent, so a fast producer can't overwhelm a slow handler
+	FlowControl bool
+	// Heartbeat is how often the server signals an idle consumer, so stalled delivery is
+	// noticed; flow control requires it
+	Heartbeat time.Duration
+}
+
+// CreatePushConsumer subscribes handler to a durable push consumer, which the server
+// delivers to on its deliver subject rather than waiting for fetches. The consumer is
+// created with push's delivery settings if it doesn't exist, and an existing push
+// consumer's subjects, pending limit and ack wait are brought in line with the config,
+// though it keeps the delivery settings it was created with; a pull consumer of the same
+// name is an error. Only one subscriber at a time can bind to it. Stop delivery
+// with the returned context's Drain or Stop.
+func (c *NatsClient) CreatePushConsumer(name string, filterSubjects []string, push PushConfig, handler jetstream.MessageHandler) (jetstream.ConsumeContext, error) {
+	if c.StreamCfg == nil {
+		return nil, fmt.Errorf("stream not set up; call SetupStream first")
+	}
+	if len(filterSubjects) == 0 {
+		return nil, fmt.Errorf("at least one filter subject is required")
+	}
+	if push.FlowControl && push.Heartbeat <= 0 {
+		return nil, fmt.Errorf("push flow control requires an idle heartbeat")
+	}
+
+	js, err := c.legacyJS()
+	if err != nil {
+		return nil, err
+	}
+
+	stream := c.StreamCfg.Name
+	info, err := js.ConsumerInfo(stream, name)
+	switch {
+	case errors.Is(err, nats.ErrConsumerNotFound):
+		cfg := &nats.ConsumerConfig{
+			Durable:        name,
+			DeliverSubject: nats.NewInbox(),
+			AckPolicy:      nats.AckExplicitPolicy,
+			MaxDeliver:     -1,
+			MaxAckPending:  c.maxAckPending,
+			AckWait:        c.ackWait,
+			FlowControl:    push.FlowControl,
+			Heartbeat:      push.Heartbeat,
+		}
+		setPushFilterSubjects(cfg, filterSubjects)
+		if _, err := js.AddConsumer(stream, cfg); err != nil {
+			return nil, fmt.Errorf("failed to create push consumer: %w", err)
+		}
+		log.Printf("Push consumer %s created", name)
+
+	case err != nil:
+		return nil, fmt.Errorf("failed to get consumer info: %w", err)
+
+	case info.Config.DeliverSubject == "":
+		return nil, fmt.Errorf("consumer %s is a pull consumer", name)
+
+	default:
+		cfg := info.Config
+		if c.maxAckPending > 0 {
+			cfg.MaxAckPending = c.maxAckPending
+		}
+		if c.ackWait > 0 {
+			cfg.AckWait = c.ackWait
+		}
+		setPushFilterSubjects(&cfg, filterSubjects)
+		if !samePushConfig(info.Config, cfg) {
+			if _, err := js.UpdateConsumer(stream, &cfg); err != nil {
+				return nil, fmt.Errorf("failed to update push consumer: %w", err)
+			}
+			log.Printf("Push consumer %s updated with subjects %v, max ack pending %d and ack wait %s", name, filterSubjects, cfg.MaxAckPending, cfg.AckWait)
+		}
+
+		// The server can't change how an existing consumer delivers
+		if cfg.FlowControl != push.FlowControl || cfg.Heartbeat != push.Heartbeat {
+			log.Printf("Push consumer %s keeps flow control %t and heartbeat %s; recreate it to use flow control %t and heartbeat %s",
+				name, cfg.FlowControl, cfg.Heartbeat, push.FlowControl, push.Heartbeat)
+		}
+	}
+
+	// Binding checks the subject against a single filter; several filters take none
+	var subject string
+	if len(filterSubjects) == 1 {
+		subject = filterSubjects[0]
+	}
+	sub, err := js.Subscribe(subject, func(msg *nats.Msg) {
+		handler(pushMsg{msg})
+	}, nats.Bind(stream, name), nats.ManualAck())
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to push consumer: %w", err)
+	}
+	return newPushContext(sub), nil
+}
+
+// legacyJS returns the legacy JetStream API, the only one serving push consumers
+func (c *NatsClient) legacyJS() (nats.JetStreamContext, error) {
+	js, err := c.Conn.JetStream(c.jsOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+	return js, nil
+}
+
+// isPushConsumer reports whether an existing consumer delivers to a subject, which the
+// jetstream package's consumer config doesn't show
+func (c *NatsClient) isPushConsumer(ctx context.Context, name string) (bool, error) {
+	js, err := c.legacyJS()
+	if err != nil {
+		return false, err
+	}
+	info, err := js.ConsumerInfo(c.StreamCfg.Name, name, nats.Context(ctx))
+	if err != nil {
+		return false, fmt.Errorf("failed to get consumer info: %w", err)
+	}
+	return info.Config.DeliverSubject != "", nil
+}
+
+// setPushFilterSubjects filters a push consumer on one subject, or on several with
+// FilterSubjects
+func setPushFilterSubjects(cfg *nats.ConsumerConfig, subjects []string) {
+	cfg.FilterSubject = ""
+	cfg.FilterSubjects = nil
+	if len(subjects) == 1 {
+		cfg.FilterSubject = subjects[0]
+	} else {
+		cfg.FilterSubjects = subjects
+	}
+}
+
+// samePushConfig reports whether updating a push consumer from a to b changes nothing
+func samePushConfig(a, b nats.ConsumerConfig) bool {
+	return a.FilterSubject == b.FilterSubject && slices.Equal(a.FilterSubjects, b.FilterSubjects) &&
+		a.MaxAckPending == b.MaxAckPending && a.AckWait == b.AckWait
+}
+
+// pushContext stops a push subscription like jetstream.ConsumeContext stops Consume
+type pushContext struct {
+	sub    *nats.Subscription
+	closed chan struct{}
+}
+
+func newPushContext(sub *nats.Subscription) *pushContext {
+	pc := &pushContext{sub: sub, closed: make(chan struct{})}
+	statuses := sub.StatusChanged(nats.SubscriptionClosed)
+	go func() {
+		for range statuses {
+		}
+		close(pc.closed)
+	}()
+	return pc
+}
+
+// Stop unsubscribes, dropping messages not yet handled; NATS redelivers them
+func (pc *pushContext) Stop() {
+	pc.sub.Unsubscribe()
+}
+
+// Drain unsubscribes once the messages already received have been handled
+func (pc *pushContext) Drain() {
+	pc.sub.Drain()
+}
+
+// Closed is closed once the subscription has stopped and its handler returned
+func (pc *pushContext) Closed() <-chan struct{} {
+	return pc.closed
+}
+
+// pushMsg presents a message from a push subscription as a jetstream.Msg, so push and
+// pull deliveries are handled alike
+type pushMsg struct {
+	msg *nats.Msg
+}
+
+func (m pushMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	md, err := m.msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	return &jetstream.MsgMetadata{
+		Sequence:     jetstream.SequencePair{Consumer: md.Sequence.Consumer, Stream: md.Sequence.Stream},
+		NumDelivered: md.NumDelivered,
+		NumPending:   md.NumPending,
+		Timestamp:    md.Timestamp,
+		Stream:       md.Stream,
+		Consumer:     md.Consumer,
+		Domain:       md.Domain,
+	}, nil
+}
+
+func (m pushMsg) Data() []byte         { return m.msg.Data }
+func (m pushMsg) Headers() nats.Header { return m.msg.Header }
+func (m pushMsg) Subject() string      { return m.msg.Subject }
+func (m pushMsg) Reply() string        { return m.msg.Reply }
+
+func (m pushMsg) Ack() error { return m.msg.Ack() }
+
+func (m pushMsg) DoubleAck(ctx context.Context) error { return m.msg.AckSync(nats.Context(ctx)) }
+
+func (m pushMsg) Nak() error                             { return m.msg.Nak() }
+func (m pushMsg) NakWithDelay(delay time.Duration) error { return m.msg.NakWithDelay(delay) }
+func (m pushMsg) InProgress() error                      { return m.msg.InProgress() }
+func (m pushMsg) Term() error                            { return m.msg.Term() }
+
+// TermWithReason terminates the message, recording reason in the server's advisory
+func (m pushMsg) TermWithReason(reason string) error {
+	return m.msg.Respond([]byte("+TERM " + reason))
+}
@@ -0,0 +1,187 @@
+package nats
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+var testPush = PushConfig{FlowControl: true, Heartbeat: time.Second}
+
+func TestPushConsumerDeliversAndAcks(t *testing.T) {
+	t.Run("one filter", func(t *testing.T) { testPushDelivery(t, []string{"logs.>"}) })
+	t.Run("several filters", func(t *testing.T) { testPushDelivery(t, []string{"logs.api", "logs.web"}) })
+}
+
+func testPushDelivery(t *testing.T, filterSubjects []string) {
+	client := newTestClient(t)
+
+	received := make(chan jetstream.Msg, 10)
+	cc, err := client.CreatePushConsumer("log-consumer", filterSubjects, testPush, func(msg jetstream.Msg) {
+		received <- msg
+	})
+	if err != nil {
+		t.Fatalf("CreatePushConsumer: %v", err)
+	}
+
+	if _, err := client.Publish("logs.api", []byte("hello")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if string(msg.Data()) != "hello" || msg.Subject() != "logs.api" {
+			t.Errorf("received %q on %s", msg.Data(), msg.Subject())
+		}
+		md, err := msg.Metadata()
+		if err != nil || md.Consumer != "log-consumer" || md.NumDelivered != 1 {
+			t.Errorf("metadata = %+v, %v", md, err)
+		}
+		if err := msg.Ack(); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+	}
+
+	cc.Drain()
+	select {
+	case <-cc.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("push subscription not closed after Drain")
+	}
+
+	// The ack removed the message from the work queue
+	info, err := client.ConsumerInfo("log-consumer")
+	if err != nil {
+		t.Fatalf("ConsumerInfo: %v", err)
+	}
+	if info.NumAckPending != 0 || info.NumPending != 0 {
+		t.Errorf("%d pending, %d awaiting ack after the ack", info.NumPending, info.NumAckPending)
+	}
+}
+
+func TestPullConsumerRefusesPushDurable(t *testing.T) {
+	client := newTestClient(t)
+
+	cc, err := client.CreatePushConsumer("log-consumer", []string{"logs.>"}, testPush, func(msg jetstream.Msg) {})
+	if err != nil {
+		t.Fatalf("CreatePushConsumer: %v", err)
+	}
+	cc.Stop()
+
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.>"}); err == nil || !strings.Contains(err.Error(), "push consumer") {
+		t.Errorf("CreatePullConsumer on a push durable: err = %v", err)
+	}
+}
+
+func TestPushConsumerRefusesPullDurable(t *testing.T) {
+	client := newTestClient(t)
+
+	if _, err := client.CreatePullConsumer("log-consumer", []string{"logs.>"}); err != nil {
+		t.Fatalf("CreatePullConsumer: %v", err)
+	}
+	if _, err := client.CreatePushConsumer("log-consumer", []string{"logs.>"}, testPush, func(msg jetstream.Msg) {}); err == nil || !strings.Contains(err.Error(), "pull consumer") {
+		t.Errorf("CreatePushConsumer on a pull durable: err = %v", err)
+	}
+}
+
+// pushConsumerConfig reads a push consumer's config through the legacy API, which shows
+// its delivery settings
+func pushConsumerConfig(t *testing.T, client *NatsClient, name string) nats.ConsumerConfig {
+	t.Helper()
+	js, err := client.legacyJS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := js.ConsumerInfo(client.StreamCfg.Name, name)
+	if err != nil {
+		t.Fatalf("ConsumerInfo: %v", err)
+	}
+	return info.Config
+}
+
+func TestPushConsumerFlowControlAndHeartbeat(t *testing.T) {
+	client := newTestClient(t)
+
+	cc, err := client.CreatePushConsumer("log-consumer", []string{"logs.>"}, PushConfig{FlowControl: true, Heartbeat: 2 * time.Second}, func(msg jetstream.Msg) {})
+	if err != nil {
+		t.Fatalf("CreatePushConsumer: %v", err)
+	}
+	defer cc.Stop()
+
+	cfg := pushConsumerConfig(t, client, "log-consumer")
+	if !cfg.FlowControl || cfg.Heartbeat != 2*time.Second {
+		t.Errorf("flow control %t, heartbeat %s; want true, 2s", cfg.FlowControl, cfg.Heartbeat)
+	}
+}
+
+func TestPushConsumerBindsToProvisionedDurable(t *testing.T) {
+	client := newTestClient(t)
+
+	// A durable provisioned without flow control, as e.g. the nats CLI would
+	js, err := client.legacyJS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.AddConsumer("logs", &nats.ConsumerConfig{
+		Durable:        "log-consumer",
+		DeliverSubject: "deliver.logs",
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        time.Minute,
+		FilterSubject:  "logs.api",
+	}); err != nil {
+		t.Fatalf("AddConsumer: %v", err)
+	}
+
+	received := make(chan jetstream.Msg, 1)
+	cc, err := client.CreatePushConsumer("log-consumer", []string{"logs.>"}, testPush, func(msg jetstream.Msg) {
+		received <- msg
+	})
+	if err != nil {
+		t.Fatalf("CreatePushConsumer: %v", err)
+	}
+	defer cc.Stop()
+
+	// The subjects follow the config; the delivery settings can't change
+	cfg := pushConsumerConfig(t, client, "log-consumer")
+	if cfg.FilterSubject != "logs.>" {
+		t.Errorf("filter subject = %q, want logs.>", cfg.FilterSubject)
+	}
+	if cfg.FlowControl || cfg.DeliverSubject != "deliver.logs" {
+		t.Errorf("flow control %t, deliver subject %q; want them unchanged", cfg.FlowControl, cfg.DeliverSubject)
+	}
+
+	if _, err := client.Publish("logs.web", []byte("hello")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	select {
+	case msg := <-received:
+		msg.Ack()
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+	}
+}
+
+func TestPushConsumerWithoutFlowControl(t *testing.T) {
+	client := newTestClient(t)
+
+	cc, err := client.CreatePushConsumer("log-consumer", []string{"logs.>"}, PushConfig{Heartbeat: time.Second}, func(msg jetstream.Msg) {})
+	if err != nil {
+		t.Fatalf("CreatePushConsumer: %v", err)
+	}
+	defer cc.Stop()
+
+	cfg := pushConsumerConfig(t, client, "log-consumer")
+	if cfg.FlowControl || cfg.Heartbeat != time.Second {
+		t.Errorf("flow control %t, heartbeat %s; want false, 1s", cfg.FlowControl, cfg.Heartbeat)
+	}
+
+	// Flow control can't work without heartbeats
+	if _, err := client.CreatePushConsumer("other", []string{"logs.>"}, PushConfig{FlowControl: true}, func(msg jetstream.Msg) {}); err == nil {
+		t.Error("flow control without a heartbeat accepted")
+	}
+}
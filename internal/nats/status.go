@@ -0,0 +1,90 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// StatusBucket is the key-value bucket in which consumers record their last successful
+// push, so the API's status endpoint can read it across processes
+const StatusBucket = "logtrace_status"
+
+// lastPushKey is the StatusBucket key holding a consumer's last successful push
+func lastPushKey(consumer string) string {
+	return "last_push." + consumer
+}
+
+// RecordPush stores t as the time consumer last pushed a batch to its sink, creating
+// StatusBucket on first use
+func (c *NatsClient) RecordPush(consumer string, t time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	kv, err := c.statusKV(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := kv.Put(ctx, lastPushKey(consumer), []byte(t.UTC().Format(time.RFC3339Nano))); err != nil {
+		return fmt.Errorf("failed to record last push: %w", err)
+	}
+	return nil
+}
+
+// LastPush returns when consumer last recorded a successful push, or the zero time if
+// it never did
+func (c *NatsClient) LastPush(consumer string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	kv, err := c.JS.KeyValue(ctx, StatusBucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to open status bucket: %w", err)
+	}
+
+	entry, err := kv.Get(ctx, lastPushKey(consumer))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read last push: %w", err)
+	}
+
+	last, err := time.Parse(time.RFC3339Nano, string(entry.Value()))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed last push %q: %w", entry.Value(), err)
+	}
+	return last, nil
+}
+
+// statusKV returns StatusBucket, creating it the first time
+func (c *NatsClient) statusKV(ctx context.Context) (jetstream.KeyValue, error) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	if c.status != nil {
+		return c.status, nil
+	}
+
+	cfg := jetstream.KeyValueConfig{
+		Bucket:      StatusBucket,
+		Description: "Last successful sink push per log consumer",
+		History:     1,
+	}
+	if c.StreamCfg != nil {
+		cfg.Storage = c.StreamCfg.Storage
+		cfg.Replicas = c.StreamCfg.Replicas
+	}
+	kv, err := c.JS.CreateOrUpdateKeyValue(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create status bucket: %w", err)
+	}
+	c.status = kv
+	return kv, nil
+}
@@ -0,0 +1,31 @@
+package nats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordAndReadLastPush(t *testing.T) {
+	client := newTestClient(t)
+
+	last, err := client.LastPush("log-consumer")
+	if err != nil || !last.IsZero() {
+		t.Fatalf("LastPush before any push = %v, %v; want zero time", last, err)
+	}
+
+	pushed := time.Date(2026, 3, 4, 5, 6, 7, 8, time.UTC)
+	if err := client.RecordPush("log-consumer", pushed); err != nil {
+		t.Fatalf("RecordPush: %v", err)
+	}
+	if err := client.RecordPush("other-consumer", pushed.Add(time.Hour)); err != nil {
+		t.Fatalf("RecordPush: %v", err)
+	}
+
+	last, err = client.LastPush("log-consumer")
+	if err != nil {
+		t.Fatalf("LastPush: %v", err)
+	}
+	if !last.Equal(pushed) {
+		t.Errorf("LastPush = %v, want %v", last, pushed)
+	}
+}
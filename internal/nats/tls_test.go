@@ -0,0 +1,372 @@
+package nats
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nkeys"
+
+	"logtrace/internal/natstest"
+)
+
+// testCA signs certificates for the TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// file is the CA certificate in PEM
+	file string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(t.TempDir(), name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue signs a certificate for 127.0.0.1 with the given usage, returning its certificate
+// and key files
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runTLSServer starts a server presenting a certificate from ca, requiring client
+// certificates signed by it when mutual is set
+func runTLSServer(t *testing.T, ca *testCA, mutual bool) *server.Server {
+	t.Helper()
+	certFile, keyFile := ca.issue(t, "server", x509.ExtKeyUsageServerAuth)
+	tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CaFile:   ca.file,
+		Verify:   mutual,
+		Timeout:  2,
+	})
+	if err != nil {
+		t.Fatalf("server TLS config: %v", err)
+	}
+	return natstest.RunServer(t, func(opts *server.Options) {
+		opts.TLSConfig = tlsConfig
+		opts.TLSVerify = mutual
+		opts.TLSTimeout = 2
+	})
+}
+
+// dial connects to s with the stream set up as in the other client tests
+func dial(t *testing.T, s *server.Server, configure func(cfg *Config)) (*NatsClient, error) {
+	t.Helper()
+	cfg := Config{
+		URL:             s.ClientURL(),
+		ConnectionName:  t.Name(),
+		StreamName:      "logs",
+		StreamSubjects:  []string{"logs.>"},
+		RetentionPolicy: jetstream.WorkQueuePolicy,
+		StorageType:     jetstream.MemoryStorage,
+		MaxAge:          time.Hour,
+		Replicas:        1,
+	}
+	configure(&cfg)
+	client, err := NewClient(cfg)
+	if err == nil {
+		t.Cleanup(client.Close)
+	}
+	return client, err
+}
+
+// loadTLS is LoadTLSConfig failing the test on error
+func loadTLS(t *testing.T, caFile, certFile, keyFile string) *tls.Config {
+	t.Helper()
+	tlsConfig, err := LoadTLSConfig(caFile, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadTLSConfig: %v", err)
+	}
+	return tlsConfig
+}
+
+func TestTLSConnection(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	s := runTLSServer(t, ca, false)
+
+	client, err := dial(t, s, func(cfg *Config) { cfg.TLSConfig = loadTLS(t, ca.file, "", "") })
+	if err != nil {
+		t.Fatalf("NewClient over TLS: %v", err)
+	}
+	if _, err := client.Publish("logs.test", []byte("{}")); err != nil {
+		t.Errorf("Publish over TLS: %v", err)
+	}
+	if state, err := client.Conn.TLSConnectionState(); err != nil || !state.HandshakeComplete {
+		t.Errorf("connection is not TLS: %v", err)
+	}
+}
+
+func TestTLSRejectsUnknownCA(t *testing.T) {
+	s := runTLSServer(t, newTestCA(t, "ca"), false)
+	other := newTestCA(t, "other-ca")
+
+	_, err := dial(t, s, func(cfg *Config) { cfg.TLSConfig = loadTLS(t, other.file, "", "") })
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("NewClient trusting another CA = %v, want a certificate error", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	s := runTLSServer(t, ca, true)
+
+	certFile, keyFile := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	if _, err := dial(t, s, func(cfg *Config) { cfg.TLSConfig = loadTLS(t, ca.file, certFile, keyFile) }); err != nil {
+		t.Errorf("NewClient with a client certificate: %v", err)
+	}
+
+	if _, err := dial(t, s, func(cfg *Config) { cfg.TLSConfig = loadTLS(t, ca.file, "", "") }); err == nil {
+		t.Error("NewClient without a client certificate succeeded")
+	}
+
+	// A certificate from a CA the server doesn't trust is refused too
+	other := newTestCA(t, "other-ca")
+	certFile, keyFile = other.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	if _, err := dial(t, s, func(cfg *Config) { cfg.TLSConfig = loadTLS(t, ca.file, certFile, keyFile) }); err == nil {
+		t.Error("NewClient with an untrusted client certificate succeeded")
+	}
+}
+
+func TestLoadTLSConfigErrors(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	certFile, keyFile := ca.issue(t, "client", x509.ExtKeyUsageClientAuth)
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                      string
+		caFile, certFile, keyFile string
+	}{
+		{"missing CA file", filepath.Join(t.TempDir(), "missing.pem"), "", ""},
+		{"CA file without certificates", notPEM, "", ""},
+		{"certificate without key", ca.file, certFile, ""},
+		{"key that doesn't match", ca.file, ca.file, keyFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadTLSConfig(tt.caFile, tt.certFile, tt.keyFile); err == nil {
+				t.Error("LoadTLSConfig succeeded")
+			}
+		})
+	}
+
+	if tlsConfig, err := LoadTLSConfig("", "", ""); tlsConfig != nil || err != nil {
+		t.Errorf("LoadTLSConfig without files = %v, %v; want nil, nil", tlsConfig, err)
+	}
+}
+
+func TestUserPassword(t *testing.T) {
+	s := natstest.RunServer(t, func(opts *server.Options) {
+		opts.Username = "logtrace"
+		opts.Password = "s3cret"
+	})
+
+	if _, err := dial(t, s, func(cfg *Config) { cfg.Username, cfg.Password = "logtrace", "s3cret" }); err != nil {
+		t.Errorf("NewClient with the right password: %v", err)
+	}
+	if _, err := dial(t, s, func(cfg *Config) { cfg.Username, cfg.Password = "logtrace", "wrong" }); err == nil {
+		t.Error("NewClient with a wrong password succeeded")
+	}
+	if _, err := dial(t, s, func(*Config) {}); err == nil {
+		t.Error("NewClient without credentials succeeded")
+	}
+}
+
+func TestNKeyAuthentication(t *testing.T) {
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := user.PublicKey()
+	seed, _ := user.Seed()
+	s := natstest.RunServer(t, func(opts *server.Options) {
+		opts.Nkeys = []*server.NkeyUser{{Nkey: pub}}
+	})
+
+	if _, err := dial(t, s, func(cfg *Config) { cfg.NKeySeed = string(seed) }); err != nil {
+		t.Errorf("NewClient with the NKey seed: %v", err)
+	}
+
+	other, _ := nkeys.CreateUser()
+	otherSeed, _ := other.Seed()
+	if _, err := dial(t, s, func(cfg *Config) { cfg.NKeySeed = string(otherSeed) }); err == nil {
+		t.Error("NewClient with an unknown NKey succeeded")
+	}
+	if _, err := dial(t, s, func(cfg *Config) { cfg.NKeySeed = "SUnotaseed" }); err == nil || !strings.Contains(err.Error(), "invalid NATS NKey seed") {
+		t.Errorf("NewClient with a malformed seed = %v", err)
+	}
+}
+
+func TestConflictingCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"creds file and password", Config{CredsFile: "user.creds", Username: "u", Password: "p"}},
+		{"creds file and seed", Config{CredsFile: "user.creds", NKeySeed: "SU..."}},
+		{"seed and password", Config{NKeySeed: "SU...", Username: "u", Password: "p"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := securityOptions(tt.config); err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+				t.Errorf("securityOptions = %v, want a mutually exclusive error", err)
+			}
+		})
+	}
+}
+
+// operatorCreds configures a server for operator mode with one JetStream-enabled account,
+// returning a creds file for a user of that account
+func operatorCreds(t *testing.T) (configure func(opts *server.Options), credsFile string) {
+	t.Helper()
+	operator, _ := nkeys.CreateOperator()
+	operatorPub, _ := operator.PublicKey()
+	operatorClaims := jwt.NewOperatorClaims(operatorPub)
+	operatorJWT, err := operatorClaims.Encode(operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := jwt.DecodeOperatorClaims(operatorJWT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, _ := nkeys.CreateAccount()
+	accountPub, _ := account.PublicKey()
+	accountClaims := jwt.NewAccountClaims(accountPub)
+	accountClaims.Limits.JetStreamLimits = jwt.JetStreamLimits{MemoryStorage: -1, DiskStorage: -1, Streams: -1, Consumer: -1}
+	accountJWT, err := accountClaims.Encode(operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := nkeys.CreateUser()
+	userPub, _ := user.PublicKey()
+	userSeed, _ := user.Seed()
+	userJWT, err := jwt.NewUserClaims(userPub).Encode(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := jwt.FormatUserConfig(userJWT, userSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credsFile = filepath.Join(t.TempDir(), "user.creds")
+	if err := os.WriteFile(credsFile, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// JetStream in operator mode needs a system account
+	system, _ := nkeys.CreateAccount()
+	systemPub, _ := system.PublicKey()
+	systemJWT, err := jwt.NewAccountClaims(systemPub).Encode(operator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &server.MemAccResolver{}
+	for pub, claims := range map[string]string{accountPub: accountJWT, systemPub: systemJWT} {
+		if err := resolver.Store(pub, claims); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func(opts *server.Options) {
+		opts.TrustedOperators = []*jwt.OperatorClaims{trusted}
+		opts.AccountResolver = resolver
+		opts.SystemAccount = systemPub
+	}, credsFile
+}
+
+func TestCredsFile(t *testing.T) {
+	configure, credsFile := operatorCreds(t)
+	s := natstest.RunServer(t, configure)
+
+	client, err := dial(t, s, func(cfg *Config) { cfg.CredsFile = credsFile })
+	if err != nil {
+		t.Fatalf("NewClient with the creds file: %v", err)
+	}
+	if _, err := client.Publish("logs.test", []byte("{}")); err != nil {
+		t.Errorf("Publish with the creds file: %v", err)
+	}
+
+	// Credentials of another operator's user are refused
+	_, otherCreds := operatorCreds(t)
+	if _, err := dial(t, s, func(cfg *Config) { cfg.CredsFile = otherCreds }); err == nil {
+		t.Error("NewClient with an untrusted user's creds succeeded")
+	}
+}
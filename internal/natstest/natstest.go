@@ -0,0 +1,43 @@
+// Package natstest runs embedded NATS servers with JetStream for tests.
+package natstest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// RunServer starts a JetStream-enabled server on a random port, storing its data in a
+// temporary directory, and shuts it down when the test ends. configure, when given,
+// adjusts the options before the server starts, e.g. to enable TLS or authentication.
+func RunServer(t testing.TB, configure ...func(opts *server.Options)) *server.Server {
+	t.Helper()
+
+	opts := &server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	}
+	for _, c := range configure {
+		c(opts)
+	}
+
+	s, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		s.Shutdown()
+		t.Fatal("NATS server not ready for connections")
+	}
+	t.Cleanup(func() {
+		s.Shutdown()
+		s.WaitForShutdown()
+	})
+	return s
+}
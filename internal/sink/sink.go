@@ -0,0 +1,63 @@
+// Package sink defines where the consumer forwards log entries.
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"logtrace/internal/middleware"
+)
+
+// LogSink is a log storage backend the consumer forwards entries to
+type LogSink interface {
+	// SendBatchLogsContext sends a batch of entries, giving up when ctx is done; a
+	// *PartialError says which entries failed when the others were sent
+	SendBatchLogsContext(ctx context.Context, entries []middleware.LogEntry) error
+	// SendLogContext sends a single entry, giving up when ctx is done
+	SendLogContext(ctx context.Context, entry middleware.LogEntry) error
+}
+
+// PartialError is returned by SendBatchLogsContext when the sink took only part of a
+// batch, e.g. when one tenant's push failed and another's succeeded. Entries not listed
+// in Failed were delivered and must not be sent again.
+type PartialError struct {
+	Failed []FailedGroup
+}
+
+// FailedGroup is a set of entries that failed together
+type FailedGroup struct {
+	// Indexes are the entries' positions in the batch given to SendBatchLogsContext
+	Indexes []int
+	Err     error
+}
+
+func (e *PartialError) Error() string {
+	failed := 0
+	for _, group := range e.Failed {
+		failed += len(group.Indexes)
+	}
+	return fmt.Sprintf("%d entries not sent: %v", failed, errors.Join(e.Unwrap()...))
+}
+
+func (e *PartialError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, group := range e.Failed {
+		errs[i] = group.Err
+	}
+	return errs
+}
+
+// retryable is implemented by sink errors that know whether a resend may succeed
+type retryable interface {
+	Retryable() bool
+}
+
+// IsRetryable reports whether err is a sink failure that may succeed if retried;
+// a send cut off by its context's deadline always may
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var r retryable
+	return errors.As(err, &r) && r.Retryable()
+}